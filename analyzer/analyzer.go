@@ -0,0 +1,326 @@
+// Package analyzer implements the analysis of the keyspace of a database via
+// an Upstash-compatible Redis REST API. It walks the keyspace with SCAN and
+// collects the type, TTL and memory usage of each key to produce a Report
+// with counts and bytes by type and key prefix, the distribution of TTLs and
+// the largest keys. The upstash-redis-cli command uses it in its --analyze
+// mode.
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+// Options configures the analysis of the keyspace.
+type Options struct {
+	// Match is the glob-style pattern of the keys to analyze, all keys are
+	// analyzed if it is empty.
+	Match string
+
+	// Count is the COUNT value of the SCAN command, which is also the number of
+	// keys analyzed in a single pipeline request. Defaults to 100.
+	Count int
+
+	// Interval is the duration to sleep between SCAN calls, e.g. to respect
+	// rate limits.
+	Interval time.Duration
+
+	// PrefixDelimiter is the delimiter of the parts of a key, defaults to ":".
+	PrefixDelimiter string
+
+	// PrefixDepth is the number of parts of a key that make its prefix,
+	// defaults to 1, e.g. the prefix of "user:1:name" is "user:".
+	PrefixDepth int
+
+	// Top is the number of largest keys to report, defaults to 10.
+	Top int
+
+	// NoMemory disables the MEMORY USAGE command, e.g. if it is not supported
+	// by the server. In that case, the bytes are not reported and the largest
+	// keys are undefined.
+	NoMemory bool
+}
+
+// Report is the result of the analysis of the keyspace.
+type Report struct {
+	// Keys is the number of keys analyzed.
+	Keys int64 `json:"keys"`
+	// Bytes is the total memory usage of the keys analyzed.
+	Bytes int64 `json:"bytes"`
+	// ByType is the statistics of the keys by type.
+	ByType map[string]*Stats `json:"by_type"`
+	// ByPrefix is the statistics of the keys by prefix. The prefix of keys
+	// without delimiter is the empty string.
+	ByPrefix map[string]*Stats `json:"by_prefix"`
+	// TTL is the distribution of the keys by remaining time to live.
+	TTL []*TTLBucket `json:"ttl"`
+	// Largest is the list of the largest keys by memory usage, in descending
+	// order.
+	Largest []*KeyInfo `json:"largest"`
+}
+
+// Stats is the statistics of a group of keys.
+type Stats struct {
+	// Keys is the number of keys in the group.
+	Keys int64 `json:"keys"`
+	// Bytes is the total memory usage of the keys.
+	Bytes int64 `json:"bytes"`
+	// Volatile is the number of keys that have a TTL.
+	Volatile int64 `json:"volatile"`
+}
+
+// TTLBucket is the number of keys with a TTL lower than Max (and greater
+// than or equal to the Max of the previous bucket). The last bucket has a
+// Max of 0 and counts the keys without TTL.
+type TTLBucket struct {
+	Label string        `json:"label"`
+	Max   time.Duration `json:"max"`
+	Stats
+}
+
+// KeyInfo is the information about a single key.
+type KeyInfo struct {
+	Key   string        `json:"key"`
+	Type  string        `json:"type"`
+	TTL   time.Duration `json:"ttl"` // 0 if the key does not expire
+	Bytes int64         `json:"bytes"`
+}
+
+var ttlBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"< 1m", time.Minute},
+	{"< 1h", time.Hour},
+	{"< 1d", 24 * time.Hour},
+	{"< 7d", 7 * 24 * time.Hour},
+	{"< 30d", 30 * 24 * time.Hour},
+	{">= 30d", 1<<63 - 1},
+	{"no ttl", 0},
+}
+
+// Analyze walks the keyspace and returns the report of its analysis. It
+// stops and returns ctx.Err() if ctx is cancelled.
+func Analyze(ctx context.Context, client *upstashdis.Client, opts *Options) (*Report, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Count <= 0 {
+		o.Count = 100
+	}
+	if o.PrefixDelimiter == "" {
+		o.PrefixDelimiter = ":"
+	}
+	if o.PrefixDepth <= 0 {
+		o.PrefixDepth = 1
+	}
+	if o.Top <= 0 {
+		o.Top = 10
+	}
+
+	rep := newReport()
+	err := scanKeys(ctx, client, o.Match, o.Count, o.Interval, func(keys []string) error {
+		infos, err := keyInfos(client, keys, o.NoMemory)
+		if err != nil {
+			return err
+		}
+		for _, ki := range infos {
+			rep.add(ki, prefixOf(ki.Key, o.PrefixDelimiter, o.PrefixDepth), o.Top)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// scanKeys walks the keyspace with SCAN and calls fn with each batch of keys
+// matching the pattern, sleeping for the interval between SCAN calls.
+func scanKeys(ctx context.Context, client *upstashdis.Client, match string, count int, interval time.Duration, fn func([]string) error) error {
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		args := []interface{}{cursor, "COUNT", count}
+		if match != "" {
+			args = append(args, "MATCH", match)
+		}
+		var scan []json.RawMessage
+		if err := client.NewRequest().ExecOne(&scan, "SCAN", args...); err != nil {
+			return err
+		}
+		if len(scan) != 2 {
+			return fmt.Errorf("analyzer: unexpected SCAN result: %d values", len(scan))
+		}
+		var keys []string
+		if err := json.Unmarshal(scan[0], &cursor); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(scan[1], &keys); err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+func newReport() *Report {
+	rep := &Report{
+		ByType:   make(map[string]*Stats),
+		ByPrefix: make(map[string]*Stats),
+	}
+	for _, b := range ttlBuckets {
+		rep.TTL = append(rep.TTL, &TTLBucket{Label: b.label, Max: b.max})
+	}
+	return rep
+}
+
+func (r *Report) add(ki *KeyInfo, prefix string, top int) {
+	r.Keys++
+	r.Bytes += ki.Bytes
+
+	addStats := func(m map[string]*Stats, k string) {
+		st := m[k]
+		if st == nil {
+			st = &Stats{}
+			m[k] = st
+		}
+		st.add(ki)
+	}
+	addStats(r.ByType, ki.Type)
+	addStats(r.ByPrefix, prefix)
+
+	for i, b := range ttlBuckets {
+		if (ki.TTL == 0 && b.max == 0) || (ki.TTL > 0 && ki.TTL < b.max) {
+			r.TTL[i].add(ki)
+			break
+		}
+	}
+
+	// keep the top largest keys, sorted in descending order of bytes
+	if len(r.Largest) < top || ki.Bytes > r.Largest[len(r.Largest)-1].Bytes {
+		ix := sort.Search(len(r.Largest), func(i int) bool {
+			return r.Largest[i].Bytes < ki.Bytes
+		})
+		r.Largest = append(r.Largest, nil)
+		copy(r.Largest[ix+1:], r.Largest[ix:])
+		r.Largest[ix] = ki
+		if len(r.Largest) > top {
+			r.Largest = r.Largest[:top]
+		}
+	}
+}
+
+func (s *Stats) add(ki *KeyInfo) {
+	s.Keys++
+	s.Bytes += ki.Bytes
+	if ki.TTL > 0 {
+		s.Volatile++
+	}
+}
+
+// prefixOf returns the prefix of the key made of its first depth parts
+// separated by delim, including the trailing delimiter. It returns the empty
+// string if the key has no delimiter.
+func prefixOf(key, delim string, depth int) string {
+	var n int
+	for i := 0; i < depth; i++ {
+		ix := strings.Index(key[n:], delim)
+		if ix < 0 {
+			break
+		}
+		n += ix + len(delim)
+	}
+	return key[:n]
+}
+
+// keyInfos returns the information about the keys, in a single pipeline.
+// Keys that do not exist anymore are skipped.
+func keyInfos(client *upstashdis.Client, keys []string, noMem bool) ([]*KeyInfo, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	per := 3
+	if noMem {
+		per = 2
+	}
+	req := client.NewRequest()
+	for _, key := range keys {
+		if err := req.Send("TYPE", key); err != nil {
+			return nil, err
+		}
+		if err := req.Send("PTTL", key); err != nil {
+			return nil, err
+		}
+		if !noMem {
+			if err := req.Send("MEMORY", "USAGE", key, "SAMPLES", 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	res, err := req.ExecRaw()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != per*len(keys) {
+		return nil, fmt.Errorf("analyzer: unexpected number of results: %d", len(res))
+	}
+
+	infos := make([]*KeyInfo, 0, len(keys))
+	for i, key := range keys {
+		var (
+			typ   string
+			pttl  int64
+			bytes *int64
+		)
+		for j, dst := range []interface{}{&typ, &pttl, &bytes}[:per] {
+			r := res[per*i+j]
+			if r.Error != "" {
+				return nil, fmt.Errorf("analyzer: key %q: %s", key, r.Error)
+			}
+			if err := json.Unmarshal(r.Result, dst); err != nil {
+				return nil, err
+			}
+		}
+		if typ == "none" || pttl == -2 {
+			// key expired or deleted since the SCAN
+			continue
+		}
+
+		ki := &KeyInfo{Key: key, Type: typ}
+		if pttl > 0 {
+			ki.TTL = time.Duration(pttl) * time.Millisecond
+		}
+		if bytes != nil {
+			ki.Bytes = *bytes
+		}
+		infos = append(infos, ki)
+	}
+	return infos, nil
+}
@@ -0,0 +1,77 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+
+	require.NoError(t, srv.Redis.Set("user:1:name", "a"))
+	require.NoError(t, srv.Redis.Set("user:2:name", "b"))
+	srv.Redis.SetTTL("user:2:name", 30*time.Second)
+	srv.Redis.HSet("session:1", "f", "v")
+	srv.Redis.SetTTL("session:1", 2*time.Hour)
+	_, err := srv.Redis.Push("queue", "x")
+	require.NoError(t, err)
+
+	// miniredis does not support MEMORY USAGE
+	rep, err := Analyze(context.Background(), srv.Client(), &Options{Count: 2, NoMemory: true, Top: 2})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(4), rep.Keys)
+	require.Equal(t, &Stats{Keys: 2, Volatile: 1}, rep.ByType["string"])
+	require.Equal(t, &Stats{Keys: 1, Volatile: 1}, rep.ByType["hash"])
+	require.Equal(t, &Stats{Keys: 1}, rep.ByType["list"])
+	require.Equal(t, &Stats{Keys: 2, Volatile: 1}, rep.ByPrefix["user:"])
+	require.Equal(t, &Stats{Keys: 1, Volatile: 1}, rep.ByPrefix["session:"])
+	require.Equal(t, &Stats{Keys: 1}, rep.ByPrefix[""])
+	require.Len(t, rep.Largest, 2)
+
+	ttls := make(map[string]int64)
+	for _, b := range rep.TTL {
+		ttls[b.Label] = b.Keys
+	}
+	require.Equal(t, map[string]int64{
+		"< 1m": 1, "< 1h": 0, "< 1d": 1, "< 7d": 0, "< 30d": 0, ">= 30d": 0, "no ttl": 2,
+	}, ttls)
+}
+
+func TestPrefixOf(t *testing.T) {
+	cases := []struct {
+		key, delim string
+		depth      int
+		want       string
+	}{
+		{"a", ":", 1, ""},
+		{"a:b", ":", 1, "a:"},
+		{"a:b:c", ":", 1, "a:"},
+		{"a:b:c", ":", 2, "a:b:"},
+		{"a:b", ":", 3, "a:"},
+		{"a::b", "::", 1, "a::"},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			require.Equal(t, c.want, prefixOf(c.key, c.delim, c.depth))
+		})
+	}
+}
+
+func TestLargest(t *testing.T) {
+	rep := newReport()
+
+	for i, n := range []int64{5, 1, 10, 3, 7} {
+		rep.add(&KeyInfo{Key: string(rune('a' + i)), Bytes: n}, "", 3)
+	}
+	var got []int64
+	for _, ki := range rep.Largest {
+		got = append(got, ki.Bytes)
+	}
+	require.Equal(t, []int64{10, 7, 5}, got)
+	require.Equal(t, int64(26), rep.Bytes)
+}
@@ -0,0 +1,188 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+// TTLAuditOptions configures the audit of the TTLs of the keys.
+type TTLAuditOptions struct {
+	// Patterns is the list of glob-style patterns of the keys to audit, all
+	// keys are audited if it is empty.
+	Patterns []string
+
+	// Count is the COUNT value of the SCAN command, which is also the number of
+	// keys audited in a single pipeline request. Defaults to 100.
+	Count int
+
+	// Interval is the duration to sleep between SCAN calls, e.g. to respect
+	// rate limits.
+	Interval time.Duration
+
+	// ExpiringWithin reports the keys that expire within that duration. If it
+	// is 0, expiring keys are not reported.
+	ExpiringWithin time.Duration
+
+	// DefaultTTL is the TTL to set on the keys without TTL. If it is 0, the
+	// keys are left untouched. The TTL is set with a separate command after
+	// it has been checked, so a TTL set concurrently on the key is
+	// overwritten.
+	DefaultTTL time.Duration
+
+	// Limit is the maximum number of keys listed in the NoTTL and Expiring
+	// fields of the audit, the counts are not limited. Defaults to 1000.
+	Limit int
+}
+
+// TTLAudit is the result of the audit of the TTLs of the keys.
+type TTLAudit struct {
+	// Keys is the number of keys audited.
+	Keys int64 `json:"keys"`
+
+	// NoTTL is the list of keys without TTL, and NoTTLCount their number.
+	NoTTL      []string `json:"no_ttl"`
+	NoTTLCount int64    `json:"no_ttl_count"`
+
+	// Expiring is the list of keys that expire within the requested duration,
+	// and ExpiringCount their number.
+	Expiring      []*KeyTTL `json:"expiring"`
+	ExpiringCount int64     `json:"expiring_count"`
+
+	// Applied is the number of keys on which the default TTL was set.
+	Applied int64 `json:"applied"`
+}
+
+// KeyTTL is a key and its remaining time to live.
+type KeyTTL struct {
+	Key string        `json:"key"`
+	TTL time.Duration `json:"ttl"`
+}
+
+// AuditTTL walks the keys matching the patterns and reports the keys
+// without TTL and the keys that expire soon, optionally setting a default
+// TTL on the keys without TTL. It stops and returns ctx.Err() if ctx is
+// cancelled.
+func AuditTTL(ctx context.Context, client *upstashdis.Client, opts *TTLAuditOptions) (*TTLAudit, error) {
+	var o TTLAuditOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Count <= 0 {
+		o.Count = 100
+	}
+	if o.Limit <= 0 {
+		o.Limit = 1000
+	}
+	patterns := o.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+
+	// with multiple patterns, a key may match more than one
+	var seen map[string]bool
+	if len(patterns) > 1 {
+		seen = make(map[string]bool)
+	}
+
+	var audit TTLAudit
+	for _, pat := range patterns {
+		err := scanKeys(ctx, client, pat, o.Count, o.Interval, func(keys []string) error {
+			if seen != nil {
+				unseen := keys[:0]
+				for _, key := range keys {
+					if !seen[key] {
+						seen[key] = true
+						unseen = append(unseen, key)
+					}
+				}
+				keys = unseen
+			}
+			return audit.check(client, keys, &o)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &audit, nil
+}
+
+func (a *TTLAudit) check(client *upstashdis.Client, keys []string, o *TTLAuditOptions) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	req := client.NewRequest()
+	for _, key := range keys {
+		if err := req.Send("PTTL", key); err != nil {
+			return err
+		}
+	}
+	res, err := req.ExecRaw()
+	if err != nil {
+		return err
+	}
+	if len(res) != len(keys) {
+		return fmt.Errorf("analyzer: unexpected number of results: %d", len(res))
+	}
+
+	var noTTL []string
+	for i, key := range keys {
+		if res[i].Error != "" {
+			return fmt.Errorf("analyzer: key %q: %s", key, res[i].Error)
+		}
+		var pttl int64
+		if err := json.Unmarshal(res[i].Result, &pttl); err != nil {
+			return err
+		}
+
+		switch {
+		case pttl == -2:
+			// key expired or deleted since the SCAN
+			continue
+		case pttl == -1:
+			noTTL = append(noTTL, key)
+			a.NoTTLCount++
+			if len(a.NoTTL) < o.Limit {
+				a.NoTTL = append(a.NoTTL, key)
+			}
+		case o.ExpiringWithin > 0:
+			ttl := time.Duration(pttl) * time.Millisecond
+			if ttl <= o.ExpiringWithin {
+				a.ExpiringCount++
+				if len(a.Expiring) < o.Limit {
+					a.Expiring = append(a.Expiring, &KeyTTL{Key: key, TTL: ttl})
+				}
+			}
+		}
+		a.Keys++
+	}
+
+	if o.DefaultTTL <= 0 || len(noTTL) == 0 {
+		return nil
+	}
+
+	for _, key := range noTTL {
+		if err := req.Send("PEXPIRE", key, o.DefaultTTL.Milliseconds()); err != nil {
+			return err
+		}
+	}
+	res, err = req.ExecRaw()
+	if err != nil {
+		return err
+	}
+	for i, r := range res {
+		if r.Error != "" {
+			return fmt.Errorf("analyzer: key %q: %s", noTTL[i], r.Error)
+		}
+		var n int
+		if err := json.Unmarshal(r.Result, &n); err != nil {
+			return err
+		}
+		a.Applied += int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditTTL(t *testing.T) {
+	ctx := context.Background()
+	srv := upstashtest.NewServer(t)
+
+	require.NoError(t, srv.Redis.Set("cache:1", "a"))
+	require.NoError(t, srv.Redis.Set("cache:2", "b"))
+	srv.Redis.SetTTL("cache:2", 30*time.Second)
+	require.NoError(t, srv.Redis.Set("session:1", "c"))
+	srv.Redis.SetTTL("session:1", time.Hour)
+	require.NoError(t, srv.Redis.Set("other", "d"))
+
+	audit, err := AuditTTL(ctx, srv.Client(), &TTLAuditOptions{
+		Patterns:       []string{"cache:*", "session:*", "cache:1"},
+		ExpiringWithin: time.Minute,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &TTLAudit{
+		Keys:          3,
+		NoTTL:         []string{"cache:1"},
+		NoTTLCount:    1,
+		Expiring:      []*KeyTTL{{Key: "cache:2", TTL: 30 * time.Second}},
+		ExpiringCount: 1,
+	}, audit)
+
+	audit, err = AuditTTL(ctx, srv.Client(), &TTLAuditOptions{
+		DefaultTTL: 24 * time.Hour,
+		Limit:      1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), audit.Keys)
+	require.Equal(t, int64(2), audit.NoTTLCount)
+	require.Len(t, audit.NoTTL, 1)
+	require.Equal(t, int64(2), audit.Applied)
+	require.Equal(t, 24*time.Hour, srv.Redis.TTL("cache:1"))
+	require.Equal(t, 24*time.Hour, srv.Redis.TTL("other"))
+	require.Equal(t, time.Hour, srv.Redis.TTL("session:1"))
+}
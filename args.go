@@ -0,0 +1,125 @@
+package upstashdis
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Args is a helper to build the arguments of a command, compatible with
+// redigo's redis.Args. It is typically used with HSET to store the fields
+// of a struct, which can then be read back with HGETALL and ScanStruct:
+//
+//	args := upstashdis.Args{"user:1"}.AddFlat(&user)
+//	err := req.ExecOne(nil, "HSET", args...)
+type Args []interface{}
+
+// Add returns the result of appending values to args.
+func (args Args) Add(values ...interface{}) Args {
+	return append(args, values...)
+}
+
+// AddFlat returns the result of appending the flattened value of v to args:
+//   - a struct (or pointer to a struct) is flattened to alternating field
+//     names and values, using the same names as ScanStruct. Fields with a
+//     tag of "-" are ignored, and fields with the "omitempty" option (e.g.
+//     `redis:"name,omitempty"`) are ignored if they have the zero value, as
+//     are nil pointer fields.
+//   - a map is flattened to alternating keys and values, in unspecified
+//     order.
+//   - a slice (other than a []byte) or an array is flattened to its
+//     elements.
+//   - any other value is appended as-is.
+//
+// Struct field values of type time.Time are formatted as RFC3339 timestamps,
+// and values that implement encoding.TextMarshaler (but not Argument) are
+// converted with MarshalText, so that ScanStruct can read them back.
+func (args Args) AddFlat(v interface{}) Args {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Struct:
+		args = flattenStruct(args, rv)
+	case reflect.Ptr:
+		if !rv.IsNil() && rv.Elem().Kind() == reflect.Struct {
+			args = flattenStruct(args, rv.Elem())
+		} else {
+			args = append(args, v)
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			args = append(args, iter.Key().Interface(), iter.Value().Interface())
+		}
+	case reflect.Slice:
+		if rv.Type() == bytesType {
+			args = append(args, v)
+			break
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			args = append(args, rv.Index(i).Interface())
+		}
+	default:
+		args = append(args, v)
+	}
+	return args
+}
+
+func flattenStruct(args Args, v reflect.Value) Args {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name, omitEmpty := f.Name, false
+		if tag := f.Tag.Get("redis"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+		}
+
+		fv := v.Field(i)
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		args = append(args, name, flatValue(fv.Interface()))
+	}
+	return args
+}
+
+// flatValue returns the value to store for a struct field, so that it can
+// be read back by ScanStruct.
+func flatValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Argument:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return b
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+type flatUser struct {
+	Name     string    `redis:"name"`
+	Age      int       `redis:"age"`
+	Admin    bool      `redis:"admin"`
+	Nick     string    `redis:"nick,omitempty"`
+	Created  time.Time `redis:"created"`
+	IP       net.IP    `redis:"ip"`
+	Score    *float64  `redis:"score"`
+	Password string    `redis:"-"`
+	Plain    string
+	hidden   string
+}
+
+func TestArgsAddFlat(t *testing.T) {
+	args := upstashdis.Args{"k"}.Add(1, "a")
+	require.Equal(t, upstashdis.Args{"k", 1, "a"}, args)
+
+	require.Equal(t, upstashdis.Args{"a", "b", "c"}, upstashdis.Args{}.AddFlat([]string{"a", "b", "c"}))
+	require.Equal(t, upstashdis.Args{[]byte("x")}, upstashdis.Args{}.AddFlat([]byte("x")))
+	require.Equal(t, upstashdis.Args{"f", 1}, upstashdis.Args{}.AddFlat(map[string]int{"f": 1}))
+	require.Equal(t, upstashdis.Args{42}, upstashdis.Args{}.AddFlat(42))
+
+	created := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	u := flatUser{Name: "bob", Age: 3, Created: created, IP: net.IPv4(127, 0, 0, 1), Password: "x", Plain: "p", hidden: "h"}
+	require.Equal(t, upstashdis.Args{
+		"name", "bob", "age", 3, "admin", false, "created", "2022-05-01T10:00:00Z",
+		"ip", []byte("127.0.0.1"), "Plain", "p",
+	}, upstashdis.Args{}.AddFlat(&u))
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cmds := upstashdis.Commands{Client: srv.Client()}
+	ctx := context.Background()
+
+	score := 1.5
+	want := flatUser{
+		Name:    "alice",
+		Age:     30,
+		Admin:   true,
+		Nick:    "al",
+		Created: time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC),
+		IP:      net.IPv4(10, 0, 0, 1),
+		Score:   &score,
+		Plain:   "p",
+	}
+	n, err := cmds.HSetStruct(ctx, "user:1", want)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), n)
+
+	var got flatUser
+	require.NoError(t, cmds.HGetAllStruct(ctx, "user:1", &got))
+	require.True(t, want.IP.Equal(got.IP))
+	got.IP = want.IP
+	require.Equal(t, want, got)
+
+	// via the generic helpers
+	var got2 flatUser
+	req := srv.Client().NewRequest()
+	require.NoError(t, req.Send("HGETALL", "user:1"))
+	res, err := req.ExecRaw()
+	require.NoError(t, err)
+	vals, err := upstashdis.Values(res[0], nil)
+	require.NoError(t, err)
+	require.NoError(t, upstashdis.ScanStruct(vals, &got2))
+	require.Equal(t, "alice", got2.Name)
+
+	err = cmds.HGetAllStruct(ctx, "nope", &got)
+	require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+}
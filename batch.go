@@ -0,0 +1,72 @@
+package upstashdis
+
+import "context"
+
+// Batch queues independent commands that are executed concurrently, each in
+// its own REST API call, with a limit on the number of calls in flight. It is
+// created by calling Client.NewBatch and is not safe for concurrent use.
+//
+// Unlike a pipeline, where the commands are executed one after the other in a
+// single call, the latency of a batch is that of its slowest command (times
+// the number of rounds required by the limit), which is usually better for
+// reads of unrelated keys. The commands are not executed in any particular
+// order.
+type Batch struct {
+	c     *Client
+	limit int
+	cmds  []batchCmd
+}
+
+type batchCmd struct {
+	dst  interface{}
+	cmd  string
+	args []interface{}
+}
+
+// NewBatch returns a Batch that executes at most limit commands concurrently.
+// If limit <= 0, the number of concurrent commands is not limited.
+func (c *Client) NewBatch(limit int) *Batch {
+	return &Batch{c: c, limit: limit}
+}
+
+// Queue queues the command for execution by Exec and returns its index in
+// the batch. Once the batch is executed, the result of the command is
+// unmarshaled into dst as for Request.ExecOne, unless dst is nil.
+func (b *Batch) Queue(dst interface{}, cmd string, args ...interface{}) int {
+	b.cmds = append(b.cmds, batchCmd{
+		dst:  dst,
+		cmd:  cmd,
+		args: append([]interface{}(nil), args...),
+	})
+	return len(b.cmds) - 1
+}
+
+// Len returns the number of commands queued for execution.
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+// Exec executes all queued commands concurrently, making their HTTP calls
+// with ctx, and waits for them to complete. The batch is empty after the
+// call and can be reused.
+//
+// If any of the commands failed, it returns a *GroupError that holds the
+// error of each command that failed, keyed by the index returned by Queue.
+// The error of a command can be an *Error returned by Redis, or e.g. a
+// network error. The results of the commands that succeeded are unmarshaled
+// into their destination regardless of the failure of the others.
+func (b *Batch) Exec(ctx context.Context) error {
+	cmds := b.cmds
+	b.cmds = nil
+
+	// the functions are started in the order of the commands, so the indices
+	// of the group's errors are those of the commands.
+	g := b.c.NewExecGroup(ctx, b.limit)
+	for _, cmd := range cmds {
+		cmd := cmd
+		g.Go(func(req *Request) error {
+			return req.ExecOne(cmd.dst, cmd.cmd, cmd.args...)
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,107 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+// peakDoer records the maximum number of concurrent HTTP calls.
+type peakDoer struct {
+	doer      upstashdis.HTTPDoer
+	cur, peak int64
+}
+
+func (d *peakDoer) Do(req *http.Request) (*http.Response, error) {
+	n := atomic.AddInt64(&d.cur, 1)
+	defer atomic.AddInt64(&d.cur, -1)
+	for {
+		m := atomic.LoadInt64(&d.peak)
+		if n <= m || atomic.CompareAndSwapInt64(&d.peak, m, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return d.doer.Do(req)
+}
+
+func TestBatch(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	doer := &peakDoer{doer: cli.HTTPClient}
+	cli.HTTPClient = doer
+
+	for i := 0; i < 50; i++ {
+		srv.Redis.Set(fmt.Sprintf("key:%d", i), fmt.Sprint(i))
+	}
+
+	t.Run("success", func(t *testing.T) {
+		atomic.StoreInt64(&doer.peak, 0)
+		b := cli.NewBatch(4)
+		vals := make([]string, 50)
+		for i := range vals {
+			require.Equal(t, i, b.Queue(&vals[i], "GET", fmt.Sprintf("key:%d", i)))
+		}
+		require.Equal(t, 50, b.Len())
+		require.NoError(t, b.Exec(context.Background()))
+		require.Equal(t, 0, b.Len())
+		for i, v := range vals {
+			require.Equal(t, fmt.Sprint(i), v)
+		}
+		require.LessOrEqual(t, atomic.LoadInt64(&doer.peak), int64(4))
+		require.Greater(t, atomic.LoadInt64(&doer.peak), int64(1))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var (
+			s1, s2 string
+			n      int
+		)
+		b := cli.NewBatch(0)
+		b.Queue(&s1, "GET", "key:1")
+		b.Queue(nil, "NOTACMD")
+		b.Queue(&n, "INCR", "key:missing")
+		b.Queue(&s2, "GET", "key:2")
+		b.Queue(nil, "")
+
+		err := b.Exec(context.Background())
+		var gerr *upstashdis.GroupError
+		require.True(t, errors.As(err, &gerr))
+		require.Equal(t, 5, gerr.Total)
+		require.Len(t, gerr.Errors, 2)
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(gerr.Errors[1], &rerr))
+		require.Error(t, gerr.Errors[4])
+
+		require.Equal(t, "1", s1)
+		require.Equal(t, "2", s2)
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("args", func(t *testing.T) {
+		var s string
+		b := cli.NewBatch(1)
+		args := []interface{}{"key:3"}
+		b.Queue(&s, "GET", args...)
+		args[0] = "key:4"
+		require.NoError(t, b.Exec(context.Background()))
+		require.Equal(t, "3", s)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		b := cli.NewBatch(2)
+		b.Queue(nil, "PING")
+		err := b.Exec(ctx)
+		require.ErrorIs(t, err.(*upstashdis.GroupError).Errors[0], context.Canceled)
+	})
+}
@@ -0,0 +1,101 @@
+package upstashdis_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mna/upstashdis"
+)
+
+// benchDoer is an HTTPDoer that discards the request and returns a canned
+// response, so that the benchmarks measure the client's own work.
+type benchDoer struct {
+	body []byte
+}
+
+func (d *benchDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(d.body)),
+	}, nil
+}
+
+func benchClient(n int) *upstashdis.Client {
+	results := make([]string, n)
+	for i := range results {
+		results[i] = `{"result":"OK"}`
+	}
+	body := "[" + strings.Join(results, ",") + "]"
+	if n == 1 {
+		body = results[0]
+	}
+	return &upstashdis.Client{
+		BaseURL:    "http://upstash.test",
+		APIToken:   "token",
+		HTTPClient: &benchDoer{body: []byte(body)},
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	const n = 100
+
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+
+	b.Run("Exec", func(b *testing.B) {
+		cli := benchClient(n)
+		req := cli.NewRequest()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j, key := range keys {
+				if err := req.Send("SET", key, "value", "EX", 3600+j); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := req.Exec(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ExecRaw", func(b *testing.B) {
+		cli := benchClient(n)
+		req := cli.NewRequest()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j, key := range keys {
+				if err := req.Send("SET", key, "value", "EX", 3600+j); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if _, err := req.ExecRaw(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkExecOne(b *testing.B) {
+	cli := benchClient(1)
+	req := cli.NewRequest()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var s string
+		if err := req.ExecOne(&s, "SET", "key", "value", "EX", 3600); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
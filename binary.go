@@ -0,0 +1,271 @@
+package upstashdis
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// encodingHeader is the request header that requests the base64 encoding
+// of the string values of the results.
+const encodingHeader = "Upstash-Encoding"
+
+// checkBase64 returns an error wrapping ErrNotSupported if the endpoint does
+// not return base64-encoded results when requested, as required by the
+// binary-safe mode: the plain string results would otherwise be decoded as
+// base64. If DetectCapabilities is set, this is its Base64 capability,
+// otherwise only that feature is probed, on the first call.
+func (c *Client) checkBase64(ctx context.Context) error {
+	if c.DetectCapabilities {
+		return c.checkCapability(ctx, "base64 encoding", hasBase64)
+	}
+
+	c.capsMu.Lock()
+	defer c.capsMu.Unlock()
+
+	if c.caps != nil {
+		c.base64 = &c.caps.Base64
+	}
+	if c.base64 == nil {
+		ok, err := c.probeBase64(ctx)
+		if err != nil {
+			return fmt.Errorf("upstashdis: detect base64 encoding: %w", err)
+		}
+		c.base64 = &ok
+	}
+	if !*c.base64 {
+		return fmt.Errorf("upstashdis: base64 encoding: %w", ErrNotSupported)
+	}
+	return nil
+}
+
+// checkBinaryArgs returns an error if an argument of the command is not
+// valid UTF-8, as it cannot be sent intact in the JSON request.
+func checkBinaryArgs(cmd []interface{}) error {
+	for i, arg := range cmd {
+		if s, ok := arg.(string); ok && !utf8.ValidString(s) {
+			return fmt.Errorf("upstashdis: argument %d of %v is not valid UTF-8 and cannot be sent intact", i, cmd[0])
+		}
+	}
+	return nil
+}
+
+// unmarshal unmarshals the result into dst as described for Request.Exec,
+// decoding it first if it is base64-encoded.
+func (r *Result) unmarshal(dst interface{}) error {
+	if !r.base64 {
+		return unmarshalResult(r.Result, dst)
+	}
+	return unmarshalBase64(r.Result, dst)
+}
+
+// raw returns the raw JSON result, decoded if it is base64-encoded. Note
+// that the decoded string values that are not valid UTF-8 cannot be
+// represented intact in JSON.
+func (r *Result) raw() (json.RawMessage, error) {
+	if !r.base64 {
+		return r.Result, nil
+	}
+	v, err := decodeBase64(r.Result)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(plainValue(v, false))
+}
+
+// Value returns the result as a generic value, in the same form as the
+// replies of redigo: strings are returned as []byte, integers as int64,
+// arrays as []interface{} and null as nil. Numbers that are not integers
+// are returned as []byte, like the floats encoded as strings by Redis. If
+// the result is base64-encoded (see Client.BinarySafe), the strings are
+// decoded so that binary values are preserved. If the result is an error,
+// it is returned as an *Error.
+func (r *Result) Value() (interface{}, error) {
+	if r.Error != "" {
+		return nil, newError(r.Error, 0)
+	}
+	if len(bytes.TrimSpace(r.Result)) == 0 {
+		return nil, nil
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	if r.base64 {
+		v, err = decodeBase64(r.Result)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(r.Result))
+		dec.UseNumber()
+		err = dec.Decode(&v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return genericValue(v), nil
+}
+
+// genericValue converts the decoded tree v as described for Result.Value.
+func genericValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		return []byte(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = genericValue(vv)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// unmarshalBase64 unmarshals the base64-encoded raw result into dst. The
+// string values are decoded and set as-is in string and []byte destinations
+// (and slices of those), so that binary values are preserved. Other
+// destinations are unmarshaled as described for unmarshalResult.
+func unmarshalBase64(raw json.RawMessage, dst interface{}) error {
+	v, err := decodeBase64(raw)
+	if err != nil {
+		return err
+	}
+
+	switch dst := dst.(type) {
+	case *time.Time, json.Unmarshaler:
+		// not binary-safe, unmarshal as JSON
+	case *[]byte:
+		if b, ok := v.([]byte); ok || v == nil {
+			*dst = b
+			return nil
+		}
+	case *string:
+		if b, ok := v.([]byte); ok {
+			*dst = string(b)
+			return nil
+		}
+	case *[][]byte:
+		if bs, ok := byteSlices(v); ok {
+			*dst = bs
+			return nil
+		}
+	case *[]string:
+		if bs, ok := byteSlices(v); ok {
+			strs := make([]string, len(bs))
+			for i, b := range bs {
+				strs[i] = string(b)
+			}
+			*dst = strs
+			return nil
+		}
+	case *interface{}:
+		*dst = plainValue(v, true)
+		return nil
+	case encoding.TextUnmarshaler:
+		if b, ok := v.([]byte); ok {
+			return dst.UnmarshalText(b)
+		}
+	case encoding.BinaryUnmarshaler:
+		if b, ok := v.([]byte); ok {
+			return dst.UnmarshalBinary(b)
+		}
+	}
+
+	b, err := json.Marshal(plainValue(v, false))
+	if err != nil {
+		return err
+	}
+	return unmarshalResult(b, dst)
+}
+
+// decodeBase64 decodes the base64-encoded raw result into a tree of values,
+// where the strings are decoded as []byte, the numbers as json.Number and
+// the arrays as []interface{}.
+func decodeBase64(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return decodeBase64Value(v)
+}
+
+func decodeBase64Value(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("upstashdis: invalid base64-encoded result: %w", err)
+		}
+		return b, nil
+	case []interface{}:
+		for i, vv := range v {
+			dv, err := decodeBase64Value(vv)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = dv
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// byteSlices returns the decoded array v as a slice of []byte values, nil
+// for the null elements. It returns false if v is not an array of strings
+// or nulls.
+func byteSlices(v interface{}) ([][]byte, bool) {
+	vals, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	bs := make([][]byte, len(vals))
+	for i, vv := range vals {
+		b, ok := vv.([]byte)
+		if !ok && vv != nil {
+			return nil, false
+		}
+		bs[i] = b
+	}
+	return bs, true
+}
+
+// plainValue returns the decoded tree v with its []byte values converted
+// to strings, and its numbers converted to float64 if floats is true (as
+// they would be unmarshaled in an interface{}).
+func plainValue(v interface{}, floats bool) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case json.Number:
+		if floats {
+			f, _ := v.Float64()
+			return f
+		}
+		return v
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, vv := range v {
+			res[i] = plainValue(vv, floats)
+		}
+		return res
+	default:
+		return v
+	}
+}
@@ -0,0 +1,131 @@
+package upstashdis_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinarySafe(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	cli.BinarySafe = true
+
+	bin := "\xff\x00\xfe\x80"
+	srv.Redis.Set("bin", bin)
+	srv.Redis.RPush("list", "a", bin)
+	srv.Redis.Set("ip", "10.0.0.1")
+
+	t.Run("scalar", func(t *testing.T) {
+		var b []byte
+		require.NoError(t, cli.NewRequest().ExecOne(&b, "GET", "bin"))
+		require.Equal(t, []byte(bin), b)
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "bin"))
+		require.Equal(t, bin, s)
+
+		var p *string
+		require.NoError(t, cli.NewRequest().ExecOne(&p, "GET", "nope"))
+		require.Nil(t, p)
+
+		var ip net.IP
+		require.NoError(t, cli.NewRequest().ExecOne(&ip, "GET", "ip"))
+		require.Equal(t, "10.0.0.1", ip.String())
+	})
+
+	t.Run("non-string", func(t *testing.T) {
+		var n int
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "STRLEN", "bin"))
+		require.Equal(t, 4, n)
+
+		var ok string
+		require.NoError(t, cli.NewRequest().ExecOne(&ok, "SET", "a", "12"))
+		require.Equal(t, "OK", ok)
+
+		var v interface{}
+		require.NoError(t, cli.NewRequest().ExecOne(&v, "LRANGE", "list", 0, -1))
+		require.Equal(t, []interface{}{"a", bin}, v)
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		var bs [][]byte
+		var strs []string
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("MGET", "bin", "nope"))
+		require.NoError(t, req.Exec(&bs, &strs))
+		require.Equal(t, [][]byte{[]byte("a"), []byte(bin)}, bs)
+		require.Equal(t, []string{bin, ""}, strs)
+	})
+
+	t.Run("reply helpers", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "bin"))
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("STRLEN", "bin"))
+		res, err := req.ExecRaw()
+		require.NoError(t, err)
+
+		s, err := upstashdis.String(res[0], nil)
+		require.NoError(t, err)
+		require.Equal(t, bin, s)
+		b, err := upstashdis.Bytes(res[0], nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte(bin), b)
+
+		bs, err := upstashdis.ByteSlices(res[1], nil)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("a"), []byte(bin)}, bs)
+		strs, err := upstashdis.Strings(res[1], nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", bin}, strs)
+
+		n, err := upstashdis.Int(res[2], nil)
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+	})
+
+	t.Run("value", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "bin"))
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("STRLEN", "bin"))
+		require.NoError(t, req.Send("GET", "nope"))
+		require.NoError(t, req.Send("HGET", "bin", "x"))
+		res, err := req.ExecRaw()
+		require.NoError(t, err)
+
+		want := []interface{}{[]byte(bin), []interface{}{[]byte("a"), []byte(bin)}, int64(4), nil}
+		for i, w := range want {
+			v, err := res[i].Value()
+			require.NoError(t, err)
+			require.Equal(t, w, v)
+		}
+		_, err = res[4].Value()
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+	})
+
+	t.Run("binary argument", func(t *testing.T) {
+		err := cli.NewRequest().Send("SET", "k", []byte(bin))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not valid UTF-8")
+
+		// valid UTF-8 is fine
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "k", "é"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "k"))
+		require.Equal(t, "é", s)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := cli.NewRequest().ExecOne(nil, "HGETALL", "bin")
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, "WRONGTYPE", rerr.Kind)
+	})
+}
@@ -0,0 +1,500 @@
+package upstashdis
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+const defaultCacheTTL = time.Minute
+
+// cacheCommands is the set of read-only commands whose results are cached
+// by default. It excludes the commands that return random results (e.g.
+// SRANDMEMBER), time-dependent results (e.g. TTL), and the commands whose
+// keys are unknown or that apply to the whole database (e.g. SCAN).
+var cacheCommands = map[string]bool{
+	"bitcount":         true,
+	"bitpos":           true,
+	"exists":           true,
+	"geodist":          true,
+	"geohash":          true,
+	"geopos":           true,
+	"get":              true,
+	"getbit":           true,
+	"getrange":         true,
+	"hexists":          true,
+	"hget":             true,
+	"hgetall":          true,
+	"hkeys":            true,
+	"hlen":             true,
+	"hmget":            true,
+	"hstrlen":          true,
+	"hvals":            true,
+	"json.get":         true,
+	"json.mget":        true,
+	"json.type":        true,
+	"lindex":           true,
+	"llen":             true,
+	"lpos":             true,
+	"lrange":           true,
+	"mget":             true,
+	"scard":            true,
+	"sdiff":            true,
+	"sinter":           true,
+	"sismember":        true,
+	"smembers":         true,
+	"smismember":       true,
+	"strlen":           true,
+	"sunion":           true,
+	"type":             true,
+	"xlen":             true,
+	"xrange":           true,
+	"xrevrange":        true,
+	"zcard":            true,
+	"zcount":           true,
+	"zlexcount":        true,
+	"zmscore":          true,
+	"zrange":           true,
+	"zrangebylex":      true,
+	"zrangebyscore":    true,
+	"zrank":            true,
+	"zrevrange":        true,
+	"zrevrangebylex":   true,
+	"zrevrangebyscore": true,
+	"zrevrank":         true,
+	"zscore":           true,
+}
+
+// Cache is a client-side cache of the results of read-only commands, see
+// Client.Cache. The results are cached for the TTL, and the cached results
+// that involve a key are invalidated when a command that modifies that key
+// is executed with a client that uses the same Cache. To also invalidate the
+// keys modified by other clients, see Watch. It is safe for concurrent use.
+//
+// The results of the read-only commands are served from the cache when a
+// request (single command or pipeline) only contains such commands, the
+// others are executed as usual. The commands of a transaction are never
+// served from the cache.
+//
+// As the cache is shared by the clients that use it, it should not be
+// shared between clients with different access rights (e.g. tokens
+// generated with ACL RESTTOKEN for different users).
+type Cache struct {
+	// TTL is the duration for which a result is cached. If it is <= 0, it
+	// defaults to a minute.
+	TTL time.Duration
+
+	// StaleTTL, if set, enables the stale-while-revalidate mode: once its TTL
+	// is over, a result is still served from the cache for StaleTTL, while
+	// its command is executed again in the background to refresh it. Only one
+	// refresh of a result runs at a time, and if it fails, the next request
+	// served the stale result starts another one. Past the StaleTTL, the
+	// command is executed as for a result that is not cached.
+	StaleTTL time.Duration
+
+	// MaxEntries is the maximum number of results cached, the least recently
+	// used ones are evicted to make room for new ones. If it is <= 0, the
+	// number of results is not limited.
+	MaxEntries int
+
+	// Commands is the list of commands (case-insensitive) whose results are
+	// cached, instead of the default ones (read-only commands that return
+	// deterministic results for their keys, such as GET, HGETALL, MGET and
+	// ZRANGE). Only the read-only commands with known keys can be cached,
+	// the others are ignored.
+	Commands []string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	byKey   map[string]map[string]bool // the entries of each Redis key
+	lru     *list.List                 // of *cacheEntry, most recently used first
+	stats   CacheStats
+	cmds    map[string]bool
+
+	// the results of the calls in flight are not cached if one of their keys
+	// was invalidated since the call started, so the version of the last
+	// invalidation of each key is recorded while there are calls in flight.
+	version  uint64            // incremented on each invalidation
+	purged   uint64            // the version of the last purge
+	keyVers  map[string]uint64 // the version of the last invalidation of the keys
+	inflight int               // the number of calls in flight
+}
+
+// CacheStats holds the statistics of a Cache.
+type CacheStats struct {
+	// Hits is the number of results served from the cache.
+	Hits int64
+	// Misses is the number of results of cacheable commands that were not in
+	// the cache.
+	Misses int64
+	// StaleHits is the number of Hits that served a result past its TTL,
+	// see StaleTTL.
+	StaleHits int64
+	// Entries is the number of results currently cached.
+	Entries int
+}
+
+type cacheEntry struct {
+	key        string
+	keys       []string // the Redis keys of the command
+	res        Result
+	expires    time.Time
+	refreshing bool // a refresh of the stale result is running
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.Entries = len(c.entries)
+	return st
+}
+
+// Purge removes all results from the cache.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+}
+
+// Invalidate removes the cached results that involve any of the keys. The
+// keys must include the KeyPrefix of the client, if any.
+func (c *Cache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate(keys)
+}
+
+// Watch subscribes to the keyspace notifications of the database via the
+// subscribe endpoint of the REST API, using the client, and invalidates the
+// cached results of the keys that are modified, including by other
+// clients. The keyspace notifications must be enabled on the Redis server
+// (see the notify-keyspace-events configuration). All results are removed
+// from the cache each time the subscription is (re)established, as the
+// notifications sent while it was not may have been missed. It runs until
+// ctx is done or the subscription fails, as for Subscriber.Run, and returns
+// the error that ended it.
+func (c *Cache) Watch(ctx context.Context, client *Client) error {
+	s := &Subscriber{
+		Client:      client,
+		Channel:     "__keyspace@*__:*",
+		Pattern:     true,
+		OnSubscribe: c.Purge,
+	}
+	return s.Run(ctx, func(m Message) {
+		if _, key, ok := strings.Cut(m.Channel, "__:"); ok {
+			c.Invalidate(key)
+		}
+	})
+}
+
+// exec executes the commands with the exec function, serving the results of
+// the cacheable commands from the cache if the commands are all cacheable,
+// and invalidating the results of the keys modified by the commands. The
+// stale results served from the cache are refreshed in the background with
+// the refresh function.
+func (c *Cache) exec(cmds [][]interface{}, tx, binary bool, exec func([][]interface{}) ([]*Result, error), refresh func([]interface{}) (*Result, error)) ([]*Result, error) {
+	keys := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		if tx || !c.cacheable(cmd) {
+			keys = nil
+			break
+		}
+		keys[i] = cacheKey(cmd, binary)
+	}
+
+	if keys == nil {
+		c.invalidateCmds(cmds)
+		res, err := exec(cmds)
+		// invalidate again in case a concurrent read cached a result before the
+		// commands were executed
+		c.invalidateCmds(cmds)
+		return res, err
+	}
+
+	c.mu.Lock()
+	results := make([]*Result, len(cmds))
+	var (
+		missIxs []int
+		misses  [][]interface{}
+	)
+	for i, key := range keys {
+		if res, ok, stale := c.get(key); ok {
+			results[i] = res
+			if stale {
+				c.stats.StaleHits++
+				if e := c.entries[key].Value.(*cacheEntry); !e.refreshing {
+					e.refreshing = true
+					// the command may be reused by the request once executed
+					cmd := append([]interface{}(nil), cmds[i]...)
+					go c.refresh(key, cmd, c.start(), refresh)
+				}
+			}
+			continue
+		}
+		missIxs = append(missIxs, i)
+		misses = append(misses, cmds[i])
+	}
+	c.stats.Hits += int64(len(cmds) - len(misses))
+	c.stats.Misses += int64(len(misses))
+	if len(misses) == 0 {
+		c.mu.Unlock()
+		return results, nil
+	}
+	version := c.start()
+	c.mu.Unlock()
+
+	res, err := exec(misses)
+	if err == nil && len(res) != len(misses) {
+		err = fmt.Errorf("upstashdis: got %d results for %d commands", len(res), len(misses))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.end()
+	if err != nil {
+		return nil, err
+	}
+	for i, ix := range missIxs {
+		results[ix] = res[i]
+		// do not cache the result if a key was invalidated since the start of
+		// the call, as it may be stale
+		cmdKeys := cmdKeys(cmds[ix])
+		if res[i] != nil && res[i].Error == "" && c.valid(cmdKeys, version) {
+			c.set(keys[ix], cmdKeys, res[i])
+		}
+	}
+	return results, nil
+}
+
+// refresh executes the command of the stale entry key with the refresh
+// function and caches its result, unless one of its keys was invalidated
+// since the version.
+func (c *Cache) refresh(key string, cmd []interface{}, version uint64, refresh func([]interface{}) (*Result, error)) {
+	res, err := refresh(cmd)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.end()
+	keys := cmdKeys(cmd)
+	if err == nil && res != nil && res.Error == "" && c.valid(keys, version) {
+		c.set(key, keys, res)
+		return
+	}
+	// let the next request that is served the stale result try again
+	if el := c.entries[key]; el != nil {
+		el.Value.(*cacheEntry).refreshing = false
+	}
+}
+
+// cacheable returns true if the result of the command can be cached.
+func (c *Cache) cacheable(cmd []interface{}) bool {
+	name, ok := cmd[0].(string)
+	if !ok {
+		return false
+	}
+	name = strings.ToLower(name)
+
+	c.mu.Lock()
+	if c.cmds == nil {
+		c.cmds = cacheCommands
+		if c.Commands != nil {
+			c.cmds = make(map[string]bool, len(c.Commands))
+			for _, cmd := range c.Commands {
+				c.cmds[strings.ToLower(cmd)] = true
+			}
+		}
+	}
+	cached := c.cmds[name]
+	c.mu.Unlock()
+
+	if !cached || !rediscmd.IsReadOnly(name) {
+		return false
+	}
+	ixs, ok := rediscmd.KeyIndexes(cmd)
+	return ok && len(ixs) > 0
+}
+
+// invalidateCmds removes the cached results of the keys of the commands that
+// are not read-only. If the keys of such a command are unknown or it has no
+// key (e.g. FLUSHDB), all results are removed.
+func (c *Cache) invalidateCmds(cmds [][]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cmd := range cmds {
+		name, _ := cmd[0].(string)
+		if rediscmd.IsReadOnly(name) {
+			continue
+		}
+		ixs, ok := rediscmd.KeyIndexes(cmd)
+		if !ok || len(ixs) == 0 {
+			c.purge()
+			return
+		}
+		c.invalidate(cmdKeys(cmd))
+	}
+}
+
+// get returns a copy of the cached result of the entry key, if it is cached
+// and not expired, and true if the result is stale (past its TTL, but within
+// the StaleTTL). The lock must be held by the caller.
+func (c *Cache) get(key string) (*Result, bool, bool) {
+	el := c.entries[key]
+	if el == nil {
+		return nil, false, false
+	}
+	e := el.Value.(*cacheEntry)
+	now := time.Now()
+	stale := now.After(e.expires)
+	if stale && (c.StaleTTL <= 0 || now.After(e.expires.Add(c.StaleTTL))) {
+		c.remove(el)
+		return nil, false, false
+	}
+	c.lru.MoveToFront(el)
+	res := e.res
+	return &res, true, stale
+}
+
+// set caches the result of the entry key, which involves the Redis keys. The
+// lock must be held by the caller.
+func (c *Cache) set(key string, keys []string, res *Result) {
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.byKey = make(map[string]map[string]bool)
+		c.lru = list.New()
+	}
+	if el := c.entries[key]; el != nil {
+		c.remove(el)
+	}
+
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	e := &cacheEntry{key: key, keys: keys, res: *res, expires: time.Now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(e)
+	for _, k := range keys {
+		set := c.byKey[k]
+		if set == nil {
+			set = make(map[string]bool)
+			c.byKey[k] = set
+		}
+		set[key] = true
+	}
+
+	if c.MaxEntries > 0 {
+		for len(c.entries) > c.MaxEntries {
+			c.remove(c.lru.Back())
+		}
+	}
+}
+
+// remove removes the entry from the cache. The lock must be held by the
+// caller.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	for _, k := range e.keys {
+		if set := c.byKey[k]; set != nil {
+			delete(set, e.key)
+			if len(set) == 0 {
+				delete(c.byKey, k)
+			}
+		}
+	}
+}
+
+// invalidate removes the results of the Redis keys. The lock must be held
+// by the caller.
+func (c *Cache) invalidate(keys []string) {
+	c.version++
+	for _, k := range keys {
+		if c.inflight > 0 {
+			if c.keyVers == nil {
+				c.keyVers = make(map[string]uint64)
+			}
+			c.keyVers[k] = c.version
+		}
+		for key := range c.byKey[k] {
+			c.remove(c.entries[key])
+		}
+	}
+}
+
+// purge removes all results. The lock must be held by the caller.
+func (c *Cache) purge() {
+	c.version++
+	c.purged = c.version
+	c.entries = nil
+	c.byKey = nil
+	c.lru = nil
+}
+
+// start records the start of a call whose results may be cached and
+// returns the current version, to check with valid once it is done. The
+// call must be ended with end. The lock must be held by the caller.
+func (c *Cache) start() uint64 {
+	c.inflight++
+	return c.version
+}
+
+// end records the end of a call started with start. The lock must be held
+// by the caller.
+func (c *Cache) end() {
+	if c.inflight--; c.inflight == 0 {
+		c.keyVers = nil
+	}
+}
+
+// valid returns true if none of the keys was invalidated since the version,
+// returned by start. The lock must be held by the caller.
+func (c *Cache) valid(keys []string, version uint64) bool {
+	if c.purged > version {
+		return false
+	}
+	for _, k := range keys {
+		if c.keyVers[k] > version {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey returns the key of the cache entry of the command, the name of
+// the command being case-insensitive. The results requested base64-encoded
+// (binary-safe mode) are cached separately.
+func cacheKey(cmd []interface{}, binary bool) string {
+	var sb strings.Builder
+	if binary {
+		sb.WriteString("b")
+	}
+	sb.WriteString(strings.ToLower(cmd[0].(string)))
+	for _, arg := range cmd[1:] {
+		// the arguments are strings, numbers or booleans, see writeArg
+		b, err := json.Marshal(arg)
+		if err != nil {
+			b = []byte(fmt.Sprint(arg))
+		}
+		sb.WriteByte(' ')
+		sb.Write(b)
+	}
+	return sb.String()
+}
+
+// cmdKeys returns the keys of the command, which must be known.
+func cmdKeys(cmd []interface{}) []string {
+	ixs, _ := rediscmd.KeyIndexes(cmd)
+	keys := make([]string, len(ixs))
+	for i, ix := range ixs {
+		keys[i] = fmt.Sprint(cmd[ix])
+	}
+	return keys
+}
@@ -0,0 +1,316 @@
+package upstashdis_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	ctx := context.Background()
+
+	newClient := func(cache *upstashdis.Cache) (*upstashdis.Client, *recordHooks) {
+		hooks := &recordHooks{}
+		cli := srv.Client()
+		cli.Cache = cache
+		cli.Hooks = hooks
+		return cli, hooks
+	}
+
+	t.Run("read", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{}
+		cli, hooks := newClient(cache)
+		srv.Redis.Set("a", "1")
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "get", "a"))
+		require.Equal(t, "1", s)
+		require.Len(t, hooks.ended, 1)
+		require.Equal(t, upstashdis.CacheStats{Hits: 1, Misses: 1, Entries: 1}, cache.Stats())
+
+		// modified by another client, the cached value is returned
+		srv.Redis.Set("a", "2")
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+
+		// errors are not cached
+		srv.Redis.HSet("h", "f", "v")
+		require.Error(t, cli.NewRequest().ExecOne(&s, "GET", "h"))
+		require.Error(t, cli.NewRequest().ExecOne(&s, "GET", "h"))
+		require.Len(t, hooks.ended, 3)
+
+		// non-cacheable commands are always executed
+		var n int
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "TTL", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "TTL", "a"))
+		require.Len(t, hooks.ended, 5)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{}
+		cli, hooks := newClient(cache)
+		srv.Redis.Set("a", "1")
+		srv.Redis.Set("b", "2")
+
+		var vals []string
+		require.NoError(t, cli.NewRequest().ExecOne(&vals, "MGET", "a", "b"))
+		require.Equal(t, []string{"1", "2"}, vals)
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+
+		// a write through the client invalidates the results of its keys
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "b", "3"))
+		require.NoError(t, cli.NewRequest().ExecOne(&vals, "MGET", "a", "b"))
+		require.Equal(t, []string{"1", "3"}, vals)
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+		require.Len(t, hooks.ended, 4)
+
+		// so does a write by a client that shares the cache
+		other, _ := newClient(cache)
+		require.NoError(t, other.NewRequest().ExecOne(nil, "DEL", "a"))
+		ps := &s
+		require.NoError(t, cli.NewRequest().ExecOne(&ps, "GET", "a"))
+		require.Nil(t, ps)
+
+		// a write with no key purges the cache
+		require.NoError(t, cli.NewRequest().ExecOne(&vals, "MGET", "a", "b"))
+		require.Equal(t, 2, cache.Stats().Entries)
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "FLUSHDB"))
+		require.Equal(t, 0, cache.Stats().Entries)
+
+		// manual invalidation
+		srv.Redis.Set("a", "4")
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		srv.Redis.Set("a", "5")
+		cache.Invalidate("a")
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "5", s)
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{}
+		cli, hooks := newClient(cache)
+		srv.Redis.Set("a", "1")
+		srv.Redis.Set("b", "2")
+		srv.Redis.HSet("h", "f", "v")
+
+		var a string
+		require.NoError(t, cli.NewRequest().ExecOne(&a, "GET", "a"))
+
+		// only the commands not cached are executed, still as a pipeline
+		var b, h string
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("GET", "h"))
+		require.NoError(t, req.Send("GET", "b"))
+		err := req.Exec(&a, &h, &b)
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, 1, rerr.PipelineIndex)
+		require.Equal(t, "1", a)
+		require.Equal(t, "2", b)
+		require.Equal(t, "pipeline", hooks.last().Endpoint)
+		require.Equal(t, []string{"GET", "GET"}, hooks.last().Commands)
+
+		// a pipeline with a write is executed as-is and invalidates its keys
+		res, err := cli.Pipelined(ctx, func(p *upstashdis.Request) error {
+			_ = p.Send("GET", "a")
+			_ = p.Send("SET", "b", "3")
+			return p.Send("GET", "b")
+		})
+		require.NoError(t, err)
+		require.Len(t, res, 3)
+		require.Equal(t, []string{"GET", "SET", "GET"}, hooks.last().Commands)
+		require.NoError(t, cli.NewRequest().ExecOne(&b, "GET", "b"))
+		require.Equal(t, "3", b)
+
+		// all cached, no call
+		n := len(hooks.ended)
+		require.NoError(t, cli.NewRequest().ExecOne(&a, "GET", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&b, "GET", "b"))
+		require.Len(t, hooks.ended, n)
+
+		// transactions are never served from the cache
+		_, err = cli.TxPipelined(ctx, func(p *upstashdis.Request) error {
+			return p.Send("GET", "a")
+		})
+		require.NoError(t, err)
+		require.Equal(t, "multi-exec", hooks.last().Endpoint)
+	})
+
+	t.Run("ttl and max entries", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{TTL: 10 * time.Millisecond, MaxEntries: 2}
+		cli, hooks := newClient(cache)
+		srv.Redis.Set("a", "1")
+		srv.Redis.Set("b", "2")
+		srv.Redis.Set("c", "3")
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "b"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "c"))
+		require.Equal(t, 2, cache.Stats().Entries)
+		require.Len(t, hooks.ended, 3)
+
+		// b was the least recently used
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "b"))
+		require.Len(t, hooks.ended, 4)
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "b"))
+		require.Len(t, hooks.ended, 5)
+	})
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{TTL: 10 * time.Millisecond, StaleTTL: time.Hour}
+		cli := srv.Client()
+		cli.Cache = cache
+
+		var (
+			calls int64
+			block int32
+			gate  = make(chan struct{})
+		)
+		doer := cli.HTTPClient
+		cli.HTTPClient = doerFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt64(&calls, 1)
+			if atomic.LoadInt32(&block) == 1 {
+				<-gate
+			}
+			return doer.Do(r)
+		})
+
+		srv.Redis.Set("a", "1")
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+		srv.Redis.Set("a", "2")
+		time.Sleep(20 * time.Millisecond)
+
+		// the stale result is served while it is refreshed, only once
+		atomic.StoreInt32(&block, 1)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+			require.Equal(t, "1", s)
+		}
+		require.Eventually(t, func() bool { return atomic.LoadInt64(&calls) == 2 }, time.Second, time.Millisecond)
+		require.Equal(t, upstashdis.CacheStats{Hits: 3, Misses: 1, StaleHits: 3, Entries: 1}, cache.Stats())
+
+		// the invalidation of another key does not discard the refresh
+		cache.Invalidate("b")
+		atomic.StoreInt32(&block, 0)
+		close(gate)
+		require.Eventually(t, func() bool {
+			var s string
+			return cli.NewRequest().ExecOne(&s, "GET", "a") == nil && s == "2"
+		}, time.Second, 5*time.Millisecond)
+		require.Equal(t, int64(2), atomic.LoadInt64(&calls))
+
+		// past the stale window, the command is executed
+		cli2, hooks := newClient(&upstashdis.Cache{TTL: 10 * time.Millisecond, StaleTTL: 10 * time.Millisecond})
+		require.NoError(t, cli2.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "2", s)
+		srv.Redis.Set("a", "3")
+		time.Sleep(30 * time.Millisecond)
+		require.NoError(t, cli2.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "3", s)
+		require.Len(t, hooks.ended, 2)
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{Commands: []string{"ttl", "hget", "set"}}
+		cli, hooks := newClient(cache)
+		srv.Redis.Set("a", "1")
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		var n int
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "TTL", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "TTL", "a"))
+		require.Len(t, hooks.ended, 3)
+	})
+
+	t.Run("key prefix", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{}
+		cli, hooks := newClient(cache)
+		ns := cli.Namespace("app:")
+		srv.Redis.Set("a", "1")
+		srv.Redis.Set("app:a", "2")
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+		require.NoError(t, ns.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "2", s)
+		require.Len(t, hooks.ended, 2)
+
+		require.NoError(t, ns.NewRequest().ExecOne(nil, "SET", "a", "3"))
+		cache.Invalidate("b")
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "1", s)
+		require.NoError(t, ns.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "3", s)
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		srv.FlushAll()
+		cache := &upstashdis.Cache{}
+		cli, _ := newClient(cache)
+		srv.Redis.Set("a", "1")
+		srv.Redis.Set("b", "2")
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- cache.Watch(ctx, srv.Client()) }()
+
+		// wait for the subscription, then for a notification to be processed
+		// so that the cache is not purged by the subscription afterwards
+		require.Eventually(t, func() bool {
+			return srv.Redis.PubSubNumPat() > 0
+		}, time.Second, 5*time.Millisecond)
+		var s string
+		ps := &s
+		require.NoError(t, cli.NewRequest().ExecOne(&ps, "GET", "sync"))
+		require.Nil(t, ps)
+		srv.Redis.Publish("__keyspace@0__:sync", "set")
+		require.Eventually(t, func() bool {
+			return cache.Stats().Entries == 0
+		}, time.Second, 5*time.Millisecond)
+
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "b"))
+		require.Equal(t, 2, cache.Stats().Entries)
+
+		// miniredis does not send keyspace notifications, publish it
+		srv.Redis.Set("a", "3")
+		srv.Redis.Publish("__keyspace@0__:a", "set")
+		require.Eventually(t, func() bool {
+			return cache.Stats().Entries == 1
+		}, time.Second, 5*time.Millisecond)
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "GET", "a"))
+		require.Equal(t, "3", s)
+
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+}
@@ -0,0 +1,189 @@
+package upstashdis
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNotSupported is the error returned when a feature is not supported by
+// the REST API endpoint. It is wrapped in an error that identifies the
+// feature, use errors.Is to test for it.
+var ErrNotSupported = errors.New("not supported by the REST API endpoint")
+
+// Capabilities describes the optional features supported by a REST API
+// endpoint. The real Upstash Redis REST API supports all of them, while
+// other implementations such as older builds of the restserver package may
+// not.
+type Capabilities struct {
+	// Transactions is true if the /multi-exec endpoint is supported, as
+	// required by TxPipelined.
+	Transactions bool
+	// Subscribe is true if the /subscribe endpoint is supported, streaming
+	// published messages as server-sent events.
+	Subscribe bool
+	// Base64 is true if results can be returned base64-encoded by setting
+	// the Upstash-Encoding request header to "base64".
+	Base64 bool
+	// RESP is true if results can be returned in the RESP2 format by setting
+	// the Upstash-Response-Format request header to "resp2". This package
+	// never requests that format, so it is only informative.
+	RESP bool
+}
+
+// Capabilities returns the optional features supported by the REST API
+// endpoint of the client. The endpoint is probed on the first call (which
+// requires a few requests) and the result is cached for subsequent calls,
+// unless the probe failed, in which case the error is returned and the next
+// call probes again. The probe fails if the client's token is not valid.
+//
+// If DetectCapabilities is set on the client, the capabilities are
+// detected the same way when a feature that depends on one of them is first
+// used, so that it fails fast with an error that wraps ErrNotSupported if
+// the endpoint does not support it.
+func (c *Client) Capabilities(ctx context.Context) (Capabilities, error) {
+	// hold the lock during the probe so that concurrent calls wait for it
+	// instead of probing again.
+	c.capsMu.Lock()
+	defer c.capsMu.Unlock()
+
+	if c.caps != nil {
+		return *c.caps, nil
+	}
+	caps, err := c.probeCapabilities(ctx)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	c.caps = &caps
+	return caps, nil
+}
+
+// checkCapability returns an error wrapping ErrNotSupported if
+// DetectCapabilities is set and the feature is not supported according to
+// the has function, or if the capabilities could not be detected.
+func (c *Client) checkCapability(ctx context.Context, feature string, has func(Capabilities) bool) error {
+	if !c.DetectCapabilities {
+		return nil
+	}
+	caps, err := c.Capabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("upstashdis: detect capabilities: %w", err)
+	}
+	if !has(caps) {
+		return fmt.Errorf("upstashdis: %s: %w", feature, ErrNotSupported)
+	}
+	return nil
+}
+
+func hasTransactions(caps Capabilities) bool { return caps.Transactions }
+func hasSubscribe(caps Capabilities) bool    { return caps.Subscribe }
+func hasBase64(caps Capabilities) bool       { return caps.Base64 }
+
+func (c *Client) probeCapabilities(ctx context.Context) (Capabilities, error) {
+	var caps Capabilities
+
+	// a standard command must succeed, so that failures of the other probes
+	// can be attributed to the missing feature and not e.g. to an invalid
+	// token.
+	tok := c.token()
+	if _, err := c.call(ctx, "POST", "", []byte(`["PING"]`), callOptions{
+		tok:       &tok,
+		clientTok: true,
+		cmds:      []string{"PING"},
+	}); err != nil {
+		return caps, err
+	}
+
+	body, ok, err := c.probe(ctx, "POST", "multi-exec", []byte(`[["PING"]]`), nil)
+	if err != nil {
+		return caps, err
+	}
+	if ok {
+		var res []*Result
+		caps.Transactions = json.Unmarshal(body, &res) == nil && len(res) == 1 && res[0].Error == ""
+	}
+
+	if caps.Base64, err = c.probeBase64(ctx); err != nil {
+		return caps, err
+	}
+
+	body, ok, err = c.probe(ctx, "POST", "", []byte(`["PING"]`), http.Header{"Upstash-Response-Format": {"resp2"}})
+	if err != nil {
+		return caps, err
+	}
+	caps.RESP = ok && bytes.HasPrefix(body, []byte("+PONG\r\n"))
+
+	if caps.Subscribe, err = c.probeSubscribe(ctx); err != nil {
+		return caps, err
+	}
+	return caps, nil
+}
+
+// probe makes a request to the endpoint and returns the response body and
+// true if the response status is 200. Other statuses are returned as false
+// and a nil error, as they indicate that the probed feature is not
+// supported, except for 401 and 403 that return an error.
+func (c *Client) probe(ctx context.Context, method, endpoint string, body []byte, hdr http.Header) ([]byte, bool, error) {
+	tok := c.token()
+	res, err := c.do(ctx, method, endpoint, body, hdr, &tok, true)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		b, err := io.ReadAll(res.Body)
+		return b, err == nil, err
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, false, statusError(res, -1)
+	default:
+		return nil, false, nil
+	}
+}
+
+// probeBase64 returns true if the results are base64-encoded when requested
+// with the Upstash-Encoding header.
+func (c *Client) probeBase64(ctx context.Context) (bool, error) {
+	body, ok, err := c.probe(ctx, "POST", "", []byte(`["PING"]`), http.Header{encodingHeader: {"base64"}})
+	if err != nil || !ok {
+		return false, err
+	}
+	var res Result
+	return json.Unmarshal(body, &res) == nil &&
+		string(res.Result) == `"`+base64.StdEncoding.EncodeToString([]byte("PONG"))+`"`, nil
+}
+
+// probeSubscribe returns true if subscribing to a channel returns a stream
+// of server-sent events. The stream is closed as soon as the response
+// headers are received.
+func (c *Client) probeSubscribe(ctx context.Context) (bool, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tok := c.token()
+	res, err := c.do(ctx, "GET", "subscribe/upstashdis-capabilities-probe", nil,
+		http.Header{"Accept": {"text/event-stream"}}, &tok, true)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream"), nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, statusError(res, -1)
+	default:
+		return false, nil
+	}
+}
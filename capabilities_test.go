@@ -0,0 +1,167 @@
+package upstashdis_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+// fullServer mimics the responses of the Upstash Redis REST API to the
+// capabilities probes, counting the requests it receives.
+type fullServer struct {
+	reqs int64
+}
+
+func (f *fullServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&f.reqs, 1)
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/multi-exec":
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"result": "PONG"}})
+	case r.URL.Path == "/subscribe/upstashdis-capabilities-probe":
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	case r.Header.Get("Upstash-Response-Format") == "resp2":
+		_, _ = w.Write([]byte("+PONG\r\n"))
+	case r.Header.Get("Upstash-Encoding") == "base64":
+		_ = json.NewEncoder(w).Encode(map[string]string{"result": "UE9ORw=="})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]string{"result": "PONG"})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("full", func(t *testing.T) {
+		fake := &fullServer{}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+
+		cli := &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok"}
+		caps, err := cli.Capabilities(ctx)
+		require.NoError(t, err)
+		require.Equal(t, upstashdis.Capabilities{Transactions: true, Subscribe: true, Base64: true, RESP: true}, caps)
+
+		// cached
+		n := atomic.LoadInt64(&fake.reqs)
+		caps2, err := cli.Capabilities(ctx)
+		require.NoError(t, err)
+		require.Equal(t, caps, caps2)
+		require.Equal(t, n, atomic.LoadInt64(&fake.reqs))
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		srv := httptest.NewServer(&fullServer{})
+		defer srv.Close()
+
+		cli := &upstashdis.Client{BaseURL: srv.URL, APIToken: "nope"}
+		_, err := cli.Capabilities(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Unauthorized")
+	})
+
+	t.Run("restserver", func(t *testing.T) {
+		srv := upstashtest.NewServer(t)
+		cli := srv.Client()
+		caps, err := cli.Capabilities(ctx)
+		require.NoError(t, err)
+		require.Equal(t, upstashdis.Capabilities{Transactions: true, Subscribe: true, Base64: true}, caps)
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		// a server that only supports single commands
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"result": "PONG"})
+		}))
+		defer srv.Close()
+
+		var called bool
+		cli := &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok", DetectCapabilities: true}
+		_, err := cli.TxPipelined(ctx, func(p *upstashdis.Request) error {
+			called = true
+			return p.Send("PING")
+		})
+		require.True(t, errors.Is(err, upstashdis.ErrNotSupported), "%v", err)
+		require.Contains(t, err.Error(), "transactions")
+		require.False(t, called)
+
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("PING"))
+		err = req.ExecTx()
+		require.True(t, errors.Is(err, upstashdis.ErrNotSupported), "%v", err)
+
+		// without detection, the error is the one returned by the endpoint
+		cli.DetectCapabilities = false
+		_, err = cli.TxPipelined(ctx, func(p *upstashdis.Request) error {
+			return p.Send("PING")
+		})
+		require.Error(t, err)
+		require.False(t, errors.Is(err, upstashdis.ErrNotSupported))
+
+		// subscriptions
+		cli.DetectCapabilities = true
+		sub := &upstashdis.Subscriber{Client: cli, Channel: "ch", MaxReconnects: -1}
+		err = sub.Run(ctx, func(upstashdis.Message) {})
+		require.True(t, errors.Is(err, upstashdis.ErrNotSupported), "%v", err)
+		require.Contains(t, err.Error(), "subscribe")
+	})
+
+	t.Run("binary safe", func(t *testing.T) {
+		// a server that ignores the Upstash-Encoding header
+		var reqs int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&reqs, 1)
+			_ = json.NewEncoder(w).Encode(map[string]string{"result": "abcd"})
+		}))
+		defer srv.Close()
+
+		for _, detect := range []bool{true, false} {
+			atomic.StoreInt64(&reqs, 0)
+			cli := &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok", BinarySafe: true, DetectCapabilities: detect}
+			var s string
+			err := cli.NewRequest().ExecOne(&s, "GET", "k")
+			require.True(t, errors.Is(err, upstashdis.ErrNotSupported), "%v", err)
+			require.Contains(t, err.Error(), "base64")
+			require.Empty(t, s)
+
+			// the endpoint is checked once
+			n := atomic.LoadInt64(&reqs)
+			err = cli.NewRequest().ExecOne(&s, "GET", "k")
+			require.True(t, errors.Is(err, upstashdis.ErrNotSupported), "%v", err)
+			require.Equal(t, n, atomic.LoadInt64(&reqs))
+		}
+
+		// a server that supports it
+		fake := &fullServer{}
+		srv2 := httptest.NewServer(fake)
+		defer srv2.Close()
+
+		cli := &upstashdis.Client{BaseURL: srv2.URL, APIToken: "tok", BinarySafe: true}
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "PING"))
+		require.Equal(t, "PONG", s)
+		require.Equal(t, int64(2), atomic.LoadInt64(&fake.reqs))
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "PING"))
+		require.Equal(t, int64(3), atomic.LoadInt64(&fake.reqs))
+	})
+}
@@ -0,0 +1,91 @@
+package upstashdis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrConflict is the error returned by UpdateKey when the key was modified
+// concurrently on each attempt to update it.
+var ErrConflict = errors.New("upstashdis: key modified concurrently")
+
+const (
+	casMaxAttempts = 10
+	casMinBackoff  = 10 * time.Millisecond
+	casMaxBackoff  = time.Second
+)
+
+// casScript sets KEYS[1] to ARGV[3] only if its current value is still the
+// one read by the client, ARGV[2] (or if it still does not exist, if ARGV[1]
+// is "0"). The TTL of the key, if any, is preserved. It returns 1 if the key
+// was set, 0 otherwise.
+var casScript = NewScript(1, `
+local cur = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+  if cur ~= ARGV[2] then return 0 end
+elseif cur then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[3], 'KEEPTTL')
+return 1
+`)
+
+// UpdateKey atomically updates the string value stored at key. Since WATCH
+// cannot be used with the stateless REST API, it implements an optimistic
+// compare-and-set: it reads the current value of the key, calls fn with it
+// (exists is false if the key does not exist) to compute the new value, and
+// sets it only if the key was not modified in the meantime, using a Lua
+// script. If it was, fn is called again with the new value after a
+// randomized, exponential backoff delay.
+//
+// If fn returns an error, the update is aborted and that error is returned.
+// If the key was modified concurrently on every attempt, ErrConflict is
+// returned. The TTL of the key, if any, is preserved. It returns the value
+// that was set.
+func (c *Client) UpdateKey(ctx context.Context, key string, fn func(old string, exists bool) (string, error)) (string, error) {
+	backoff := casMinBackoff
+	for attempt := 0; attempt < casMaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(delay):
+			}
+			if backoff *= 2; backoff > casMaxBackoff {
+				backoff = casMaxBackoff
+			}
+		}
+
+		var old *string
+		req := c.NewRequestContext(ctx)
+		if err := req.ExecOne(&old, "GET", key); err != nil {
+			return "", err
+		}
+
+		var cur string
+		if old != nil {
+			cur = *old
+		}
+		new, err := fn(cur, old != nil)
+		if err != nil {
+			return "", err
+		}
+
+		exists := "0"
+		if old != nil {
+			exists = "1"
+		}
+		var ok int
+		req = c.NewRequestContext(ctx)
+		if err := casScript.Exec(req, &ok, key, exists, cur, new); err != nil {
+			return "", err
+		}
+		if ok == 1 {
+			return new, nil
+		}
+	}
+	return "", ErrConflict
+}
@@ -0,0 +1,109 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateKey(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	incr := func(old string, exists bool) (string, error) {
+		if !exists {
+			return "1", nil
+		}
+		n, err := strconv.Atoi(old)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(n + 1), nil
+	}
+
+	t.Run("missing key", func(t *testing.T) {
+		v, err := cli.UpdateKey(ctx, "k", incr)
+		require.NoError(t, err)
+		require.Equal(t, "1", v)
+		srv.Redis.CheckGet(t, "k", "1")
+	})
+
+	t.Run("existing key keeps ttl", func(t *testing.T) {
+		srv.Redis.SetTTL("k", time.Minute)
+		v, err := cli.UpdateKey(ctx, "k", incr)
+		require.NoError(t, err)
+		require.Equal(t, "2", v)
+		srv.Redis.CheckGet(t, "k", "2")
+		require.Equal(t, time.Minute, srv.Redis.TTL("k"))
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		want := errors.New("abort")
+		_, err := cli.UpdateKey(ctx, "k", func(string, bool) (string, error) { return "x", want })
+		require.ErrorIs(t, err, want)
+		srv.Redis.CheckGet(t, "k", "2")
+	})
+
+	t.Run("conflict retried", func(t *testing.T) {
+		var calls int
+		v, err := cli.UpdateKey(ctx, "k", func(old string, exists bool) (string, error) {
+			calls++
+			if calls == 1 {
+				// concurrent modification after the value was read
+				require.NoError(t, srv.Redis.Set("k", "10"))
+			}
+			return incr(old, exists)
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, calls)
+		require.Equal(t, "11", v)
+	})
+
+	t.Run("conflict exhausted", func(t *testing.T) {
+		var calls int
+		_, err := cli.UpdateKey(ctx, "k", func(old string, exists bool) (string, error) {
+			calls++
+			require.NoError(t, srv.Redis.Set("k", strconv.Itoa(100+calls)))
+			return incr(old, exists)
+		})
+		require.ErrorIs(t, err, upstashdis.ErrConflict)
+		require.Equal(t, 10, calls)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		srv.Redis.Del("k")
+
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 5 && errs[i] == nil; j++ {
+					_, errs[i] = cli.UpdateKey(ctx, "k", incr)
+				}
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		srv.Redis.CheckGet(t, "k", "15")
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		_, err := srv.Redis.Lpush("l", "a")
+		require.NoError(t, err)
+		_, err = cli.UpdateKey(ctx, "l", incr)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "WRONGTYPE")
+	})
+}
@@ -7,6 +7,7 @@ package upstashdis
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +16,8 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Argument allows arbitrary values to encode themselves as a valid argument
@@ -39,6 +42,8 @@ type HTTPDoer interface {
 type Result struct {
 	Error  string          `json:"error"`
 	Result json.RawMessage `json:"result"`
+
+	base64 bool // the string values of Result are base64-encoded
 }
 
 // Client is an Upstash Redis REST client. It is safe for concurrent use as
@@ -62,11 +67,165 @@ type Client struct {
 	// the token provided to NewRequestWithToken) as Bearer value. If
 	// NewRequestFunc is nil, http.NewRequest is used.
 	NewRequestFunc func(method, url string, body io.Reader) (*http.Request, error)
+
+	// OnUnauthorized is called when a REST API call fails with a 401
+	// Unauthorized status, with the token that was rejected. It should return
+	// a new valid token, e.g. by executing ACL RESTTOKEN or by fetching a
+	// rotated secret. The call is then retried once with that token, and if
+	// the rejected token was the client's token, it is used for all subsequent
+	// requests made with this client (the APIToken field is left untouched).
+	// Concurrent calls that fail with the same token trigger a single call to
+	// OnUnauthorized. If it returns an error, the REST API call fails with that
+	// error. If OnUnauthorized is nil, 401 responses are not retried. Note
+	// that the token is not refreshed if the Authorization header is set by
+	// NewRequestFunc.
+	OnUnauthorized func(ctx context.Context, token string) (string, error)
+
+	// DetectCapabilities enables the automatic detection of the optional
+	// features supported by the REST API endpoint (see Capabilities) when a
+	// feature that depends on one of them is first used, so that it fails
+	// fast with an error wrapping ErrNotSupported instead of an error
+	// returned by the endpoint, which may be unclear. Currently this applies
+	// to TxPipelined and Request.ExecTx, to Subscriber.Run and to the
+	// requests made in the BinarySafe mode.
+	DetectCapabilities bool
+
+	// Retry is the policy used to retry the REST API calls that failed
+	// because of a transient error, see RetryPolicy. If nil, calls are not
+	// retried. It can be overridden for a request with Request.WithRetry.
+	Retry *RetryPolicy
+
+	// BinarySafe enables the binary-safe mode for the results, in which the
+	// string values of the results are requested base64-encoded (via the
+	// Upstash-Encoding request header) and decoded transparently, so that
+	// binary values that are not valid UTF-8 are returned intact in string
+	// and []byte destinations (and slices of those). The results returned by
+	// Request.ExecRaw are decoded by the reply conversion helpers such as
+	// String and Bytes. As a server that ignores the Upstash-Encoding header
+	// would return results that cannot be told apart from encoded ones, the
+	// endpoint is checked on the first request (see DetectCapabilities),
+	// which fails with an error wrapping ErrNotSupported if the results are
+	// not encoded.
+	//
+	// The arguments are not binary-safe: the REST API has no equivalent of
+	// that header for the requests, and the arguments are always sent as JSON
+	// strings, which cannot represent binary values that are not valid UTF-8.
+	// In this mode, Request.Send returns an error for such arguments instead
+	// of sending them altered, so such values must be encoded by the caller
+	// (e.g. in base64) to be stored.
+	BinarySafe bool
+
+	// MaxPipelineCommands and MaxPipelineBytes limit the size of the pipeline
+	// calls, to stay within the request size limits of the REST API and
+	// avoid building huge request bodies. When a pipeline exceeds either
+	// limit, it is transparently executed in multiple pipeline calls, in
+	// order, each with at most MaxPipelineCommands commands and a JSON body
+	// of at most MaxPipelineBytes (a single command that is larger than that
+	// is sent alone). The results are returned as for a single call, with
+	// the PipelineIndex of the errors relative to the whole pipeline. If a
+	// call fails, the commands of the previous calls have been executed. A
+	// value of 0 means no limit. Transactions are never split, as they must
+	// execute atomically.
+	MaxPipelineCommands int
+	MaxPipelineBytes    int
+
+	// KeyPrefix, if set, is prepended to the keys of the commands queued with
+	// Request.Send (and the methods that use it), so that multiple
+	// applications or test runs can share the same database without
+	// conflicts, see also Namespace. The positions of the keys are known for
+	// the data commands (including the variadic ones such as DEL and MSET,
+	// and those with a number of keys such as EVAL and ZUNIONSTORE), and Send
+	// returns an error for the commands with unknown keys instead of sending
+	// them unprefixed. The pattern of KEYS and the MATCH option of SCAN are
+	// prefixed, but the keys returned in the results (e.g. by KEYS, SCAN,
+	// RANDOMKEY and BLPOP) are not stripped of the prefix, and the commands
+	// that apply to the whole database, such as FLUSHDB and DBSIZE, are not
+	// restricted to the prefixed keys.
+	KeyPrefix string
+
+	// Hooks, if set, is called at the start and end of each REST API call
+	// made to execute commands or with Client.Call, e.g. to record metrics
+	// and traces (see the upstashotel package).
+	Hooks Hooks
+
+	// Logger, if set, logs a line for each REST API call made to execute
+	// commands or with Client.Call, for debugging. It logs the method and
+	// endpoint of the call, its status code, duration and number of
+	// attempts, the commands and a summary of the results or the error. The
+	// arguments of the commands and the results are redacted according to
+	// LogRedaction.
+	Logger Logger
+
+	// LogRedaction controls the redaction of the values logged by Logger.
+	// The default is RedactAll.
+	LogRedaction LogRedaction
+
+	// Cache, if set, caches the results of the read-only commands executed
+	// with the client, and invalidates them when the keys are modified by
+	// commands executed with the client (or with any client that uses the
+	// same Cache), see Cache.
+	Cache *Cache
+
+	mu        sync.Mutex // protects refreshed
+	refreshed string     // token returned by OnUnauthorized, if any
+
+	capsMu sync.Mutex    // protects caps and base64
+	caps   *Capabilities // cached result of Capabilities, if any
+	base64 *bool         // cached result of checkBase64, if any
+}
+
+// token returns the current token of the client.
+func (c *Client) token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshed != "" {
+		return c.refreshed
+	}
+	return c.APIToken
+}
+
+// refreshToken calls OnUnauthorized to replace the rejected token and returns
+// the new token. If clientTok is true, the rejected token is the client's
+// token and the refreshed token replaces it for subsequent requests.
+func (c *Client) refreshToken(ctx context.Context, rejected string, clientTok bool) (string, error) {
+	if !clientTok {
+		return c.OnUnauthorized(ctx, rejected)
+	}
+
+	// hold the lock during the refresh so that concurrent requests that fail
+	// with the same token wait for the new one instead of refreshing again.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur := c.APIToken
+	if c.refreshed != "" {
+		cur = c.refreshed
+	}
+	if cur != rejected {
+		// already refreshed by a concurrent request
+		return cur, nil
+	}
+
+	tok, err := c.OnUnauthorized(ctx, rejected)
+	if err != nil {
+		return "", err
+	}
+	c.refreshed = tok
+	return tok, nil
 }
 
 // NewRequest starts a new REST API request using this client.
 func (c *Client) NewRequest() *Request {
-	return &Request{c: c, tok: c.APIToken}
+	return &Request{c: c, tok: c.token(), clientTok: true}
+}
+
+// NewRequestContext is like NewRequest, but the HTTP requests made to
+// execute the commands use ctx, so that they can be canceled or have a
+// deadline.
+func (c *Client) NewRequestContext(ctx context.Context) *Request {
+	r := c.NewRequest()
+	r.ctx = ctx
+	return r
 }
 
 // NewRequestWithToken starts a new REST API request using this client, but
@@ -77,22 +236,126 @@ func (c *Client) NewRequestWithToken(token string) *Request {
 	return &Request{c: c, tok: token}
 }
 
+// NewRequestWithTokenContext is like NewRequestWithToken, but the HTTP
+// requests made to execute the commands use ctx, as for NewRequestContext.
+func (c *Client) NewRequestWithTokenContext(ctx context.Context, token string) *Request {
+	r := c.NewRequestWithToken(token)
+	r.ctx = ctx
+	return r
+}
+
 // CloneWithToken returns a copy of c with the APIToken field replaced with the
 // provided token. This can be useful to use an ACL RESTTOKEN-generated token
 // for multiple requests while sharing the rest of the client configuration
 // with the base client.
 func (c *Client) CloneWithToken(token string) *Client {
-	clone := *c
-	clone.APIToken = token
-	return &clone
+	return &Client{
+		BaseURL:        c.BaseURL,
+		APIToken:       token,
+		HTTPClient:     c.HTTPClient,
+		NewRequestFunc: c.NewRequestFunc,
+		OnUnauthorized: c.OnUnauthorized,
+
+		DetectCapabilities: c.DetectCapabilities,
+		Retry:              c.Retry,
+		BinarySafe:         c.BinarySafe,
+		Hooks:              c.Hooks,
+
+		MaxPipelineCommands: c.MaxPipelineCommands,
+		MaxPipelineBytes:    c.MaxPipelineBytes,
+		KeyPrefix:           c.KeyPrefix,
+		Logger:              c.Logger,
+		LogRedaction:        c.LogRedaction,
+		Cache:               c.Cache,
+	}
+}
+
+// Namespace returns a copy of c with prefix appended to its KeyPrefix, so
+// that the keys of the commands executed with the returned client are
+// prefixed with c's KeyPrefix followed by prefix. As with CloneWithToken,
+// the returned client shares the rest of the client configuration with c,
+// and its token is the current token of c (which may have been refreshed
+// via OnUnauthorized).
+func (c *Client) Namespace(prefix string) *Client {
+	nc := c.CloneWithToken(c.token())
+	nc.KeyPrefix += prefix
+	return nc
+}
+
+// Pipelined starts a new request and calls fn with it so that commands can
+// be queued with Send. When fn returns, all queued commands are executed in a
+// pipeline and the results are returned in the same order as the commands.
+// If fn returns an error, no command is executed and that error is returned.
+// The HTTP request is made with the provided ctx.
+//
+// Like ExecRaw, it returns the results of all commands, but like Exec, it
+// also returns an error of type *Error for the first command that failed, if
+// any. If the request itself failed, the results are nil.
+func (c *Client) Pipelined(ctx context.Context, fn func(p *Request) error) ([]*Result, error) {
+	return c.pipelined(ctx, false, fn)
 }
 
-// A Request is started by calling Client.NewRequest. It is not safe for
+// TxPipelined is like Pipelined, except that the commands are executed
+// atomically in a transaction, using the /multi-exec endpoint of the Upstash
+// Redis REST API. If the transaction is discarded, e.g. because a command
+// is invalid, an *Error with a PipelineIndex of -1 is returned and no
+// command is executed. If DetectCapabilities is set and the endpoint does
+// not support transactions, an error wrapping ErrNotSupported is returned
+// and fn is not called.
+func (c *Client) TxPipelined(ctx context.Context, fn func(p *Request) error) ([]*Result, error) {
+	if err := c.checkCapability(ctx, "transactions", hasTransactions); err != nil {
+		return nil, err
+	}
+	return c.pipelined(ctx, true, fn)
+}
+
+func (c *Client) pipelined(ctx context.Context, tx bool, fn func(p *Request) error) ([]*Result, error) {
+	r := c.NewRequestContext(ctx)
+	r.tx, r.pipeline = tx, true
+	if err := fn(r); err != nil {
+		return nil, err
+	}
+	if len(r.req) == 0 {
+		return nil, nil
+	}
+
+	res, err := r.exec()
+	if err != nil {
+		return nil, err
+	}
+	for i, rr := range res {
+		if rr.Error != "" {
+			return res, newError(rr.Error, i)
+		}
+	}
+	return res, nil
+}
+
+// A Request is started by calling Client.NewRequest or one of its variants.
+// If it was started with a context, that context applies to all HTTP
+// requests made by its Exec, ExecOne and ExecRaw methods. It is not safe for
 // concurrent use.
 type Request struct {
 	c   *Client
 	tok string
 	req [][]interface{} // the pending requests to execute
+	ctx context.Context // the context of the HTTP request, if set
+	tx  bool            // execute the pending requests in a transaction
+
+	// execute the pending requests in a pipeline, even a single one
+	pipeline bool
+
+	clientTok bool         // tok is the client's token
+	retry     *RetryPolicy // retry policy set by WithRetry
+	retrySet  bool         // WithRetry was called
+
+	// the commands of req are slices of args, and the request bodies are
+	// encoded with enc, so that their memory is reused across calls.
+	args []interface{}
+	enc  cmdEncoder
+
+	pool   bool          // decode the results in pooled buffers, see execPooled
+	pooled []*resultsBuf // the buffers to release once the results are consumed
 }
 
 // Error represents an error returned by Redis.
@@ -106,6 +369,14 @@ type Error struct {
 	// pipeline, 0 if the command was executed without pipeline, and -1 if the
 	// error did not originate from a command execution.
 	PipelineIndex int
+	// StatusCode is the HTTP status code of the response if the error was
+	// returned as the response of a failed REST API call (e.g. a single
+	// command that failed, or a request that was rejected), 0 if it is the
+	// result of a command in a successful response.
+	StatusCode int
+	// Header holds the headers of the failed response if StatusCode is set,
+	// e.g. with the Retry-After and rate-limit headers.
+	Header http.Header
 }
 
 // Error returns the error message.
@@ -127,11 +398,35 @@ func (r *Request) Send(cmd string, args ...interface{}) error {
 		return errors.New("upstashdis: empty command")
 	}
 
-	// serialize and buffer the command
-	new := make([]interface{}, len(args)+1)
-	new[0] = cmd
-	for i, arg := range args {
-		new[i+1] = writeArg(arg, true)
+	// serialize and buffer the command, in the backing array of the arguments
+	// of all pending commands. When it is full, a new one is allocated, the
+	// previous commands keep referencing the old one.
+	n := len(args) + 1
+	if cap(r.args)-len(r.args) < n {
+		size := 2 * cap(r.args)
+		if size < n {
+			size = n
+		}
+		r.args = make([]interface{}, 0, size)
+	}
+	start := len(r.args)
+	r.args = append(r.args, cmd)
+	for _, arg := range args {
+		r.args = append(r.args, writeArg(arg, true))
+	}
+	new := r.args[start:len(r.args):len(r.args)]
+
+	if r.c.KeyPrefix != "" {
+		if err := prefixKeys(new, r.c.KeyPrefix); err != nil {
+			r.args = r.args[:start]
+			return err
+		}
+	}
+	if r.c.BinarySafe {
+		if err := checkBinaryArgs(new); err != nil {
+			r.args = r.args[:start]
+			return err
+		}
 	}
 	r.req = append(r.req, new)
 	return nil
@@ -150,12 +445,20 @@ func (r *Request) Send(cmd string, args ...interface{}) error {
 // discarded. A nil dst value simply ignores the corresponding result at that
 // position.
 //
+// Results are unmarshaled as JSON, except for string and integer results
+// unmarshaled into a *time.Time (parsed as an RFC3339 timestamp or as a Unix
+// timestamp in seconds if it is an integer), or into a destination that
+// implements encoding.TextUnmarshaler or encoding.BinaryUnmarshaler (the
+// value is passed to UnmarshalText or UnmarshalBinary, respectively) but not
+// json.Unmarshaler.
+//
 // The error returned will be of type *Error if it is a command that failed.
 // You may inspect its fields for more information about what failed after
 // obtaining its typed value with errors.As. It can also be of a different type
 // if e.g. the request failed due to a network error.
 func (r *Request) Exec(dst ...interface{}) error {
-	res, err := r.exec()
+	res, err := r.execPooled()
+	defer r.releaseResults()
 	if err != nil {
 		return err
 	}
@@ -176,7 +479,7 @@ func (r *Request) Exec(dst ...interface{}) error {
 			continue
 		}
 		if d != nil && r.Result != nil {
-			if err := json.Unmarshal(r.Result, d); err != nil {
+			if err := r.unmarshal(d); err != nil {
 				return err
 			}
 		}
@@ -184,6 +487,31 @@ func (r *Request) Exec(dst ...interface{}) error {
 	return firstErr
 }
 
+// ExecTx is like Exec, except that the queued commands are executed
+// atomically in a transaction, using the /multi-exec endpoint of the Upstash
+// Redis REST API, even if a single command is queued. The results are
+// unmarshaled as for Exec, and a command that failed during the execution of
+// the transaction (e.g. with a WRONGTYPE error, which does not abort the
+// transaction) is returned as an *Error with its index in the transaction
+// as PipelineIndex. If the transaction is discarded, e.g. because a command
+// is invalid, an *Error with a PipelineIndex of -1 is returned and no
+// command is executed.
+//
+// If DetectCapabilities is set on the client and the endpoint does not
+// support transactions, an error wrapping ErrNotSupported is returned and
+// the queued commands are discarded.
+func (r *Request) ExecTx(dst ...interface{}) error {
+	if err := r.c.checkCapability(r.ctx, "transactions", hasTransactions); err != nil {
+		r.reset(r.req)
+		return err
+	}
+
+	tx := r.tx
+	r.tx = true
+	defer func() { r.tx = tx }()
+	return r.Exec(dst...)
+}
+
 // ExecOne executes the provided command and unmarshals its result into dst. If
 // there were commands already queued for execution, they will be executed in a
 // pipeline with the provided command as last, but only the result of that last
@@ -192,13 +520,15 @@ func (r *Request) Exec(dst ...interface{}) error {
 // In other words, it executes all pending commands and discards their results,
 // before executing the specified command and returning its result - either as
 // the returned error if it failed, or unmarshaled into dst if it succeeded. If
-// dst is nil, the successful result is ignored.
+// dst is nil, the successful result is ignored. The result is unmarshaled
+// into dst as described for Exec.
 func (r *Request) ExecOne(dst interface{}, cmd string, args ...interface{}) error {
 	if err := r.Send(cmd, args...); err != nil {
 		return err
 	}
 
-	res, err := r.exec()
+	res, err := r.execPooled()
+	defer r.releaseResults()
 	if err != nil {
 		return err
 	}
@@ -212,7 +542,7 @@ func (r *Request) ExecOne(dst interface{}, cmd string, args ...interface{}) erro
 	if dst == nil {
 		return nil
 	}
-	return json.Unmarshal(last.Result, dst)
+	return last.unmarshal(dst)
 }
 
 // ExecRaw executes all commands queued by calls to Send and returns a slice
@@ -227,117 +557,446 @@ func (r *Request) ExecRaw() ([]*Result, error) {
 	return r.exec()
 }
 
+// execPooled is like exec, but the results are decoded in pooled buffers,
+// which must be released with releaseResults once the results have been
+// consumed.
+func (r *Request) execPooled() ([]*Result, error) {
+	r.pool = true
+	return r.exec()
+}
+
+// releaseResults releases the pooled buffers of the results, which must not
+// be used anymore.
+func (r *Request) releaseResults() {
+	for i, rb := range r.pooled {
+		rb.release()
+		r.pooled[i] = nil
+	}
+	r.pooled = r.pooled[:0]
+	r.pool = false
+}
+
+// reset clears the commands, which are the pending commands of the request
+// that were executed or discarded, so that the memory of the request can be
+// reused for the next ones.
+func (r *Request) reset(cmds [][]interface{}) {
+	for i := range cmds {
+		cmds[i] = nil
+	}
+	for i := range r.args {
+		r.args[i] = nil
+	}
+	r.req = r.req[:0]
+	r.args = r.args[:0]
+}
+
 func (r *Request) exec() ([]*Result, error) {
+	cmds := r.req
+	defer r.reset(cmds)
+	if len(cmds) == 0 {
+		return nil, errors.New("upstashdis: no command to execute")
+	}
+	if r.c.BinarySafe {
+		if err := r.c.checkBase64(r.ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.c.Cache != nil {
+		// the commands that are not served from the cache must still be executed
+		// as a pipeline if the request is a pipeline, so that their errors are
+		// returned as results.
+		pipeline := r.pipeline || len(cmds) > 1
+		return r.c.Cache.exec(cmds, r.tx, r.c.BinarySafe, func(cmds [][]interface{}) ([]*Result, error) {
+			return r.execCmds(cmds, pipeline)
+		}, r.refreshCmd)
+	}
+	return r.execCmds(cmds, r.pipeline)
+}
+
+// refreshCmd executes the command to refresh its stale result in the cache,
+// in the background, so it uses a new request that is not bound to the
+// context of r, and bypasses the cache.
+func (r *Request) refreshCmd(cmd []interface{}) (*Result, error) {
+	nr := &Request{c: r.c, tok: r.tok, clientTok: r.clientTok, retry: r.retry, retrySet: r.retrySet}
+	res, err := nr.execCmds([][]interface{}{cmd}, false)
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// execCmds executes the commands, in a pipeline if there is more than one
+// command or pipeline is true.
+func (r *Request) execCmds(cmds [][]interface{}, pipeline bool) ([]*Result, error) {
 	var (
-		body     bytes.Buffer
-		pipeline bool
+		body     []byte
+		endpoint string
 		err      error
 	)
 
-	// create the request (pipeline if > 1), make the call
-	switch len(r.req) {
-	case 0:
-		return nil, errors.New("upstashdis: no command to execute")
-	case 1:
+	// create the request (pipeline if > 1, transaction if requested), make the
+	// call
+	switch {
+	case r.tx:
+		// transaction, even for a single command
+		body, err = r.enc.appendCommands(r.enc.buf[:0], cmds)
+		endpoint = "multi-exec"
+	case len(cmds) == 1 && !pipeline:
 		// single command
-		err = json.NewEncoder(&body).Encode(r.req[0])
+		body, err = r.enc.appendCommand(r.enc.buf[:0], cmds[0])
 	default:
-		// pipeline
-		err = json.NewEncoder(&body).Encode(r.req)
-		pipeline = true
+		// pipeline, possibly in chunks
+		return r.execPipeline(cmds)
 	}
-	r.req = r.req[:0]
 
 	if err != nil {
 		return nil, err
 	}
-
-	return r.makeRequest(&body, pipeline)
+	res, err := r.makeRequest(body, endpoint, cmds)
+	r.keepBuffer(body, err)
+	return res, err
 }
 
-func (r *Request) makeRequest(body io.Reader, pipeline bool) ([]*Result, error) {
-	httpCli := r.c.HTTPClient
-	if httpCli == nil {
-		httpCli = http.DefaultClient
+// keepBuffer keeps the buffer of the request body for the next call if the
+// call succeeded. Otherwise the HTTP client may still be reading it (e.g. if
+// the request was canceled), so it is not reused.
+func (r *Request) keepBuffer(body []byte, err error) {
+	if err != nil {
+		body = nil
 	}
+	r.enc.buf = body[:0]
+}
 
-	newReq := r.c.NewRequestFunc
-	if newReq == nil {
-		newReq = http.NewRequest
-	}
+// execPipeline executes the commands in a pipeline, split in as many
+// pipeline calls as required by the client's MaxPipelineCommands and
+// MaxPipelineBytes limits. The results are returned in the same order as
+// the commands.
+func (r *Request) execPipeline(cmds [][]interface{}) ([]*Result, error) {
+	var (
+		body    = r.enc.buf[:0]
+		start   int
+		results = make([]*Result, 0, len(cmds))
+	)
 
-	surl := r.c.BaseURL
-	if pipeline {
-		purl, err := url.Parse(surl)
+	send := func(end int, body []byte) error {
+		res, err := r.makeRequest(body, "pipeline", cmds[start:end])
+		if err == nil && len(res) != end-start {
+			err = fmt.Errorf("upstashdis: got %d results for %d commands", len(res), end-start)
+		}
 		if err != nil {
+			if start > 0 {
+				return fmt.Errorf("upstashdis: pipeline chunk starting at command %d (previous commands were executed): %w", start, err)
+			}
+			return err
+		}
+		results = append(results, res...)
+		start = end
+		return nil
+	}
+
+	maxCmds, maxBytes := r.c.MaxPipelineCommands, r.c.MaxPipelineBytes
+	for i, cmd := range cmds {
+		n := len(body)
+		if n == 0 {
+			body = append(body, '[')
+		} else {
+			body = append(body, ',')
+		}
+		var err error
+		if body, err = r.enc.appendCommand(body, cmd); err != nil {
 			return nil, err
 		}
-		purl.Path = path.Join(purl.Path, "pipeline")
-		surl = purl.String()
+
+		// start a new chunk if the command does not fit in the current one, the
+		// closing bracket must be accounted for in the body size.
+		if i > start && ((maxCmds > 0 && i-start >= maxCmds) ||
+			(maxBytes > 0 && len(body)+1 > maxBytes)) {
+			// the comma before the command closes the current chunk, and the
+			// command is moved at the start of the next one.
+			body[n] = ']'
+			err := send(i, body[:n+1])
+			r.keepBuffer(body, err)
+			if err != nil {
+				return nil, err
+			}
+			body[0] = '['
+			m := copy(body[1:], body[n+1:])
+			body = body[:m+1]
+		}
 	}
-	req, err := newReq("POST", surl, body)
+	body = append(body, ']')
+	err := send(len(cmds), body)
+	r.keepBuffer(body, err)
 	if err != nil {
 		return nil, err
 	}
-	if req.Header.Get("Authorization") == "" {
-		req.Header.Set("Authorization", "Bearer "+r.tok)
+	return results, nil
+}
+
+// makeRequest makes the REST API call to the endpoint, which is empty for a
+// single command, to execute the commands cmds encoded in body. The names of
+// the commands are passed to the client's Hooks, if any.
+func (r *Request) makeRequest(body []byte, endpoint string, cmds [][]interface{}) ([]*Result, error) {
+	var (
+		names   []string
+		logCmds [][]interface{}
+	)
+	if r.c.Hooks != nil {
+		names = commandNames(cmds)
+	}
+	if r.c.Logger != nil {
+		logCmds = cmds
 	}
 
-	res, err := httpCli.Do(req)
+	pipeline := endpoint != ""
+	var ix int
+	if pipeline {
+		ix = -1 // pipeline errors still return 200, so unrelated to a command if it is a pipeline or transaction
+	}
+	var hdr http.Header
+	if r.c.BinarySafe {
+		hdr = http.Header{encodingHeader: {"base64"}}
+	}
+	raw, err := r.c.call(r.ctx, "POST", endpoint, body, callOptions{
+		hdr:        hdr,
+		tok:        &r.tok,
+		clientTok:  r.clientTok,
+		errIx:      ix,
+		retry:      r.retryPolicy(),
+		idempotent: idempotent(cmds),
+		cmds:       names,
+		logCmds:    logCmds,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
-		if len(b) == 0 {
-			b = []byte(res.Status)
-		} else {
-			// try to decode the body as JSON into a Result with an error value
-			var pld Result
-			if err := json.Unmarshal(b, &pld); err == nil && pld.Error != "" {
-				var ix int
-				if pipeline {
-					ix = -1 // pipeline errors still return 200, so unrelated to a command if it is a pipeline
-				}
-				return nil, newError(pld.Error, ix)
+	var results []*Result
+	switch {
+	case r.pool:
+		rb := resultsPool.Get().(*resultsBuf)
+		r.pooled = append(r.pooled, rb)
+		results, err = rb.decode(raw, pipeline)
+	case pipeline:
+		err = json.Unmarshal(raw, &results)
+	default:
+		var result Result
+		if err = json.Unmarshal(raw, &result); err == nil {
+			results = []*Result{&result}
+		}
+	}
+	if hdr != nil {
+		for _, res := range results {
+			if res != nil {
+				res.base64 = true
 			}
 		}
-		return nil, fmt.Errorf("[%d]: %s", res.StatusCode, string(b))
 	}
+	return results, err
+}
 
-	var results []*Result
-	raw, err := io.ReadAll(res.Body)
+// Call makes a REST API call with the method to the path, relative to the
+// BaseURL, and returns the raw result of the response. If body is not nil,
+// it is encoded as JSON and sent as the request body. This is a low-level
+// method meant for the REST APIs of other Upstash services that share the
+// same authentication and response format, such as Upstash Vector (see the
+// vector subpackage). The call is made with the client's configuration and
+// token, refreshed via OnUnauthorized as for the other requests. If the
+// response is an error message, it is returned as an *Error with a
+// PipelineIndex of -1.
+func (c *Client) Call(ctx context.Context, method, path string, body interface{}) (json.RawMessage, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	tok := c.token()
+	raw, err := c.call(ctx, method, path, b, callOptions{
+		tok:        &tok,
+		clientTok:  true,
+		errIx:      -1,
+		retry:      c.Retry,
+		idempotent: idempotentMethod(method),
+	})
 	if err != nil {
 		return nil, err
 	}
-	if pipeline {
-		err = json.Unmarshal(raw, &results)
+
+	var res Result
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, newError(res.Error, -1)
+	}
+	return res.Result, nil
+}
+
+// callOptions are the options of a REST API call made with call.
+type callOptions struct {
+	hdr        http.Header     // additional request headers
+	tok        *string         // token to authenticate with, updated if refreshed
+	clientTok  bool            // tok is the client's token
+	errIx      int             // PipelineIndex of the *Error for an error response
+	retry      *RetryPolicy    // retry policy, nil to not retry
+	idempotent bool            // call can be retried even if it may have been executed
+	cmds       []string        // names of the commands executed, for the Hooks
+	logCmds    [][]interface{} // commands executed, for the Logger
+}
+
+// call makes the HTTP request with the method to the endpoint, which is
+// relative to the BaseURL (empty for the BaseURL itself), with the body. It
+// authenticates with *opts.tok, refreshing it with OnUnauthorized and
+// retrying once on a 401 response, and retries transient failures according
+// to opts.retry. If the response status is not 200, it returns an error, of
+// type *Error with a PipelineIndex of opts.errIx if the response body holds
+// an error message (wrapped in a *RetryError if the call was retried).
+// Otherwise it returns the response body. The client's Hooks, if any, are
+// called at the start and end of the call, and it is logged by its Logger,
+// if any.
+func (c *Client) call(ctx context.Context, method, endpoint string, body []byte, opts callOptions) (b []byte, err error) {
+	ctx, info := c.startHooks(ctx, method, endpoint, opts.cmds)
+	var (
+		attempt, status int
+		start           = time.Now()
+	)
+	defer func() {
+		c.endHooks(ctx, info, attempt, status, err)
+		c.logCall(method, endpoint, opts, start, attempt, status, b, err)
+	}()
+
+	for attempt = 1; ; attempt++ {
+		res, err := c.do(ctx, method, endpoint, body, opts.hdr, opts.tok, opts.clientTok)
+		status = 0
+		if err == nil {
+			status = res.StatusCode
+		}
+		if err == nil && res.StatusCode == http.StatusOK {
+			defer res.Body.Close()
+			return io.ReadAll(res.Body)
+		}
+
+		var (
+			retry bool
+			after time.Duration
+		)
+		if p := opts.retry; p != nil && attempt < p.MaxAttempts {
+			retry, after = p.retryable(ctx, res, err, opts.idempotent)
+		}
+		if err == nil {
+			err = statusError(res, opts.errIx)
+			res.Body.Close()
+		}
+
+		if retry {
+			retry = sleepCtx(ctx, opts.retry.backoff(attempt, after)) == nil
+		}
+		if !retry {
+			if attempt > 1 {
+				err = &RetryError{Attempts: attempt, Err: err}
+			}
+			return nil, err
+		}
+	}
+}
+
+// statusError returns the error for the failed response res, of type *Error
+// with a PipelineIndex of errIx if the response body holds an error message,
+// otherwise of type *StatusError.
+func statusError(res *http.Response, errIx int) error {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if len(b) == 0 {
+		b = []byte(res.Status)
 	} else {
-		var result Result
-		if err = json.Unmarshal(raw, &result); err == nil {
-			results = []*Result{&result}
+		// try to decode the body as JSON into a Result with an error value
+		var pld Result
+		if err := json.Unmarshal(b, &pld); err == nil && pld.Error != "" {
+			err := newError(pld.Error, errIx)
+			err.StatusCode = res.StatusCode
+			err.Header = res.Header
+			return err
 		}
 	}
-	return results, err
+	return &StatusError{StatusCode: res.StatusCode, Header: res.Header, Body: string(b)}
+}
+
+// do makes the HTTP request as described for call, with the additional
+// headers hdr, and returns the response regardless of its status. The
+// caller must close the response body.
+func (c *Client) do(ctx context.Context, method, endpoint string, body []byte, hdr http.Header, tok *string, clientTok bool) (*http.Response, error) {
+	httpCli := c.HTTPClient
+	if httpCli == nil {
+		httpCli = http.DefaultClient
+	}
+
+	newReq := c.NewRequestFunc
+	if newReq == nil {
+		newReq = http.NewRequest
+	}
+
+	surl := c.BaseURL
+	if endpoint != "" {
+		purl, err := url.Parse(surl)
+		if err != nil {
+			return nil, err
+		}
+		purl.Path = path.Join(purl.Path, endpoint)
+		surl = purl.String()
+	}
+
+	for retried := false; ; retried = true {
+		var rbody io.Reader
+		if body != nil {
+			rbody = bytes.NewReader(body)
+		}
+		req, err := newReq(method, surl, rbody)
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range hdr {
+			req.Header[k] = vs
+		}
+		setAuth := req.Header.Get("Authorization") == ""
+		if setAuth {
+			req.Header.Set("Authorization", "Bearer "+*tok)
+		}
+		if ctx != nil {
+			req = req.WithContext(ctx)
+		}
+
+		res, err := httpCli.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusUnauthorized || retried || !setAuth || c.OnUnauthorized == nil {
+			return res, nil
+		}
+
+		// refresh the token and retry once
+		res.Body.Close()
+		newTok, err := c.refreshToken(req.Context(), *tok, clientTok)
+		if err != nil {
+			return nil, fmt.Errorf("upstashdis: refresh token: %w", err)
+		}
+		*tok = newTok
+	}
 }
 
 // adjusted from redigo's internal helper function.
 func writeArg(arg interface{}, argumentTypeOK bool) interface{} {
-	switch arg := arg.(type) {
-	case string:
+	switch v := arg.(type) {
+	case string, int, int64, float64:
+		// returned as-is to avoid boxing the value again, the int is encoded
+		// like an int64.
 		return arg
 	case []byte:
-		return string(arg)
-	case int:
-		return int64(arg)
-	case int64:
-		return arg
-	case float64:
-		return arg
+		return string(v)
 	case bool:
-		if arg {
+		if v {
 			return "1"
 		} else {
 			return "0"
@@ -346,7 +1005,7 @@ func writeArg(arg interface{}, argumentTypeOK bool) interface{} {
 		return ""
 	case Argument:
 		if argumentTypeOK {
-			return writeArg(arg.RedisArg(), false)
+			return writeArg(v.RedisArg(), false)
 		}
 		// See comment in default clause below.
 		var buf bytes.Buffer
@@ -0,0 +1,63 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestContext(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		srv := upstashtest.NewServer(t)
+		cli := srv.Client()
+
+		var got string
+		req := cli.NewRequestContext(context.Background())
+		require.NoError(t, req.ExecOne(nil, "SET", "a", "1"))
+		require.NoError(t, req.ExecOne(&got, "GET", "a"))
+		require.Equal(t, "1", got)
+
+		req = cli.NewRequestWithTokenContext(context.Background(), "nope")
+		require.Error(t, req.ExecOne(nil, "GET", "a"))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		done := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		}))
+		defer srv.Close()
+		defer close(done)
+
+		cli := &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok"}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		req := cli.NewRequestContext(ctx)
+		require.NoError(t, req.Send("PING"))
+		_, err := req.ExecRaw()
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		srv := upstashtest.NewServer(t)
+		cli := srv.Client()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := cli.NewRequestContext(ctx).ExecOne(nil, "PING")
+		require.True(t, errors.Is(err, context.Canceled), "%v", err)
+	})
+}
@@ -0,0 +1,121 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnUnauthorized(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+
+	var calls int64
+	refresh := func(ctx context.Context, token string) (string, error) {
+		atomic.AddInt64(&calls, 1)
+		if token == "revoked" {
+			return "", errors.New("revoked")
+		}
+		return upstashtest.APIToken, nil
+	}
+	newClient := func() *upstashdis.Client {
+		cli := srv.Client()
+		cli.APIToken = "expired"
+		cli.OnUnauthorized = refresh
+		return cli
+	}
+
+	t.Run("refresh and retry once", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		cli := newClient()
+
+		var got string
+		require.NoError(t, cli.NewRequest().ExecOne(&got, "ECHO", "a"))
+		require.Equal(t, "a", got)
+		require.NoError(t, cli.NewRequest().ExecOne(&got, "ECHO", "b"))
+		require.Equal(t, "b", got)
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+		require.Equal(t, "expired", cli.APIToken)
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		cli := newClient()
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "PING"))
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+		// the namespace uses the refreshed token
+		ns := cli.Namespace("app:")
+		require.NoError(t, ns.NewRequest().ExecOne(nil, "SET", "a", "1"))
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+		srv.Redis.CheckGet(t, "app:a", "1")
+	})
+
+	t.Run("concurrent refresh", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		cli := newClient()
+
+		var wg sync.WaitGroup
+		errs := make([]error, 5)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = cli.NewRequest().ExecOne(nil, "PING")
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("refresh fails", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		cli := newClient()
+		cli.APIToken = "revoked"
+
+		err := cli.NewRequest().ExecOne(nil, "PING")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "refresh token: revoked")
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("still unauthorized", func(t *testing.T) {
+		cli := newClient()
+		cli.OnUnauthorized = func(ctx context.Context, token string) (string, error) {
+			atomic.AddInt64(&calls, 1)
+			return "stillbad", nil
+		}
+		atomic.StoreInt64(&calls, 0)
+
+		err := cli.NewRequest().ExecOne(nil, "PING")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Unauthorized")
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("request token", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		cli := srv.Client()
+		cli.OnUnauthorized = refresh
+
+		require.NoError(t, cli.NewRequestWithToken("expired").ExecOne(nil, "PING"))
+		require.NoError(t, cli.NewRequestWithToken("expired").ExecOne(nil, "PING"))
+		require.Equal(t, int64(2), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("no hook", func(t *testing.T) {
+		cli := srv.Client()
+		cli.APIToken = "expired"
+		err := cli.NewRequest().ExecOne(nil, "PING")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Unauthorized")
+	})
+}
@@ -1,6 +1,7 @@
 package upstashdis
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
@@ -251,4 +252,99 @@ func TestUpstash(t *testing.T) {
 		require.Empty(t, res[3].Error)
 		require.NotEmpty(t, res[4].Error)
 	})
+
+	t.Run("Pipelined", func(t *testing.T) {
+		res, err := cli.Pipelined(context.Background(), func(p *Request) error {
+			if err := p.Send("SET", "k", 1); err != nil {
+				return err
+			}
+			return p.Send("INCR", "k")
+		})
+		require.NoError(t, err)
+		require.Len(t, res, 2)
+		require.JSONEq(t, `"OK"`, string(res[0].Result))
+		require.JSONEq(t, `2`, string(res[1].Result))
+	})
+
+	t.Run("Pipelined failed", func(t *testing.T) {
+		res, err := cli.Pipelined(context.Background(), func(p *Request) error {
+			if err := p.Send("NOTACMD", "a"); err != nil {
+				return err
+			}
+			return p.Send("ECHO", "a")
+		})
+		require.Error(t, err)
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr))
+		require.Equal(t, 0, rerr.PipelineIndex)
+		require.Len(t, res, 2)
+		require.JSONEq(t, `"a"`, string(res[1].Result))
+	})
+
+	t.Run("Pipelined single failed", func(t *testing.T) {
+		res, err := cli.Pipelined(context.Background(), func(p *Request) error {
+			return p.Send("NOTACMD", "a")
+		})
+		require.Error(t, err)
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr))
+		require.Equal(t, 0, rerr.PipelineIndex)
+		require.Len(t, res, 1)
+		require.NotEmpty(t, res[0].Error)
+	})
+
+	t.Run("Pipelined closure error", func(t *testing.T) {
+		want := errors.New("fail")
+		res, err := cli.Pipelined(context.Background(), func(p *Request) error {
+			if err := p.Send("SET", "k", 10); err != nil {
+				return err
+			}
+			return want
+		})
+		require.ErrorIs(t, err, want)
+		require.Nil(t, res)
+
+		var got string
+		err = cli.NewRequest().ExecOne(&got, "GET", "k")
+		require.NoError(t, err)
+		require.Equal(t, "2", got)
+	})
+
+	t.Run("Pipelined empty", func(t *testing.T) {
+		res, err := cli.Pipelined(context.Background(), func(p *Request) error { return nil })
+		require.NoError(t, err)
+		require.Nil(t, res)
+	})
+
+	t.Run("Pipelined cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := cli.Pipelined(ctx, func(p *Request) error {
+			return p.Send("ECHO", "a")
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("TxPipelined", func(t *testing.T) {
+		res, err := cli.TxPipelined(context.Background(), func(p *Request) error {
+			return p.Send("INCR", "k")
+		})
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.JSONEq(t, `3`, string(res[0].Result))
+	})
+
+	t.Run("TxPipelined discarded", func(t *testing.T) {
+		res, err := cli.TxPipelined(context.Background(), func(p *Request) error {
+			if err := p.Send("INCR", "k"); err != nil {
+				return err
+			}
+			return p.Send("NOTACMD", "a")
+		})
+		require.Error(t, err)
+		require.Nil(t, res)
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr))
+		require.Equal(t, -1, rerr.PipelineIndex)
+	})
 }
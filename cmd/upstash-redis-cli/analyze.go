@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/analyzer"
+)
+
+// maxPrefixes is the maximum number of prefixes printed in the analysis
+// report.
+const maxPrefixes = 20
+
+// analyze runs the analysis of the keyspace and prints its report.
+func (c *cmd) analyze(p *printer, client *upstashdis.Client) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	rep, err := analyzer.Analyze(ctx, client, &analyzer.Options{
+		Count:    c.BatchSize,
+		Interval: c.Interval,
+		NoMemory: c.NoMemory,
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.format == formatJSON {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	writeAnalysis(p.w, rep, !c.NoMemory)
+	return nil
+}
+
+func writeAnalysis(w io.Writer, rep *analyzer.Report, mem bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "Analyzed %d keys", rep.Keys)
+	if mem {
+		fmt.Fprintf(tw, " using %d bytes", rep.Bytes)
+	}
+	fmt.Fprint(tw, "\n\n")
+
+	// the bytes column is only printed if the memory usage is known
+	row := func(label string, keys, bytes, volatile int64) {
+		if mem {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", label, keys, bytes, volatile)
+		} else {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t\n", label, keys, volatile)
+		}
+	}
+	header := func(title string) {
+		if mem {
+			fmt.Fprintf(tw, "%s\tkeys\tbytes\tvolatile\t\n", title)
+		} else {
+			fmt.Fprintf(tw, "%s\tkeys\tvolatile\t\n", title)
+		}
+	}
+
+	writeStats := func(title string, m map[string]*analyzer.Stats, max int, none string) {
+		names := make([]string, 0, len(m))
+		for k := range m {
+			names = append(names, k)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			si, sj := m[names[i]], m[names[j]]
+			if si.Bytes != sj.Bytes {
+				return si.Bytes > sj.Bytes
+			}
+			if si.Keys != sj.Keys {
+				return si.Keys > sj.Keys
+			}
+			return names[i] < names[j]
+		})
+		if max > 0 && len(names) > max {
+			names = names[:max]
+		}
+
+		header(title)
+		for _, name := range names {
+			st := m[name]
+			label := name
+			if label == "" {
+				label = none
+			}
+			row(label, st.Keys, st.Bytes, st.Volatile)
+		}
+		fmt.Fprintln(tw)
+	}
+	writeStats("type", rep.ByType, 0, "")
+	writeStats("prefix", rep.ByPrefix, maxPrefixes, "(none)")
+
+	header("ttl")
+	for _, b := range rep.TTL {
+		row(b.Label, b.Keys, b.Bytes, b.Volatile)
+	}
+
+	if mem && len(rep.Largest) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprint(tw, "largest key\ttype\tbytes\tttl\t\n")
+		for _, ki := range rep.Largest {
+			ttl := "-"
+			if ki.TTL > 0 {
+				ttl = ki.TTL.Round(time.Second).String()
+			}
+			fmt.Fprintf(tw, "%q\t%s\t%d\t%s\t\n", ki.Key, ki.Type, ki.Bytes, ttl)
+		}
+	}
+}
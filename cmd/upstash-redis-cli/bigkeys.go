@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+// keyType describes how the size of a key of a given Redis type is computed
+// in bigkeys mode.
+type keyType struct {
+	sizeCmd string
+	unit    string
+}
+
+var keyTypes = map[string]keyType{
+	"string": {"STRLEN", "bytes"},
+	"list":   {"LLEN", "items"},
+	"set":    {"SCARD", "members"},
+	"hash":   {"HLEN", "fields"},
+	"zset":   {"ZCARD", "members"},
+	"stream": {"XLEN", "entries"},
+}
+
+type typeStats struct {
+	count     int64
+	totalSize int64
+	biggest   string
+	maxSize   int64
+}
+
+// scanReport walks the keyspace with SCAN and reports the biggest keys of
+// each type, either by number of elements (or string length) if mem is false,
+// or by memory usage as reported by MEMORY USAGE if mem is true. It sleeps
+// for the interval between each SCAN call to avoid hitting rate limits.
+func scanReport(w io.Writer, client *upstashdis.Client, count int, interval time.Duration, mem bool) error {
+	var (
+		cursor    = "0"
+		sampled   int64
+		keyLength int64
+		stats     = make(map[string]*typeStats)
+	)
+
+	unit := func(typ string) string {
+		if mem {
+			return "bytes"
+		}
+		if kt, ok := keyTypes[typ]; ok {
+			return kt.unit
+		}
+		return "units"
+	}
+
+	fmt.Fprintln(w, "\n# Scanning the entire keyspace to find biggest keys as well as")
+	fmt.Fprintln(w, "# average sizes per key type. You can use --interval 100ms to sleep")
+	fmt.Fprintln(w, "# between SCAN calls and reduce the rate of requests (e.g. to")
+	fmt.Fprintln(w, "# respect rate limits).")
+	fmt.Fprintln(w)
+
+	for {
+		var scan []json.RawMessage
+		if err := client.NewRequest().ExecOne(&scan, "SCAN", cursor, "COUNT", count); err != nil {
+			return err
+		}
+		if len(scan) != 2 {
+			return fmt.Errorf("unexpected SCAN reply: %d values", len(scan))
+		}
+		var keys []string
+		if err := json.Unmarshal(scan[0], &cursor); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(scan[1], &keys); err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			types, err := keyTypesOf(client, keys)
+			if err != nil {
+				return err
+			}
+			sizes, err := keySizes(client, keys, types, mem)
+			if err != nil {
+				return err
+			}
+
+			for i, key := range keys {
+				typ := types[i]
+				if typ == "none" {
+					// key expired or deleted since the SCAN
+					continue
+				}
+				sampled++
+				keyLength += int64(len(key))
+
+				st := stats[typ]
+				if st == nil {
+					st = &typeStats{}
+					stats[typ] = st
+				}
+				st.count++
+				st.totalSize += sizes[i]
+				if sizes[i] > st.maxSize || st.biggest == "" {
+					st.maxSize = sizes[i]
+					st.biggest = key
+					fmt.Fprintf(w, "Biggest %-6s found so far %q with %d %s\n", typ, key, sizes[i], unit(typ))
+				}
+			}
+		}
+
+		if cursor == "0" {
+			break
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+
+	typeNames := make([]string, 0, len(stats))
+	for typ := range stats {
+		typeNames = append(typeNames, typ)
+	}
+	sort.Strings(typeNames)
+
+	fmt.Fprintln(w, "\n-------- summary -------")
+	fmt.Fprintf(w, "\nSampled %d keys in the keyspace!\n", sampled)
+	var avgLen float64
+	if sampled > 0 {
+		avgLen = float64(keyLength) / float64(sampled)
+	}
+	fmt.Fprintf(w, "Total key length in bytes is %d (avg len %.2f)\n\n", keyLength, avgLen)
+
+	for _, typ := range typeNames {
+		st := stats[typ]
+		fmt.Fprintf(w, "Biggest %-6s found %q has %d %s\n", typ, st.biggest, st.maxSize, unit(typ))
+	}
+	fmt.Fprintln(w)
+	for _, typ := range typeNames {
+		st := stats[typ]
+		fmt.Fprintf(w, "%d %ss with %d %s (%05.2f%% of keys, avg size %.2f)\n", st.count, typ,
+			st.totalSize, unit(typ), float64(st.count)*100/float64(sampled), float64(st.totalSize)/float64(st.count))
+	}
+	return nil
+}
+
+// keyTypesOf returns the type of each key, in a single pipeline.
+func keyTypesOf(client *upstashdis.Client, keys []string) ([]string, error) {
+	req := client.NewRequest()
+	for _, key := range keys {
+		if err := req.Send("TYPE", key); err != nil {
+			return nil, err
+		}
+	}
+
+	types := make([]string, len(keys))
+	dst := make([]interface{}, len(keys))
+	for i := range types {
+		dst[i] = &types[i]
+	}
+	if err := req.Exec(dst...); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// keySizes returns the size of each key, in a single pipeline. Keys of an
+// unknown type have a size of 0.
+func keySizes(client *upstashdis.Client, keys, types []string, mem bool) ([]int64, error) {
+	req := client.NewRequest()
+	sizes := make([]int64, len(keys))
+	var dst []interface{}
+	for i, key := range keys {
+		var err error
+		if mem {
+			err = req.Send("MEMORY", "USAGE", key, "SAMPLES", 0)
+		} else if kt, ok := keyTypes[strings.ToLower(types[i])]; ok {
+			err = req.Send(kt.sizeCmd, key)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, &sizes[i])
+	}
+
+	if len(dst) == 0 {
+		return sizes, nil
+	}
+	if err := req.Exec(dst...); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mna/upstashdis"
+)
+
+// List of supported output formats.
+const (
+	formatTable = "table"
+	formatRaw   = "raw"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+var validFormats = map[string]bool{
+	formatTable: true,
+	formatRaw:   true,
+	formatCSV:   true,
+	formatJSON:  true,
+}
+
+// printer writes the results of commands to w in the requested format.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// decodeResult decodes the raw JSON result of a command into a Go value,
+// preserving numbers as json.Number so that integers are printed as-is.
+func decodeResult(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// result writes the result of a command, either as an error or as a reply.
+func (p *printer) result(res *upstashdis.Result) error {
+	if p.format == formatJSON {
+		return p.writeJSON(res)
+	}
+
+	if res.Error != "" {
+		switch p.format {
+		case formatRaw:
+			fmt.Fprintln(p.w, res.Error)
+		case formatCSV:
+			return p.writeCSV([]string{"ERROR", res.Error})
+		default:
+			fmt.Fprintf(p.w, "(error) %s\n", res.Error)
+		}
+		return nil
+	}
+
+	v, err := decodeResult(res.Result)
+	if err != nil {
+		return err
+	}
+
+	switch p.format {
+	case formatRaw:
+		writeRawReply(p.w, v)
+	case formatCSV:
+		return p.writeCSV(appendCSVFields(nil, v))
+	default:
+		writeTableReply(p.w, v, "")
+	}
+	return nil
+}
+
+// writeJSON writes the JSON result on a single line, or an object with an
+// error field if the command failed.
+func (p *printer) writeJSON(res *upstashdis.Result) error {
+	if res.Error != "" {
+		b, err := json.Marshal(map[string]string{"error": res.Error})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	}
+
+	raw := []byte(res.Result)
+	if len(raw) == 0 {
+		raw = []byte("null")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(p.w)
+	return err
+}
+
+func (p *printer) writeCSV(fields []string) error {
+	cw := csv.NewWriter(p.w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// appendCSVFields flattens v, appending each value to fields.
+func appendCSVFields(fields []string, v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+		return append(fields, "NULL")
+	case []interface{}:
+		for _, elem := range v {
+			fields = appendCSVFields(fields, elem)
+		}
+		return fields
+	default:
+		return append(fields, fmt.Sprint(v))
+	}
+}
+
+// writeRawReply writes the reply v in the same raw format as redis-cli
+// --raw: values are printed as-is, one per line, with nested arrays
+// flattened.
+func writeRawReply(w io.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		fmt.Fprintln(w)
+	case []interface{}:
+		for _, elem := range v {
+			writeRawReply(w, elem)
+		}
+	default:
+		fmt.Fprintln(w, v)
+	}
+}
+
+// writeTableReply writes the reply v in the same human-readable format as
+// redis-cli, with nested arrays indented under their parent's index.
+func writeTableReply(w io.Writer, v interface{}, prefix string) {
+	switch v := v.(type) {
+	case nil:
+		fmt.Fprintln(w, "(nil)")
+	case string:
+		fmt.Fprintln(w, strconv.Quote(v))
+	case json.Number:
+		fmt.Fprintf(w, "(integer) %s\n", v)
+	case bool:
+		if v {
+			fmt.Fprintln(w, "(integer) 1")
+		} else {
+			fmt.Fprintln(w, "(integer) 0")
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			fmt.Fprintln(w, "(empty array)")
+			return
+		}
+
+		width := len(strconv.Itoa(len(v)))
+		for i, elem := range v {
+			if i > 0 {
+				fmt.Fprint(w, prefix)
+			}
+			label := fmt.Sprintf("%*d) ", width, i+1)
+			fmt.Fprint(w, label)
+			writeTableReply(w, elem, prefix+strings.Repeat(" ", len(label)))
+		}
+	default:
+		fmt.Fprintln(w, v)
+	}
+}
@@ -0,0 +1,388 @@
+// Command upstash-redis-cli is an interactive command-line client similar to
+// redis-cli, but that executes the commands via an Upstash Redis REST API
+// endpoint (see [1]). It can be used against Upstash databases as well as
+// any compatible server, such as the upstash-redis-rest-server command.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+)
+
+const binName = "upstash-redis-cli"
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s --url <URL> [--token <TOKEN>] [--output <FORMAT>] [-x]
+       [<command> [<arg>...]]
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s --url <URL> [--token <TOKEN>] [--output <FORMAT>] [-x]
+       [<command> [<arg>...]]
+       %[1]s --url <URL> [--token <TOKEN>] [--output <FORMAT>]
+       [--batch-size <N>] < FILE
+       %[1]s --url <URL> [--token <TOKEN>] --bigkeys|--memkeys
+       [--batch-size <N>] [--interval <DURATION>]
+       %[1]s --url <URL> [--token <TOKEN>] [--output <FORMAT>] --analyze
+       [--no-memory] [--batch-size <N>] [--interval <DURATION>]
+       %[1]s --url <URL> [--token <TOKEN>] [--output <FORMAT>] --ttl-audit
+       [--match <PATTERNS>] [--expiring-within <DURATION>]
+       [--default-ttl <DURATION>] [--batch-size <N>] [--interval <DURATION>]
+       %[1]s --url <URL> [--token <TOKEN>] [--output <FORMAT>]
+       [--filter-cmd <NAMES>] [--filter-key <PATTERN>] monitor
+       %[1]s --url <URL> [--token <TOKEN>] [--output <FORMAT>]
+       subscribe|psubscribe <channel|pattern>...
+       %[1]s --help
+
+Execute Redis commands via an Upstash-compatible Redis REST API. If a
+command is provided as arguments, it is executed and its result is
+printed. Otherwise, if stdin is not a terminal, newline-separated
+commands are read from stdin and executed in pipelines, and if it is a
+terminal, an interactive prompt is started.
+
+In --bigkeys and --memkeys modes, the keyspace is scanned and a report of
+the biggest keys and average sizes per key type is printed. The size of
+a key is its number of elements (or string length) with --bigkeys, and
+its memory usage in bytes with --memkeys (the MEMORY USAGE command must
+be supported by the server).
+
+In --analyze mode, the keyspace is scanned and a report of the number of
+keys and memory usage by type and key prefix, the distribution of TTLs
+and the largest keys is printed, as JSON if --output is 'json'.
+
+In --ttl-audit mode, the keys matching the --match patterns (all keys by
+default) are scanned and the keys without TTL, and optionally the keys
+expiring within the --expiring-within duration, are reported. If
+--default-ttl is set, that TTL is applied to the keys without TTL.
+
+The monitor command streams the commands executed by the server via its
+/monitor endpoint, optionally filtered by command names and key pattern,
+until interrupted with Ctrl-C. It can also be used in interactive mode.
+
+Similarly, the subscribe and psubscribe commands stream the messages
+published on the channels (or the channels matching the patterns) via
+the server's pub/sub endpoint, until interrupted with Ctrl-C.
+
+Valid flag options are:
+       --analyze                 Scan the keyspace and report statistics
+                                 by key type, prefix and TTL.
+       --batch-size N            Maximum number of commands to execute
+                                 in a single pipeline when reading
+                                 commands from stdin, defaults to 100.
+                                 Also used as SCAN's COUNT value in
+                                 --bigkeys, --memkeys, --analyze and
+                                 --ttl-audit modes.
+       --bigkeys                 Scan the keyspace and report the keys
+                                 with the most elements.
+       --default-ttl DURATION    Set this TTL on the keys without TTL in
+                                 --ttl-audit mode.
+       --expiring-within DURATION
+                                 Report the keys that expire within this
+                                 duration in --ttl-audit mode.
+       --filter-cmd NAMES        Comma-separated list of command names to
+                                 print in monitor mode, case-insensitive.
+       --filter-key PATTERN      Glob-style pattern that at least one
+                                 argument of a command must match for it
+                                 to be printed in monitor mode.
+       -h --help                 Show this help.
+       -i --interval DURATION    Sleep for that duration between SCAN
+                                 calls in --bigkeys, --memkeys, --analyze
+                                 and --ttl-audit modes.
+       -m --match PATTERNS       Comma-separated list of glob-style
+                                 patterns of the keys to audit in
+                                 --ttl-audit mode.
+       --memkeys                 Scan the keyspace and report the keys
+                                 that use the most memory.
+       --no-memory               Do not use the MEMORY USAGE command in
+                                 --analyze mode, e.g. if it is not
+                                 supported by the server.
+       -o --output FORMAT        Output format of the results, one of
+                                 'table', 'raw', 'csv' or 'json'.
+                                 Defaults to 'table' if stdout is a
+                                 terminal, 'raw' otherwise.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       --ttl-audit               Scan the keyspace and report the keys
+                                 without TTL.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+       -x                        Read the last argument of the command
+                                 from stdin.
+
+In interactive mode, the command history is saved in the
+$HOME/.upstash_redis_cli_history file, command names can be completed
+with the TAB key and 'quit' or 'exit' terminates the session.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	URL            string        `flag:"u,url" envconfig:"url"`
+	Token          string        `flag:"t,token" envconfig:"token"`
+	Timeout        time.Duration `flag:"timeout" ignored:"true"`
+	BatchSize      int           `flag:"batch-size" ignored:"true"`
+	Output         string        `flag:"o,output" ignored:"true"`
+	BigKeys        bool          `flag:"bigkeys" ignored:"true"`
+	MemKeys        bool          `flag:"memkeys" ignored:"true"`
+	Analyze        bool          `flag:"analyze" ignored:"true"`
+	NoMemory       bool          `flag:"no-memory" ignored:"true"`
+	TTLAudit       bool          `flag:"ttl-audit" ignored:"true"`
+	Match          string        `flag:"m,match" ignored:"true"`
+	ExpiringWithin time.Duration `flag:"expiring-within" ignored:"true"`
+	DefaultTTL     time.Duration `flag:"default-ttl" ignored:"true"`
+	Interval       time.Duration `flag:"i,interval" ignored:"true"`
+	FilterCmd      string        `flag:"filter-cmd" ignored:"true"`
+	FilterKey      string        `flag:"filter-key" ignored:"true"`
+	StdinArg       bool          `flag:"x" ignored:"true"`
+	Help           bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("invalid --batch-size value")
+	}
+	if c.Output != "" && !validFormats[c.Output] {
+		return fmt.Errorf("invalid --output value: %s", c.Output)
+	}
+	var modes int
+	for _, b := range []bool{c.BigKeys, c.MemKeys, c.Analyze, c.TTLAudit} {
+		if b {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return errors.New("--bigkeys, --memkeys, --analyze and --ttl-audit are mutually exclusive")
+	}
+	if c.ExpiringWithin < 0 {
+		return errors.New("invalid --expiring-within value")
+	}
+	if c.DefaultTTL < 0 || (c.DefaultTTL > 0 && c.DefaultTTL < time.Millisecond) {
+		return errors.New("invalid --default-ttl value")
+	}
+	if c.Interval < 0 {
+		return errors.New("invalid --interval value")
+	}
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Timeout = 30 * time.Second
+	c.BatchSize = 100
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+
+	// the command arguments must not be parsed as flags (e.g. LRANGE key 0 -1)
+	args, cmdArgs := splitCommand(args)
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+	c.args = append(c.args, cmdArgs...)
+	if (c.BigKeys || c.MemKeys || c.Analyze || c.TTLAudit) && len(c.args) > 0 {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: unexpected command with --bigkeys, --memkeys, --analyze or --ttl-audit\n%s", shortUsage)
+		return mainer.InvalidArgs
+	}
+	if c.StdinArg && len(c.args) == 0 {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: -x requires a command\n%s", shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	pr := &printer{w: stdio.Stdout, format: c.Output}
+	if pr.format == "" {
+		pr.format = formatRaw
+		if isTerminal(stdio.Stdout) {
+			pr.format = formatTable
+		}
+	}
+
+	client := &upstashdis.Client{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout},
+	}
+
+	if c.BigKeys || c.MemKeys {
+		if err := scanReport(stdio.Stdout, client, c.BatchSize, c.Interval, c.MemKeys); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if c.Analyze {
+		if err := c.analyze(pr, client); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if c.TTLAudit {
+		if err := c.ttlAudit(pr, client); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if len(c.args) == 1 && strings.EqualFold(c.args[0], "monitor") {
+		if err := c.monitor(pr); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if isSubscribeCmd(c.args) {
+		if err := c.subscribe(pr, c.args); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if len(c.args) > 0 {
+		if c.StdinArg {
+			arg, err := readLastArg(stdio.Stdin)
+			if err != nil {
+				fmt.Fprintf(stdio.Stderr, "failed to read stdin: %s\n", err)
+				return mainer.Failure
+			}
+			c.args = append(c.args, arg)
+		}
+
+		if err := execCmd(pr, client, c.args); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if !isTerminal(stdio.Stdin) {
+		if err := execPipe(pr, stdio.Stdin, client, c.BatchSize); err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s\n", err)
+			return mainer.Failure
+		}
+		return mainer.Success
+	}
+
+	if err := c.repl(stdio, pr, client); err != nil {
+		fmt.Fprintf(stdio.Stderr, "%s\n", err)
+		return mainer.Failure
+	}
+	return mainer.Success
+}
+
+// monitor runs the monitor mode until it is interrupted.
+func (c *cmd) monitor(p *printer) error {
+	f, err := newMonitorFilter(c.FilterCmd, c.FilterKey)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	return monitor(ctx, p, c.URL, c.Token, f)
+}
+
+// subscribe runs the subscribe mode until it is interrupted.
+func (c *cmd) subscribe(p *printer, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	return subscribe(ctx, p, c.URL, c.Token, args[0], args[1:])
+}
+
+// isSubscribeCmd returns true if args is a SUBSCRIBE or PSUBSCRIBE command
+// with at least one channel or pattern.
+func isSubscribeCmd(args []string) bool {
+	return len(args) > 1 && (strings.EqualFold(args[0], "subscribe") || strings.EqualFold(args[0], "psubscribe"))
+}
+
+// flagsWithValue is the set of flags that take a value.
+var flagsWithValue = map[string]bool{
+	"u":               true,
+	"url":             true,
+	"t":               true,
+	"token":           true,
+	"timeout":         true,
+	"batch-size":      true,
+	"o":               true,
+	"output":          true,
+	"i":               true,
+	"interval":        true,
+	"filter-cmd":      true,
+	"filter-key":      true,
+	"m":               true,
+	"match":           true,
+	"expiring-within": true,
+	"default-ttl":     true,
+}
+
+// splitCommand splits args in two parts: the program name and flags, and the
+// Redis command and its arguments (the first non-flag argument and all those
+// that follow).
+func splitCommand(args []string) (flags, cmd []string) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return args[:i], args[i:]
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if !strings.Contains(name, "=") && flagsWithValue[name] {
+			// skip the flag's value
+			i++
+		}
+	}
+	return args, nil
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
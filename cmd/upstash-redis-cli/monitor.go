@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+// monitorLine is a command executed on the server, as reported by MONITOR.
+type monitorLine struct {
+	Time    time.Time `json:"time"`
+	DB      string    `json:"db"`
+	Client  string    `json:"client"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args"`
+}
+
+// monitorFilter filters the monitored commands by name and key pattern.
+type monitorFilter struct {
+	cmds   map[string]bool
+	keyPat string
+}
+
+func newMonitorFilter(cmds, keyPat string) (*monitorFilter, error) {
+	var f monitorFilter
+	if cmds != "" {
+		f.cmds = make(map[string]bool)
+		for _, c := range strings.Split(cmds, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				f.cmds[strings.ToLower(c)] = true
+			}
+		}
+	}
+	if keyPat != "" {
+		if _, err := path.Match(keyPat, ""); err != nil {
+			return nil, fmt.Errorf("invalid key pattern: %w", err)
+		}
+		f.keyPat = keyPat
+	}
+	return &f, nil
+}
+
+// match returns true if the command is kept by the filter: if it is one of
+// the filter's command names (if any), and if at least one of its arguments
+// matches the key pattern (if any).
+func (f *monitorFilter) match(ml *monitorLine) bool {
+	if f.cmds != nil && !f.cmds[strings.ToLower(ml.Command)] {
+		return false
+	}
+	if f.keyPat == "" {
+		return true
+	}
+	for _, arg := range ml.Args {
+		if ok, _ := path.Match(f.keyPat, arg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMonitorLine parses a line in the MONITOR format, e.g.:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func parseMonitorLine(s string) (*monitorLine, error) {
+	ts, rest, ok := strings.Cut(s, " ")
+	if !ok || !strings.HasPrefix(rest, "[") {
+		return nil, errors.New("invalid monitor line")
+	}
+	info, rest, ok := strings.Cut(rest[1:], "] ")
+	if !ok {
+		return nil, errors.New("invalid monitor line")
+	}
+
+	var ml monitorLine
+	secs, frac, _ := strings.Cut(ts, ".")
+	isecs, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid monitor timestamp: %w", err)
+	}
+	var nsecs int64
+	if frac != "" {
+		// right-pad to nanoseconds precision
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsecs, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid monitor timestamp: %w", err)
+		}
+	}
+	ml.Time = time.Unix(isecs, nsecs)
+	ml.DB, ml.Client, _ = strings.Cut(info, " ")
+
+	args, err := rediscmd.SplitArgs(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("invalid monitor line: no command")
+	}
+	ml.Command, ml.Args = args[0], args[1:]
+	return &ml, nil
+}
+
+// monitor streams the commands executed on the server via the /monitor
+// endpoint and prints those that match the filter until the context is
+// cancelled or the stream ends.
+func monitor(ctx context.Context, p *printer, baseURL, token string, f *monitorFilter) error {
+	body, err := openStream(ctx, baseURL, token, "monitor")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = readSSE(body, func(ev sseEvent) error {
+		if ev.Data == "OK" {
+			// initial reply to the MONITOR command
+			return nil
+		}
+		ml, err := parseMonitorLine(ev.Data)
+		if err != nil {
+			// print as-is, it may be a format not supported by this version
+			fmt.Fprintln(p.w, ev.Data)
+			return nil
+		}
+		if !f.match(ml) {
+			return nil
+		}
+		return p.monitorLine(ml)
+	})
+	if ctx.Err() != nil {
+		// cancelled by the user
+		return nil
+	}
+	return err
+}
+
+// monitorLine prints the monitored command.
+func (p *printer) monitorLine(ml *monitorLine) error {
+	switch p.format {
+	case formatJSON:
+		b, err := json.Marshal(ml)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+
+	case formatCSV:
+		fields := append([]string{ml.Time.Format(time.RFC3339Nano), ml.DB, ml.Client, ml.Command}, ml.Args...)
+		return p.writeCSV(fields)
+
+	default:
+		var sb strings.Builder
+		sb.WriteString(ml.Time.Format("15:04:05.000000"))
+		fmt.Fprintf(&sb, " [%s %s] %s", ml.DB, ml.Client, strings.ToUpper(ml.Command))
+		for _, arg := range ml.Args {
+			sb.WriteByte(' ')
+			if p.format == formatRaw {
+				sb.WriteString(arg)
+			} else {
+				sb.WriteString(strconv.Quote(arg))
+			}
+		}
+		_, err := fmt.Fprintln(p.w, sb.String())
+		return err
+	}
+}
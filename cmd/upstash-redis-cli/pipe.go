@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+// isTerminal returns true if v is a terminal (character device).
+func isTerminal(v interface{}) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readLastArg reads all of r and returns it as an argument, with the trailing
+// newline removed.
+func readLastArg(r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+}
+
+// execPipe reads newline-separated commands from r and executes them in
+// pipelines of at most batchSize commands, printing the results as each
+// pipeline completes. Empty lines are ignored.
+func execPipe(p *printer, r io.Reader, client *upstashdis.Client, batchSize int) error {
+	var (
+		lineNum int
+		pending int
+		req     = client.NewRequest()
+	)
+
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		pending = 0
+
+		res, err := req.ExecRaw()
+		if err != nil {
+			return err
+		}
+		for _, r := range res {
+			if err := p.result(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 512*1024*1024)
+	for sc.Scan() {
+		lineNum++
+		args, err := rediscmd.SplitArgs(sc.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if err := req.Send(args[0], stringsToArgs(args[1:])...); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		pending++
+		if pending >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func stringsToArgs(args []string) []interface{} {
+	iargs := make([]interface{}, len(args))
+	for i, arg := range args {
+		iargs[i] = arg
+	}
+	return iargs
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/internal/rediscmd"
+	"github.com/peterh/liner"
+)
+
+const historyFile = ".upstash_redis_cli_history"
+
+// execCmd executes the command made of args and prints its result. An error
+// is returned only if the request failed, a command that returns an error is
+// printed as a normal result.
+func execCmd(p *printer, client *upstashdis.Client, args []string) error {
+	var raw json.RawMessage
+	err := client.NewRequest().ExecOne(&raw, args[0], stringsToArgs(args[1:])...)
+	if err != nil {
+		var rerr *upstashdis.Error
+		if errors.As(err, &rerr) {
+			return p.result(&upstashdis.Result{Error: rerr.Message})
+		}
+		return err
+	}
+	return p.result(&upstashdis.Result{Result: raw})
+}
+
+// repl runs the interactive read-eval-print loop until the user exits.
+func (c *cmd) repl(stdio mainer.Stdio, p *printer, client *upstashdis.Client) error {
+	line := liner.NewLiner()
+	defer line.Close()
+
+	line.SetCtrlCAborts(true)
+	line.SetTabCompletionStyle(liner.TabPrints)
+	line.SetCompleter(completeCommand)
+
+	var histPath string
+	if home, err := os.UserHomeDir(); err == nil {
+		histPath = filepath.Join(home, historyFile)
+		if f, err := os.Open(histPath); err == nil {
+			_, _ = line.ReadHistory(f)
+			f.Close()
+		}
+	}
+	defer func() {
+		if histPath == "" {
+			return
+		}
+		if f, err := os.OpenFile(histPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err == nil {
+			_, _ = line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	prompt := c.URL
+	if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+		prompt = u.Host
+	}
+	prompt += "> "
+
+	for {
+		input, err := line.Prompt(prompt)
+		if err != nil {
+			if errors.Is(err, liner.ErrPromptAborted) || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		args, err := rediscmd.SplitArgs(input)
+		if err != nil {
+			fmt.Fprintln(stdio.Stdout, err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		line.AppendHistory(input)
+
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return nil
+		case "monitor":
+			if len(args) == 1 {
+				if err := c.monitor(p); err != nil {
+					fmt.Fprintf(stdio.Stdout, "(error) %s\n", err)
+				}
+				continue
+			}
+		}
+		if isSubscribeCmd(args) {
+			if err := c.subscribe(p, args); err != nil {
+				fmt.Fprintf(stdio.Stdout, "(error) %s\n", err)
+			}
+			continue
+		}
+
+		if err := execCmd(p, client, args); err != nil {
+			fmt.Fprintf(stdio.Stdout, "(error) %s\n", err)
+		}
+	}
+}
+
+func completeCommand(line string) []string {
+	if strings.ContainsAny(line, " \t") {
+		// only the command name is completed
+		return nil
+	}
+
+	// complete using the same case as the input
+	upper := strings.ToUpper(line)
+	lower := line != upper && line == strings.ToLower(line)
+
+	var matches []string
+	for _, name := range commandNames {
+		if strings.HasPrefix(name, upper) {
+			if lower {
+				name = strings.ToLower(name)
+			}
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
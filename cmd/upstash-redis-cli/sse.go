@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// sseEvent is a server-sent event.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// openStream starts a streaming request to the endpoint path under baseURL
+// and returns the response body. The caller must close the body when done.
+func openStream(ctx context.Context, baseURL, token, endpoint string) (io.ReadCloser, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, endpoint)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// no timeout for the streaming request, it is cancelled via the context
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		if len(b) == 0 {
+			b = []byte(res.Status)
+		}
+		return nil, fmt.Errorf("[%d]: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return res.Body, nil
+}
+
+// readSSE reads server-sent events from r and calls fn for each event, until
+// r returns an error or fn returns an error.
+func readSSE(r io.Reader, fn func(sseEvent) error) error {
+	var (
+		ev   sseEvent
+		data []string
+	)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 512*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			// dispatch the event
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+			ev, data = sseEvent{}, data[:0]
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment
+			continue
+		}
+
+		field, val, _ := strings.Cut(line, ":")
+		val = strings.TrimPrefix(val, " ")
+		switch field {
+		case "event":
+			ev.Event = val
+		case "data":
+			data = append(data, val)
+		}
+	}
+	return sc.Err()
+}
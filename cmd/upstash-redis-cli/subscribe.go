@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// pubsubMessage is a message received in subscribe mode.
+type pubsubMessage struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern,omitempty"`
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+	Count   *int64 `json:"count,omitempty"`
+}
+
+// parsePubsubData parses the data of a pub/sub server-sent event, e.g.:
+//
+//	subscribe,chat,1
+//	message,chat,hello
+//	pmessage,ch*,chat,hello
+func parsePubsubData(data string) *pubsubMessage {
+	kind, rest, _ := strings.Cut(data, ",")
+	msg := &pubsubMessage{Kind: kind}
+
+	switch strings.ToLower(kind) {
+	case "message":
+		msg.Channel, msg.Message, _ = strings.Cut(rest, ",")
+	case "pmessage":
+		parts := strings.SplitN(rest, ",", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+		msg.Pattern, msg.Channel, msg.Message = parts[0], parts[1], parts[2]
+	default:
+		// subscribe, unsubscribe, psubscribe, punsubscribe
+		var count string
+		msg.Channel, count, _ = strings.Cut(rest, ",")
+		if n, err := strconv.ParseInt(count, 10, 64); err == nil {
+			msg.Count = &n
+		}
+	}
+	return msg
+}
+
+// subscribe subscribes to the channels (or patterns, depending on cmdName)
+// via the SSE endpoint and prints the messages as they arrive, until the
+// context is cancelled or the stream ends.
+func subscribe(ctx context.Context, p *printer, baseURL, token, cmdName string, channels []string) error {
+	endpoint := path.Join(append([]string{strings.ToLower(cmdName)}, channels...)...)
+	body, err := openStream(ctx, baseURL, token, endpoint)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = readSSE(body, func(ev sseEvent) error {
+		return p.pubsubMessage(parsePubsubData(ev.Data))
+	})
+	if ctx.Err() != nil {
+		// cancelled by the user
+		return nil
+	}
+	return err
+}
+
+// pubsubMessage prints the pub/sub message.
+func (p *printer) pubsubMessage(msg *pubsubMessage) error {
+	if p.format == formatJSON {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	}
+
+	// print as an array reply, like redis-cli does
+	reply := []interface{}{msg.Kind}
+	if msg.Pattern != "" {
+		reply = append(reply, msg.Pattern)
+	}
+	reply = append(reply, msg.Channel)
+	if msg.Count != nil {
+		reply = append(reply, json.Number(strconv.FormatInt(*msg.Count, 10)))
+	} else {
+		reply = append(reply, msg.Message)
+	}
+
+	switch p.format {
+	case formatRaw:
+		writeRawReply(p.w, reply)
+	case formatCSV:
+		return p.writeCSV(appendCSVFields(nil, reply))
+	default:
+		writeTableReply(p.w, reply, "")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/analyzer"
+)
+
+// ttlAudit runs the audit of the TTLs of the keys and prints its result.
+func (c *cmd) ttlAudit(p *printer, client *upstashdis.Client) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var patterns []string
+	for _, pat := range strings.Split(c.Match, ",") {
+		if pat = strings.TrimSpace(pat); pat != "" {
+			patterns = append(patterns, pat)
+		}
+	}
+
+	audit, err := analyzer.AuditTTL(ctx, client, &analyzer.TTLAuditOptions{
+		Patterns:       patterns,
+		Count:          c.BatchSize,
+		Interval:       c.Interval,
+		ExpiringWithin: c.ExpiringWithin,
+		DefaultTTL:     c.DefaultTTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.format == formatJSON {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(audit)
+	}
+	writeTTLAudit(p.w, audit, c.ExpiringWithin, c.DefaultTTL)
+	return nil
+}
+
+func writeTTLAudit(w io.Writer, audit *analyzer.TTLAudit, within, defTTL time.Duration) {
+	fmt.Fprintf(w, "Audited %d keys\n", audit.Keys)
+
+	fmt.Fprintf(w, "\n%d keys without TTL", audit.NoTTLCount)
+	if n := int64(len(audit.NoTTL)); n < audit.NoTTLCount {
+		fmt.Fprintf(w, " (first %d listed)", n)
+	}
+	fmt.Fprintln(w)
+	for _, key := range audit.NoTTL {
+		fmt.Fprintf(w, "  %q\n", key)
+	}
+
+	if within > 0 {
+		fmt.Fprintf(w, "\n%d keys expiring within %s", audit.ExpiringCount, within)
+		if n := int64(len(audit.Expiring)); n < audit.ExpiringCount {
+			fmt.Fprintf(w, " (first %d listed)", n)
+		}
+		fmt.Fprintln(w)
+		for _, kt := range audit.Expiring {
+			fmt.Fprintf(w, "  %q expires in %s\n", kt.Key, kt.TTL.Round(time.Second))
+		}
+	}
+
+	if defTTL > 0 {
+		fmt.Fprintf(w, "\nSet a TTL of %s on %d keys\n", defTTL, audit.Applied)
+	}
+}
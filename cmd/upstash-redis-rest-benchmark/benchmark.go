@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+type benchmark struct {
+	client   *upstashdis.Client
+	clients  int
+	dataSize int
+	duration time.Duration
+	requests int
+	pipeline int
+	keyspace int
+	mix      []weightedTest
+
+	started int64 // number of requests started, atomically accessed
+}
+
+// stats are the statistics collected by a single client (or aggregated for
+// all clients).
+type stats struct {
+	requests  int
+	commands  int
+	errors    int
+	firstErr  string
+	latencies []time.Duration
+	byCmd     map[string]*cmdStats
+}
+
+type cmdStats struct {
+	count     int
+	errors    int
+	latencies []time.Duration
+}
+
+type results struct {
+	elapsed time.Duration
+	stats
+}
+
+func (b *benchmark) printHeader(w io.Writer, url string) {
+	var total int
+	for _, t := range b.mix {
+		total += t.weight
+	}
+	var mix []string
+	for _, t := range b.mix {
+		mix = append(mix, fmt.Sprintf("%s=%.1f%%", t.name, float64(t.weight)*100/float64(total)))
+	}
+
+	limit := b.duration.String()
+	if b.requests > 0 {
+		limit = strconv.Itoa(b.requests) + " requests"
+	}
+	fmt.Fprintf(w, "Benchmarking %s for %s with %d client(s), pipeline %d, %d bytes payload\n", url, limit, b.clients, b.pipeline, b.dataSize)
+	fmt.Fprintf(w, "Command mix: %s\n\n", strings.Join(mix, " "))
+}
+
+// run runs the benchmark until the duration expires, the number of requests
+// is reached or ctx is cancelled.
+func (b *benchmark) run(ctx context.Context) (*results, error) {
+	if b.requests == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.duration)
+		defer cancel()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		all      = make([]*stats, b.clients)
+	)
+
+	start := time.Now()
+	for i := 0; i < b.clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			st, err := b.runClient(ctx, rand.New(rand.NewSource(start.UnixNano()+int64(i))))
+			all[i] = st
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	res := &results{elapsed: time.Since(start)}
+	res.byCmd = make(map[string]*cmdStats)
+	for _, st := range all {
+		res.requests += st.requests
+		res.commands += st.commands
+		res.errors += st.errors
+		if res.firstErr == "" {
+			res.firstErr = st.firstErr
+		}
+		res.latencies = append(res.latencies, st.latencies...)
+		for name, cs := range st.byCmd {
+			rcs := res.byCmd[name]
+			if rcs == nil {
+				rcs = &cmdStats{}
+				res.byCmd[name] = rcs
+			}
+			rcs.count += cs.count
+			rcs.errors += cs.errors
+			rcs.latencies = append(rcs.latencies, cs.latencies...)
+		}
+	}
+
+	if res.requests == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return res, nil
+}
+
+func (b *benchmark) runClient(ctx context.Context, rnd *rand.Rand) (*stats, error) {
+	var totalWeight int
+	for _, t := range b.mix {
+		totalWeight += t.weight
+	}
+
+	val := strings.Repeat("x", b.dataSize)
+	st := &stats{byCmd: make(map[string]*cmdStats)}
+	names := make([]string, b.pipeline)
+
+	for ctx.Err() == nil {
+		if b.requests > 0 && atomic.AddInt64(&b.started, 1) > int64(b.requests) {
+			break
+		}
+
+		req := b.client.NewRequest()
+		for i := 0; i < b.pipeline; i++ {
+			test := pickTest(b.mix, totalWeight, rnd)
+			key := "0"
+			if b.keyspace > 0 {
+				key = strconv.Itoa(rnd.Intn(b.keyspace))
+			}
+			cmd, args := test.fn(key, val)
+			if err := req.Send(cmd, args...); err != nil {
+				return st, err
+			}
+			names[i] = test.name
+		}
+
+		t0 := time.Now()
+		res, err := req.ExecRaw()
+		lat := time.Since(t0)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+				break
+			}
+			st.errors += b.pipeline
+			st.requests++
+			if st.firstErr == "" {
+				st.firstErr = err.Error()
+			}
+			continue
+		}
+
+		st.requests++
+		st.commands += len(res)
+		st.latencies = append(st.latencies, lat)
+		for i, r := range res {
+			if i >= len(names) {
+				break
+			}
+			cs := st.byCmd[names[i]]
+			if cs == nil {
+				cs = &cmdStats{}
+				st.byCmd[names[i]] = cs
+			}
+			cs.count++
+			cs.latencies = append(cs.latencies, lat)
+			if r.Error != "" {
+				cs.errors++
+				st.errors++
+				if st.firstErr == "" {
+					st.firstErr = r.Error
+				}
+			}
+		}
+	}
+	return st, nil
+}
+
+func pickTest(mix []weightedTest, totalWeight int, rnd *rand.Rand) weightedTest {
+	if len(mix) == 1 {
+		return mix[0]
+	}
+	n := rnd.Intn(totalWeight)
+	for _, t := range mix {
+		if n < t.weight {
+			return t
+		}
+		n -= t.weight
+	}
+	return mix[len(mix)-1]
+}
+
+func (r *results) print(w io.Writer) {
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "Completed in %s\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  requests: %d (%.2f req/s)\n", r.requests, float64(r.requests)/secs)
+	fmt.Fprintf(w, "  commands: %d (%.2f cmd/s)\n", r.commands, float64(r.commands)/secs)
+	fmt.Fprintf(w, "  errors:   %d\n", r.errors)
+	if r.firstErr != "" {
+		fmt.Fprintf(w, "  first error: %s\n", r.firstErr)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "latency (ms)\tcount\terrors\tmin\tp50\tp90\tp99\tmax\t")
+	writeLatencyRow(tw, "all requests", r.requests, r.errors, r.latencies)
+
+	names := make([]string, 0, len(r.byCmd))
+	for name := range r.byCmd {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cs := r.byCmd[name]
+		writeLatencyRow(tw, name, cs.count, cs.errors, cs.latencies)
+	}
+	tw.Flush()
+}
+
+func writeLatencyRow(w io.Writer, label string, count, errors int, lats []time.Duration) {
+	sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n", label, count, errors,
+		fmtMillis(lats, 0), fmtMillis(lats, 50), fmtMillis(lats, 90), fmtMillis(lats, 99), fmtMillis(lats, 100))
+}
+
+// fmtMillis formats the percentile p of the sorted latencies in
+// milliseconds.
+func fmtMillis(sorted []time.Duration, p int) string {
+	if len(sorted) == 0 {
+		return "-"
+	}
+	ix := (len(sorted) - 1) * p / 100
+	return strconv.FormatFloat(float64(sorted[ix])/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,192 @@
+// Command upstash-redis-rest-benchmark benchmarks an Upstash Redis REST API
+// endpoint (see [1]), similar to what redis-benchmark does for the Redis
+// protocol. It executes a configurable mix of commands with a number of
+// concurrent clients and reports the throughput and latency percentiles.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+)
+
+const binName = "upstash-redis-rest-benchmark"
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s --url <URL> [--token <TOKEN>] [<option>...]
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s --url <URL> [--token <TOKEN>] [<option>...]
+       %[1]s --help
+
+Benchmark an Upstash-compatible Redis REST API endpoint by executing a
+mix of commands with concurrent clients, and report the throughput and
+latency percentiles. Note that the benchmark writes to the database
+(keys are prefixed with "benchmark:").
+
+Valid flag options are:
+       -c --clients N            Number of concurrent clients, defaults
+                                 to 10.
+       -d --data-size N          Size in bytes of the values used in
+                                 write commands, defaults to 3.
+       -D --duration DURATION    Duration of the benchmark, defaults to
+                                 10s. Ignored if --requests is set.
+       -h --help                 Show this help.
+       -n --requests N           Total number of HTTP requests to
+                                 execute. If set, the benchmark runs
+                                 until that number is reached.
+       -P --pipeline N           Number of commands per HTTP request,
+                                 defaults to 1 (no pipeline).
+       -r --keyspace N           Use random keys in the range [0, N)
+                                 instead of a single key per command.
+       --tests MIX               Comma-separated list of commands to
+                                 execute, each optionally followed by
+                                 ':WEIGHT' (default weight is 1), e.g.
+                                 'get:3,set:1'. Defaults to 'get,set'.
+                                 Supported commands are: %s.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName, supportedTests())
+)
+
+type cmd struct {
+	URL      string        `flag:"u,url" envconfig:"url"`
+	Token    string        `flag:"t,token" envconfig:"token"`
+	Clients  int           `flag:"c,clients" ignored:"true"`
+	DataSize int           `flag:"d,data-size" ignored:"true"`
+	Duration time.Duration `flag:"D,duration" ignored:"true"`
+	Requests int           `flag:"n,requests" ignored:"true"`
+	Pipeline int           `flag:"P,pipeline" ignored:"true"`
+	Keyspace int           `flag:"r,keyspace" ignored:"true"`
+	Tests    string        `flag:"tests" ignored:"true"`
+	Timeout  time.Duration `flag:"timeout" ignored:"true"`
+	Help     bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+	mix  []weightedTest
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) > 0 {
+		return errors.New("unexpected arguments provided")
+	}
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Clients <= 0 {
+		return errors.New("invalid --clients value")
+	}
+	if c.DataSize < 0 {
+		return errors.New("invalid --data-size value")
+	}
+	if c.Duration <= 0 && c.Requests <= 0 {
+		return errors.New("invalid --duration value")
+	}
+	if c.Requests < 0 {
+		return errors.New("invalid --requests value")
+	}
+	if c.Pipeline <= 0 {
+		return errors.New("invalid --pipeline value")
+	}
+	if c.Keyspace < 0 {
+		return errors.New("invalid --keyspace value")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+
+	mix, err := parseMix(c.Tests)
+	if err != nil {
+		return err
+	}
+	c.mix = mix
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Clients = 10
+	c.DataSize = 3
+	c.Duration = 10 * time.Second
+	c.Pipeline = 1
+	c.Tests = "get,set"
+	c.Timeout = 30 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	// make sure the HTTP connections are reused by the concurrent clients
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = c.Clients
+	client := &upstashdis.Client{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout, Transport: tr},
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	b := &benchmark{
+		client:   client,
+		clients:  c.Clients,
+		dataSize: c.DataSize,
+		duration: c.Duration,
+		requests: c.Requests,
+		pipeline: c.Pipeline,
+		keyspace: c.Keyspace,
+		mix:      c.mix,
+	}
+	b.printHeader(stdio.Stdout, c.URL)
+	res, err := b.run(ctx)
+	if err != nil {
+		fmt.Fprintf(stdio.Stderr, "benchmark failed: %s\n", err)
+		return mainer.Failure
+	}
+	res.print(stdio.Stdout)
+	return mainer.Success
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// testFunc returns the command and arguments to execute for a test, given
+// the key and value to use.
+type testFunc func(key, val string) (string, []interface{})
+
+var tests = map[string]testFunc{
+	"ping":  func(_, _ string) (string, []interface{}) { return "PING", nil },
+	"set":   func(k, v string) (string, []interface{}) { return "SET", []interface{}{"benchmark:key:" + k, v} },
+	"get":   func(k, _ string) (string, []interface{}) { return "GET", []interface{}{"benchmark:key:" + k} },
+	"incr":  func(k, _ string) (string, []interface{}) { return "INCR", []interface{}{"benchmark:counter:" + k} },
+	"lpush": func(k, v string) (string, []interface{}) { return "LPUSH", []interface{}{"benchmark:list:" + k, v} },
+	"rpush": func(k, v string) (string, []interface{}) { return "RPUSH", []interface{}{"benchmark:list:" + k, v} },
+	"lpop":  func(k, _ string) (string, []interface{}) { return "LPOP", []interface{}{"benchmark:list:" + k} },
+	"rpop":  func(k, _ string) (string, []interface{}) { return "RPOP", []interface{}{"benchmark:list:" + k} },
+	"sadd":  func(k, v string) (string, []interface{}) { return "SADD", []interface{}{"benchmark:set:" + k, v} },
+	"spop":  func(k, _ string) (string, []interface{}) { return "SPOP", []interface{}{"benchmark:set:" + k} },
+	"hset": func(k, v string) (string, []interface{}) {
+		return "HSET", []interface{}{"benchmark:hash:" + k, "field:" + k, v}
+	},
+	"zadd": func(k, v string) (string, []interface{}) {
+		return "ZADD", []interface{}{"benchmark:zset:" + k, len(k), v}
+	},
+	"lrange_100": func(k, _ string) (string, []interface{}) {
+		return "LRANGE", []interface{}{"benchmark:list:" + k, 0, 99}
+	},
+	"mset": func(k, v string) (string, []interface{}) {
+		args := make([]interface{}, 0, 20)
+		for i := 0; i < 10; i++ {
+			args = append(args, "benchmark:key:"+k+":"+strconv.Itoa(i), v)
+		}
+		return "MSET", args
+	},
+}
+
+func supportedTests() string {
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+type weightedTest struct {
+	name   string
+	fn     testFunc
+	weight int
+}
+
+// parseMix parses the comma-separated list of tests with optional weights.
+func parseMix(s string) ([]weightedTest, error) {
+	var mix []weightedTest
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, sw, hasWeight := strings.Cut(part, ":")
+		name = strings.ToLower(name)
+		fn, ok := tests[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported test: %s", name)
+		}
+
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(sw)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight for test %s: %s", name, sw)
+			}
+			weight = w
+		}
+		mix = append(mix, weightedTest{name: name, fn: fn, weight: weight})
+	}
+
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("no test specified")
+	}
+	return mix, nil
+}
@@ -0,0 +1,218 @@
+// Command upstash-redis-rest-dump exports the keys of a database to a dump
+// file and imports them in another (or the same) database, via an Upstash
+// Redis REST API endpoint (see [1]).
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/dump"
+)
+
+const binName = "upstash-redis-rest-dump"
+
+const (
+	actionExport = "export"
+	actionImport = "import"
+)
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s export|import --url <URL> [--token <TOKEN>] [<option>...]
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s export --url <URL> [--token <TOKEN>] [--file <FILE>]
+       [--match <PATTERN>] [--count <N>]
+       %[1]s import --url <URL> [--token <TOKEN>] [--file <FILE>]
+       [--replace] [--batch-size <N>]
+       %[1]s --help
+
+Export the keys of the database served by the Upstash-compatible Redis
+REST API endpoint to a dump file, or import the keys of a dump file in
+the database.
+
+The dump file contains one JSON object per line, with the key, its type,
+its value and its remaining time to live in milliseconds (the TTL is
+applied relative to the time of the import). Supported types are
+strings, lists, sets, hashes, sorted sets and streams; keys of other
+types are not exported.
+
+Valid flag options are:
+       --batch-size N            Number of keys imported in a single
+                                 pipeline request, defaults to 100.
+       --count N                 COUNT value of the SCAN command used to
+                                 export the keys, defaults to 100.
+       -f --file FILE            Write the dump to FILE on export, and
+                                 read it from FILE on import. Defaults to
+                                 stdout and stdin, respectively.
+       -h --help                 Show this help.
+       -m --match PATTERN        Only export the keys matching this
+                                 glob-style pattern.
+       --replace                 Replace the keys that already exist in
+                                 the database on import. By default,
+                                 those keys are skipped.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	URL       string        `flag:"u,url" envconfig:"url"`
+	Token     string        `flag:"t,token" envconfig:"token"`
+	File      string        `flag:"f,file" ignored:"true"`
+	Match     string        `flag:"m,match" ignored:"true"`
+	Count     int           `flag:"count" ignored:"true"`
+	BatchSize int           `flag:"batch-size" ignored:"true"`
+	Replace   bool          `flag:"replace" ignored:"true"`
+	Timeout   time.Duration `flag:"timeout" ignored:"true"`
+	Help      bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) == 0 {
+		return errors.New("no action provided")
+	}
+	switch c.args[0] {
+	case actionExport, actionImport:
+	default:
+		return fmt.Errorf("unknown action: %s", c.args[0])
+	}
+	if len(c.args) > 1 {
+		return errors.New("unexpected arguments provided")
+	}
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Count <= 0 {
+		return errors.New("invalid --count value")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("invalid --batch-size value")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Count = 100
+	c.BatchSize = 100
+	c.Timeout = 30 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	client := &upstashdis.Client{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout},
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var err error
+	if c.args[0] == actionExport {
+		err = c.export(ctx, client, stdio)
+	} else {
+		err = c.imp(ctx, client, stdio)
+	}
+	if err != nil {
+		fmt.Fprintf(stdio.Stderr, "%s failed: %s\n", c.args[0], err)
+		return mainer.Failure
+	}
+	return mainer.Success
+}
+
+func (c *cmd) export(ctx context.Context, client *upstashdis.Client, stdio mainer.Stdio) error {
+	if c.File == "" {
+		return c.exportTo(ctx, client, stdio.Stdout, stdio)
+	}
+
+	f, err := os.Create(c.File)
+	if err != nil {
+		return err
+	}
+	if err := c.exportTo(ctx, client, f, stdio); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *cmd) exportTo(ctx context.Context, client *upstashdis.Client, w io.Writer, stdio mainer.Stdio) error {
+	n, err := dump.Export(ctx, client, w, &dump.ExportOptions{
+		Match: c.Match,
+		Count: c.Count,
+	})
+	fmt.Fprintf(stdio.Stderr, "exported %d key(s)\n", n)
+	return err
+}
+
+func (c *cmd) imp(ctx context.Context, client *upstashdis.Client, stdio mainer.Stdio) error {
+	var r io.Reader = stdio.Stdin
+	if c.File != "" {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, skipped, err := dump.Import(ctx, client, r, &dump.ImportOptions{
+		Replace:   c.Replace,
+		BatchSize: c.BatchSize,
+	})
+	fmt.Fprintf(stdio.Stderr, "imported %d key(s), skipped %d existing key(s)\n", imported, skipped)
+	return err
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
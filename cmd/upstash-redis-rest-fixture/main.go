@@ -0,0 +1,212 @@
+// Command upstash-redis-rest-fixture records golden fixtures of the Upstash
+// Redis REST API (see [1]) payloads by executing scripts of commands against
+// a reference server, and replays them against another server to verify
+// that it returns identical payloads.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis/fixture"
+)
+
+const binName = "upstash-redis-rest-fixture"
+
+const (
+	actionRecord = "record"
+	actionReplay = "replay"
+)
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s record|replay --url <URL> [--token <TOKEN>] [<option>...] FILE...
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s record --url <URL> [--token <TOKEN>] [--dir <DIR>] SCRIPT...
+       %[1]s replay --url <URL> [--token <TOKEN>] FIXTURE...
+       %[1]s --help
+
+Record golden fixtures of the Upstash-compatible Redis REST API payloads,
+or replay them to verify that a server returns byte-for-byte identical
+responses and status codes.
+
+The record action executes the steps of each SCRIPT file against the
+REST API and writes the requests and responses in a fixture file named
+after the script's name, in the --dir directory. A script is a JSON
+object with a name and a list of steps, each step being either a single
+command or a pipeline of commands, e.g.:
+
+       {"name": "basic", "steps": [
+         {"command": ["SET", "key", "value"]},
+         {"pipeline": [["GET", "key"], ["DEL", "key"]]}
+       ]}
+
+Scripts must produce deterministic results, they should only use keys
+that do not exist before their execution.
+
+The replay action executes the requests of each FIXTURE file against the
+REST API and reports the responses that differ from the recorded ones.
+The database should not contain the keys used by the fixtures.
+
+Valid flag options are:
+       -d --dir DIR              Directory where the fixture files are
+                                 written, defaults to the current
+                                 directory.
+       -h --help                 Show this help.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	URL     string        `flag:"u,url" envconfig:"url"`
+	Token   string        `flag:"t,token" envconfig:"token"`
+	Dir     string        `flag:"d,dir" ignored:"true"`
+	Timeout time.Duration `flag:"timeout" ignored:"true"`
+	Help    bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) == 0 {
+		return errors.New("no action provided")
+	}
+	switch c.args[0] {
+	case actionRecord, actionReplay:
+	default:
+		return fmt.Errorf("unknown action: %s", c.args[0])
+	}
+	if len(c.args) == 1 {
+		return errors.New("no file provided")
+	}
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Dir = "."
+	c.Timeout = 30 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	srv := &fixture.Server{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout},
+	}
+
+	code := mainer.Success
+	for _, file := range c.args[1:] {
+		var err error
+		if c.args[0] == actionRecord {
+			err = c.record(srv, file, stdio)
+		} else {
+			err = replay(srv, file, stdio)
+		}
+		if err != nil {
+			fmt.Fprintf(stdio.Stderr, "%s: %s\n", file, err)
+			code = mainer.Failure
+		}
+	}
+	return code
+}
+
+func (c *cmd) record(srv *fixture.Server, file string, stdio mainer.Stdio) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	script, err := fixture.ReadScript(f)
+	if err != nil {
+		return err
+	}
+	fx, err := srv.Record(script)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(c.Dir, script.Name+".json")
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if _, err := fx.WriteTo(w); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdio.Stdout, "%s: recorded %d exchange(s) in %s\n", file, len(fx.Exchanges), out)
+	return nil
+}
+
+func replay(srv *fixture.Server, file string, stdio mainer.Stdio) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fx, err := fixture.ReadFixture(f)
+	if err != nil {
+		return err
+	}
+	if err := srv.Replay(fx); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdio.Stdout, "%s: ok\n", file)
+	return nil
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
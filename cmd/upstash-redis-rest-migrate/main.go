@@ -0,0 +1,223 @@
+// Command upstash-redis-rest-migrate copies the keys of a Redis instance to
+// an Upstash Redis REST API endpoint (see [1]). Keys are listed with SCAN and
+// copied with DUMP and RESTORE when possible, along with their TTL.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/internal/migrate"
+)
+
+const binName = "upstash-redis-rest-migrate"
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s [sync] --source <REDIS_URL> --url <URL> [--token <TOKEN>] [<option>...]
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s --source <REDIS_URL> --url <URL> [--token <TOKEN>] [<option>...]
+       %[1]s sync --source <REDIS_URL> --url <URL> [--token <TOKEN>] [<option>...]
+       %[1]s --help
+
+Copy the keys of the source Redis instance to the Upstash-compatible
+Redis REST API endpoint, along with their TTL. Keys are listed with the
+SCAN command and copied with DUMP and RESTORE when possible, otherwise
+their value is read and written with type-specific commands (in that
+case, the copy of a key is not atomic in the target). Keys with binary
+values that are not valid UTF-8 cannot be sent via the REST API and
+are reported as failed.
+
+Valid flag options are:
+       -c --concurrency N        Number of keys copied concurrently,
+                                 defaults to 10.
+       --count N                 COUNT value of the SCAN command,
+                                 defaults to 100.
+       --exclude PATTERN         Do not copy the keys matching this
+                                 glob-style pattern.
+       -h --help                 Show this help.
+       -m --match PATTERN        Only copy the keys matching this
+                                 glob-style pattern (the MATCH value of
+                                 the SCAN command).
+       --progress DURATION       Interval at which progress is reported
+                                 on stderr, defaults to 5s. Set to 0 to
+                                 disable progress reporting.
+       --replace                 Replace the keys that already exist in
+                                 the target. By default, those keys are
+                                 left untouched.
+       -s --source REDIS_URL     URL of the source Redis instance, e.g.
+                                 redis://:password@localhost:6379/0.
+       --state-file FILE         Save the SCAN cursor in FILE after each
+                                 batch of keys is copied, and resume from
+                                 that cursor if FILE exists. The file is
+                                 removed when the migration completes.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       --type TYPE               Only copy the keys of this type (the TYPE
+                                 value of the SCAN command, requires Redis
+                                 6 and above).
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+The sync command continuously replicates the changes made in the source
+to the target after an initial copy, see '%[1]s sync --help' for
+details.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	URL         string        `flag:"u,url" envconfig:"url"`
+	Token       string        `flag:"t,token" envconfig:"token"`
+	Source      string        `flag:"s,source" ignored:"true"`
+	Concurrency int           `flag:"c,concurrency" ignored:"true"`
+	Count       int           `flag:"count" ignored:"true"`
+	Match       string        `flag:"m,match" ignored:"true"`
+	Exclude     string        `flag:"exclude" ignored:"true"`
+	Type        string        `flag:"type" ignored:"true"`
+	Replace     bool          `flag:"replace" ignored:"true"`
+	StateFile   string        `flag:"state-file" ignored:"true"`
+	Progress    time.Duration `flag:"progress" ignored:"true"`
+	Timeout     time.Duration `flag:"timeout" ignored:"true"`
+	Help        bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) > 0 {
+		return errors.New("unexpected arguments provided")
+	}
+	if c.Source == "" {
+		return errors.New("no --source provided")
+	}
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Concurrency <= 0 {
+		return errors.New("invalid --concurrency value")
+	}
+	if c.Count <= 0 {
+		return errors.New("invalid --count value")
+	}
+	if c.Exclude != "" {
+		if _, err := path.Match(c.Exclude, ""); err != nil {
+			return fmt.Errorf("invalid --exclude pattern: %w", err)
+		}
+	}
+	if c.Progress < 0 {
+		return errors.New("invalid --progress value")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	if len(args) > 1 && args[1] == "sync" {
+		var sc syncCmd
+		return sc.Main(args[1:], stdio)
+	}
+
+	c.Concurrency = 10
+	c.Count = 100
+	c.Progress = 5 * time.Second
+	c.Timeout = 30 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	pool := &redis.Pool{
+		MaxIdle: c.Concurrency + 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(c.Source)
+		},
+	}
+	defer pool.Close()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = c.Concurrency
+	client := &upstashdis.Client{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout, Transport: tr},
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	m := &migration{
+		copier: &migrate.Copier{
+			Source:  pool,
+			Target:  client,
+			Replace: c.Replace,
+		},
+		concurrency: c.Concurrency,
+		count:       c.Count,
+		match:       c.Match,
+		exclude:     c.Exclude,
+		typ:         c.Type,
+		stateFile:   c.StateFile,
+		progress:    c.Progress,
+		stderr:      stdio.Stderr,
+	}
+	st, err := m.run(ctx)
+	st.print(stdio.Stdout)
+	if err != nil {
+		if errors.Is(err, context.Canceled) && c.StateFile != "" {
+			fmt.Fprintf(stdio.Stderr, "migration interrupted, run again with the same --state-file to resume\n")
+			return mainer.Failure
+		}
+		fmt.Fprintf(stdio.Stderr, "migration failed: %s\n", err)
+		return mainer.Failure
+	}
+	if st.failed > 0 {
+		return mainer.Failure
+	}
+	return mainer.Success
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mna/upstashdis/internal/migrate"
+)
+
+type migration struct {
+	copier      *migrate.Copier
+	concurrency int
+	count       int
+	match       string
+	exclude     string
+	typ         string
+	stateFile   string
+	progress    time.Duration
+
+	mu     sync.Mutex // protects stderr
+	stderr io.Writer
+}
+
+// counters are the number of keys processed by the migration, atomically
+// accessed.
+type counters struct {
+	scanned  int64
+	excluded int64
+	copied   int64
+	existing int64
+	missing  int64
+	failed   int64
+	start    time.Time
+}
+
+func (c *counters) String() string {
+	elapsed := time.Since(c.start)
+	done := atomic.LoadInt64(&c.copied) + atomic.LoadInt64(&c.existing) +
+		atomic.LoadInt64(&c.missing) + atomic.LoadInt64(&c.failed)
+	return fmt.Sprintf("scanned: %d, excluded: %d, copied: %d, existing: %d, missing: %d, failed: %d (%.0f keys/s)",
+		atomic.LoadInt64(&c.scanned), atomic.LoadInt64(&c.excluded),
+		atomic.LoadInt64(&c.copied), atomic.LoadInt64(&c.existing),
+		atomic.LoadInt64(&c.missing), atomic.LoadInt64(&c.failed),
+		float64(done)/elapsed.Seconds())
+}
+
+func (c *counters) print(w io.Writer) {
+	fmt.Fprintf(w, "%s in %s\n", c, time.Since(c.start).Round(time.Millisecond))
+}
+
+// run executes the migration until all keys are processed or ctx is
+// cancelled. The counters are always returned, even if an error occurs.
+func (m *migration) run(ctx context.Context) (*counters, error) {
+	st := &counters{start: time.Now()}
+
+	cursor, err := m.loadCursor()
+	if err != nil {
+		return st, err
+	}
+	if cursor != "0" {
+		m.logf("resuming from cursor %s", cursor)
+	}
+
+	var wg sync.WaitGroup
+	keys := make(chan string)
+	defer close(keys)
+	for i := 0; i < m.concurrency; i++ {
+		go m.worker(keys, &wg, st)
+	}
+
+	if m.progress > 0 {
+		ticker := time.NewTicker(m.progress)
+		defer ticker.Stop()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					m.logf("%s", st)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return st, err
+		}
+
+		conn := m.copier.Source.Get()
+		next, batch, err := migrate.Scan(conn, cursor, m.match, m.typ, m.count)
+		conn.Close()
+		if err != nil {
+			return st, err
+		}
+
+		for _, key := range batch {
+			atomic.AddInt64(&st.scanned, 1)
+			if m.exclude != "" {
+				if ok, _ := path.Match(m.exclude, key); ok {
+					atomic.AddInt64(&st.excluded, 1)
+					continue
+				}
+			}
+			wg.Add(1)
+			keys <- key
+		}
+		// wait for the whole batch to be processed so that the cursor can be
+		// saved safely.
+		wg.Wait()
+
+		cursor = next
+		if cursor == "0" {
+			return st, m.removeState()
+		}
+		if err := m.saveCursor(cursor); err != nil {
+			return st, err
+		}
+	}
+}
+
+func (m *migration) worker(keys <-chan string, wg *sync.WaitGroup, st *counters) {
+	for key := range keys {
+		out, err := m.copier.Copy(key)
+		switch {
+		case err != nil:
+			atomic.AddInt64(&st.failed, 1)
+			m.logf("%s: %s", key, err)
+		case out == migrate.Copied:
+			atomic.AddInt64(&st.copied, 1)
+		case out == migrate.Exists:
+			atomic.AddInt64(&st.existing, 1)
+		case out == migrate.Missing:
+			atomic.AddInt64(&st.missing, 1)
+		}
+		wg.Done()
+	}
+}
+
+func (m *migration) logf(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(m.stderr, format+"\n", args...)
+}
+
+func (m *migration) loadCursor() (string, error) {
+	if m.stateFile == "" {
+		return "0", nil
+	}
+	b, err := os.ReadFile(m.stateFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "0", nil
+		}
+		return "", err
+	}
+	cursor := strings.TrimSpace(string(b))
+	if cursor == "" {
+		return "0", nil
+	}
+	return cursor, nil
+}
+
+func (m *migration) saveCursor(cursor string) error {
+	if m.stateFile == "" {
+		return nil
+	}
+	// write to a temporary file and rename, so that the state file is never
+	// left half-written.
+	tmp := m.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(cursor+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.stateFile)
+}
+
+func (m *migration) removeState() error {
+	if m.stateFile == "" {
+		return nil
+	}
+	if err := os.Remove(m.stateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
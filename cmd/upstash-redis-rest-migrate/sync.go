@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/internal/migrate"
+)
+
+const (
+	syncModeNotify = "notify"
+	syncModeScan   = "scan"
+)
+
+var (
+	syncShortUsage = fmt.Sprintf(`
+usage: %s sync --source <REDIS_URL> --url <URL> [--token <TOKEN>] [<option>...]
+Run '%[1]s sync --help' for details.
+`, binName)
+
+	syncLongUsage = fmt.Sprintf(`usage: %s sync --source <REDIS_URL> --url <URL> [--token <TOKEN>] [<option>...]
+       %[1]s sync --help
+
+Continuously replicate the keys of the source Redis instance to the
+Upstash-compatible Redis REST API endpoint, until interrupted with
+Ctrl-C. Keys that exist in the target are replaced, and keys deleted
+from the source are deleted from the target.
+
+In '%s' mode (the default), the changes are tailed via keyspace
+notifications, which must be enabled on the source (see --notify-config).
+After the initial copy of all keys, each changed key is copied again.
+Notifications are not persisted by Redis, so changes made while the
+command is not running are lost and a new initial copy is required.
+
+In '%s' mode, the whole keyspace is scanned at every --interval and the
+keys whose DUMP payload changed since the previous scan are copied
+again. The first scan performs the initial copy. Changes to the TTL of
+a key alone are not detected, and all keys are copied at every scan if
+the source does not support the DUMP command.
+
+Valid flag options are:
+       -c --concurrency N        Number of keys copied concurrently,
+                                 defaults to 10.
+       --count N                 COUNT value of the SCAN command,
+                                 defaults to 100.
+       --exclude PATTERN         Do not replicate the keys matching this
+                                 glob-style pattern.
+       -h --help                 Show this help.
+       -i --interval DURATION    Interval between scans in '%[3]s' mode,
+                                 defaults to 1m.
+       -m --match PATTERN        Only replicate the keys matching this
+                                 glob-style pattern.
+       --mode MODE               Replication mode, either '%[2]s' or
+                                 '%[3]s'. Defaults to '%[2]s'.
+       --notify-config           Enable the keyspace notifications on the
+                                 source with CONFIG SET if required.
+       --progress DURATION       Interval at which progress is reported
+                                 on stderr, defaults to 5s. Set to 0 to
+                                 disable progress reporting.
+       --skip-initial            Do not copy all keys before tailing the
+                                 changes in '%[2]s' mode.
+       -s --source REDIS_URL     URL of the source Redis instance, e.g.
+                                 redis://:password@localhost:6379/0.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName, syncModeNotify, syncModeScan)
+)
+
+type syncCmd struct {
+	URL          string        `flag:"u,url" envconfig:"url"`
+	Token        string        `flag:"t,token" envconfig:"token"`
+	Source       string        `flag:"s,source" ignored:"true"`
+	Concurrency  int           `flag:"c,concurrency" ignored:"true"`
+	Count        int           `flag:"count" ignored:"true"`
+	Match        string        `flag:"m,match" ignored:"true"`
+	Exclude      string        `flag:"exclude" ignored:"true"`
+	Mode         string        `flag:"mode" ignored:"true"`
+	Interval     time.Duration `flag:"i,interval" ignored:"true"`
+	NotifyConfig bool          `flag:"notify-config" ignored:"true"`
+	SkipInitial  bool          `flag:"skip-initial" ignored:"true"`
+	Progress     time.Duration `flag:"progress" ignored:"true"`
+	Timeout      time.Duration `flag:"timeout" ignored:"true"`
+	Help         bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+	db   int
+}
+
+func (c *syncCmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *syncCmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) > 0 {
+		return errors.New("unexpected arguments provided")
+	}
+	if c.Source == "" {
+		return errors.New("no --source provided")
+	}
+	db, err := sourceDB(c.Source)
+	if err != nil {
+		return fmt.Errorf("invalid --source: %w", err)
+	}
+	c.db = db
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Concurrency <= 0 {
+		return errors.New("invalid --concurrency value")
+	}
+	if c.Count <= 0 {
+		return errors.New("invalid --count value")
+	}
+	for _, pat := range []string{c.Match, c.Exclude} {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pat, err)
+		}
+	}
+	switch c.Mode {
+	case syncModeNotify, syncModeScan:
+	default:
+		return fmt.Errorf("invalid --mode: %s", c.Mode)
+	}
+	if c.Interval <= 0 {
+		return errors.New("invalid --interval value")
+	}
+	if c.Progress < 0 {
+		return errors.New("invalid --progress value")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	return nil
+}
+
+func (c *syncCmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Concurrency = 10
+	c.Count = 100
+	c.Mode = syncModeNotify
+	c.Interval = time.Minute
+	c.Progress = 5 * time.Second
+	c.Timeout = 30 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, syncShortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, syncLongUsage)
+		return mainer.Success
+	}
+
+	pool := &redis.Pool{
+		MaxIdle: c.Concurrency + 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(c.Source)
+		},
+	}
+	defer pool.Close()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = c.Concurrency
+	client := &upstashdis.Client{
+		BaseURL:    c.URL,
+		APIToken:   c.Token,
+		HTTPClient: &http.Client{Timeout: c.Timeout, Transport: tr},
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	s := &syncer{
+		migration: migration{
+			copier: &migrate.Copier{
+				Source:  pool,
+				Target:  client,
+				Replace: true,
+			},
+			concurrency: c.Concurrency,
+			count:       c.Count,
+			match:       c.Match,
+			exclude:     c.Exclude,
+			progress:    c.Progress,
+			stderr:      stdio.Stderr,
+		},
+		sourceURL:    c.Source,
+		db:           c.db,
+		interval:     c.Interval,
+		notifyConfig: c.NotifyConfig,
+		skipInitial:  c.SkipInitial,
+	}
+
+	var err error
+	if c.Mode == syncModeScan {
+		err = s.runScan(ctx)
+	} else {
+		err = s.runNotify(ctx)
+	}
+	s.st.print(stdio.Stdout)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(stdio.Stderr, "sync failed: %s\n", err)
+		return mainer.Failure
+	}
+	return mainer.Success
+}
+
+// sourceDB returns the database number selected by the Redis URL.
+func sourceDB(rawURL string) (int, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	db := strings.Trim(u.Path, "/")
+	if db == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(db)
+}
+
+type syncer struct {
+	migration
+
+	sourceURL    string
+	db           int
+	interval     time.Duration
+	notifyConfig bool
+	skipInitial  bool
+
+	st syncCounters
+}
+
+// syncCounters are the number of keys processed by the sync, atomically
+// accessed.
+type syncCounters struct {
+	changed int64
+	copied  int64
+	deleted int64
+	failed  int64
+	start   time.Time
+}
+
+func (c *syncCounters) String() string {
+	return fmt.Sprintf("changed: %d, copied: %d, deleted: %d, failed: %d",
+		atomic.LoadInt64(&c.changed), atomic.LoadInt64(&c.copied),
+		atomic.LoadInt64(&c.deleted), atomic.LoadInt64(&c.failed))
+}
+
+func (c *syncCounters) print(w io.Writer) {
+	fmt.Fprintf(w, "%s in %s\n", c, time.Since(c.start).Round(time.Second))
+}
+
+func (s *syncer) startProgress() (stop func()) {
+	if s.progress <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(s.progress)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.logf("%s", &s.st)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// filtered returns true if the key must not be replicated.
+func (s *syncer) filtered(key string) bool {
+	if s.match != "" {
+		if ok, _ := path.Match(s.match, key); !ok {
+			return true
+		}
+	}
+	if s.exclude != "" {
+		if ok, _ := path.Match(s.exclude, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply replicates the current state of the key in the source to the
+// target, deleting it from the target if it does not exist in the source. It
+// returns true if the key was replicated successfully.
+func (s *syncer) apply(key string) bool {
+	out, err := s.copier.Copy(key)
+	if err == nil && out == migrate.Missing {
+		if err = s.copier.Delete(key); err == nil {
+			atomic.AddInt64(&s.st.deleted, 1)
+			return true
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&s.st.failed, 1)
+		s.logf("%s: %s", key, err)
+		return false
+	}
+	atomic.AddInt64(&s.st.copied, 1)
+	return true
+}
+
+// applyAll applies the keys concurrently and waits for completion.
+func (s *syncer) applyAll(keys []string, fn func(key string)) {
+	var wg sync.WaitGroup
+	ch := make(chan string)
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range ch {
+				fn(key)
+			}
+		}()
+	}
+	for _, key := range keys {
+		ch <- key
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// keySet is a set of changed keys that coalesces multiple changes to the
+// same key. It is safe for concurrent use.
+type keySet struct {
+	mu     sync.Mutex
+	keys   map[string]bool
+	notify chan struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{
+		keys:   make(map[string]bool),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (s *keySet) add(key string) {
+	s.mu.Lock()
+	s.keys[key] = true
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *keySet) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	s.keys = make(map[string]bool)
+	return keys
+}
+
+// runNotify runs the sync in notify mode until ctx is cancelled or the
+// subscription fails.
+func (s *syncer) runNotify(ctx context.Context) error {
+	s.st.start = time.Now()
+
+	if err := s.checkNotifyConfig(); err != nil {
+		return err
+	}
+
+	conn, err := redis.DialURL(s.sourceURL)
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	prefix := fmt.Sprintf("__keyspace@%d__:", s.db)
+	if err := psc.PSubscribe(prefix + "*"); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// subscribe before the initial copy so that no change is lost, changed
+	// keys are collected while the initial copy runs.
+	changed := newKeySet()
+	subErr := make(chan error, 1)
+	go func() {
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				key := strings.TrimPrefix(v.Channel, prefix)
+				if !s.filtered(key) {
+					atomic.AddInt64(&s.st.changed, 1)
+					changed.add(key)
+				}
+			case error:
+				subErr <- v
+				return
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if !s.skipInitial {
+		st, err := s.run(ctx)
+		st.print(s.stderr)
+		if err != nil {
+			return err
+		}
+	}
+
+	stop := s.startProgress()
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-subErr:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("keyspace notifications subscription: %w", err)
+		case <-changed.notify:
+			s.applyAll(changed.take(), func(key string) { s.apply(key) })
+		}
+	}
+}
+
+// checkNotifyConfig checks that the keyspace notifications are enabled on
+// the source, and enables them if notifyConfig is true.
+func (s *syncer) checkNotifyConfig() error {
+	conn := s.copier.Source.Get()
+	defer conn.Close()
+
+	vals, err := redis.Strings(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil || len(vals) != 2 {
+		// CONFIG may be disabled, assume that it is properly configured
+		s.logf("failed to check the notify-keyspace-events configuration, make sure keyspace notifications are enabled")
+		return nil
+	}
+
+	cfg := vals[1]
+	enabled := strings.Contains(cfg, "K") &&
+		(strings.Contains(cfg, "A") || strings.ContainsAny(cfg, "g$lshzxe"))
+	if enabled {
+		return nil
+	}
+	if !s.notifyConfig {
+		return errors.New("keyspace notifications are not enabled on the source, set notify-keyspace-events to 'KA' or use --notify-config")
+	}
+	_, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", "KA"+strings.NewReplacer("K", "", "A", "").Replace(cfg))
+	return err
+}
+
+// runScan runs the sync in scan mode until ctx is cancelled or a scan
+// fails.
+func (s *syncer) runScan(ctx context.Context) error {
+	s.st.start = time.Now()
+	stop := s.startProgress()
+	defer stop()
+
+	var digests map[string]string
+	for {
+		next, err := s.scanPass(ctx, digests)
+		if err != nil {
+			return err
+		}
+		digests = next
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// scanPass scans the whole keyspace and replicates the keys whose digest
+// differs from the one in prev, and deletes the keys of prev that do not
+// exist anymore. It returns the digests of the scanned keys.
+func (s *syncer) scanPass(ctx context.Context, prev map[string]string) (map[string]string, error) {
+	var mu sync.Mutex
+	next := make(map[string]string, len(prev))
+
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		conn := s.copier.Source.Get()
+		c, batch, err := migrate.Scan(conn, cursor, s.match, "", s.count)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		keys := batch[:0]
+		for _, key := range batch {
+			if !s.filtered(key) {
+				keys = append(keys, key)
+			}
+		}
+		s.applyAll(keys, func(key string) {
+			dg := s.digest(key)
+			if dg != "" && prev[key] == dg {
+				mu.Lock()
+				next[key] = dg
+				mu.Unlock()
+				return
+			}
+
+			if prev != nil {
+				atomic.AddInt64(&s.st.changed, 1)
+			}
+			if !s.apply(key) {
+				// no digest so that it is copied again on the next scan
+				dg = ""
+			}
+			mu.Lock()
+			next[key] = dg
+			mu.Unlock()
+		})
+
+		if cursor = c; cursor == "0" {
+			break
+		}
+	}
+
+	var deleted []string
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	s.applyAll(deleted, func(key string) {
+		atomic.AddInt64(&s.st.changed, 1)
+		s.apply(key)
+	})
+	return next, nil
+}
+
+// digest returns the digest of the DUMP payload of the key, or an empty
+// string if it cannot be computed.
+func (s *syncer) digest(key string) string {
+	conn := s.copier.Source.Get()
+	defer conn.Close()
+
+	b, err := redis.Bytes(conn.Do("DUMP", key))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
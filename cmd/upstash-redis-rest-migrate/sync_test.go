@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/internal/migrate"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSyncer(t *testing.T) (*syncer, *miniredis.Miniredis, *upstashtest.Server) {
+	src := miniredis.RunT(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", src.Addr())
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	dst := upstashtest.NewServer(t)
+	s := &syncer{
+		migration: migration{
+			copier: &migrate.Copier{
+				Source:  pool,
+				Target:  dst.Client(),
+				Replace: true,
+			},
+			concurrency: 2,
+			count:       10,
+			exclude:     "skip:*",
+			stderr:      io.Discard,
+		},
+		sourceURL: "redis://" + src.Addr(),
+		interval:  time.Hour,
+	}
+	return s, src, dst
+}
+
+func TestSyncScanPass(t *testing.T) {
+	s, src, dst := newTestSyncer(t)
+	ctx := context.Background()
+
+	require.NoError(t, src.Set("a", "1"))
+	require.NoError(t, src.Set("b", "2"))
+	require.NoError(t, src.Set("skip:c", "3"))
+
+	digests, err := s.scanPass(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, digests, 2)
+	dst.Redis.CheckGet(t, "a", "1")
+	dst.Redis.CheckGet(t, "b", "2")
+	require.False(t, dst.Redis.Exists("skip:c"))
+
+	require.NoError(t, src.Set("a", "10"))
+	src.Del("b")
+	digests, err = s.scanPass(ctx, digests)
+	require.NoError(t, err)
+	require.Len(t, digests, 1)
+	dst.Redis.CheckGet(t, "a", "10")
+	require.False(t, dst.Redis.Exists("b"))
+}
+
+func TestSyncNotify(t *testing.T) {
+	s, src, dst := newTestSyncer(t)
+	s.skipInitial = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.runNotify(ctx) }()
+
+	// wait for the subscription to be active
+	require.Eventually(t, func() bool {
+		return src.PubSubNumPat() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, src.Set("a", "1"))
+	src.Publish("__keyspace@0__:a", "set")
+	require.NoError(t, src.Set("skip:b", "2"))
+	src.Publish("__keyspace@0__:skip:b", "set")
+
+	require.Eventually(t, func() bool {
+		return dst.Redis.Exists("a")
+	}, time.Second, 10*time.Millisecond)
+	dst.Redis.CheckGet(t, "a", "1")
+
+	src.Del("a")
+	src.Publish("__keyspace@0__:a", "del")
+	require.Eventually(t, func() bool {
+		return !dst.Redis.Exists("a")
+	}, time.Second, 10*time.Millisecond)
+	require.False(t, dst.Redis.Exists("skip:b"))
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,215 @@
+// Command upstash-redis-rest-probe periodically executes PING, SET and GET
+// commands against one or more Upstash Redis REST API endpoints (see [1])
+// and records their latency. The latencies can be exposed as Prometheus
+// metrics and written as a time series (a Redis stream) to the database
+// itself, so that the latency of the endpoints can be monitored from the
+// regions where the probe runs.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis/internal/metrics"
+)
+
+const binName = "upstash-redis-rest-probe"
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s [<option>...] [NAME=]URL...
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s [<option>...] [NAME=]URL...
+       %[1]s --help
+
+Periodically execute PING, SET and GET commands against the Upstash-
+compatible Redis REST API endpoints and record their latency. Each
+endpoint is identified by its NAME (defaults to the host of its URL).
+
+The API token is the same for all endpoints and is set via the --token
+flag, but an endpoint-specific token can be provided in its URL via the
+_token query string parameter.
+
+Valid flag options are:
+       -h --help                 Show this help.
+       -i --interval DURATION    Interval between probes, defaults to
+                                 10s.
+       -k --key KEY              Key used by the SET and GET commands,
+                                 defaults to 'upstash-redis-rest-probe'.
+       -m --metrics-addr ADDR    Serve the latency metrics in the
+                                 Prometheus text format on ADDR, at
+                                 the /metrics path.
+       -q --quiet                Do not print the latencies on stdout.
+       --record-key KEY          Record the latencies as entries in
+                                 the Redis stream KEY of each probed
+                                 endpoint.
+       --record-maxlen N         Approximate maximum number of entries
+                                 to keep in the stream, defaults to
+                                 10000.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 10s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	Token        string        `flag:"t,token" envconfig:"token"`
+	Interval     time.Duration `flag:"i,interval" ignored:"true"`
+	Key          string        `flag:"k,key" ignored:"true"`
+	MetricsAddr  string        `flag:"m,metrics-addr" ignored:"true"`
+	Quiet        bool          `flag:"q,quiet" ignored:"true"`
+	RecordKey    string        `flag:"record-key" ignored:"true"`
+	RecordMaxLen int           `flag:"record-maxlen" ignored:"true"`
+	Timeout      time.Duration `flag:"timeout" ignored:"true"`
+	Help         bool          `flag:"h,help" ignored:"true"`
+
+	args      []string
+	endpoints []*endpoint
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) == 0 {
+		return errors.New("no endpoint provided")
+	}
+	if c.Interval <= 0 {
+		return errors.New("invalid --interval value")
+	}
+	if c.Key == "" {
+		return errors.New("invalid --key value")
+	}
+	if c.RecordMaxLen <= 0 {
+		return errors.New("invalid --record-maxlen value")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+
+	names := make(map[string]bool)
+	for _, arg := range c.args {
+		ep, err := parseEndpoint(arg)
+		if err != nil {
+			return err
+		}
+		if names[ep.name] {
+			return fmt.Errorf("duplicate endpoint name: %s", ep.name)
+		}
+		names[ep.name] = true
+		c.endpoints = append(c.endpoints, ep)
+	}
+	return nil
+}
+
+func parseEndpoint(s string) (*endpoint, error) {
+	name, rawURL, ok := strings.Cut(s, "=")
+	if !ok || strings.Contains(name, "/") {
+		// no name provided (the = may be part of the URL's query string)
+		name, rawURL = "", s
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint URL: %s", rawURL)
+	}
+	if name == "" {
+		name = u.Host
+	}
+	return &endpoint{name: name, url: rawURL}, nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Interval = 10 * time.Second
+	c.Key = binName
+	c.RecordMaxLen = 10000
+	c.Timeout = 10 * time.Second
+
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var reg metrics.Registry
+	pr := &prober{
+		httpClient:   &http.Client{Timeout: c.Timeout},
+		token:        c.Token,
+		key:          c.Key,
+		recordKey:    c.RecordKey,
+		recordMaxLen: c.RecordMaxLen,
+		latency: reg.NewHistogram("upstash_probe_latency_seconds",
+			"Latency of the probe commands.", nil, "endpoint", "command"),
+		errors: reg.NewCounter("upstash_probe_errors_total",
+			"Number of failed probe commands.", "endpoint", "command"),
+		last: reg.NewGauge("upstash_probe_last_latency_seconds",
+			"Latency of the last successful probe command.", "endpoint", "command"),
+	}
+	if !c.Quiet {
+		pr.out = stdio.Stdout
+	}
+
+	if c.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", &reg)
+		srv := &http.Server{Addr: c.MetricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+		go func() {
+			log.Printf("serving metrics on %s/metrics...", c.MetricsAddr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(stdio.Stderr, "metrics server error: %s\n", err)
+				cancel()
+			}
+		}()
+	}
+
+	pr.run(ctx, c.endpoints, c.Interval)
+	return mainer.Success
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
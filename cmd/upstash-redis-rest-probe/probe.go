@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/internal/metrics"
+)
+
+type endpoint struct {
+	name   string
+	url    string
+	client *upstashdis.Client
+}
+
+type prober struct {
+	httpClient   upstashdis.HTTPDoer
+	token        string
+	key          string
+	recordKey    string
+	recordMaxLen int
+	out          io.Writer // nil if quiet
+
+	latency *metrics.HistogramVec
+	errors  *metrics.CounterVec
+	last    *metrics.GaugeVec
+
+	outMu sync.Mutex
+}
+
+// sample is the result of a probe command.
+type sample struct {
+	command string
+	latency time.Duration
+	err     error
+}
+
+// run probes all endpoints concurrently at each interval until ctx is
+// cancelled.
+func (p *prober) run(ctx context.Context, endpoints []*endpoint, interval time.Duration) {
+	for _, ep := range endpoints {
+		ep.client = p.newClient(ep)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, ep := range endpoints {
+			wg.Add(1)
+			go func(ep *endpoint) {
+				defer wg.Done()
+				p.probe(ctx, ep)
+			}(ep)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newClient returns the REST client for the endpoint.
+func (p *prober) newClient(ep *endpoint) *upstashdis.Client {
+	return &upstashdis.Client{
+		BaseURL:    ep.url,
+		APIToken:   p.token,
+		HTTPClient: p.httpClient,
+	}
+}
+
+// probe executes the probe commands once against the endpoint and records
+// the results.
+func (p *prober) probe(ctx context.Context, ep *endpoint) {
+	now := time.Now()
+	samples := []sample{
+		p.exec(ctx, ep, "PING"),
+		p.exec(ctx, ep, "SET", p.key, now.UnixNano(), "EX", 3600),
+		p.exec(ctx, ep, "GET", p.key),
+	}
+	if ctx.Err() != nil {
+		// results are meaningless if the probe was interrupted
+		return
+	}
+
+	for _, s := range samples {
+		cmd := strings.ToLower(s.command)
+		if s.err != nil {
+			p.errors.With(ep.name, cmd).Inc()
+			continue
+		}
+		secs := s.latency.Seconds()
+		p.latency.With(ep.name, cmd).Observe(secs)
+		p.last.With(ep.name, cmd).Set(secs)
+	}
+
+	if p.out != nil {
+		p.print(now, ep, samples)
+	}
+	if p.recordKey != "" {
+		p.record(ctx, now, ep, samples)
+	}
+}
+
+func (p *prober) exec(ctx context.Context, ep *endpoint, cmd string, args ...interface{}) sample {
+	s := sample{command: cmd}
+	if ctx.Err() != nil {
+		s.err = ctx.Err()
+		return s
+	}
+
+	start := time.Now()
+	s.err = ep.client.NewRequest().ExecOne(nil, cmd, args...)
+	s.latency = time.Since(start)
+	return s
+}
+
+func (p *prober) print(ts time.Time, ep *endpoint, samples []sample) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s", ts.Format(time.RFC3339), ep.name)
+	for _, s := range samples {
+		if s.err != nil {
+			fmt.Fprintf(&sb, " %s=error(%s)", strings.ToLower(s.command), s.err)
+			continue
+		}
+		fmt.Fprintf(&sb, " %s=%s", strings.ToLower(s.command), s.latency.Round(time.Microsecond))
+	}
+
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	fmt.Fprintln(p.out, sb.String())
+}
+
+// record adds an entry to the record stream of the endpoint, with the
+// latency in microseconds of each command (or -1 if it failed).
+func (p *prober) record(ctx context.Context, ts time.Time, ep *endpoint, samples []sample) {
+	args := []interface{}{p.recordKey, "MAXLEN", "~", p.recordMaxLen, "*", "ts", ts.UnixMilli()}
+	for _, s := range samples {
+		lat := int64(-1)
+		if s.err == nil {
+			lat = s.latency.Microseconds()
+		}
+		args = append(args, strings.ToLower(s.command)+"_us", strconv.FormatInt(lat, 10))
+	}
+
+	if err := ep.client.NewRequest().ExecOne(nil, "XADD", args...); err != nil && ctx.Err() == nil {
+		p.errors.With(ep.name, "xadd").Inc()
+		if p.out != nil {
+			p.outMu.Lock()
+			fmt.Fprintf(p.out, "%s %s record=error(%s)\n", ts.Format(time.RFC3339), ep.name, err)
+			p.outMu.Unlock()
+		}
+	}
+}
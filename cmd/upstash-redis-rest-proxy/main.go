@@ -0,0 +1,154 @@
+// Command upstash-redis-rest-proxy listens for connections using the Redis
+// protocol (RESP) and executes the commands it receives via an Upstash Redis
+// REST API endpoint (see [1]). This allows unmodified tools and libraries
+// such as redis-cli to talk to a database that is only accessible via the
+// REST API.
+//
+// Since the REST API is stateless, commands that require a stateful
+// connection (WATCH, MONITOR and pub/sub commands) are not supported, MULTI
+// transactions are executed atomically via the transaction endpoint when
+// EXEC is called and only the database 0 can be selected. The AUTH command can be used to set
+// the API token to use for the connection.
+//
+//	[1]: https://docs.upstash.com/redis/features/restapi
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mna/mainer"
+	"github.com/mna/upstashdis"
+)
+
+const binName = "upstash-redis-rest-proxy"
+
+var (
+	shortUsage = fmt.Sprintf(`
+usage: %s --addr <ADDR> --url <URL> [--token <TOKEN>]
+Run '%[1]s --help' for details.
+`, binName)
+
+	longUsage = fmt.Sprintf(`usage: %s --addr <ADDR> --url <URL> [--token <TOKEN>]
+       %[1]s --help
+
+Listen for connections using the Redis protocol (RESP) and execute the
+commands via an Upstash-compatible Redis REST API. This allows tools like
+redis-cli and existing Redis client libraries to access a database that
+only exposes the REST API.
+
+Valid flag options are:
+       -a --addr ADDR            Address to listen on for Redis protocol
+                                 connections.
+       -h --help                 Show this help.
+       --timeout DURATION        Timeout of the HTTP requests, defaults
+                                 to 30s.
+       -t --token TOKEN          API token used to authenticate the
+                                 requests. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_TOKEN. A connection
+                                 can use a different token by sending
+                                 it as password in an AUTH command.
+       -u --url URL              URL of the REST API endpoint. Can also
+                                 be set via the environment variable
+                                 UPSTASH_REDIS_REST_URL.
+
+Because the REST API is stateless, the WATCH, MONITOR and pub/sub
+commands are not supported, MULTI transactions are executed
+atomically via the REST API's transaction endpoint when EXEC is
+called and only the database 0 can be selected. Commands pipelined
+by the clients are sent as a single REST pipeline request.
+
+More information on the upstashdis repository:
+       https://github.com/mna/upstashdis
+`, binName)
+)
+
+type cmd struct {
+	Addr    string        `flag:"a,addr" ignored:"true"`
+	URL     string        `flag:"u,url" envconfig:"url"`
+	Token   string        `flag:"t,token" envconfig:"token"`
+	Timeout time.Duration `flag:"timeout" ignored:"true"`
+	Help    bool          `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *cmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *cmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) > 0 {
+		return errors.New("unexpected arguments provided")
+	}
+	if c.Addr == "" {
+		return errors.New("no --addr provided")
+	}
+	if c.URL == "" {
+		return errors.New("no --url provided")
+	}
+	if c.Timeout < 0 {
+		return errors.New("invalid --timeout value")
+	}
+	return nil
+}
+
+func (c *cmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	c.Timeout = 30 * time.Second
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: "upstash_redis_rest",
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, shortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, longUsage)
+		return mainer.Success
+	}
+
+	l, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		fmt.Fprintf(stdio.Stderr, "failed to listen: %s\n", err)
+		return mainer.Failure
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	prx := &proxy{
+		client: &upstashdis.Client{
+			BaseURL:    c.URL,
+			APIToken:   c.Token,
+			HTTPClient: &http.Client{Timeout: c.Timeout},
+		},
+		logger: log.New(stdio.Stderr, "", log.LstdFlags),
+	}
+
+	log.Printf("listening on %s...", l.Addr())
+	if err := prx.serve(ctx, l); err != nil {
+		fmt.Fprintf(stdio.Stderr, "proxy server error: %s\n", err)
+		return mainer.Failure
+	}
+	return mainer.Success
+}
+
+func main() {
+	var c cmd
+	os.Exit(int(c.Main(os.Args, mainer.CurrentStdio())))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mna/upstashdis"
+)
+
+// redisVersion is the Redis version advertised in the HELLO reply.
+const redisVersion = "6.2.0"
+
+// maxBatch is the maximum number of commands sent in a single pipeline when
+// the client pipelines its commands.
+const maxBatch = 1000
+
+// proxy accepts connections speaking the Redis protocol and executes their
+// commands via the REST API client.
+type proxy struct {
+	client *upstashdis.Client
+	logger *log.Logger
+
+	mu     sync.Mutex
+	nextID int64
+	conns  map[net.Conn]bool
+}
+
+// serve accepts connections on l until ctx is cancelled or l fails.
+func (p *proxy) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+
+		// close all client connections
+		p.mu.Lock()
+		for c := range p.conns {
+			c.Close()
+		}
+		p.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		p.mu.Lock()
+		if p.conns == nil {
+			p.conns = make(map[net.Conn]bool)
+		}
+		p.conns[nc] = true
+		p.nextID++
+		id := p.nextID
+		p.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handleConn(nc, id)
+
+			p.mu.Lock()
+			delete(p.conns, nc)
+			p.mu.Unlock()
+		}()
+	}
+}
+
+// connState is the state of a client connection.
+type connState struct {
+	id     int64
+	name   string
+	token  string
+	multi  bool
+	queued [][]string
+}
+
+func (p *proxy) handleConn(nc net.Conn, id int64) {
+	defer nc.Close()
+
+	br := bufio.NewReader(nc)
+	w := respWriter{bufio.NewWriter(nc)}
+	st := &connState{id: id, token: p.client.APIToken}
+
+	var pending [][]string
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				w.errorString("ERR " + perr.Error())
+				_ = w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				p.logger.Printf("connection %d: %s", id, err)
+			}
+			return
+		}
+
+		if len(args) > 0 {
+			if st.multi && !isConnCmd(args[0]) {
+				st.queued = append(st.queued, args)
+				w.simpleString("QUEUED")
+			} else if isConnCmd(args[0]) {
+				p.execBatch(w, st, pending)
+				pending = pending[:0]
+				if quit := p.execConnCmd(w, st, args); quit {
+					_ = w.Flush()
+					return
+				}
+			} else {
+				pending = append(pending, args)
+			}
+		}
+
+		// execute the pending commands when there are no more buffered commands
+		// to read.
+		if br.Buffered() == 0 || len(pending) >= maxBatch {
+			p.execBatch(w, st, pending)
+			pending = pending[:0]
+		}
+		if br.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// execBatch executes the commands in a single request (a pipeline if there
+// is more than one command) and writes their results.
+func (p *proxy) execBatch(w respWriter, st *connState, cmds [][]string) {
+	if len(cmds) == 0 {
+		return
+	}
+
+	// a command that cannot be queued gets an error reply, the others are
+	// still executed.
+	req := p.client.NewRequestWithToken(st.token)
+	sendErrs := make([]error, len(cmds))
+	var queued int
+	for i, args := range cmds {
+		if sendErrs[i] = req.Send(args[0], stringsToArgs(args[1:])...); sendErrs[i] == nil {
+			queued++
+		}
+	}
+
+	var (
+		res []*upstashdis.Result
+		err error
+	)
+	if queued > 0 {
+		res, err = req.ExecRaw()
+	}
+	var ix int
+	for i := range cmds {
+		switch {
+		case sendErrs[i] != nil:
+			w.errorString(errorMessage(sendErrs[i]))
+		case err != nil:
+			w.errorString(errorMessage(err))
+		case ix >= len(res):
+			w.errorString("ERR missing result from the REST API")
+		default:
+			p.writeResult(w, res[ix])
+			ix++
+		}
+	}
+}
+
+func (p *proxy) writeResult(w respWriter, res *upstashdis.Result) {
+	if res.Error != "" {
+		w.errorString(res.Error)
+		return
+	}
+	if err := w.jsonResult(res.Result); err != nil {
+		w.errorString("ERR invalid result from the REST API: " + err.Error())
+	}
+}
+
+// errorMessage returns the error message to send to the client for err. The
+// message is prefixed with "ERR " if it does not start with an error kind.
+func errorMessage(err error) string {
+	msg := err.Error()
+	var rerr *upstashdis.Error
+	if errors.As(err, &rerr) && rerr.Kind != "" && rerr.Kind == strings.ToUpper(rerr.Kind) {
+		return msg
+	}
+	return "ERR " + msg
+}
+
+func stringsToArgs(args []string) []interface{} {
+	iargs := make([]interface{}, len(args))
+	for i, arg := range args {
+		iargs[i] = arg
+	}
+	return iargs
+}
+
+// connCmds are the commands that affect the connection's state and are
+// handled by the proxy instead of being sent to the REST API.
+var connCmds = map[string]bool{
+	"auth":         true,
+	"client":       true,
+	"discard":      true,
+	"exec":         true,
+	"hello":        true,
+	"monitor":      true,
+	"multi":        true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"quit":         true,
+	"reset":        true,
+	"select":       true,
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"unwatch":      true,
+	"watch":        true,
+}
+
+func isConnCmd(name string) bool {
+	return connCmds[strings.ToLower(name)]
+}
+
+// execConnCmd executes a connection command. It returns true if the
+// connection must be closed.
+func (p *proxy) execConnCmd(w respWriter, st *connState, args []string) bool {
+	name := strings.ToLower(args[0])
+	switch name {
+	case "quit":
+		w.simpleString("OK")
+		return true
+
+	case "auth":
+		if len(args) != 2 && len(args) != 3 {
+			w.errorString("ERR wrong number of arguments for 'auth' command")
+			return false
+		}
+		p.auth(w, st, args[len(args)-1])
+
+	case "hello":
+		if len(args) > 1 && args[1] != "2" {
+			w.errorString("NOPROTO sorry, this protocol version is not supported")
+			return false
+		}
+		for i := 2; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "auth":
+				if i+2 >= len(args) {
+					w.errorString("ERR Syntax error in HELLO option 'auth'")
+					return false
+				}
+				tok := st.token
+				st.token = args[i+2]
+				if err := p.checkToken(st.token); err != nil {
+					st.token = tok
+					w.errorString(err.Error())
+					return false
+				}
+				i += 2
+			case "setname":
+				if i+1 >= len(args) {
+					w.errorString("ERR Syntax error in HELLO option 'setname'")
+					return false
+				}
+				st.name = args[i+1]
+				i++
+			default:
+				w.errorString(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+				return false
+			}
+		}
+		w.arrayHeader(14)
+		w.bulkString("server")
+		w.bulkString(binName)
+		w.bulkString("version")
+		w.bulkString(redisVersion)
+		w.bulkString("proto")
+		w.integer("2")
+		w.bulkString("id")
+		w.integer(fmt.Sprint(st.id))
+		w.bulkString("mode")
+		w.bulkString("standalone")
+		w.bulkString("role")
+		w.bulkString("master")
+		w.bulkString("modules")
+		w.arrayHeader(0)
+
+	case "select":
+		if len(args) != 2 {
+			w.errorString("ERR wrong number of arguments for 'select' command")
+		} else if args[1] != "0" {
+			w.errorString("ERR DB index is out of range")
+		} else {
+			w.simpleString("OK")
+		}
+
+	case "client":
+		p.execClientCmd(w, st, args)
+
+	case "multi":
+		if st.multi {
+			w.errorString("ERR MULTI calls can not be nested")
+			return false
+		}
+		st.multi = true
+		w.simpleString("OK")
+
+	case "exec":
+		if !st.multi {
+			w.errorString("ERR EXEC without MULTI")
+			return false
+		}
+		cmds := st.queued
+		st.multi, st.queued = false, nil
+		p.exec(w, st, cmds)
+
+	case "discard":
+		if !st.multi {
+			w.errorString("ERR DISCARD without MULTI")
+			return false
+		}
+		st.multi, st.queued = false, nil
+		w.simpleString("OK")
+
+	case "unwatch":
+		w.simpleString("OK")
+
+	case "reset":
+		st.multi, st.queued, st.name = false, nil, ""
+		st.token = p.client.APIToken
+		w.simpleString("RESET")
+
+	default:
+		// watch, monitor and pub/sub commands require a stateful connection
+		w.errorString(fmt.Sprintf("ERR '%s' is not supported by the REST proxy", name))
+	}
+	return false
+}
+
+// auth replaces the API token used by the connection with the password, if
+// it is a valid token.
+func (p *proxy) auth(w respWriter, st *connState, tok string) {
+	if err := p.checkToken(tok); err != nil {
+		w.errorString(err.Error())
+		return
+	}
+	st.token = tok
+	w.simpleString("OK")
+}
+
+func (p *proxy) checkToken(tok string) error {
+	if err := p.client.NewRequestWithToken(tok).ExecOne(nil, "PING"); err != nil {
+		var rerr *upstashdis.Error
+		if errors.As(err, &rerr) && strings.Contains(rerr.Message, "Unauthorized") {
+			return errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		return errors.New(errorMessage(err))
+	}
+	return nil
+}
+
+func (p *proxy) execClientCmd(w respWriter, st *connState, args []string) {
+	if len(args) < 2 {
+		w.errorString("ERR wrong number of arguments for 'client' command")
+		return
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "setname":
+		if len(args) != 3 {
+			w.errorString("ERR wrong number of arguments for 'client|setname' command")
+			return
+		}
+		st.name = args[2]
+		w.simpleString("OK")
+	case "getname":
+		if st.name == "" {
+			w.null()
+			return
+		}
+		w.bulkString(st.name)
+	case "id":
+		w.integer(fmt.Sprint(st.id))
+	case "setinfo":
+		w.simpleString("OK")
+	default:
+		w.errorString(fmt.Sprintf("ERR 'client %s' is not supported by the REST proxy", strings.ToLower(args[1])))
+	}
+}
+
+// exec executes the commands queued in a MULTI block atomically in a
+// transaction, using the /multi-exec endpoint of the REST API, and writes
+// the array of results. If the transaction is discarded or a command cannot
+// be queued, no command is executed and an EXECABORT error is written.
+func (p *proxy) exec(w respWriter, st *connState, cmds [][]string) {
+	if len(cmds) == 0 {
+		w.arrayHeader(0)
+		return
+	}
+
+	var sendErr error
+	cli := p.client.CloneWithToken(st.token)
+	res, err := cli.TxPipelined(context.Background(), func(req *upstashdis.Request) error {
+		for _, args := range cmds {
+			if sendErr = req.Send(args[0], stringsToArgs(args[1:])...); sendErr != nil {
+				return sendErr
+			}
+		}
+		return nil
+	})
+	if res == nil && err != nil {
+		var rerr *upstashdis.Error
+		if sendErr != nil || (errors.As(err, &rerr) && rerr.PipelineIndex < 0) {
+			w.errorString("EXECABORT Transaction discarded because of: " + strings.TrimPrefix(errorMessage(err), "ERR "))
+			return
+		}
+		w.errorString(errorMessage(err))
+		return
+	}
+
+	w.arrayHeader(len(res))
+	for _, r := range res {
+		p.writeResult(w, r)
+	}
+}
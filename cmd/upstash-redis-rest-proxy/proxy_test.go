@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/restserver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const token = "_token_"
+	httpsrv := httptest.NewServer(&restserver.Server{
+		APIToken: token,
+		GetConnFunc: func(ctx context.Context) restserver.Conn {
+			return pool.Get()
+		},
+	})
+	defer httpsrv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	prx := &proxy{
+		client: &upstashdis.Client{BaseURL: httpsrv.URL, APIToken: token},
+		logger: log.New(io.Discard, "", 0),
+	}
+	done := make(chan error, 1)
+	go func() { done <- prx.serve(ctx, l) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	conn, err := redis.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("status and bulk strings", func(t *testing.T) {
+		v, err := conn.Do("PING")
+		require.NoError(t, err)
+		require.Equal(t, "PONG", v)
+
+		v, err = conn.Do("SET", "a", "OK")
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+
+		v, err = redis.String(conn.Do("GET", "a"))
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+
+		v, err = conn.Do("GET", "nosuchkey")
+		require.NoError(t, err)
+		require.Nil(t, v)
+	})
+
+	t.Run("integers and arrays", func(t *testing.T) {
+		n, err := redis.Int(conn.Do("RPUSH", "l", "x", "y", "1"))
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		vals, err := redis.Strings(conn.Do("LRANGE", "l", 0, -1))
+		require.NoError(t, err)
+		require.Equal(t, []string{"x", "y", "1"}, vals)
+	})
+
+	t.Run("command error", func(t *testing.T) {
+		_, err := conn.Do("HGET", "l", "f")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "WRONGTYPE")
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		require.NoError(t, conn.Send("SET", "p", 1))
+		require.NoError(t, conn.Send("INCR", "p"))
+		require.NoError(t, conn.Send("HGET", "l", "f"))
+		require.NoError(t, conn.Send("GET", "p"))
+		require.NoError(t, conn.Flush())
+
+		v, err := conn.Receive()
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+		n, err := redis.Int(conn.Receive())
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		_, err = conn.Receive()
+		require.Error(t, err)
+		s, err := redis.String(conn.Receive())
+		require.NoError(t, err)
+		require.Equal(t, "2", s)
+	})
+
+	t.Run("multi exec", func(t *testing.T) {
+		require.NoError(t, conn.Send("MULTI"))
+		require.NoError(t, conn.Send("SET", "m", "a"))
+		require.NoError(t, conn.Send("APPEND", "m", "b"))
+		vals, err := redis.Values(conn.Do("EXEC"))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"OK", int64(2)}, vals)
+
+		_, err = conn.Do("EXEC")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "EXEC without MULTI")
+	})
+
+	t.Run("discarded transaction", func(t *testing.T) {
+		require.NoError(t, conn.Send("MULTI"))
+		require.NoError(t, conn.Send("SET", "d", "a"))
+		require.NoError(t, conn.Send("NOTACMD"))
+		_, err := conn.Do("EXEC")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "EXECABORT")
+
+		n, err := redis.Int(conn.Do("EXISTS", "d"))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+	})
+
+	t.Run("connection commands", func(t *testing.T) {
+		v, err := conn.Do("SELECT", "0")
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+		_, err = conn.Do("SELECT", "1")
+		require.Error(t, err)
+
+		v, err = conn.Do("CLIENT", "SETNAME", "test")
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+		s, err := redis.String(conn.Do("CLIENT", "GETNAME"))
+		require.NoError(t, err)
+		require.Equal(t, "test", s)
+
+		_, err = conn.Do("WATCH", "a")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not supported")
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		_, err := conn.Do("AUTH", "badtoken")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "WRONGPASS")
+
+		v, err := conn.Do("AUTH", token)
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		prx.client.APIToken = "badtoken"
+		defer func() { prx.client.APIToken = token }()
+
+		conn, err := redis.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Do("GET", "a")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "ERR Unauthorized")
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// protocolError is an error in the RESP data sent by the client. The
+// connection is closed after such an error.
+type protocolError string
+
+func (e protocolError) Error() string { return "Protocol error: " + string(e) }
+
+const maxBulkLen = 512 * 1024 * 1024
+
+// readCommand reads a command from the RESP stream, either as an array of
+// bulk strings or as an inline command.
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		// inline command
+		return strings.Fields(string(line)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > 1024*1024 {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%s'", firstChar(line)))
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, protocolError("invalid bulk string terminator")
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func firstChar(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return string(b[:1])
+}
+
+// readLine reads a line terminated by \r\n (or \n, for inline commands) and
+// returns it without the terminator.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, protocolError("too big inline request")
+		}
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+// respWriter writes RESP replies.
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w respWriter) simpleString(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w respWriter) errorString(s string) {
+	// errors cannot contain newlines
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	w.WriteByte('-')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w respWriter) integer(n string) {
+	w.WriteByte(':')
+	w.WriteString(n)
+	w.WriteString("\r\n")
+}
+
+func (w respWriter) bulkString(s string) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(s)))
+	w.WriteString("\r\n")
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w respWriter) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w respWriter) arrayHeader(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
+
+// jsonResult writes the JSON result of a REST command as a RESP reply.
+// Strings are written as bulk strings, except for the "OK" and "PONG" status
+// replies which are written as simple strings, integers as integers, null as
+// a null bulk string and arrays as arrays.
+func (w respWriter) jsonResult(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		w.null()
+		return nil
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	w.value(v, true)
+	return nil
+}
+
+func (w respWriter) value(v interface{}, top bool) {
+	switch v := v.(type) {
+	case nil:
+		w.null()
+	case string:
+		if top && (v == "OK" || v == "PONG") {
+			w.simpleString(v)
+			return
+		}
+		w.bulkString(v)
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			w.integer(string(v))
+			return
+		}
+		w.bulkString(string(v))
+	case bool:
+		if v {
+			w.integer("1")
+		} else {
+			w.integer("0")
+		}
+	case []interface{}:
+		w.arrayHeader(len(v))
+		for _, elem := range v {
+			w.value(elem, false)
+		}
+	default:
+		w.bulkString(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads the options from the YAML config file and sets them
+// as environment variables, unless that variable is already set in the
+// environment, so that the flags and the environment variables take
+// precedence over the config file. The keys of the file are the long names
+// of the flags, e.g.:
+//
+//	addr: ":8080"
+//	redis-addr: redis://localhost:6379/0
+//	allow-commands: [get, set]
+//	request-timeout: 5s
+//
+// Lists are joined with commas.
+func loadConfigFile(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var opts map[string]interface{}
+	if err := yaml.Unmarshal(b, &opts); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	envVars := configEnvVars()
+	for key, v := range opts {
+		env, ok := envVars[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown option %q", file, key)
+		}
+		val, err := configValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for option %q: %w", file, key, err)
+		}
+
+		if _, ok := os.LookupEnv(env); ok {
+			continue
+		}
+		if err := os.Setenv(env, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configEnvVars returns the environment variable names of the options that
+// can be set in the config file, keyed by the long name of their flag.
+func configEnvVars() map[string]string {
+	prefix := strings.ToUpper(strings.ReplaceAll(binName, "-", "_"))
+
+	vars := make(map[string]string)
+	typ := reflect.TypeOf(cmd{})
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		env := fld.Tag.Get("envconfig")
+		if env == "" || fld.Tag.Get("ignored") == "true" {
+			continue
+		}
+		names := strings.Split(fld.Tag.Get("flag"), ",")
+		vars[names[len(names)-1]] = prefix + "_" + strings.ToUpper(env)
+	}
+	return vars
+}
+
+// configValue returns the value of an option of the config file as set in
+// an environment variable.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		vals := make([]string, len(v))
+		for i, vv := range v {
+			s, err := configValue(vv)
+			if err != nil {
+				return "", err
+			}
+			vals[i] = s
+		}
+		return strings.Join(vals, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consolePrefix is the path prefix of the web console, all other paths are
+// served by the REST API.
+const consolePrefix = "/console"
+
+// maxSlowRequests is the number of slowest requests reported by the console.
+const maxSlowRequests = 20
+
+//go:embed console.html
+var consoleHTML []byte
+
+// console serves the admin web console and records the statistics of the
+// requests served by the REST API handler.
+type console struct {
+	api    http.Handler
+	tokens []string // admin tokens allowed to use the console
+	stats  requestStats
+}
+
+func newConsole(api http.Handler, tokens []string) *console {
+	c := &console{api: api}
+	for _, tok := range tokens {
+		if tok != "" {
+			c.tokens = append(c.tokens, tok)
+		}
+	}
+	c.stats.start = time.Now()
+	return c
+}
+
+func (c *console) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case consolePrefix:
+		http.Redirect(w, r, consolePrefix+"/", http.StatusMovedPermanently)
+	case consolePrefix + "/":
+		// the page itself is public, it asks for the admin token and uses it to
+		// call the REST API and the stats endpoint.
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(consoleHTML)
+	case consolePrefix + "/api/stats":
+		if !c.authorized(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c.stats.snapshot())
+	default:
+		c.serveAPI(w, r)
+	}
+}
+
+func (c *console) authorized(r *http.Request) bool {
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, t := range c.tokens {
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// serveAPI serves the request with the REST API handler and records its
+// statistics.
+func (c *console) serveAPI(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	c.api.ServeHTTP(sw, r)
+	c.stats.record(r, sw.status, start, time.Since(start))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+type requestStats struct {
+	start time.Time
+
+	mu       sync.Mutex // protects the following fields
+	requests int64
+	errors   int64
+	total    time.Duration
+	slowest  []slowRequest // sorted from slowest, at most maxSlowRequests
+}
+
+type slowRequest struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"duration_ms"`
+}
+
+type statsSnapshot struct {
+	Uptime      float64       `json:"uptime_s"`
+	Requests    int64         `json:"requests"`
+	Errors      int64         `json:"errors"`
+	MeanLatency float64       `json:"mean_latency_ms"`
+	Slowest     []slowRequest `json:"slowest"`
+}
+
+func (s *requestStats) record(r *http.Request, status int, start time.Time, dur time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if status >= 400 {
+		s.errors++
+	}
+	s.total += dur
+
+	n := len(s.slowest)
+	if n == maxSlowRequests && dur <= time.Duration(s.slowest[n-1].Duration*float64(time.Millisecond)) {
+		return
+	}
+	// the path only, the query string may contain the token
+	s.slowest = append(s.slowest, slowRequest{
+		Time:     start.UTC(),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   status,
+		Duration: float64(dur) / float64(time.Millisecond),
+	})
+	sort.SliceStable(s.slowest, func(i, j int) bool {
+		return s.slowest[i].Duration > s.slowest[j].Duration
+	})
+	if len(s.slowest) > maxSlowRequests {
+		s.slowest = s.slowest[:maxSlowRequests]
+	}
+}
+
+func (s *requestStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := statsSnapshot{
+		Uptime:   time.Since(s.start).Seconds(),
+		Requests: s.requests,
+		Errors:   s.errors,
+		Slowest:  append([]slowRequest{}, s.slowest...),
+	}
+	if s.requests > 0 {
+		snap.MeanLatency = float64(s.total) / float64(s.requests) / float64(time.Millisecond)
+	}
+	return snap
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envFileFromArgs returns the value of the --env-file flag if it is present
+// in args. It must be known before the environment variables are parsed, so
+// it cannot wait for the normal flag parsing to be done.
+func envFileFromArgs(args []string) string {
+	return flagFromArgs(args, "e", "env-file")
+}
+
+// configFileFromArgs returns the value of the --config flag if it is present
+// in args, for the same reason as envFileFromArgs.
+func configFileFromArgs(args []string) string {
+	return flagFromArgs(args, "c", "config")
+}
+
+// flagFromArgs returns the value of the last flag with the short or long
+// name in args, or an empty string if it is not present.
+func flagFromArgs(args []string, short, long string) string {
+	var value string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || len(arg)-len(name) > 2 {
+			// not a flag
+			continue
+		}
+		name, val, hasVal := strings.Cut(name, "=")
+		if name != short && name != long {
+			continue
+		}
+		if !hasVal && i+1 < len(args) {
+			i++
+			val = args[i]
+		}
+		value = val
+	}
+	return value
+}
+
+// loadEnvFile reads the KEY=VALUE pairs from the file and sets them as
+// environment variables, unless that variable is already set in the
+// environment. Empty lines and lines starting with a '#' are ignored, an
+// optional "export " prefix is allowed and values may be enclosed in single
+// or double quotes.
+func loadEnvFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lineNum int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return fmt.Errorf("%s:%d: invalid line, expected KEY=VALUE", file, lineNum)
+		}
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		if err := os.Setenv(key, val); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
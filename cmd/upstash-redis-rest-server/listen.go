@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// listenAddr is an address on which the web server listens.
+type listenAddr struct {
+	network string // "tcp" or "unix"
+	addr    string
+	tls     bool
+}
+
+func (a listenAddr) String() string {
+	switch {
+	case a.network == "unix":
+		return "unix:" + a.addr
+	case a.tls:
+		return "tls:" + a.addr
+	default:
+		return a.addr
+	}
+}
+
+// parseAddrs parses the comma-separated list of addresses. Each address is
+// either a TCP address, a TCP address prefixed with "tls:" to serve HTTPS,
+// or a unix socket path prefixed with "unix:".
+func parseAddrs(s string) ([]listenAddr, error) {
+	var addrs []listenAddr
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		la := listenAddr{network: "tcp", addr: v}
+		if rest, ok := trimPrefix(v, "unix:"); ok {
+			la.network, la.addr = "unix", rest
+		} else if rest, ok := trimPrefix(v, "tls:"); ok {
+			la.addr, la.tls = rest, true
+		}
+		if la.addr == "" {
+			return nil, fmt.Errorf("invalid address: %q", v)
+		}
+		addrs = append(addrs, la)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no address provided")
+	}
+	return addrs, nil
+}
+
+func trimPrefix(s, prefix string) (string, bool) {
+	if strings.HasPrefix(s, prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// serve serves handler on all addresses until one of the listeners fails,
+// and returns that error, or until ctx is done, in which case the server is
+// shut down gracefully: it stops accepting connections and waits up to
+// shutdownTimeout (indefinitely if it is 0) for the requests in progress to
+// complete before closing the connections. All listeners are created before
+// serving, so that the server does not start partially if an address is
+// invalid.
+func serve(ctx context.Context, addrs []listenAddr, handler http.Handler, certFile, keyFile string, shutdownTimeout time.Duration) error {
+	srv := &http.Server{Handler: handler}
+
+	ls := make([]net.Listener, 0, len(addrs))
+	for _, a := range addrs {
+		if a.network == "unix" {
+			removeStaleSocket(a.addr)
+		}
+		l, err := net.Listen(a.network, a.addr)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return err
+		}
+		ls = append(ls, l)
+	}
+
+	errc := make(chan error, len(ls))
+	for i, l := range ls {
+		a := addrs[i]
+		log.Printf("listening on %s...", a)
+		go func(l net.Listener) {
+			if a.tls {
+				errc <- srv.ServeTLS(l, certFile, keyFile)
+				return
+			}
+			errc <- srv.Serve(l)
+		}(l)
+	}
+
+	select {
+	case err := <-errc:
+		srv.Close()
+		return err
+
+	case <-ctx.Done():
+		log.Print("shutting down...")
+		sctx := context.Background()
+		if shutdownTimeout > 0 {
+			var cancel context.CancelFunc
+			sctx, cancel = context.WithTimeout(sctx, shutdownTimeout)
+			defer cancel()
+		}
+		if err := srv.Shutdown(sctx); err != nil {
+			log.Printf("requests still in progress after %s, closing their connections", shutdownTimeout)
+			srv.Close()
+		}
+		return nil
+	}
+}
+
+// removeStaleSocket removes the unix socket file at path if it exists, so
+// that the server can be restarted after it was not shut down cleanly.
+func removeStaleSocket(path string) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/redisconn"
+)
+
+// isRedisURL returns true if addr is a redis:// or rediss:// URL, as opposed
+// to a host:port address.
+func isRedisURL(addr string) bool {
+	return strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://")
+}
+
+// redisURLDB returns the database number of the Redis URL, 0 if it does not
+// specify one.
+func redisURLDB(addr string) (int, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return 0, err
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" {
+		return 0, nil
+	}
+	db, err := strconv.Atoi(path)
+	if err != nil || db < 0 {
+		return 0, fmt.Errorf("invalid database: %s", path)
+	}
+	return db, nil
+}
+
+// redisDialOptions returns the options to connect to the Redis instance,
+// with the TLS configuration of the flags, if any.
+func (c *cmd) redisDialOptions() ([]redis.DialOption, error) {
+	if c.RedisCA == "" && c.RedisCert == "" && c.RedisKey == "" {
+		return nil, nil
+	}
+
+	var conf tls.Config
+	if c.RedisCA != "" {
+		b, err := os.ReadFile(c.RedisCA)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", c.RedisCA)
+		}
+	}
+	if c.RedisCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.RedisCert, c.RedisKey)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return []redis.DialOption{redis.DialTLSConfig(&conf)}, nil
+}
+
+// validateRedisAddr validates the Redis address and TLS flags and sets the
+// database number of the address.
+func (c *cmd) validateRedisAddr() error {
+	if (c.RedisCA != "" || c.RedisCert != "" || c.RedisKey != "") && !strings.HasPrefix(c.RedisAddr, "rediss://") {
+		return errors.New("--redis-tls-ca, --redis-tls-cert and --redis-tls-key require a rediss:// --redis-addr")
+	}
+	if (c.RedisCert == "") != (c.RedisKey == "") {
+		return errors.New("--redis-tls-cert and --redis-tls-key must be provided together")
+	}
+	if c.Cluster || c.Sentinel != "" {
+		return c.validateRedisNodes()
+	}
+	if !isRedisURL(c.RedisAddr) {
+		return nil
+	}
+
+	db, err := redisURLDB(c.RedisAddr)
+	if err != nil {
+		return fmt.Errorf("invalid --redis-addr: %w", err)
+	}
+	c.redisDB = db
+	return nil
+}
+
+// validateRedisNodes validates the comma-separated list of addresses of
+// --redis-addr used with --redis-cluster and --redis-sentinel-master, and
+// sets the addresses and dial options of the nodes. The credentials and
+// database of the first address, if it is a URL, apply to all the nodes (for
+// Sentinel, to the master).
+func (c *cmd) validateRedisNodes() error {
+	if c.Cluster && c.Sentinel != "" {
+		return errors.New("--redis-cluster and --redis-sentinel-master are mutually exclusive")
+	}
+	if c.RedisAddr == "memory" {
+		return errors.New("--redis-cluster and --redis-sentinel-master cannot be used with --redis-addr memory")
+	}
+	if c.Cluster && c.UserConns > 0 {
+		return errors.New("--user-conns is not supported with --redis-cluster")
+	}
+
+	defaultPort := "6379"
+	if c.Sentinel != "" {
+		defaultPort = "26379"
+	}
+	c.nodeTLS = strings.HasPrefix(c.RedisAddr, "rediss://")
+	for i, addr := range splitList(c.RedisAddr) {
+		if !isRedisURL(addr) {
+			if c.nodeTLS {
+				return errors.New("invalid --redis-addr: all the addresses must be rediss:// URLs")
+			}
+			c.nodeAddrs = append(c.nodeAddrs, addr)
+			continue
+		}
+
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("invalid --redis-addr: %w", err)
+		}
+		if (u.Scheme == "rediss") != c.nodeTLS {
+			return errors.New("invalid --redis-addr: all the addresses must be rediss:// URLs")
+		}
+		port := u.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		c.nodeAddrs = append(c.nodeAddrs, net.JoinHostPort(u.Hostname(), port))
+		if i > 0 {
+			continue
+		}
+
+		if u.User != nil {
+			if name := u.User.Username(); name != "" {
+				c.nodeOpts = append(c.nodeOpts, redis.DialUsername(name))
+			}
+			if pwd, ok := u.User.Password(); ok {
+				c.nodeOpts = append(c.nodeOpts, redis.DialPassword(pwd))
+			}
+		}
+		db, err := redisURLDB(addr)
+		if err != nil {
+			return fmt.Errorf("invalid --redis-addr: %w", err)
+		}
+		if db != 0 {
+			if c.Cluster {
+				return errors.New("invalid --redis-addr: Redis Cluster only supports database 0")
+			}
+			c.nodeOpts = append(c.nodeOpts, redis.DialDatabase(db))
+			c.redisDB = db
+		}
+	}
+	if len(c.nodeAddrs) == 0 {
+		return errors.New("invalid --redis-addr: no address")
+	}
+	return nil
+}
+
+// redisDialer returns the function that connects to the Redis instance at
+// addr, or to the master of --redis-sentinel-master.
+func (c *cmd) redisDialer(addr string, opts []redis.DialOption) func(context.Context) (redis.Conn, error) {
+	if c.Sentinel != "" {
+		tlsOpts := c.nodeTLSOptions(opts)
+		s := &redisconn.Sentinel{
+			Addrs:               c.nodeAddrs,
+			MasterName:          c.Sentinel,
+			DialOptions:         append(append([]redis.DialOption(nil), c.nodeOpts...), tlsOpts...),
+			SentinelDialOptions: tlsOpts,
+		}
+		return s.Dial
+	}
+	return func(ctx context.Context) (redis.Conn, error) {
+		return dialRedis(ctx, addr, opts)
+	}
+}
+
+// redisCluster returns the Redis Cluster of --redis-cluster.
+func (c *cmd) redisCluster(opts []redis.DialOption) *redisconn.Cluster {
+	return &redisconn.Cluster{
+		Addrs:       c.nodeAddrs,
+		DialOptions: append(append([]redis.DialOption(nil), c.nodeOpts...), c.nodeTLSOptions(opts)...),
+	}
+}
+
+// nodeTLSOptions returns the TLS dial options of the nodes of
+// --redis-cluster and --redis-sentinel-master, based on the TLS options of
+// the flags.
+func (c *cmd) nodeTLSOptions(opts []redis.DialOption) []redis.DialOption {
+	if !c.nodeTLS {
+		return opts
+	}
+	return append(append([]redis.DialOption(nil), opts...), redis.DialUseTLS(true))
+}
+
+// dialRedis connects to the Redis instance at addr, either a host:port
+// address or a redis:// or rediss:// URL, which may specify the username,
+// password and database number.
+func dialRedis(ctx context.Context, addr string, opts []redis.DialOption) (redis.Conn, error) {
+	if isRedisURL(addr) {
+		return redis.DialURLContext(ctx, addr, opts...)
+	}
+	return redis.DialContext(ctx, "tcp", addr, opts...)
+}
+
+func makePool(dial func(context.Context) (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{DialContext: dial}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/dump"
+	"github.com/mna/upstashdis/internal/rediscmd"
+	"github.com/mna/upstashdis/restserver"
+)
+
+// snapshotToken is the API token of the internal REST server used to
+// export and import the data of the miniredis instance. It is only served
+// in-process, so it does not need to be secret.
+const snapshotToken = "snapshot"
+
+// handlerDoer is an upstashdis.HTTPDoer that serves the requests in-process
+// by calling the handler.
+type handlerDoer struct {
+	h http.Handler
+}
+
+func (d handlerDoer) Do(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	d.h.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+// snapshotClient returns a client that executes the commands on the Redis
+// connections of the pool via an internal REST server, without the
+// restrictions of the configured server (e.g. --deny-commands).
+func snapshotClient(pool *redis.Pool) *upstashdis.Client {
+	srv := &restserver.Server{
+		APIToken: snapshotToken,
+		GetConnFunc: func(ctx context.Context) restserver.Conn {
+			return pool.Get()
+		},
+	}
+	return &upstashdis.Client{
+		BaseURL:    "http://snapshot.invalid",
+		APIToken:   snapshotToken,
+		HTTPClient: handlerDoer{h: srv},
+	}
+}
+
+// loadSeed loads the data of the file into the Redis instance and returns
+// the number of keys or commands loaded. The file is either a dump, as
+// written by saveSnapshot and the upstash-redis-rest-dump command, in which
+// case the existing keys are replaced, or a list of commands, one per line,
+// with the same syntax as the upstash-redis-cli commands. Empty lines and
+// lines starting with a '#' are ignored.
+func loadSeed(ctx context.Context, pool *redis.Pool, file string) (int, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		n, _, err := dump.Import(ctx, snapshotClient(pool), bytes.NewReader(b), &dump.ImportOptions{Replace: true})
+		return n, err
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	var n, lineNum int
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		args, err := rediscmd.SplitArgs(line)
+		if err != nil {
+			return n, fmt.Errorf("%s:%d: %w", file, lineNum, err)
+		}
+		vals := make([]interface{}, len(args)-1)
+		for i, arg := range args[1:] {
+			vals[i] = arg
+		}
+		if _, err := conn.Do(args[0], vals...); err != nil {
+			return n, fmt.Errorf("%s:%d: %w", file, lineNum, err)
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+// loadSnapshot loads the snapshot file written by saveSnapshot, if it
+// exists, and returns the number of keys loaded.
+func loadSnapshot(ctx context.Context, pool *redis.Pool, file string) (int, error) {
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return loadSeed(ctx, pool, file)
+}
+
+// saveSnapshot writes the dump of the Redis instance to the file and
+// returns the number of keys saved. It writes to a temporary file that is
+// renamed to the file, so that the file is never partially written.
+func saveSnapshot(ctx context.Context, pool *redis.Pool, file string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name()) // fails if renamed, ignore
+
+	bw := bufio.NewWriter(f)
+	n, err := dump.Export(ctx, snapshotClient(pool), bw, nil)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(f.Name(), file)
+}
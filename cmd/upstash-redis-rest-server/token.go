@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mna/mainer"
+)
+
+const (
+	roleAdmin    = "admin"
+	roleReadOnly = "readonly"
+)
+
+var (
+	tokenShortUsage = fmt.Sprintf(`
+usage: %s token new [--role <ROLE>] [--token-file <FILE>]
+Run '%[1]s token --help' for details.
+`, binName)
+
+	tokenLongUsage = fmt.Sprintf(`usage: %s token new [--role <ROLE>] [--token-file <FILE>]
+       %[1]s token --help
+
+Generate a cryptographically random API token and print it on stdout.
+
+Valid flag options are:
+       -f --token-file FILE      Append the generated token and its role
+                                 to FILE. Can also be set via the
+                                 environment variable
+                                 UPSTASH_REDIS_REST_SERVER_TOKEN_FILE.
+       -h --help                 Show this help.
+       -r --role ROLE            Role of the generated token, either
+                                 '%s' (the default) or '%s'.
+
+The token file contains one token per line, optionally followed by
+whitespace and its role. Empty lines and lines starting with '#' are
+ignored. It can be used to configure the server's valid tokens with its
+--token-file flag.
+`, binName, roleAdmin, roleReadOnly)
+)
+
+type tokenCmd struct {
+	Role      string `flag:"r,role" ignored:"true"`
+	TokenFile string `flag:"f,token-file" envconfig:"token_file"`
+	Help      bool   `flag:"h,help" ignored:"true"`
+
+	args []string
+}
+
+func (c *tokenCmd) SetArgs(args []string) {
+	c.args = args
+}
+
+func (c *tokenCmd) Validate() error {
+	if c.Help {
+		return nil
+	}
+
+	if len(c.args) == 0 {
+		return errors.New("no token action provided")
+	}
+	if !strings.EqualFold(c.args[0], "new") {
+		return fmt.Errorf("unknown token action: %s", c.args[0])
+	}
+	if len(c.args) > 1 {
+		return errors.New("unexpected arguments provided")
+	}
+
+	switch c.Role {
+	case "":
+		c.Role = roleAdmin
+	case roleAdmin, roleReadOnly:
+	default:
+		return fmt.Errorf("invalid role: %s", c.Role)
+	}
+	return nil
+}
+
+func (c *tokenCmd) Main(args []string, stdio mainer.Stdio) mainer.ExitCode {
+	p := mainer.Parser{
+		EnvVars:   true,
+		EnvPrefix: strings.ReplaceAll(binName, "-", "_"),
+	}
+	if err := p.Parse(args, c); err != nil {
+		fmt.Fprintf(stdio.Stderr, "invalid arguments: %s\n%s", err, tokenShortUsage)
+		return mainer.InvalidArgs
+	}
+
+	if c.Help {
+		fmt.Fprint(stdio.Stdout, tokenLongUsage)
+		return mainer.Success
+	}
+
+	tok, err := newToken()
+	if err != nil {
+		fmt.Fprintf(stdio.Stderr, "failed to generate token: %s\n", err)
+		return mainer.Failure
+	}
+
+	if c.TokenFile != "" {
+		if err := appendTokenFile(c.TokenFile, tok, c.Role); err != nil {
+			fmt.Fprintf(stdio.Stderr, "failed to write token file: %s\n", err)
+			return mainer.Failure
+		}
+	}
+	fmt.Fprintln(stdio.Stdout, tok)
+	return mainer.Success
+}
+
+func newToken() (string, error) {
+	var buf [48]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf[:]), nil
+}
+
+func appendTokenFile(file, tok, role string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", tok, role); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readTokenFile reads the tokens listed in file and returns the admin tokens
+// and the read-only tokens.
+func readTokenFile(file string) (admin, readOnly []string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var lineNum int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		role := roleAdmin
+		if len(fields) > 1 {
+			role = fields[1]
+		}
+		if len(fields) > 2 {
+			return nil, nil, fmt.Errorf("%s:%d: invalid line, expected TOKEN [ROLE]", file, lineNum)
+		}
+
+		switch role {
+		case roleAdmin:
+			admin = append(admin, fields[0])
+		case roleReadOnly:
+			readOnly = append(readOnly, fields[0])
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: invalid role: %s", file, lineNum, role)
+		}
+	}
+	return admin, readOnly, sc.Err()
+}
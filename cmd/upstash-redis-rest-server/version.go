@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Those variables can be set at build time via ldflags, e.g.:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abcdef"
+//
+// If they are not set, the information is read from the build information
+// embedded in the binary by the Go toolchain, if available.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+type buildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+func (bi buildInfo) String() string {
+	return fmt.Sprintf("%s (commit: %s, built: %s, %s)", bi.Version, bi.Commit, bi.BuildDate, bi.GoVersion)
+}
+
+func getBuildInfo() buildInfo {
+	bi := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if bi.Version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			bi.Version = info.Main.Version
+		}
+		for _, set := range info.Settings {
+			switch set.Key {
+			case "vcs.revision":
+				if bi.Commit == "" {
+					bi.Commit = set.Value
+				}
+			case "vcs.time":
+				if bi.BuildDate == "" {
+					bi.BuildDate = set.Value
+				}
+			}
+		}
+	}
+
+	if bi.Version == "" {
+		bi.Version = "(devel)"
+	}
+	if bi.Commit == "" {
+		bi.Commit = "unknown"
+	}
+	if bi.BuildDate == "" {
+		bi.BuildDate = "unknown"
+	}
+	return bi
+}
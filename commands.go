@@ -0,0 +1,471 @@
+package upstashdis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrNil is the error returned when a command returns a nil result and the
+// method called has no way to represent it in its return value, e.g. a GET
+// of a key that does not exist.
+var ErrNil = errors.New("upstashdis: nil result")
+
+// Commands provides strongly-typed methods for the common Redis commands,
+// so that callers do not have to build the commands and decode the results
+// themselves. Each method executes its command in a single REST API call
+// with the provided ctx. Use Client.NewRequest to execute commands that are
+// not covered, or to execute multiple commands in a pipeline.
+//
+// Methods that return a single string or number return ErrNil if the
+// command returned a nil result. Methods that return a bool return true if
+// the command returned 1, false if it returned 0.
+type Commands struct {
+	// Client is the client used to execute the commands.
+	Client *Client
+}
+
+// Z is a member of a sorted set with its score.
+type Z struct {
+	Score  float64
+	Member string
+}
+
+// SetOption is an option for the SET command.
+type SetOption struct {
+	args []interface{}
+}
+
+// SetTTL sets the key to expire after d, with millisecond precision.
+func SetTTL(d time.Duration) SetOption {
+	return SetOption{[]interface{}{"PX", d.Milliseconds()}}
+}
+
+// SetKeepTTL retains the time to live of the key.
+func SetKeepTTL() SetOption { return SetOption{[]interface{}{"KEEPTTL"}} }
+
+// SetNX only sets the key if it does not already exist.
+func SetNX() SetOption { return SetOption{[]interface{}{"NX"}} }
+
+// SetXX only sets the key if it already exists.
+func SetXX() SetOption { return SetOption{[]interface{}{"XX"}} }
+
+// Del deletes the keys and returns the number of keys deleted.
+func (c Commands) Del(ctx context.Context, keys ...string) (int64, error) {
+	return c.int(ctx, "DEL", strArgs(nil, keys)...)
+}
+
+// Exists returns the number of keys that exist.
+func (c Commands) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return c.int(ctx, "EXISTS", strArgs(nil, keys)...)
+}
+
+// Expire sets the key to expire after d, with millisecond precision. It
+// returns false if the key does not exist.
+func (c Commands) Expire(ctx context.Context, key string, d time.Duration) (bool, error) {
+	return c.bool(ctx, "PEXPIRE", key, d.Milliseconds())
+}
+
+// Persist removes the expiration of the key. It returns false if the key
+// does not exist or has no expiration.
+func (c Commands) Persist(ctx context.Context, key string) (bool, error) {
+	return c.bool(ctx, "PERSIST", key)
+}
+
+// TTL returns the remaining time to live of the key, with millisecond
+// precision. It returns ErrNil if the key does not exist, and -1 if the key
+// exists but has no expiration.
+func (c Commands) TTL(ctx context.Context, key string) (time.Duration, error) {
+	n, err := c.int(ctx, "PTTL", key)
+	switch {
+	case err != nil:
+		return 0, err
+	case n == -2:
+		return 0, ErrNil
+	case n < 0:
+		return -1, nil
+	default:
+		return time.Duration(n) * time.Millisecond, nil
+	}
+}
+
+// Type returns the type of the value stored at key, "none" if it does not
+// exist.
+func (c Commands) Type(ctx context.Context, key string) (string, error) {
+	return c.str(ctx, "TYPE", key)
+}
+
+// Rename renames key to newKey.
+func (c Commands) Rename(ctx context.Context, key, newKey string) error {
+	return c.exec(ctx, nil, "RENAME", key, newKey)
+}
+
+// Get returns the value of the key.
+func (c Commands) Get(ctx context.Context, key string) (string, error) {
+	return c.str(ctx, "GET", key)
+}
+
+// Set sets the key to value, with the provided options. It returns false if
+// the key was not set because of the SetNX or SetXX option.
+func (c Commands) Set(ctx context.Context, key string, value interface{}, opts ...SetOption) (bool, error) {
+	args := []interface{}{key, value}
+	for _, opt := range opts {
+		args = append(args, opt.args...)
+	}
+	var res *string
+	if err := c.exec(ctx, &res, "SET", args...); err != nil {
+		return false, err
+	}
+	return res != nil, nil
+}
+
+// MGet returns the values of the keys, in the same order. The value is nil
+// for keys that do not exist.
+func (c Commands) MGet(ctx context.Context, keys ...string) ([]*string, error) {
+	var res []*string
+	err := c.exec(ctx, &res, "MGET", strArgs(nil, keys)...)
+	return res, err
+}
+
+// MSet sets the keys to their values.
+func (c Commands) MSet(ctx context.Context, values map[string]interface{}) error {
+	return c.exec(ctx, nil, "MSET", mapArgs(nil, values)...)
+}
+
+// Incr increments the integer value of the key by one and returns the new
+// value.
+func (c Commands) Incr(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "INCR", key)
+}
+
+// IncrBy increments the integer value of the key by n and returns the new
+// value.
+func (c Commands) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return c.int(ctx, "INCRBY", key, n)
+}
+
+// IncrByFloat increments the float value of the key by n and returns the
+// new value.
+func (c Commands) IncrByFloat(ctx context.Context, key string, n float64) (float64, error) {
+	return c.float(ctx, "INCRBYFLOAT", key, n)
+}
+
+// Decr decrements the integer value of the key by one and returns the new
+// value.
+func (c Commands) Decr(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "DECR", key)
+}
+
+// DecrBy decrements the integer value of the key by n and returns the new
+// value.
+func (c Commands) DecrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return c.int(ctx, "DECRBY", key, n)
+}
+
+// Append appends value to the value of the key and returns the new length.
+func (c Commands) Append(ctx context.Context, key, value string) (int64, error) {
+	return c.int(ctx, "APPEND", key, value)
+}
+
+// StrLen returns the length of the value of the key.
+func (c Commands) StrLen(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "STRLEN", key)
+}
+
+// HGet returns the value of the field of the hash.
+func (c Commands) HGet(ctx context.Context, key, field string) (string, error) {
+	return c.str(ctx, "HGET", key, field)
+}
+
+// HSet sets the fields of the hash to their values and returns the number
+// of fields that were added.
+func (c Commands) HSet(ctx context.Context, key string, values map[string]interface{}) (int64, error) {
+	return c.int(ctx, "HSET", mapArgs([]interface{}{key}, values)...)
+}
+
+// HGetAll returns all fields and values of the hash.
+func (c Commands) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return c.strMap(ctx, "HGETALL", key)
+}
+
+// HMGet returns the values of the fields of the hash, in the same order.
+// The value is nil for fields that do not exist.
+func (c Commands) HMGet(ctx context.Context, key string, fields ...string) ([]*string, error) {
+	var res []*string
+	err := c.exec(ctx, &res, "HMGET", strArgs([]interface{}{key}, fields)...)
+	return res, err
+}
+
+// HDel deletes the fields of the hash and returns the number of fields
+// deleted.
+func (c Commands) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	return c.int(ctx, "HDEL", strArgs([]interface{}{key}, fields)...)
+}
+
+// HExists returns true if the field exists in the hash.
+func (c Commands) HExists(ctx context.Context, key, field string) (bool, error) {
+	return c.bool(ctx, "HEXISTS", key, field)
+}
+
+// HIncrBy increments the integer value of the field of the hash by n and
+// returns the new value.
+func (c Commands) HIncrBy(ctx context.Context, key, field string, n int64) (int64, error) {
+	return c.int(ctx, "HINCRBY", key, field, n)
+}
+
+// HKeys returns the fields of the hash.
+func (c Commands) HKeys(ctx context.Context, key string) ([]string, error) {
+	return c.strs(ctx, "HKEYS", key)
+}
+
+// HVals returns the values of the hash.
+func (c Commands) HVals(ctx context.Context, key string) ([]string, error) {
+	return c.strs(ctx, "HVALS", key)
+}
+
+// HLen returns the number of fields of the hash.
+func (c Commands) HLen(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "HLEN", key)
+}
+
+// HSetStruct sets the fields of the hash to the fields of the struct v (or
+// pointer to a struct), flattened as described for Args.AddFlat, and returns
+// the number of fields that were added.
+func (c Commands) HSetStruct(ctx context.Context, key string, v interface{}) (int64, error) {
+	return c.int(ctx, "HSET", Args{key}.AddFlat(v)...)
+}
+
+// HGetAllStruct scans the fields of the hash into the struct pointed to by
+// dst, as described for ScanStruct. It returns ErrNil if the hash does not
+// exist, in which case dst is left unchanged.
+func (c Commands) HGetAllStruct(ctx context.Context, key string, dst interface{}) error {
+	var vals []json.RawMessage
+	if err := c.exec(ctx, &vals, "HGETALL", key); err != nil {
+		return err
+	}
+	if len(vals) == 0 {
+		return ErrNil
+	}
+	return ScanStruct(vals, dst)
+}
+
+// LPush prepends the values to the list and returns its new length.
+func (c Commands) LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return c.int(ctx, "LPUSH", append([]interface{}{key}, values...)...)
+}
+
+// RPush appends the values to the list and returns its new length.
+func (c Commands) RPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return c.int(ctx, "RPUSH", append([]interface{}{key}, values...)...)
+}
+
+// LPop removes and returns the first element of the list.
+func (c Commands) LPop(ctx context.Context, key string) (string, error) {
+	return c.str(ctx, "LPOP", key)
+}
+
+// RPop removes and returns the last element of the list.
+func (c Commands) RPop(ctx context.Context, key string) (string, error) {
+	return c.str(ctx, "RPOP", key)
+}
+
+// LRange returns the elements of the list between the start and stop
+// indices, inclusive. Negative indices are offsets from the end of the list.
+func (c Commands) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.strs(ctx, "LRANGE", key, start, stop)
+}
+
+// LIndex returns the element of the list at index.
+func (c Commands) LIndex(ctx context.Context, key string, index int64) (string, error) {
+	return c.str(ctx, "LINDEX", key, index)
+}
+
+// LLen returns the length of the list.
+func (c Commands) LLen(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "LLEN", key)
+}
+
+// LRem removes count occurrences of value from the list (see the Redis
+// documentation for the meaning of count) and returns the number of
+// elements removed.
+func (c Commands) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	return c.int(ctx, "LREM", key, count, value)
+}
+
+// LTrim trims the list to the elements between the start and stop indices,
+// inclusive.
+func (c Commands) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return c.exec(ctx, nil, "LTRIM", key, start, stop)
+}
+
+// SAdd adds the members to the set and returns the number of members added.
+func (c Commands) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return c.int(ctx, "SADD", append([]interface{}{key}, members...)...)
+}
+
+// SRem removes the members from the set and returns the number of members
+// removed.
+func (c Commands) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return c.int(ctx, "SREM", append([]interface{}{key}, members...)...)
+}
+
+// SMembers returns the members of the set.
+func (c Commands) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.strs(ctx, "SMEMBERS", key)
+}
+
+// SIsMember returns true if member is a member of the set.
+func (c Commands) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return c.bool(ctx, "SISMEMBER", key, member)
+}
+
+// SCard returns the number of members of the set.
+func (c Commands) SCard(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "SCARD", key)
+}
+
+// ZAdd adds the members to the sorted set, or updates their score if they
+// already exist, and returns the number of members added.
+func (c Commands) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	args := make([]interface{}, 0, 1+2*len(members))
+	args = append(args, key)
+	for _, m := range members {
+		args = append(args, m.Score, m.Member)
+	}
+	return c.int(ctx, "ZADD", args...)
+}
+
+// ZRem removes the members from the sorted set and returns the number of
+// members removed.
+func (c Commands) ZRem(ctx context.Context, key string, members ...string) (int64, error) {
+	return c.int(ctx, "ZREM", strArgs([]interface{}{key}, members)...)
+}
+
+// ZScore returns the score of the member of the sorted set.
+func (c Commands) ZScore(ctx context.Context, key, member string) (float64, error) {
+	return c.float(ctx, "ZSCORE", key, member)
+}
+
+// ZIncrBy increments the score of the member of the sorted set by n and
+// returns the new score.
+func (c Commands) ZIncrBy(ctx context.Context, key string, n float64, member string) (float64, error) {
+	return c.float(ctx, "ZINCRBY", key, n, member)
+}
+
+// ZRank returns the rank of the member in the sorted set, ordered from low
+// to high scores.
+func (c Commands) ZRank(ctx context.Context, key, member string) (int64, error) {
+	return c.int(ctx, "ZRANK", key, member)
+}
+
+// ZCard returns the number of members of the sorted set.
+func (c Commands) ZCard(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "ZCARD", key)
+}
+
+// ZRange returns the members of the sorted set between the start and stop
+// ranks, inclusive, ordered from low to high scores.
+func (c Commands) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.strs(ctx, "ZRANGE", key, start, stop)
+}
+
+// ZRangeWithScores is like ZRange, but it returns the members with their
+// score.
+func (c Commands) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	vals, err := c.strs(ctx, "ZRANGE", key, start, stop, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	if len(vals)%2 != 0 {
+		return nil, fmt.Errorf("upstashdis: odd number of values for ZRANGE WITHSCORES: %d", len(vals))
+	}
+	zs := make([]Z, 0, len(vals)/2)
+	for i := 0; i < len(vals); i += 2 {
+		score, err := strconv.ParseFloat(vals[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		zs = append(zs, Z{Score: score, Member: vals[i]})
+	}
+	return zs, nil
+}
+
+func (c Commands) exec(ctx context.Context, dst interface{}, cmd string, args ...interface{}) error {
+	return c.Client.NewRequestContext(ctx).ExecOne(dst, cmd, args...)
+}
+
+func (c Commands) str(ctx context.Context, cmd string, args ...interface{}) (string, error) {
+	var res *string
+	if err := c.exec(ctx, &res, cmd, args...); err != nil {
+		return "", err
+	}
+	if res == nil {
+		return "", ErrNil
+	}
+	return *res, nil
+}
+
+func (c Commands) int(ctx context.Context, cmd string, args ...interface{}) (int64, error) {
+	var res *int64
+	if err := c.exec(ctx, &res, cmd, args...); err != nil {
+		return 0, err
+	}
+	if res == nil {
+		return 0, ErrNil
+	}
+	return *res, nil
+}
+
+func (c Commands) bool(ctx context.Context, cmd string, args ...interface{}) (bool, error) {
+	n, err := c.int(ctx, cmd, args...)
+	return n == 1, err
+}
+
+// float decodes a float result, which is returned as a string by Redis.
+func (c Commands) float(ctx context.Context, cmd string, args ...interface{}) (float64, error) {
+	s, err := c.str(ctx, cmd, args...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (c Commands) strs(ctx context.Context, cmd string, args ...interface{}) ([]string, error) {
+	var res []string
+	err := c.exec(ctx, &res, cmd, args...)
+	return res, err
+}
+
+// strMap decodes a result that is a flat array of alternating keys and
+// values, as returned e.g. by HGETALL.
+func (c Commands) strMap(ctx context.Context, cmd string, args ...interface{}) (map[string]string, error) {
+	vals, err := c.strs(ctx, cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(vals)%2 != 0 {
+		return nil, fmt.Errorf("upstashdis: odd number of values for %s: %d", cmd, len(vals))
+	}
+	m := make(map[string]string, len(vals)/2)
+	for i := 0; i < len(vals); i += 2 {
+		m[vals[i]] = vals[i+1]
+	}
+	return m, nil
+}
+
+func strArgs(args []interface{}, vals []string) []interface{} {
+	for _, v := range vals {
+		args = append(args, v)
+	}
+	return args
+}
+
+func mapArgs(args []interface{}, vals map[string]interface{}) []interface{} {
+	for k, v := range vals {
+		args = append(args, k, v)
+	}
+	return args
+}
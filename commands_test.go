@@ -0,0 +1,231 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cmds := upstashdis.Commands{Client: srv.Client()}
+	ctx := context.Background()
+
+	t.Run("strings", func(t *testing.T) {
+		ok, err := cmds.Set(ctx, "s", "v")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = cmds.Set(ctx, "s", "w", upstashdis.SetNX())
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		s, err := cmds.Get(ctx, "s")
+		require.NoError(t, err)
+		require.Equal(t, "v", s)
+
+		_, err = cmds.Get(ctx, "nope")
+		require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+
+		n, err := cmds.Append(ctx, "s", "xyz")
+		require.NoError(t, err)
+		require.Equal(t, int64(4), n)
+
+		require.NoError(t, cmds.MSet(ctx, map[string]interface{}{"m1": 1, "m2": "two"}))
+		vals, err := cmds.MGet(ctx, "m1", "nope", "m2")
+		require.NoError(t, err)
+		require.Len(t, vals, 3)
+		require.Equal(t, "1", *vals[0])
+		require.Nil(t, vals[1])
+		require.Equal(t, "two", *vals[2])
+
+		n, err = cmds.IncrBy(ctx, "m1", 10)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), n)
+		n, err = cmds.Decr(ctx, "m1")
+		require.NoError(t, err)
+		require.Equal(t, int64(10), n)
+
+		f, err := cmds.IncrByFloat(ctx, "f", 1.5)
+		require.NoError(t, err)
+		require.Equal(t, 1.5, f)
+
+		var rerr *upstashdis.Error
+		_, err = cmds.Incr(ctx, "s")
+		require.True(t, errors.As(err, &rerr), "%v", err)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		_, err := cmds.Set(ctx, "k", "v", upstashdis.SetTTL(time.Minute))
+		require.NoError(t, err)
+
+		ttl, err := cmds.TTL(ctx, "k")
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+
+		ok, err := cmds.Persist(ctx, "k")
+		require.NoError(t, err)
+		require.True(t, ok)
+		ttl, err = cmds.TTL(ctx, "k")
+		require.NoError(t, err)
+		require.Equal(t, time.Duration(-1), ttl)
+
+		_, err = cmds.TTL(ctx, "nope")
+		require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+
+		ok, err = cmds.Expire(ctx, "nope", time.Second)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, cmds.Rename(ctx, "k", "k2"))
+		typ, err := cmds.Type(ctx, "k2")
+		require.NoError(t, err)
+		require.Equal(t, "string", typ)
+
+		n, err := cmds.Exists(ctx, "k", "k2")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		n, err = cmds.Del(ctx, "k2")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+	})
+
+	t.Run("hashes", func(t *testing.T) {
+		n, err := cmds.HSet(ctx, "h", map[string]interface{}{"a": 1, "b": "x"})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		m, err := cmds.HGetAll(ctx, "h")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"a": "1", "b": "x"}, m)
+
+		n, err = cmds.HIncrBy(ctx, "h", "a", 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), n)
+
+		s, err := cmds.HGet(ctx, "h", "a")
+		require.NoError(t, err)
+		require.Equal(t, "3", s)
+		_, err = cmds.HGet(ctx, "h", "nope")
+		require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+
+		vals, err := cmds.HMGet(ctx, "h", "b", "nope")
+		require.NoError(t, err)
+		require.Equal(t, "x", *vals[0])
+		require.Nil(t, vals[1])
+
+		ok, err := cmds.HExists(ctx, "h", "b")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		keys, err := cmds.HKeys(ctx, "h")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b"}, keys)
+
+		n, err = cmds.HDel(ctx, "h", "a")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		n, err = cmds.HLen(ctx, "h")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+
+		m, err = cmds.HGetAll(ctx, "nope")
+		require.NoError(t, err)
+		require.Empty(t, m)
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		n, err := cmds.RPush(ctx, "l", "b", "c", "b")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), n)
+		_, err = cmds.LPush(ctx, "l", "a")
+		require.NoError(t, err)
+
+		vals, err := cmds.LRange(ctx, "l", 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b", "c", "b"}, vals)
+
+		s, err := cmds.LIndex(ctx, "l", -1)
+		require.NoError(t, err)
+		require.Equal(t, "b", s)
+
+		n, err = cmds.LRem(ctx, "l", 0, "b")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		s, err = cmds.LPop(ctx, "l")
+		require.NoError(t, err)
+		require.Equal(t, "a", s)
+		s, err = cmds.RPop(ctx, "l")
+		require.NoError(t, err)
+		require.Equal(t, "c", s)
+		_, err = cmds.RPop(ctx, "l")
+		require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+
+		n, err = cmds.LLen(ctx, "l")
+		require.NoError(t, err)
+		require.Equal(t, int64(0), n)
+	})
+
+	t.Run("sets", func(t *testing.T) {
+		n, err := cmds.SAdd(ctx, "set", "a", "b", "a")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		ok, err := cmds.SIsMember(ctx, "set", "b")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		n, err = cmds.SRem(ctx, "set", "b", "c")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+
+		vals, err := cmds.SMembers(ctx, "set")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, vals)
+
+		n, err = cmds.SCard(ctx, "set")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+	})
+
+	t.Run("sorted sets", func(t *testing.T) {
+		n, err := cmds.ZAdd(ctx, "z", upstashdis.Z{Score: 2, Member: "b"}, upstashdis.Z{Score: 1.5, Member: "a"})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		f, err := cmds.ZIncrBy(ctx, "z", 1, "a")
+		require.NoError(t, err)
+		require.Equal(t, 2.5, f)
+
+		f, err = cmds.ZScore(ctx, "z", "b")
+		require.NoError(t, err)
+		require.Equal(t, 2.0, f)
+		_, err = cmds.ZScore(ctx, "z", "nope")
+		require.True(t, errors.Is(err, upstashdis.ErrNil), "%v", err)
+
+		n, err = cmds.ZRank(ctx, "z", "a")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+
+		vals, err := cmds.ZRange(ctx, "z", 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "a"}, vals)
+
+		zs, err := cmds.ZRangeWithScores(ctx, "z", 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []upstashdis.Z{{Score: 2, Member: "b"}, {Score: 2.5, Member: "a"}}, zs)
+
+		n, err = cmds.ZRem(ctx, "z", "a")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		n, err = cmds.ZCard(ctx, "z")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+	})
+}
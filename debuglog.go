@@ -0,0 +1,179 @@
+package upstashdis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	maxLogItems    = 10 // maximum number of commands, arguments and array elements logged
+	maxLogValueLen = 64 // maximum length of a value logged
+)
+
+// Logger is the interface used to log the REST API calls for debugging, see
+// Client.Logger. It is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// LogRedaction controls how the arguments of the commands and the results
+// are logged by the Logger of a Client. The error messages are always
+// logged.
+type LogRedaction int
+
+// List of supported redaction modes.
+const (
+	// RedactAll logs the names of the commands and replaces their arguments
+	// with "?", and logs the type and length of the results instead of their
+	// value. This is the default.
+	RedactAll LogRedaction = iota
+
+	// RedactValues is like RedactAll, except that the first argument of the
+	// commands, which is usually the key, is logged.
+	RedactValues
+
+	// RedactNone logs the arguments of the commands and the results.
+	RedactNone
+)
+
+// logCall logs the REST API call made by call, if the client has a Logger.
+// The response body b is summarized according to the client's LogRedaction.
+func (c *Client) logCall(method, endpoint string, opts callOptions, start time.Time, attempts, status int, b []byte, err error) {
+	if c.Logger == nil {
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "upstashdis: %s /%s %d %s attempts=%d", method, endpoint, status,
+		time.Since(start).Round(time.Microsecond), attempts)
+
+	if len(opts.logCmds) > 0 {
+		sb.WriteString(": ")
+		for i, cmd := range opts.logCmds {
+			if i == maxLogItems {
+				fmt.Fprintf(&sb, "; ... (+%d)", len(opts.logCmds)-i)
+				break
+			}
+			if i > 0 {
+				sb.WriteString("; ")
+			}
+			c.logCommand(&sb, cmd)
+		}
+	}
+
+	sb.WriteString(" => ")
+	if err != nil {
+		sb.WriteString("error: " + err.Error())
+	} else {
+		c.logResults(&sb, b, endpoint != "" && opts.logCmds != nil, opts.hdr != nil)
+	}
+	c.Logger.Printf("%s", sb.String())
+}
+
+func (c *Client) logCommand(sb *strings.Builder, cmd []interface{}) {
+	for i, arg := range cmd {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i > maxLogItems {
+			fmt.Fprintf(sb, "... (+%d)", len(cmd)-i)
+			break
+		}
+		if i == 0 || c.LogRedaction == RedactNone || (i == 1 && c.LogRedaction == RedactValues) {
+			sb.WriteString(truncateLog(fmt.Sprint(arg)))
+		} else {
+			sb.WriteByte('?')
+		}
+	}
+}
+
+// logResults writes the summary of the results in the response body b, which
+// holds an array of results if pipeline is true.
+func (c *Client) logResults(sb *strings.Builder, b []byte, pipeline, base64 bool) {
+	var (
+		results []*Result
+		err     error
+	)
+	if pipeline {
+		err = json.Unmarshal(b, &results)
+	} else {
+		var res Result
+		err = json.Unmarshal(b, &res)
+		results = []*Result{&res}
+	}
+	if err != nil {
+		sb.WriteString("invalid response: " + err.Error())
+		return
+	}
+
+	for i, res := range results {
+		if i == maxLogItems {
+			fmt.Fprintf(sb, "; ... (+%d)", len(results)-i)
+			break
+		}
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		if res == nil {
+			sb.WriteString("nil")
+			continue
+		}
+
+		res.base64 = base64
+		v, err := res.Value()
+		if err != nil {
+			sb.WriteString("error: " + err.Error())
+			continue
+		}
+		c.logValue(sb, v)
+	}
+}
+
+func (c *Client) logValue(sb *strings.Builder, v interface{}) {
+	redact := c.LogRedaction != RedactNone
+
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("nil")
+	case int64:
+		if redact {
+			sb.WriteString("int")
+		} else {
+			fmt.Fprint(sb, v)
+		}
+	case []byte:
+		if redact {
+			fmt.Fprintf(sb, "string(%d)", len(v))
+		} else {
+			sb.WriteString(truncateLog(fmt.Sprintf("%q", v)))
+		}
+	case []interface{}:
+		if redact {
+			fmt.Fprintf(sb, "array(%d)", len(v))
+			return
+		}
+		sb.WriteByte('[')
+		for i, vv := range v {
+			if i == maxLogItems {
+				fmt.Fprintf(sb, " ... (+%d)", len(v)-i)
+				break
+			}
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			c.logValue(sb, vv)
+		}
+		sb.WriteByte(']')
+	default:
+		fmt.Fprintf(sb, "%T", v)
+	}
+}
+
+func truncateLog(s string) string {
+	if len(s) <= maxLogValueLen {
+		return s
+	}
+	return s[:maxLogValueLen] + "..."
+}
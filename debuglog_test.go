@@ -0,0 +1,96 @@
+package upstashdis_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+// recordLogger records the logged lines.
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) last() string {
+	return l.lines[len(l.lines)-1]
+}
+
+func TestLogger(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	logger := &recordLogger{}
+	cli := srv.Client()
+	cli.Logger = logger
+
+	srv.Redis.Set("a", "secret")
+	srv.Redis.RPush("list", "x", "y")
+
+	t.Run("redact all", func(t *testing.T) {
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "key", "secret", "EX", 10))
+		line := logger.last()
+		require.True(t, strings.HasPrefix(line, "upstashdis: POST / 200 "), line)
+		require.True(t, strings.HasSuffix(line, "attempts=1: SET ? ? ? ? => string(2)"), line)
+
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("INCR", "n"))
+		require.NoError(t, req.Send("HGET", "a", "f"))
+		_, err := req.ExecRaw()
+		require.NoError(t, err)
+		line = logger.last()
+		require.Contains(t, line, "POST /pipeline 200")
+		require.True(t, strings.HasSuffix(line, ": GET ?; LRANGE ? ? ?; INCR ?; HGET ? ? => string(6); array(2); int; error: WRONGTYPE Operation against a key holding the wrong kind of value"), line)
+		require.NotContains(t, line, "secret")
+	})
+
+	t.Run("redact values", func(t *testing.T) {
+		cli.LogRedaction = upstashdis.RedactValues
+		defer func() { cli.LogRedaction = upstashdis.RedactAll }()
+
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "key", "secret"))
+		require.True(t, strings.HasSuffix(logger.last(), ": SET key ? => string(2)"), logger.last())
+	})
+
+	t.Run("redact none", func(t *testing.T) {
+		cli.LogRedaction = upstashdis.RedactNone
+		defer func() { cli.LogRedaction = upstashdis.RedactAll }()
+
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("INCR", "n"))
+		require.NoError(t, req.Send("GET", "nope"))
+		require.NoError(t, req.Send("SET", "big", strings.Repeat("x", 100)))
+		_, err := req.ExecRaw()
+		require.NoError(t, err)
+		line := logger.last()
+		require.True(t, strings.HasSuffix(line, `: GET a; LRANGE list 0 -1; INCR n; GET nope; SET big `+strings.Repeat("x", 64)+`... => "secret"; ["x" "y"]; 2; nil; "OK"`), line)
+
+		cli.BinarySafe = true
+		defer func() { cli.BinarySafe = false }()
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "GET", "a"))
+		require.True(t, strings.HasSuffix(logger.last(), `: GET a => "secret"`), logger.last())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		require.Error(t, cli.NewRequestWithToken("invalid").ExecOne(nil, "GET", "a"))
+		require.True(t, strings.HasSuffix(logger.last(), ": GET ? => error: Unauthorized"), logger.last())
+		require.Contains(t, logger.last(), "POST / 401")
+	})
+
+	t.Run("call", func(t *testing.T) {
+		_, err := cli.Call(context.Background(), "GET", "get/a", nil)
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(logger.last(), "attempts=1 => string(6)"), logger.last())
+		require.Contains(t, logger.last(), "GET /get/a 200")
+	})
+}
@@ -0,0 +1,88 @@
+package upstashdis
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// unmarshalResult unmarshals the raw result into dst. In addition to the
+// standard JSON unmarshaling, it supports the following destinations for
+// bulk string and integer results:
+//   - *time.Time: the value is parsed as an RFC3339 timestamp, or as a Unix
+//     timestamp in seconds if it is an integer.
+//   - encoding.TextUnmarshaler: the value is passed to UnmarshalText.
+//   - encoding.BinaryUnmarshaler: the value is passed to UnmarshalBinary.
+//
+// Destinations that implement json.Unmarshaler are unmarshaled with it,
+// with the exception of *time.Time. A null result is always unmarshaled as
+// JSON, e.g. it sets a pointer destination to nil.
+func unmarshalResult(raw json.RawMessage, dst interface{}) error {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return json.Unmarshal(raw, dst)
+	}
+
+	if t, ok := dst.(*time.Time); ok {
+		return unmarshalTime(raw, t)
+	}
+	if _, ok := dst.(json.Unmarshaler); ok {
+		return json.Unmarshal(raw, dst)
+	}
+
+	switch dst := dst.(type) {
+	case encoding.TextUnmarshaler:
+		b, ok := scalarBytes(raw)
+		if !ok {
+			break
+		}
+		return dst.UnmarshalText(b)
+	case encoding.BinaryUnmarshaler:
+		b, ok := scalarBytes(raw)
+		if !ok {
+			break
+		}
+		return dst.UnmarshalBinary(b)
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+// scalarBytes returns the bytes of the JSON string or number in raw. It
+// returns false if raw is not a string or a number.
+func scalarBytes(raw json.RawMessage) ([]byte, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, false
+	}
+	switch c := raw[0]; {
+	case c == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, false
+		}
+		return []byte(s), true
+	case c == '-' || (c >= '0' && c <= '9'):
+		return raw, true
+	}
+	return nil, false
+}
+
+func unmarshalTime(raw json.RawMessage, t *time.Time) error {
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return fmt.Errorf("upstashdis: cannot unmarshal %s into time.Time", raw)
+	}
+	s := string(b)
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*t = time.Unix(secs, 0)
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("upstashdis: cannot unmarshal %q into time.Time: %w", s, err)
+	}
+	*t = v
+	return nil
+}
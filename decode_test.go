@@ -0,0 +1,106 @@
+package upstashdis
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// binaryValue implements encoding.BinaryUnmarshaler only.
+type binaryValue []byte
+
+func (b *binaryValue) UnmarshalBinary(data []byte) error {
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// level implements encoding.TextUnmarshaler only.
+type level int
+
+func (l *level) UnmarshalText(data []byte) error {
+	switch string(data) {
+	case "low", "1":
+		*l = 1
+	case "high", "2":
+		*l = 2
+	default:
+		return &json.UnsupportedValueError{Str: string(data)}
+	}
+	return nil
+}
+
+func TestUnmarshalResult(t *testing.T) {
+	t.Run("text unmarshaler string", func(t *testing.T) {
+		var ip net.IP
+		require.NoError(t, unmarshalResult(json.RawMessage(`"10.0.0.1"`), &ip))
+		require.Equal(t, "10.0.0.1", ip.String())
+	})
+
+	t.Run("text unmarshaler integer", func(t *testing.T) {
+		var l level
+		require.NoError(t, unmarshalResult(json.RawMessage(`2`), &l))
+		require.Equal(t, level(2), l)
+	})
+
+	t.Run("text unmarshaler error", func(t *testing.T) {
+		var l level
+		require.Error(t, unmarshalResult(json.RawMessage(`"medium"`), &l))
+	})
+
+	t.Run("text unmarshaler array", func(t *testing.T) {
+		var l level
+		require.Error(t, unmarshalResult(json.RawMessage(`["low"]`), &l))
+	})
+
+	t.Run("binary unmarshaler", func(t *testing.T) {
+		var b binaryValue
+		require.NoError(t, unmarshalResult(json.RawMessage(`"a\u0000b"`), &b))
+		require.Equal(t, binaryValue("a\x00b"), b)
+	})
+
+	t.Run("json unmarshaler", func(t *testing.T) {
+		var n big.Int
+		require.NoError(t, unmarshalResult(json.RawMessage(`12345678901234567890`), &n))
+		require.Equal(t, "12345678901234567890", n.String())
+	})
+
+	t.Run("time rfc3339", func(t *testing.T) {
+		var tm time.Time
+		require.NoError(t, unmarshalResult(json.RawMessage(`"2022-05-01T10:20:30.5Z"`), &tm))
+		require.True(t, tm.Equal(time.Date(2022, 5, 1, 10, 20, 30, 5e8, time.UTC)))
+	})
+
+	t.Run("time unix", func(t *testing.T) {
+		var tm time.Time
+		require.NoError(t, unmarshalResult(json.RawMessage(`1651400430`), &tm))
+		require.Equal(t, int64(1651400430), tm.Unix())
+
+		require.NoError(t, unmarshalResult(json.RawMessage(`"1651400431"`), &tm))
+		require.Equal(t, int64(1651400431), tm.Unix())
+	})
+
+	t.Run("time invalid", func(t *testing.T) {
+		var tm time.Time
+		require.Error(t, unmarshalResult(json.RawMessage(`"yesterday"`), &tm))
+	})
+
+	t.Run("null", func(t *testing.T) {
+		l := level(1)
+		require.NoError(t, unmarshalResult(json.RawMessage(`null`), &l))
+		require.Equal(t, level(1), l)
+
+		lp := &l
+		require.NoError(t, unmarshalResult(json.RawMessage(`null`), &lp))
+		require.Nil(t, lp)
+	})
+
+	t.Run("standard", func(t *testing.T) {
+		var s []string
+		require.NoError(t, unmarshalResult(json.RawMessage(`["a","b"]`), &s))
+		require.Equal(t, []string{"a", "b"}, s)
+	})
+}
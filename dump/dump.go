@@ -0,0 +1,376 @@
+// Package dump implements the export of a database to a dump file and its
+// import, via an Upstash-compatible Redis REST API. The dump is a stream of
+// newline-delimited JSON records, one per key, with its type, value and
+// remaining time to live. It can be used for backups, to clone a database
+// in a different environment or to create test fixtures (e.g. to seed an
+// upstashtest server). See also the cmd/upstash-redis-rest-dump command that
+// implements a command-line tool based on this package.
+//
+// The values are encoded as returned by the REST API, so that binary values
+// may not be preserved.
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mna/upstashdis"
+)
+
+// Key types supported in a dump.
+const (
+	TypeString = "string"
+	TypeList   = "list"
+	TypeSet    = "set"
+	TypeHash   = "hash"
+	TypeZSet   = "zset"
+	TypeStream = "stream"
+)
+
+// maxArgs is the maximum number of values sent in a single command when
+// importing a collection.
+const maxArgs = 1000
+
+// Record is the dump of a single key. The type of its Value depends on its
+// Type:
+//   - TypeString: string
+//   - TypeList and TypeSet: []string
+//   - TypeHash: map[string]string
+//   - TypeZSet: []ZMember
+//   - TypeStream: []StreamEntry
+type Record struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	TTL   int64       `json:"ttl,omitempty"` // remaining time to live in milliseconds, 0 if the key does not expire
+	Value interface{} `json:"value"`
+}
+
+// ZMember is a member of a sorted set.
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// StreamEntry is an entry of a stream. Its Fields are the field-value pairs
+// of the entry, in order.
+type StreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the Record, so that its
+// Value is decoded in the Go type corresponding to its Type.
+func (r *Record) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Key   string          `json:"key"`
+		Type  string          `json:"type"`
+		TTL   int64           `json:"ttl"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	var err error
+	switch raw.Type {
+	case TypeString:
+		var v string
+		err = json.Unmarshal(raw.Value, &v)
+		r.Value = v
+	case TypeList, TypeSet:
+		var v []string
+		err = json.Unmarshal(raw.Value, &v)
+		r.Value = v
+	case TypeHash:
+		var v map[string]string
+		err = json.Unmarshal(raw.Value, &v)
+		r.Value = v
+	case TypeZSet:
+		var v []ZMember
+		err = json.Unmarshal(raw.Value, &v)
+		r.Value = v
+	case TypeStream:
+		var v []StreamEntry
+		err = json.Unmarshal(raw.Value, &v)
+		r.Value = v
+	default:
+		return fmt.Errorf("dump: unsupported type %q for key %q", raw.Type, raw.Key)
+	}
+	if err != nil {
+		return fmt.Errorf("dump: invalid %s value for key %q: %w", raw.Type, raw.Key, err)
+	}
+	r.Key, r.Type, r.TTL = raw.Key, raw.Type, raw.TTL
+	return nil
+}
+
+// ExportOptions configures the export of a database.
+type ExportOptions struct {
+	// Match is the glob-style pattern of the keys to export, all keys are
+	// exported if it is empty.
+	Match string
+
+	// Count is the COUNT value of the SCAN command, which is also the number of
+	// keys read in a single pipeline request. Defaults to 100.
+	Count int
+}
+
+// Export exports the keys of the database to w, writing one JSON-encoded
+// Record per line. It returns the number of keys exported. Keys of
+// unsupported types (e.g. modules) are skipped. It stops and returns
+// ctx.Err() if ctx is cancelled.
+func Export(ctx context.Context, client *upstashdis.Client, w io.Writer, opts *ExportOptions) (int, error) {
+	var o ExportOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Count <= 0 {
+		o.Count = 100
+	}
+
+	enc := json.NewEncoder(w)
+	var n int
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		args := []interface{}{cursor, "COUNT", o.Count}
+		if o.Match != "" {
+			args = append(args, "MATCH", o.Match)
+		}
+		var scan []json.RawMessage
+		if err := client.NewRequest().ExecOne(&scan, "SCAN", args...); err != nil {
+			return n, err
+		}
+		if len(scan) != 2 {
+			return n, fmt.Errorf("dump: unexpected SCAN result: %d values", len(scan))
+		}
+		var keys []string
+		if err := json.Unmarshal(scan[0], &cursor); err != nil {
+			return n, err
+		}
+		if err := json.Unmarshal(scan[1], &keys); err != nil {
+			return n, err
+		}
+
+		recs, err := readRecords(client, keys)
+		if err != nil {
+			return n, err
+		}
+		for _, rec := range recs {
+			if err := enc.Encode(rec); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if cursor == "0" {
+			return n, nil
+		}
+	}
+}
+
+// readRecords reads the records of the keys in two pipeline requests, one for
+// the types and TTLs and one for the values. Keys that do not exist anymore
+// or that have an unsupported type are skipped.
+func readRecords(client *upstashdis.Client, keys []string) ([]*Record, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	req := client.NewRequest()
+	for _, key := range keys {
+		if err := req.Send("TYPE", key); err != nil {
+			return nil, err
+		}
+		if err := req.Send("PTTL", key); err != nil {
+			return nil, err
+		}
+	}
+	res, err := req.ExecRaw()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2*len(keys) {
+		return nil, fmt.Errorf("dump: unexpected number of results: %d", len(res))
+	}
+
+	recs := make([]*Record, 0, len(keys))
+	for i, key := range keys {
+		rtyp, rttl := res[2*i], res[2*i+1]
+		if err := resultError(rtyp, rttl); err != nil {
+			return nil, fmt.Errorf("dump: key %q: %w", key, err)
+		}
+		var (
+			typ string
+			ttl int64
+		)
+		if err := json.Unmarshal(rtyp.Result, &typ); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rttl.Result, &ttl); err != nil {
+			return nil, err
+		}
+		if ttl == -2 {
+			continue
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+
+		rec := &Record{Key: key, Type: typ, TTL: ttl}
+		if err := sendRead(req, rec); err != nil {
+			if errors.Is(err, errUnsupportedType) {
+				continue
+			}
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+
+	res, err = req.ExecRaw()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != len(recs) {
+		return nil, fmt.Errorf("dump: unexpected number of results: %d", len(res))
+	}
+
+	kept := recs[:0]
+	for i, rec := range recs {
+		if err := resultError(res[i]); err != nil {
+			return nil, fmt.Errorf("dump: key %q: %w", rec.Key, err)
+		}
+		ok, err := decodeValue(rec, res[i].Result)
+		if err != nil {
+			return nil, fmt.Errorf("dump: key %q: %w", rec.Key, err)
+		}
+		if ok {
+			kept = append(kept, rec)
+		}
+	}
+	return kept, nil
+}
+
+var errUnsupportedType = errors.New("unsupported type")
+
+func sendRead(req *upstashdis.Request, rec *Record) error {
+	switch rec.Type {
+	case TypeString:
+		return req.Send("GET", rec.Key)
+	case TypeList:
+		return req.Send("LRANGE", rec.Key, 0, -1)
+	case TypeSet:
+		return req.Send("SMEMBERS", rec.Key)
+	case TypeHash:
+		return req.Send("HGETALL", rec.Key)
+	case TypeZSet:
+		return req.Send("ZRANGE", rec.Key, 0, -1, "WITHSCORES")
+	case TypeStream:
+		return req.Send("XRANGE", rec.Key, "-", "+")
+	default:
+		return errUnsupportedType
+	}
+}
+
+// decodeValue decodes the raw result of the read command in the value of the
+// record. It returns false if the key does not exist anymore.
+func decodeValue(rec *Record, raw json.RawMessage) (bool, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false, nil
+	}
+
+	switch rec.Type {
+	case TypeString:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return false, err
+		}
+		rec.Value = v
+
+	case TypeList, TypeSet:
+		var v []string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return false, err
+		}
+		if len(v) == 0 {
+			return false, nil
+		}
+		rec.Value = v
+
+	case TypeHash:
+		var v []string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return false, err
+		}
+		if len(v) == 0 {
+			return false, nil
+		}
+		m := make(map[string]string, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			m[v[i]] = v[i+1]
+		}
+		rec.Value = m
+
+	case TypeZSet:
+		// members are strings, scores may be returned as strings or numbers
+		var v []interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return false, err
+		}
+		if len(v) == 0 {
+			return false, nil
+		}
+		zm := make([]ZMember, 0, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			score, err := strconv.ParseFloat(fmt.Sprint(v[i+1]), 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid score: %w", err)
+			}
+			zm = append(zm, ZMember{Member: fmt.Sprint(v[i]), Score: score})
+		}
+		rec.Value = zm
+
+	case TypeStream:
+		var v [][]json.RawMessage
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return false, err
+		}
+		if len(v) == 0 {
+			return false, nil
+		}
+		entries := make([]StreamEntry, 0, len(v))
+		for _, e := range v {
+			if len(e) != 2 {
+				return false, fmt.Errorf("unexpected stream entry format: %d values", len(e))
+			}
+			var se StreamEntry
+			if err := json.Unmarshal(e[0], &se.ID); err != nil {
+				return false, err
+			}
+			if err := json.Unmarshal(e[1], &se.Fields); err != nil {
+				return false, err
+			}
+			entries = append(entries, se)
+		}
+		rec.Value = entries
+	}
+	return true, nil
+}
+
+func resultError(res ...*upstashdis.Result) error {
+	for _, r := range res {
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	return nil
+}
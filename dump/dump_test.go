@@ -0,0 +1,89 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := upstashtest.NewServer(t)
+
+	require.NoError(t, src.Redis.Set("str", "a"))
+	src.Redis.SetTTL("str", time.Minute)
+	_, err := src.Redis.Push("list", "a", "b", "c")
+	require.NoError(t, err)
+	_, err = src.Redis.SetAdd("set", "x", "y")
+	require.NoError(t, err)
+	src.Redis.HSet("hash", "f1", "v1", "f2", "v2")
+	_, err = src.Redis.ZAdd("zset", 1.5, "m1")
+	require.NoError(t, err)
+	_, err = src.Redis.ZAdd("zset", 2, "m2")
+	require.NoError(t, err)
+	_, err = src.Redis.XAdd("stream", "1-1", []string{"f", "v"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, src.Client(), &buf, &ExportOptions{Count: 2})
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	require.Equal(t, 6, strings.Count(buf.String(), "\n"))
+
+	dst := upstashtest.NewServer(t)
+	require.NoError(t, dst.Redis.Set("str", "old"))
+
+	// without replace, the existing key is skipped
+	imported, skipped, err := Import(ctx, dst.Client(), bytes.NewReader(buf.Bytes()), &ImportOptions{BatchSize: 4})
+	require.NoError(t, err)
+	require.Equal(t, 5, imported)
+	require.Equal(t, 1, skipped)
+	dst.Redis.CheckGet(t, "str", "old")
+
+	imported, skipped, err = Import(ctx, dst.Client(), bytes.NewReader(buf.Bytes()), &ImportOptions{Replace: true})
+	require.NoError(t, err)
+	require.Equal(t, 6, imported)
+	require.Equal(t, 0, skipped)
+
+	dst.Redis.CheckGet(t, "str", "a")
+	require.Equal(t, time.Minute, dst.Redis.TTL("str"))
+	dst.Redis.CheckList(t, "list", "a", "b", "c")
+	dst.Redis.CheckSet(t, "set", "x", "y")
+	require.Equal(t, "v2", dst.Redis.HGet("hash", "f2"))
+	score, err := dst.Redis.ZScore("zset", "m1")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, score)
+	entries, err := dst.Redis.Stream("stream")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, []string{"f", "v"}, entries[0].Values)
+}
+
+func TestExportMatch(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	require.NoError(t, srv.Redis.Set("a:1", "1"))
+	require.NoError(t, srv.Redis.Set("b:1", "1"))
+
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), srv.Client(), &buf, &ExportOptions{Match: "a:*"})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, `{"key":"a:1","type":"string","value":"1"}`+"\n", buf.String())
+}
+
+func TestRecordUnmarshal(t *testing.T) {
+	var rec Record
+	err := rec.UnmarshalJSON([]byte(`{"key":"k","type":"zset","ttl":10,"value":[{"member":"m","score":1}]}`))
+	require.NoError(t, err)
+	require.Equal(t, Record{Key: "k", Type: TypeZSet, TTL: 10, Value: []ZMember{{Member: "m", Score: 1}}}, rec)
+
+	err = rec.UnmarshalJSON([]byte(`{"key":"k","type":"nope","value":1}`))
+	require.Error(t, err)
+	err = rec.UnmarshalJSON([]byte(`{"key":"k","type":"list","value":"x"}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,210 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mna/upstashdis"
+)
+
+// ImportOptions configures the import of a dump.
+type ImportOptions struct {
+	// Replace indicates if keys that already exist in the database are
+	// replaced. By default, those keys are skipped.
+	Replace bool
+
+	// BatchSize is the number of records imported in a single pipeline
+	// request. Defaults to 100.
+	BatchSize int
+}
+
+// Import imports the records read from r, as written by Export, into the
+// database. The TTL of the records is applied relative to the time of the
+// import. It returns the number of keys imported and skipped (because they
+// already exist). It stops and returns ctx.Err() if ctx is cancelled.
+//
+// The import of a key is not atomic, and the import stops at the first
+// failure.
+func Import(ctx context.Context, client *upstashdis.Client, r io.Reader, opts *ImportOptions) (imported, skipped int, err error) {
+	var o ImportOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+
+	dec := json.NewDecoder(r)
+	batch := make([]*Record, 0, o.BatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return imported, skipped, err
+		}
+
+		batch = batch[:0]
+		var eof bool
+		for len(batch) < o.BatchSize {
+			var rec Record
+			if err := dec.Decode(&rec); err != nil {
+				if errors.Is(err, io.EOF) {
+					eof = true
+					break
+				}
+				return imported, skipped, err
+			}
+			batch = append(batch, &rec)
+		}
+
+		n, sk, err := importBatch(client, batch, o.Replace)
+		imported += n
+		skipped += sk
+		if err != nil || eof {
+			return imported, skipped, err
+		}
+	}
+}
+
+func importBatch(client *upstashdis.Client, recs []*Record, replace bool) (imported, skipped int, err error) {
+	if len(recs) == 0 {
+		return 0, 0, nil
+	}
+
+	req := client.NewRequest()
+	if !replace {
+		for _, rec := range recs {
+			if err := req.Send("EXISTS", rec.Key); err != nil {
+				return 0, 0, err
+			}
+		}
+		res, err := req.ExecRaw()
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(res) != len(recs) {
+			return 0, 0, fmt.Errorf("dump: unexpected number of results: %d", len(res))
+		}
+
+		kept := recs[:0]
+		for i, rec := range recs {
+			var n int
+			if err := resultError(res[i]); err != nil {
+				return 0, 0, fmt.Errorf("dump: key %q: %w", rec.Key, err)
+			}
+			if err := json.Unmarshal(res[i].Result, &n); err != nil {
+				return 0, 0, err
+			}
+			if n > 0 {
+				skipped++
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		recs = kept
+		if len(recs) == 0 {
+			return 0, skipped, nil
+		}
+	}
+
+	var keys []string // key of each command sent, for error reporting
+	for _, rec := range recs {
+		cmds, err := rec.commands(replace)
+		if err != nil {
+			return 0, skipped, err
+		}
+		for _, cmd := range cmds {
+			if err := req.Send(cmd[0].(string), cmd[1:]...); err != nil {
+				return 0, skipped, err
+			}
+			keys = append(keys, rec.Key)
+		}
+	}
+	res, err := req.ExecRaw()
+	if err != nil {
+		return 0, skipped, err
+	}
+	for i, r := range res {
+		if err := resultError(r); err != nil {
+			return 0, skipped, fmt.Errorf("dump: key %q: %w", keys[i], err)
+		}
+	}
+	return len(recs), skipped, nil
+}
+
+// commands returns the commands that create the key of the record, and
+// delete it first if replace is true.
+func (r *Record) commands(replace bool) ([][]interface{}, error) {
+	var cmds [][]interface{}
+	if replace {
+		cmds = append(cmds, []interface{}{"DEL", r.Key})
+	}
+
+	switch v := r.Value.(type) {
+	case string:
+		cmds = append(cmds, []interface{}{"SET", r.Key, v})
+
+	case []string:
+		cmd := "RPUSH"
+		if r.Type == TypeSet {
+			cmd = "SADD"
+		}
+		vals := make([]interface{}, len(v))
+		for i, s := range v {
+			vals[i] = s
+		}
+		cmds = appendChunks(cmds, cmd, r.Key, vals, 1)
+
+	case map[string]string:
+		vals := make([]interface{}, 0, 2*len(v))
+		for f, s := range v {
+			vals = append(vals, f, s)
+		}
+		cmds = appendChunks(cmds, "HSET", r.Key, vals, 2)
+
+	case []ZMember:
+		vals := make([]interface{}, 0, 2*len(v))
+		for _, m := range v {
+			vals = append(vals, m.Score, m.Member)
+		}
+		cmds = appendChunks(cmds, "ZADD", r.Key, vals, 2)
+
+	case []StreamEntry:
+		for _, e := range v {
+			cmd := make([]interface{}, 0, len(e.Fields)+3)
+			cmd = append(cmd, "XADD", r.Key, e.ID)
+			for _, f := range e.Fields {
+				cmd = append(cmd, f)
+			}
+			cmds = append(cmds, cmd)
+		}
+
+	default:
+		return nil, fmt.Errorf("dump: unsupported value type %T for key %q", r.Value, r.Key)
+	}
+
+	if r.TTL > 0 {
+		cmds = append(cmds, []interface{}{"PEXPIRE", r.Key, r.TTL})
+	}
+	return cmds, nil
+}
+
+// appendChunks appends the commands that add the values to the key, with at
+// most maxArgs values per command. Values are grouped by size, so that e.g.
+// field-value pairs of a hash are not split.
+func appendChunks(cmds [][]interface{}, cmd, key string, vals []interface{}, size int) [][]interface{} {
+	chunk := maxArgs - maxArgs%size
+	for len(vals) > 0 {
+		n := chunk
+		if n > len(vals) {
+			n = len(vals)
+		}
+		args := make([]interface{}, 0, n+2)
+		args = append(args, cmd, key)
+		args = append(args, vals[:n]...)
+		cmds = append(cmds, args)
+		vals = vals[n:]
+	}
+	return cmds
+}
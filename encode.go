@@ -0,0 +1,193 @@
+package upstashdis
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxPooledResults is the maximum number of results of a resultsBuf that is
+// returned to the pool, so that the pool does not retain the memory of huge
+// pipelines.
+const maxPooledResults = 1024
+
+var resultsPool = sync.Pool{
+	New: func() interface{} { return new(resultsBuf) },
+}
+
+// resultsBuf holds the results decoded from a response, so that their
+// memory can be reused for other responses once they have been consumed
+// (by Exec and ExecOne, which do not return the results to the caller).
+type resultsBuf struct {
+	vals []Result
+	ptrs []*Result
+}
+
+// decode decodes the results of the response body raw, which is an array of
+// results if pipeline is true.
+func (rb *resultsBuf) decode(raw []byte, pipeline bool) ([]*Result, error) {
+	if pipeline {
+		if err := json.Unmarshal(raw, &rb.vals); err != nil {
+			return nil, err
+		}
+	} else {
+		rb.vals = append(rb.vals[:0], Result{})
+		if err := json.Unmarshal(raw, &rb.vals[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	rb.ptrs = rb.ptrs[:0]
+	for i := range rb.vals {
+		rb.ptrs = append(rb.ptrs, &rb.vals[i])
+	}
+	return rb.ptrs, nil
+}
+
+// release returns rb to the pool. The results must not be used anymore. The
+// decoded values themselves are not reused, only the Result structs and the
+// slices that hold them, so the values extracted from the results remain
+// valid.
+func (rb *resultsBuf) release() {
+	if cap(rb.vals) > maxPooledResults {
+		return
+	}
+	for i := range rb.vals {
+		rb.vals[i] = Result{}
+	}
+	for i := range rb.ptrs {
+		rb.ptrs[i] = nil
+	}
+	rb.vals = rb.vals[:0]
+	rb.ptrs = rb.ptrs[:0]
+	resultsPool.Put(rb)
+}
+
+// cmdEncoder encodes the commands in the JSON format of the REST API. It
+// writes the argument types produced by writeArg directly, without
+// reflection, and uses a json.Encoder for the others. Its buffers are reused
+// across the calls made with the same Request.
+type cmdEncoder struct {
+	buf     []byte       // the request body
+	scratch bytes.Buffer // output of enc
+	enc     *json.Encoder
+}
+
+// appendCommands appends the JSON array of the commands to b.
+func (e *cmdEncoder) appendCommands(b []byte, cmds [][]interface{}) ([]byte, error) {
+	b = append(b, '[')
+	for i, cmd := range cmds {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var err error
+		if b, err = e.appendCommand(b, cmd); err != nil {
+			return b, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+// appendCommand appends the JSON array of the command name and arguments to
+// b.
+func (e *cmdEncoder) appendCommand(b []byte, cmd []interface{}) ([]byte, error) {
+	b = append(b, '[')
+	for i, arg := range cmd {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var err error
+		if b, err = e.appendArg(b, arg); err != nil {
+			return b, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+func (e *cmdEncoder) appendArg(b []byte, arg interface{}) ([]byte, error) {
+	switch arg := arg.(type) {
+	case string:
+		if sb, ok := appendString(b, arg); ok {
+			return sb, nil
+		}
+	case int64:
+		return strconv.AppendInt(b, arg, 10), nil
+	case int:
+		return strconv.AppendInt(b, int64(arg), 10), nil
+	case float64:
+		if !math.IsInf(arg, 0) && !math.IsNaN(arg) {
+			return appendFloat(b, arg), nil
+		}
+	}
+
+	// the other values (e.g. the strings with control characters or invalid
+	// UTF-8, which are rare) are encoded exactly as encoding/json does.
+	if e.enc == nil {
+		e.enc = json.NewEncoder(&e.scratch)
+	}
+	e.scratch.Reset()
+	if err := e.enc.Encode(arg); err != nil {
+		return b, err
+	}
+	return append(b, bytes.TrimSuffix(e.scratch.Bytes(), []byte{'\n'})...), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends the JSON string of s to b, escaped as encoding/json
+// does (including its escaping of HTML characters). It returns false if s
+// contains a control character, invalid UTF-8 or a line or paragraph
+// separator, in which case the returned slice must be discarded.
+func appendString(b []byte, s string) ([]byte, bool) {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c < 0x20:
+				return b, false
+			case c == '"' || c == '\\':
+				b = append(b, s[start:i]...)
+				b = append(b, '\\', c)
+				start = i + 1
+			case c == '<' || c == '>' || c == '&':
+				b = append(b, s[start:i]...)
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+				start = i + 1
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+			return b, false
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"'), true
+}
+
+// appendFloat appends the JSON number of the finite f to b, formatted as
+// encoding/json does.
+func appendFloat(b []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
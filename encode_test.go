@@ -0,0 +1,85 @@
+package upstashdis
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendCommand(t *testing.T) {
+	cases := []struct {
+		name string
+		cmd  []interface{}
+	}{
+		{"empty", []interface{}{"PING"}},
+		{"ascii", []interface{}{"SET", "key", "value"}},
+		{"escaped", []interface{}{"SET", `a"b\c`, "<a href='x'>&</a>"}},
+		{"unicode", []interface{}{"SET", "clé", "日本語 🎉"}},
+		{"control", []interface{}{"SET", "a\nb\tc\x00\x1f", "\u007f"}},
+		{"separators", []interface{}{"SET", "a b", "c d"}},
+		{"invalid utf8", []interface{}{"SET", "a\xffb", "\xc3"}},
+		{"integers", []interface{}{"INCRBY", "key", int64(-42), 0, math.MaxInt64}},
+		{"floats", []interface{}{"ZADD", "z", 1.5, 0.0, -3.0, 1e20, 1e21, 1e-6, 1e-7, 123456789.125, -2.5e-10}},
+		{"other", []interface{}{"ECHO", uint8(1), true, nil, []int{1, 2}}},
+	}
+
+	var enc cmdEncoder
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			want, err := json.Marshal(c.cmd)
+			require.NoError(t, err)
+			got, err := enc.appendCommand([]byte("prefix"), c.cmd)
+			require.NoError(t, err)
+			require.Equal(t, "prefix"+string(want), string(got))
+		})
+	}
+
+	t.Run("commands", func(t *testing.T) {
+		cmds := [][]interface{}{{"SET", "a", 1}, {"GET", "a"}}
+		want, err := json.Marshal(cmds)
+		require.NoError(t, err)
+		got, err := enc.appendCommands(nil, cmds)
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(got))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := enc.appendCommand(nil, []interface{}{"ZADD", "z", math.Inf(1), "a"})
+		require.Error(t, err)
+		_, err = enc.appendCommand(nil, []interface{}{"ZADD", "z", math.NaN(), "a"})
+		require.Error(t, err)
+	})
+}
+
+func TestResultsBuf(t *testing.T) {
+	rb := resultsPool.Get().(*resultsBuf)
+	res, err := rb.decode([]byte(`[{"result":"a"},{"error":"ERR x"},{"result":[1,2]}]`), true)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	require.Equal(t, `"a"`, string(res[0].Result))
+	require.Equal(t, "ERR x", res[1].Error)
+	require.Nil(t, res[1].Result)
+	require.Equal(t, `[1,2]`, string(res[2].Result))
+
+	// the values extracted from the results are not reused
+	raw, msg := res[0].Result, res[1].Error
+	rb.release()
+
+	rb2 := &resultsBuf{vals: rb.vals[:0], ptrs: rb.ptrs[:0]}
+	res, err = rb2.decode([]byte(`[{"error":"ERR y"},{"result":"bbbbbbbbbb"}]`), true)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, "ERR y", res[0].Error)
+	require.Nil(t, res[0].Result)
+	require.Empty(t, res[1].Error)
+	require.Equal(t, `"bbbbbbbbbb"`, string(res[1].Result))
+	require.Equal(t, `"a"`, string(raw))
+	require.Equal(t, "ERR x", msg)
+
+	res, err = rb2.decode([]byte(`{"result":1}`), false)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, `1`, string(res[0].Result))
+}
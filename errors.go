@@ -0,0 +1,130 @@
+package upstashdis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Common Redis error kinds, as set in the Kind field of an *Error.
+const (
+	KindErr       = "ERR"
+	KindWrongType = "WRONGTYPE"
+	KindNoScript  = "NOSCRIPT"
+	KindNoPerm    = "NOPERM"
+	KindReadOnly  = "READONLY"
+	KindExecAbort = "EXECABORT"
+	KindBusyKey   = "BUSYKEY"
+	KindLoading   = "LOADING"
+	KindTryAgain  = "TRYAGAIN"
+)
+
+// StatusError is the error returned when a REST API call fails with a
+// non-2xx status code and the response body does not hold a Redis error
+// message (in which case an *Error is returned, with its StatusCode set).
+type StatusError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Header holds the headers of the response, e.g. with the Retry-After
+	// and rate-limit headers.
+	Header http.Header
+	// Body is the start of the response body (at most 512 bytes), or the
+	// status if the body is empty.
+	Body string
+}
+
+// Error returns the error message.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("[%d]: %s", e.StatusCode, e.Body)
+}
+
+// IsRetryable returns true if the status code indicates a failure that may
+// be transient, that is 429 Too Many Requests, 500 Internal Server Error,
+// 502 Bad Gateway, 503 Service Unavailable or 504 Gateway Timeout. Note
+// that unless the status is 429 or 503, the request may have been executed
+// (see RetryPolicy).
+func (e *StatusError) IsRetryable() bool {
+	return retryableStatus(e.StatusCode)
+}
+
+// IsRateLimited returns true if the status code is 429 Too Many Requests.
+func (e *StatusError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of the
+// response, or 0 if it is not set.
+func (e *StatusError) RetryAfter() time.Duration {
+	return retryAfter(e.Header)
+}
+
+// IsRetryable returns true if the error may be transient: if it was
+// returned with a retryable status code as described for
+// StatusError.IsRetryable, or if its Kind is LOADING or TRYAGAIN.
+func (e *Error) IsRetryable() bool {
+	return retryableStatus(e.StatusCode) || e.Kind == KindLoading || e.Kind == KindTryAgain
+}
+
+// IsRateLimited returns true if the error was returned with the 429 Too Many
+// Requests status code, e.g. when the request limit of the database is
+// exceeded.
+func (e *Error) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of the
+// failed response, or 0 if it is not set.
+func (e *Error) RetryAfter() time.Duration {
+	return retryAfter(e.Header)
+}
+
+// IsRetryable returns true if err, or an error it wraps, is an *Error or
+// *StatusError whose IsRetryable method returns true, or a network error.
+// Errors caused by the cancellation or expiration of a context are not
+// retryable.
+func IsRetryable(err error) bool {
+	var (
+		rerr *Error
+		serr *StatusError
+		nerr net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &rerr):
+		return rerr.IsRetryable()
+	case errors.As(err, &serr):
+		return serr.IsRetryable()
+	case errors.As(err, &nerr):
+		return true
+	}
+	return false
+}
+
+// IsRateLimited returns true if err, or an error it wraps, is an *Error or
+// *StatusError returned with the 429 Too Many Requests status code.
+func IsRateLimited(err error) bool {
+	var (
+		rerr *Error
+		serr *StatusError
+	)
+	switch {
+	case errors.As(err, &rerr):
+		return rerr.IsRateLimited()
+	case errors.As(err, &serr):
+		return serr.IsRateLimited()
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrors(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	srv.Redis.Set("a", "1")
+
+	t.Run("command", func(t *testing.T) {
+		err := cli.NewRequest().ExecOne(nil, "HGET", "a", "x")
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, upstashdis.KindWrongType, rerr.Kind)
+		require.Equal(t, http.StatusBadRequest, rerr.StatusCode)
+		require.False(t, rerr.IsRetryable())
+		require.False(t, upstashdis.IsRetryable(err))
+		require.False(t, upstashdis.IsRateLimited(err))
+
+		// in a pipeline, the response is successful
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("HGET", "a", "x"))
+		err = req.Exec(nil, nil)
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, 1, rerr.PipelineIndex)
+		require.Equal(t, 0, rerr.StatusCode)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		err := cli.NewRequestWithToken("invalid").ExecOne(nil, "GET", "a")
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, http.StatusUnauthorized, rerr.StatusCode)
+		require.False(t, upstashdis.IsRetryable(err))
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"ERR max requests limit exceeded"}`))
+		}))
+		defer hsrv.Close()
+
+		err := (&upstashdis.Client{BaseURL: hsrv.URL}).NewRequest().ExecOne(nil, "GET", "a")
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, upstashdis.KindErr, rerr.Kind)
+		require.True(t, rerr.IsRateLimited())
+		require.Equal(t, 3*time.Second, rerr.RetryAfter())
+		require.True(t, upstashdis.IsRateLimited(err))
+		require.True(t, upstashdis.IsRetryable(err))
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("bad gateway"))
+		}))
+		defer hsrv.Close()
+
+		cli := &upstashdis.Client{
+			BaseURL: hsrv.URL,
+			Retry:   &upstashdis.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond},
+		}
+		err := cli.NewRequest().ExecOne(nil, "GET", "a")
+		var retryErr *upstashdis.RetryError
+		require.ErrorAs(t, err, &retryErr)
+		var serr *upstashdis.StatusError
+		require.ErrorAs(t, err, &serr)
+		require.Equal(t, http.StatusBadGateway, serr.StatusCode)
+		require.Equal(t, "bad gateway", serr.Body)
+		require.True(t, serr.IsRetryable())
+		require.False(t, serr.IsRateLimited())
+		require.True(t, upstashdis.IsRetryable(err))
+	})
+
+	t.Run("network", func(t *testing.T) {
+		hsrv := httptest.NewServer(http.NotFoundHandler())
+		hsrv.Close()
+
+		cli := &upstashdis.Client{BaseURL: hsrv.URL}
+		err := cli.NewRequest().ExecOne(nil, "GET", "a")
+		require.Error(t, err)
+		require.True(t, upstashdis.IsRetryable(err))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = cli.NewRequestContext(ctx).ExecOne(nil, "GET", "a")
+		require.True(t, errors.Is(err, context.Canceled))
+		require.False(t, upstashdis.IsRetryable(err))
+	})
+}
@@ -0,0 +1,254 @@
+// Package fixture implements the recording and replay of golden fixtures of
+// the Upstash Redis REST API payloads. A Script of commands is executed
+// against a reference server (typically an actual Upstash database) and the
+// requests and responses are recorded in a Fixture, which can then be
+// replayed against another server (e.g. the restserver package) to verify
+// that it returns byte-for-byte identical payloads and status codes. See also
+// the cmd/upstash-redis-rest-fixture command that implements a command-line
+// tool based on this package.
+//
+// Scripts must produce deterministic results: they should not use commands
+// like TIME or RANDOMKEY, and should only use keys that do not exist before
+// the execution (e.g. by deleting them in the first step).
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mna/upstashdis"
+)
+
+// Script is a named list of steps to execute.
+type Script struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single REST API request, either a single Command or a Pipeline
+// of commands. Exactly one of the fields must be set.
+type Step struct {
+	Command  []interface{}   `json:"command,omitempty"`
+	Pipeline [][]interface{} `json:"pipeline,omitempty"`
+}
+
+// Fixture is the recording of the execution of a Script.
+type Fixture struct {
+	Name      string     `json:"name"`
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// Exchange is a recorded request and its response. The Request and
+// Response bodies are stored in their compact JSON form.
+type Exchange struct {
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// ReadScript reads a JSON-encoded script from r.
+func ReadScript(r io.Reader) (*Script, error) {
+	var s Script
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Name == "" {
+		return nil, errors.New("fixture: script has no name")
+	}
+	for i, st := range s.Steps {
+		if (len(st.Command) == 0) == (len(st.Pipeline) == 0) {
+			return nil, fmt.Errorf("fixture: step %d: exactly one of command or pipeline must be set", i)
+		}
+	}
+	return &s, nil
+}
+
+// ReadFixture reads a JSON-encoded fixture from r.
+func ReadFixture(r io.Reader) (*Fixture, error) {
+	var f Fixture
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// WriteTo writes the JSON-encoded fixture to w, indented for readability.
+func (f *Fixture) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	b = append(b, '\n')
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Server is the target of the requests of a Script or Fixture.
+type Server struct {
+	// BaseURL is the base URL of the REST API.
+	BaseURL string
+
+	// APIToken is the API token used for authentication.
+	APIToken string
+
+	// HTTPClient is the HTTP client used to make the requests. If nil,
+	// http.DefaultClient is used.
+	HTTPClient upstashdis.HTTPDoer
+}
+
+// Record executes the steps of the script against the server and returns
+// the recorded fixture.
+func (s *Server) Record(script *Script) (*Fixture, error) {
+	fx := &Fixture{Name: script.Name}
+	for i, st := range script.Steps {
+		var (
+			ex   Exchange
+			body interface{} = st.Command
+		)
+		ex.Path = "/"
+		if len(st.Pipeline) > 0 {
+			ex.Path = "/pipeline"
+			body = st.Pipeline
+		}
+
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("fixture: step %d: %w", i, err)
+		}
+		ex.Request = b
+
+		status, res, err := s.do(ex.Path, ex.Request)
+		if err != nil {
+			return nil, fmt.Errorf("fixture: step %d: %w", i, err)
+		}
+		ex.Status = status
+		ex.Response = res
+		fx.Exchanges = append(fx.Exchanges, ex)
+	}
+	return fx, nil
+}
+
+// Mismatch describes an exchange of a fixture for which the server returned
+// a different response.
+type Mismatch struct {
+	Index    int
+	Exchange Exchange
+	Status   int
+	Response json.RawMessage
+}
+
+// MismatchError is the error returned by Replay when the server returned
+// different responses than the ones recorded in the fixture.
+type MismatchError struct {
+	Fixture    string
+	Mismatches []Mismatch
+}
+
+// Error returns the description of the mismatches.
+func (e *MismatchError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "fixture %s: %d mismatch(es)", e.Fixture, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		fmt.Fprintf(&sb, "\n  exchange %d: %s %s: want [%d] %s, got [%d] %s", m.Index,
+			m.Exchange.Path, m.Exchange.Request, m.Exchange.Status, m.Exchange.Response, m.Status, m.Response)
+	}
+	return sb.String()
+}
+
+// Replay executes the requests of the fixture against the server and
+// compares the responses with the recorded ones. It returns a
+// *MismatchError if any response differs.
+func (s *Server) Replay(fx *Fixture) error {
+	merr := &MismatchError{Fixture: fx.Name}
+	for i, ex := range fx.Exchanges {
+		req, err := compact(ex.Request)
+		if err != nil {
+			return fmt.Errorf("fixture: exchange %d: invalid request: %w", i, err)
+		}
+		want, err := compact(ex.Response)
+		if err != nil {
+			return fmt.Errorf("fixture: exchange %d: invalid response: %w", i, err)
+		}
+
+		status, got, err := s.do(ex.Path, req)
+		if err != nil {
+			return fmt.Errorf("fixture: exchange %d: %w", i, err)
+		}
+		if status != ex.Status || !bytes.Equal(got, want) {
+			merr.Mismatches = append(merr.Mismatches, Mismatch{
+				Index: i,
+				Exchange: Exchange{
+					Path:     ex.Path,
+					Request:  req,
+					Status:   ex.Status,
+					Response: want,
+				},
+				Status:   status,
+				Response: got,
+			})
+		}
+	}
+	if len(merr.Mismatches) > 0 {
+		return merr
+	}
+	return nil
+}
+
+// do executes the request and returns the status code and compact JSON body
+// of the response.
+func (s *Server) do(urlPath string, body []byte) (int, json.RawMessage, error) {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return 0, nil, err
+	}
+	u.Path = path.Join(u.Path, urlPath)
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIToken)
+
+	cli := s.HTTPClient
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+	res, err := cli.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		// e.g. a 405 status code has no body, store it as a JSON null
+		return res.StatusCode, json.RawMessage("null"), nil
+	}
+	cb, err := compact(b)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return res.StatusCode, cb, nil
+}
+
+func compact(b []byte) (json.RawMessage, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package fixture
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordReplay(t *testing.T) {
+	f, err := os.Open("testdata/basic.json")
+	require.NoError(t, err)
+	defer f.Close()
+	script, err := ReadScript(f)
+	require.NoError(t, err)
+
+	srv := upstashtest.NewServer(t)
+	s := &Server{BaseURL: srv.URL(), APIToken: upstashtest.APIToken}
+	fx, err := s.Record(script)
+	require.NoError(t, err)
+	require.Equal(t, "basic", fx.Name)
+	require.Len(t, fx.Exchanges, len(script.Steps))
+	require.Equal(t, `{"result":"hello"}`, string(fx.Exchanges[2].Response))
+	require.Equal(t, 400, fx.Exchanges[4].Status)
+	require.Equal(t, "/pipeline", fx.Exchanges[9].Path)
+
+	// round-trip the fixture via its JSON encoding
+	var buf bytes.Buffer
+	_, err = fx.WriteTo(&buf)
+	require.NoError(t, err)
+	fx, err = ReadFixture(&buf)
+	require.NoError(t, err)
+
+	// replay on a fresh server
+	srv = upstashtest.NewServer(t)
+	s = &Server{BaseURL: srv.URL(), APIToken: upstashtest.APIToken}
+	require.NoError(t, s.Replay(fx))
+
+	// replaying again fails as the keys now exist
+	err = s.Replay(fx)
+	var merr *MismatchError
+	require.True(t, errors.As(err, &merr))
+	require.Equal(t, 0, merr.Mismatches[0].Index)
+	require.Equal(t, `{"result":4}`, string(merr.Mismatches[0].Response))
+}
+
+func TestReadScript(t *testing.T) {
+	_, err := ReadScript(bytes.NewReader([]byte(`{"steps": []}`)))
+	require.Error(t, err)
+	_, err = ReadScript(bytes.NewReader([]byte(`{"name": "x", "steps": [{}]}`)))
+	require.Error(t, err)
+	_, err = ReadScript(bytes.NewReader([]byte(`{"name": "x", "steps": [{"command": ["A"], "pipeline": [["B"]]}]}`)))
+	require.Error(t, err)
+	s, err := ReadScript(bytes.NewReader([]byte(`{"name": "x", "steps": [{"command": ["PING"]}]}`)))
+	require.NoError(t, err)
+	require.Equal(t, &Script{Name: "x", Steps: []Step{{Command: []interface{}{"PING"}}}}, s)
+}
@@ -0,0 +1,75 @@
+package upstashdis
+
+// The generic helpers below are typed variants of the Request's Exec
+// methods and of the Result unmarshaling, so that the results are returned
+// as values of the requested type instead of being unmarshaled into
+// destination pointers. The results are unmarshaled as described for
+// Request.Exec, e.g. ExecOne[time.Time] parses a timestamp result and
+// ExecOne[*string] returns nil for a null result.
+
+// ExecOne executes the command as described for Request.ExecOne and returns
+// its result unmarshaled into a value of type T. A null result returns the
+// zero value of T.
+//
+//	n, err := upstashdis.ExecOne[int64](req, "INCR", "counter")
+func ExecOne[T any](r *Request, cmd string, args ...interface{}) (T, error) {
+	var v T
+	err := r.ExecOne(&v, cmd, args...)
+	return v, err
+}
+
+// Get returns the result unmarshaled into a value of type T, e.g. for a
+// result returned by Request.ExecRaw or Client.Pipelined. If the result is
+// an error, it is returned as an *Error with a PipelineIndex of 0. A null
+// result returns the zero value of T.
+func Get[T any](res *Result) (T, error) {
+	var v T
+	if res.Error != "" {
+		return v, newError(res.Error, 0)
+	}
+	if res.Result == nil {
+		return v, nil
+	}
+	err := res.unmarshal(&v)
+	return v, err
+}
+
+// Exec2 executes the queued commands as described for Request.Exec and
+// returns the results of the first two commands unmarshaled into values of
+// type T1 and T2. As for Exec, if a command failed, the first error is
+// returned but the other results are still unmarshaled.
+//
+//	req.Send("GET", "name")
+//	req.Send("INCR", "visits")
+//	name, visits, err := upstashdis.Exec2[string, int64](req)
+func Exec2[T1, T2 any](r *Request) (T1, T2, error) {
+	var (
+		v1 T1
+		v2 T2
+	)
+	err := r.Exec(&v1, &v2)
+	return v1, v2, err
+}
+
+// Exec3 is like Exec2, for the results of the first three commands.
+func Exec3[T1, T2, T3 any](r *Request) (T1, T2, T3, error) {
+	var (
+		v1 T1
+		v2 T2
+		v3 T3
+	)
+	err := r.Exec(&v1, &v2, &v3)
+	return v1, v2, v3, err
+}
+
+// Exec4 is like Exec2, for the results of the first four commands.
+func Exec4[T1, T2, T3, T4 any](r *Request) (T1, T2, T3, T4, error) {
+	var (
+		v1 T1
+		v2 T2
+		v3 T3
+		v4 T4
+	)
+	err := r.Exec(&v1, &v2, &v3, &v4)
+	return v1, v2, v3, v4, err
+}
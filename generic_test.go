@@ -0,0 +1,84 @@
+package upstashdis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneric(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+
+	srv.Redis.Set("name", "upstash")
+	srv.Redis.Set("ts", "2022-05-01T10:00:00Z")
+	srv.Redis.RPush("list", "a", "b")
+
+	t.Run("ExecOne", func(t *testing.T) {
+		n, err := upstashdis.ExecOne[int64](cli.NewRequest(), "INCR", "n")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+
+		ts, err := upstashdis.ExecOne[time.Time](cli.NewRequest(), "GET", "ts")
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC), ts.UTC())
+
+		p, err := upstashdis.ExecOne[*string](cli.NewRequest(), "GET", "nope")
+		require.NoError(t, err)
+		require.Nil(t, p)
+
+		_, err = upstashdis.ExecOne[string](cli.NewRequest(), "HGET", "name", "x")
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, "WRONGTYPE", rerr.Kind)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("LRANGE", "list", 0, -1))
+		require.NoError(t, req.Send("GET", "nope"))
+		require.NoError(t, req.Send("HGET", "name", "x"))
+		res, err := req.ExecRaw()
+		require.NoError(t, err)
+
+		strs, err := upstashdis.Get[[]string](res[0])
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, strs)
+
+		s, err := upstashdis.Get[string](res[1])
+		require.NoError(t, err)
+		require.Equal(t, "", s)
+
+		_, err = upstashdis.Get[string](res[2])
+		require.Error(t, err)
+	})
+
+	t.Run("Exec", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "name"))
+		require.NoError(t, req.Send("INCR", "n"))
+		name, n, err := upstashdis.Exec2[string, int](req)
+		require.NoError(t, err)
+		require.Equal(t, "upstash", name)
+		require.Equal(t, 2, n)
+
+		require.NoError(t, req.Send("HGET", "name", "x"))
+		require.NoError(t, req.Send("LLEN", "list"))
+		require.NoError(t, req.Send("EXISTS", "name"))
+		require.NoError(t, req.Send("GET", "name"))
+		_, l, ex, s, err := upstashdis.Exec4[string, int64, int, string](req)
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, 0, rerr.PipelineIndex)
+		require.Equal(t, int64(2), l)
+		require.Equal(t, 1, ex)
+		require.Equal(t, "upstash", s)
+
+		require.NoError(t, req.Send("GET", "name"))
+		_, _, _, err = upstashdis.Exec3[string, string, string](req)
+		require.Error(t, err)
+	})
+}
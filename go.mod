@@ -1,20 +1,22 @@
 module github.com/mna/upstashdis
 
-go 1.17
+go 1.18
 
 require (
+	github.com/alicebob/miniredis/v2 v2.21.0
 	github.com/gomodule/redigo v1.8.8
+	github.com/mna/mainer v0.2.0
+	github.com/peterh/liner v1.2.2
 	github.com/stretchr/testify v1.7.0
+	github.com/wI2L/jettison v0.7.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
-	github.com/alicebob/miniredis/v2 v2.21.0 // indirect
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0 // indirect
-	github.com/mna/mainer v0.2.0 // indirect
+	github.com/mattn/go-runewidth v0.0.3 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/wI2L/jettison v0.7.4 // indirect
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,122 @@
+package upstashdis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ExecGroup executes many independent requests concurrently, with a limit on
+// the number of requests in flight. It is created by calling
+// Client.NewExecGroup and is safe for concurrent use. Unlike a pipeline, each
+// function started with Go makes its own REST API calls.
+type ExecGroup struct {
+	c   *Client
+	ctx context.Context
+	sem chan struct{} // nil if unlimited
+	wg  sync.WaitGroup
+
+	mu   sync.Mutex // protects the following fields
+	n    int
+	errs map[int]error
+}
+
+// GroupError is the error returned by ExecGroup.Wait when at least one of the
+// functions failed. Errors holds the error returned by each function that
+// failed, keyed by the index of that function, in the order in which they
+// were started with ExecGroup.Go.
+type GroupError struct {
+	Errors map[int]error
+	// Total is the total number of functions started in the group.
+	Total int
+}
+
+// Error returns a message that summarizes the number of failures and the
+// error of the first function that failed.
+func (e *GroupError) Error() string {
+	ixs := e.indices()
+	return fmt.Sprintf("upstashdis: %d of %d requests failed, first error at index %d: %s",
+		len(ixs), e.Total, ixs[0], e.Errors[ixs[0]])
+}
+
+// Unwrap returns the errors of the failed functions, in the order in which
+// the functions were started.
+func (e *GroupError) Unwrap() []error {
+	ixs := e.indices()
+	errs := make([]error, len(ixs))
+	for i, ix := range ixs {
+		errs[i] = e.Errors[ix]
+	}
+	return errs
+}
+
+func (e *GroupError) indices() []int {
+	ixs := make([]int, 0, len(e.Errors))
+	for ix := range e.Errors {
+		ixs = append(ixs, ix)
+	}
+	sort.Ints(ixs)
+	return ixs
+}
+
+// NewExecGroup returns an ExecGroup that executes at most limit functions
+// concurrently. If limit <= 0, the number of concurrent functions is not
+// limited. The requests provided to the functions make their HTTP calls with
+// ctx.
+func (c *Client) NewExecGroup(ctx context.Context, limit int) *ExecGroup {
+	g := &ExecGroup{c: c, ctx: ctx}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g
+}
+
+// Go calls fn in a new goroutine with a new request started with the group's
+// client. If the concurrency limit is reached, it blocks until one of the
+// running functions returns. If fn returns an error, it is reported by Wait.
+// Go must not be called after Wait.
+func (g *ExecGroup) Go(fn func(req *Request) error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+
+	g.mu.Lock()
+	ix := g.n
+	g.n++
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+
+		req := g.c.NewRequestContext(g.ctx)
+		if err := fn(req); err != nil {
+			g.mu.Lock()
+			if g.errs == nil {
+				g.errs = make(map[int]error)
+			}
+			g.errs[ix] = err
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// Wait waits for all functions started with Go to return. If any of them
+// failed, it returns a *GroupError that holds all errors, otherwise it
+// returns nil.
+func (g *ExecGroup) Wait() error {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	return &GroupError{Errors: g.errs, Total: g.n}
+}
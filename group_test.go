@@ -0,0 +1,94 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecGroup(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+
+	t.Run("success", func(t *testing.T) {
+		g := cli.NewExecGroup(context.Background(), 3)
+		for i := 0; i < 10; i++ {
+			i := i
+			g.Go(func(req *upstashdis.Request) error {
+				return req.ExecOne(nil, "SET", fmt.Sprintf("tenant:%d", i), i)
+			})
+		}
+		require.NoError(t, g.Wait())
+		for i := 0; i < 10; i++ {
+			srv.Redis.CheckGet(t, fmt.Sprintf("tenant:%d", i), fmt.Sprint(i))
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		var cur, peak int64
+		g := cli.NewExecGroup(context.Background(), 2)
+		for i := 0; i < 10; i++ {
+			g.Go(func(req *upstashdis.Request) error {
+				n := atomic.AddInt64(&cur, 1)
+				defer atomic.AddInt64(&cur, -1)
+				for {
+					m := atomic.LoadInt64(&peak)
+					if n <= m || atomic.CompareAndSwapInt64(&peak, m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				return req.ExecOne(nil, "PING")
+			})
+		}
+		require.NoError(t, g.Wait())
+		require.LessOrEqual(t, atomic.LoadInt64(&peak), int64(2))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		sentinel := errors.New("fail")
+		g := cli.NewExecGroup(context.Background(), 0)
+		for i := 0; i < 5; i++ {
+			i := i
+			g.Go(func(req *upstashdis.Request) error {
+				switch i {
+				case 1:
+					return req.ExecOne(nil, "NOTACMD")
+				case 3:
+					return sentinel
+				}
+				return req.ExecOne(nil, "PING")
+			})
+		}
+		err := g.Wait()
+		require.Error(t, err)
+
+		var gerr *upstashdis.GroupError
+		require.True(t, errors.As(err, &gerr))
+		require.Equal(t, 5, gerr.Total)
+		require.Len(t, gerr.Errors, 2)
+		require.Equal(t, sentinel, gerr.Errors[3])
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(gerr.Errors[1], &rerr))
+		require.Contains(t, err.Error(), "2 of 5 requests failed, first error at index 1")
+		require.Equal(t, []error{gerr.Errors[1], sentinel}, gerr.Unwrap())
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		g := cli.NewExecGroup(ctx, 1)
+		g.Go(func(req *upstashdis.Request) error {
+			return req.ExecOne(nil, "PING")
+		})
+		err := g.Wait()
+		require.ErrorIs(t, err.(*upstashdis.GroupError).Errors[0], context.Canceled)
+	})
+}
@@ -0,0 +1,101 @@
+package upstashdis
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Hooks is the interface implemented by the instrumentation hooks of a
+// Client, see Client.Hooks. The upstashotel package provides an
+// implementation that records OpenTelemetry spans and metrics.
+type Hooks interface {
+	// OnRequestStart is called before a REST API call is made, with the
+	// information known at that point. The returned context is used for the
+	// HTTP request(s) of the call and passed to OnRequestEnd, so that e.g. a
+	// span can be attached to it. It must not return nil.
+	OnRequestStart(ctx context.Context, info *RequestInfo) context.Context
+
+	// OnRequestEnd is called when the REST API call completes, successfully
+	// or not, with the same info value as OnRequestStart, now with all its
+	// fields set.
+	OnRequestEnd(ctx context.Context, info *RequestInfo)
+}
+
+// RequestInfo describes a REST API call for the Hooks of a Client.
+type RequestInfo struct {
+	// Method is the HTTP method of the call.
+	Method string
+
+	// Endpoint is the endpoint of the call, relative to the BaseURL, e.g.
+	// "pipeline" or "multi-exec". It is empty for a single command.
+	Endpoint string
+
+	// Commands is the upper-case name of each command executed by the call,
+	// so its length is the pipeline (or transaction) size. It is nil for a
+	// call made with Client.Call.
+	Commands []string
+
+	// Start is the time when the call started.
+	Start time.Time
+
+	// The following fields are set when OnRequestEnd is called.
+
+	// Duration is the duration of the call, including its retries.
+	Duration time.Duration
+
+	// Attempts is the number of attempts made, more than one if the call was
+	// retried.
+	Attempts int
+
+	// StatusCode is the HTTP status code of the response of the last
+	// attempt, or 0 if no response was received (e.g. on a network error).
+	StatusCode int
+
+	// Err is the error returned by the call, if any. It is not set for the
+	// errors of individual commands in a pipeline or transaction, as the call
+	// itself succeeded.
+	Err error
+}
+
+// commandNames returns the upper-case name of each command.
+func commandNames(cmds [][]interface{}) []string {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		name, _ := cmd[0].(string)
+		names[i] = strings.ToUpper(name)
+	}
+	return names
+}
+
+// startHooks calls the OnRequestStart hook of the client, if any, and
+// returns the context to use for the call and the info to pass to
+// endHooks.
+func (c *Client) startHooks(ctx context.Context, method, endpoint string, cmds []string) (context.Context, *RequestInfo) {
+	if c.Hooks == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	info := &RequestInfo{
+		Method:   method,
+		Endpoint: endpoint,
+		Commands: cmds,
+		Start:    time.Now(),
+	}
+	return c.Hooks.OnRequestStart(ctx, info), info
+}
+
+// endHooks calls the OnRequestEnd hook of the client, if the call was
+// started with a hook.
+func (c *Client) endHooks(ctx context.Context, info *RequestInfo, attempts, status int, err error) {
+	if info == nil {
+		return
+	}
+	info.Duration = time.Since(info.Start)
+	info.Attempts = attempts
+	info.StatusCode = status
+	info.Err = err
+	c.Hooks.OnRequestEnd(ctx, info)
+}
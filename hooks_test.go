@@ -0,0 +1,124 @@
+package upstashdis_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+type ctxKey struct{}
+
+// recordHooks records the info of the completed calls.
+type recordHooks struct {
+	started int
+	ended   []upstashdis.RequestInfo
+}
+
+func (h *recordHooks) OnRequestStart(ctx context.Context, info *upstashdis.RequestInfo) context.Context {
+	h.started++
+	return context.WithValue(ctx, ctxKey{}, h.started)
+}
+
+func (h *recordHooks) OnRequestEnd(ctx context.Context, info *upstashdis.RequestInfo) {
+	if ctx.Value(ctxKey{}) != h.started {
+		panic("unexpected context")
+	}
+	h.ended = append(h.ended, *info)
+}
+
+func (h *recordHooks) last() upstashdis.RequestInfo {
+	return h.ended[len(h.ended)-1]
+}
+
+func TestHooks(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	hooks := &recordHooks{}
+	cli := srv.Client()
+	cli.Hooks = hooks
+
+	t.Run("single", func(t *testing.T) {
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "set", "a", "1"))
+		info := hooks.last()
+		require.Equal(t, "POST", info.Method)
+		require.Equal(t, "", info.Endpoint)
+		require.Equal(t, []string{"SET"}, info.Commands)
+		require.Equal(t, http.StatusOK, info.StatusCode)
+		require.Equal(t, 1, info.Attempts)
+		require.NoError(t, info.Err)
+		require.False(t, info.Start.IsZero())
+		require.Greater(t, info.Duration, time.Duration(0))
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("HGETALL", "a"))
+		require.Error(t, req.Exec(nil, nil))
+
+		// the command failed, not the call
+		info := hooks.last()
+		require.Equal(t, "pipeline", info.Endpoint)
+		require.Equal(t, []string{"GET", "HGETALL"}, info.Commands)
+		require.Equal(t, http.StatusOK, info.StatusCode)
+		require.NoError(t, info.Err)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("INCR", "a"))
+		require.NoError(t, req.ExecTx(nil))
+		require.Equal(t, "multi-exec", hooks.last().Endpoint)
+		require.Equal(t, []string{"INCR"}, hooks.last().Commands)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := cli.NewRequestWithToken("invalid").ExecOne(nil, "GET", "a")
+		require.Error(t, err)
+		info := hooks.last()
+		require.Equal(t, http.StatusUnauthorized, info.StatusCode)
+		require.Equal(t, err, info.Err)
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		n := len(hooks.ended)
+		require.NoError(t, cli.CloneWithToken(upstashtest.APIToken).NewRequest().ExecOne(nil, "PING"))
+		require.Len(t, hooks.ended, n+1)
+	})
+	require.Equal(t, hooks.started, len(hooks.ended))
+}
+
+func TestHooksRetryAndCall(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":"OK"}`))
+	}))
+	defer srv.Close()
+
+	hooks := &recordHooks{}
+	cli := &upstashdis.Client{
+		BaseURL:  srv.URL,
+		APIToken: "tok",
+		Retry:    &upstashdis.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond},
+		Hooks:    hooks,
+	}
+	_, err := cli.Call(context.Background(), "GET", "info", nil)
+	require.NoError(t, err)
+	require.Len(t, hooks.ended, 1)
+	info := hooks.last()
+	require.Equal(t, "GET", info.Method)
+	require.Equal(t, "info", info.Endpoint)
+	require.Nil(t, info.Commands)
+	require.Equal(t, 2, info.Attempts)
+	require.Equal(t, http.StatusOK, info.StatusCode)
+}
@@ -0,0 +1,377 @@
+// Package metrics implements a minimal registry of counters, gauges and
+// histograms that can be exposed in the Prometheus text format [1]. It
+// avoids a dependency on the Prometheus client library for the few metrics
+// exposed by the commands and packages of this module.
+//
+//	[1]: https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds, suitable for
+// request latencies.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics families. It is safe for concurrent use.
+// It implements http.Handler to serve the metrics in the Prometheus text
+// format.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+type family interface {
+	name() string
+	writeTo(w *bufio.Writer)
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ff := range r.families {
+		if ff.name() == f.name() {
+			panic(fmt.Sprintf("metrics: duplicate metric name %q", f.name()))
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// NewCounter registers and returns a new counter metric with the provided
+// label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge registers and returns a new gauge metric with the provided label
+// names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers and returns a new histogram metric with the
+// provided buckets (upper bounds, in increasing order) and label names. If
+// buckets is nil, DefaultBuckets is used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes all metrics in the Prometheus text format to w.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	fams := make([]family, len(r.families))
+	copy(fams, r.families)
+	r.mu.Unlock()
+
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name() < fams[j].name() })
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range fams {
+		f.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// vec is the common part of all metric types: the series are indexed by
+// their label values.
+type vec struct {
+	fname  string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		fname:  name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+	}
+}
+
+func (v *vec) name() string { return v.fname }
+
+// get returns the series for the label values, creating it with newFn if it
+// does not exist.
+func (v *vec) get(values []string, newFn func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values, got %d", v.fname, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = newFn()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn for each series, sorted by label values, with the formatted
+// labels. It holds the lock during the calls.
+func (v *vec) each(fn func(labels []string, series interface{})) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(v.values[k], v.series[k])
+	}
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.fname, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.fname, v.typ)
+}
+
+// formatLabels formats the label pairs, with the optional extra pair
+// appended (e.g. the "le" label of histogram buckets).
+func (v *vec) formatLabels(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range v.labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(values[i]))
+		sb.WriteByte('"')
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extra[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(extra[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// CounterVec is a counter metric partitioned by label values.
+type CounterVec struct {
+	vec
+}
+
+// Counter is a single counter series.
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// With returns the counter series for the label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v to the counter, it panics if v is negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.fname, c.formatLabels(values), formatFloat(s.(*Counter).Value()))
+	})
+}
+
+// GaugeVec is a gauge metric partitioned by label values.
+type GaugeVec struct {
+	vec
+}
+
+// Gauge is a single gauge series.
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// With returns the gauge series for the label values.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+// Add adds v (which may be negative) to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.v += v
+	g.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+func (g *GaugeVec) writeTo(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", g.fname, g.formatLabels(values), formatFloat(s.(*Gauge).Value()))
+	})
+}
+
+// HistogramVec is a histogram metric partitioned by label values.
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// Histogram is a single histogram series.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // non-cumulative count per bucket
+	count   uint64
+	sum     float64
+}
+
+// With returns the histogram series for the label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func() interface{} {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+// Observe records the value v in the histogram.
+func (h *Histogram) Observe(v float64) {
+	ix := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ix < len(h.counts) {
+		h.counts[ix]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// Snapshot returns the cumulative count of each bucket, the total count of
+// observations and their sum.
+func (h *Histogram) Snapshot() (cumulative []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.counts))
+	var total uint64
+	for i, c := range h.counts {
+		total += c
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s interface{}) {
+		cumul, count, sum := s.(*Histogram).Snapshot()
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, h.formatLabels(values, "le", formatFloat(b)), cumul[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, h.formatLabels(values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fname, h.formatLabels(values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fname, h.formatLabels(values), count)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpReplacer.Replace(s) }
+func escapeLabel(s string) string { return labelReplacer.Replace(s) }
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	var reg Registry
+	c := reg.NewCounter("test_total", "A counter.", "cmd")
+	g := reg.NewGauge("test_gauge", "A gauge\nwith newline.")
+	h := reg.NewHistogram("test_seconds", "A histogram.", []float64{0.1, 1}, "cmd")
+
+	c.With("get").Inc()
+	c.With("get").Add(2)
+	c.With(`s"et`).Inc()
+	g.With().Set(3)
+	g.With().Add(-1)
+	h.With("get").Observe(0.05)
+	h.With("get").Observe(0.5)
+	h.With("get").Observe(5)
+
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	require.NoError(t, err)
+
+	want := `# HELP test_gauge A gauge\nwith newline.
+# TYPE test_gauge gauge
+test_gauge 2
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{cmd="get",le="0.1"} 1
+test_seconds_bucket{cmd="get",le="1"} 2
+test_seconds_bucket{cmd="get",le="+Inf"} 3
+test_seconds_sum{cmd="get"} 5.55
+test_seconds_count{cmd="get"} 3
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{cmd="get"} 3
+test_total{cmd="s\"et"} 1
+`
+	require.Equal(t, want, sb.String())
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	var reg Registry
+	reg.NewCounter("a", "")
+	require.Panics(t, func() { reg.NewGauge("a", "") })
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	var reg Registry
+	c := reg.NewCounter("a", "", "x", "y")
+	require.Panics(t, func() { c.With("1") })
+}
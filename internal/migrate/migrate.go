@@ -0,0 +1,322 @@
+// Package migrate implements the copy of keys from a Redis instance to an
+// Upstash-compatible Redis REST API. It is shared by the commands that move
+// data into Upstash.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis"
+)
+
+// Outcome is the outcome of the copy of a key.
+type Outcome int
+
+// List of possible outcomes.
+const (
+	// Copied indicates that the key was copied to the target.
+	Copied Outcome = iota
+	// Missing indicates that the key does not exist in the source (e.g. it
+	// expired or was deleted since it was listed).
+	Missing
+	// Exists indicates that the key already exists in the target and was left
+	// untouched because Replace is false.
+	Exists
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Copied:
+		return "copied"
+	case Missing:
+		return "missing"
+	case Exists:
+		return "exists"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+}
+
+// ErrBinaryValue is returned when a key cannot be copied because its value
+// is not valid UTF-8, which cannot be represented in the JSON-encoded
+// commands of the REST API.
+var ErrBinaryValue = errors.New("binary value cannot be sent via the REST API")
+
+// maxArgs is the maximum number of values sent in a single command when
+// copying a collection.
+const maxArgs = 1000
+
+// Copier copies keys from the Source Redis instance to the Target REST API.
+// It is safe for concurrent use.
+type Copier struct {
+	// Source is the connection pool to the source Redis instance.
+	Source *redis.Pool
+
+	// Target is the client of the target REST API.
+	Target *upstashdis.Client
+
+	// Replace indicates if keys that already exist in the target are replaced.
+	Replace bool
+}
+
+// Copy copies the key along with its TTL. It first tries to DUMP the key and
+// RESTORE it in the target, and falls back to reading the value with
+// type-specific commands if the payload cannot be sent via the REST API or
+// is rejected by the target (e.g. if the Redis versions differ). In that
+// case, the copy is not atomic in the target.
+func (c *Copier) Copy(key string) (Outcome, error) {
+	conn := c.Source.Get()
+	defer conn.Close()
+
+	pttl, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	if pttl == -2 {
+		return Missing, nil
+	}
+	if pttl < 0 {
+		pttl = 0
+	}
+
+	payload, err := redis.Bytes(conn.Do("DUMP", key))
+	switch {
+	case errors.Is(err, redis.ErrNil):
+		return Missing, nil
+	case err == nil && utf8.Valid(payload):
+		args := []interface{}{key, pttl, payload}
+		if c.Replace {
+			args = append(args, "REPLACE")
+		}
+		err = c.Target.NewRequest().ExecOne(nil, "RESTORE", args...)
+		if err == nil {
+			return Copied, nil
+		}
+		var uerr *upstashdis.Error
+		if errors.As(err, &uerr) && uerr.Kind == upstashdis.KindBusyKey {
+			return Exists, nil
+		}
+		if !errors.As(err, &uerr) {
+			return 0, err
+		}
+	}
+	// DUMP is not supported by the source, the payload is binary or RESTORE
+	// failed in the target, copy using the type-specific commands.
+	return c.copyValue(conn, key, pttl)
+}
+
+func (c *Copier) copyValue(conn redis.Conn, key string, pttl int64) (Outcome, error) {
+	typ, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return 0, err
+	}
+	if typ == "none" {
+		return Missing, nil
+	}
+
+	cmds, err := readValue(conn, typ, key)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return Missing, nil
+		}
+		return 0, err
+	}
+	if cmds == nil {
+		// the key was removed between TYPE and the read of its value
+		return Missing, nil
+	}
+
+	req := c.Target.NewRequest()
+	if !c.Replace {
+		var n int
+		if err := req.ExecOne(&n, "EXISTS", key); err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return Exists, nil
+		}
+	} else {
+		cmds = append([][]interface{}{{"DEL", key}}, cmds...)
+	}
+	if pttl > 0 {
+		cmds = append(cmds, []interface{}{"PEXPIRE", key, pttl})
+	}
+
+	for _, cmd := range cmds {
+		if err := req.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			return 0, err
+		}
+	}
+	res, err := req.ExecRaw()
+	if err != nil {
+		return 0, err
+	}
+	for i, r := range res {
+		if r.Error != "" {
+			return 0, fmt.Errorf("%s: %s", cmds[i][0], r.Error)
+		}
+	}
+	return Copied, nil
+}
+
+// readValue reads the value of the key of type typ and returns the commands
+// that create it, or nil if the key does not exist.
+func readValue(conn redis.Conn, typ, key string) ([][]interface{}, error) {
+	switch typ {
+	case "string":
+		v, err := redis.String(conn.Do("GET", key))
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.ValidString(v) {
+			return nil, ErrBinaryValue
+		}
+		return [][]interface{}{{"SET", key, v}}, nil
+
+	case "list":
+		vals, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		return chunkCommands("RPUSH", key, vals, 1)
+
+	case "set":
+		vals, err := redis.Strings(conn.Do("SMEMBERS", key))
+		if err != nil {
+			return nil, err
+		}
+		return chunkCommands("SADD", key, vals, 1)
+
+	case "hash":
+		vals, err := redis.Strings(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		return chunkCommands("HSET", key, vals, 2)
+
+	case "zset":
+		vals, err := redis.Strings(conn.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+		if err != nil {
+			return nil, err
+		}
+		// ZADD expects score-member pairs
+		for i := 0; i+1 < len(vals); i += 2 {
+			vals[i], vals[i+1] = vals[i+1], vals[i]
+		}
+		return chunkCommands("ZADD", key, vals, 2)
+
+	case "stream":
+		return readStream(conn, key)
+
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", typ)
+	}
+}
+
+// chunkCommands returns the commands that add the values to the key, with at
+// most maxArgs values per command. Values are grouped by size, so that e.g.
+// field-value pairs of a hash are not split.
+func chunkCommands(cmd, key string, vals []string, size int) ([][]interface{}, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	var cmds [][]interface{}
+	chunk := maxArgs - maxArgs%size
+	for len(vals) > 0 {
+		n := chunk
+		if n > len(vals) {
+			n = len(vals)
+		}
+		args := make([]interface{}, 0, n+2)
+		args = append(args, cmd, key)
+		for _, v := range vals[:n] {
+			if !utf8.ValidString(v) {
+				return nil, ErrBinaryValue
+			}
+			args = append(args, v)
+		}
+		cmds = append(cmds, args)
+		vals = vals[n:]
+	}
+	return cmds, nil
+}
+
+func readStream(conn redis.Conn, key string) ([][]interface{}, error) {
+	entries, err := redis.Values(conn.Do("XRANGE", key, "-", "+"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds [][]interface{}
+	for _, entry := range entries {
+		parts, err := redis.Values(entry, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry format: %d values", len(parts))
+		}
+		id, err := redis.String(parts[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.Strings(parts[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		args := []interface{}{"XADD", key, id}
+		for _, f := range fields {
+			if !utf8.ValidString(f) {
+				return nil, ErrBinaryValue
+			}
+			args = append(args, f)
+		}
+		cmds = append(cmds, args)
+	}
+	return cmds, nil
+}
+
+// Scan returns the next batch of keys of the source matching the pattern,
+// using the SCAN command with the provided cursor and count. The returned
+// cursor is "0" when the iteration is complete. If typ is not empty, only
+// keys of that type are returned.
+func Scan(conn redis.Conn, cursor, pattern, typ string, count int) (string, []string, error) {
+	args := []interface{}{cursor}
+	if pattern != "" {
+		args = append(args, "MATCH", pattern)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	if typ != "" {
+		args = append(args, "TYPE", strings.ToLower(typ))
+	}
+
+	vals, err := redis.Values(conn.Do("SCAN", args...))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(vals) != 2 {
+		return "", nil, fmt.Errorf("unexpected SCAN reply: %d values", len(vals))
+	}
+	next, err := redis.String(vals[0], nil)
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := redis.Strings(vals[1], nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return next, keys, nil
+}
+
+// Delete deletes the key from the target.
+func (c *Copier) Delete(key string) error {
+	return c.Target.NewRequest().ExecOne(nil, "DEL", key)
+}
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	src := miniredis.RunT(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", src.Addr())
+		},
+	}
+	defer pool.Close()
+
+	dst := upstashtest.NewServer(t)
+	cp := &Copier{Source: pool, Target: dst.Client()}
+
+	require.NoError(t, src.Set("str", "a"))
+	src.SetTTL("str", time.Minute)
+	_, err := src.Push("list", "a", "b", "c")
+	require.NoError(t, err)
+	_, err = src.SetAdd("set", "x", "y")
+	require.NoError(t, err)
+	src.HSet("hash", "f1", "v1", "f2", "v2")
+	_, err = src.ZAdd("zset", 1.5, "m1")
+	require.NoError(t, err)
+	_, err = src.ZAdd("zset", 2, "m2")
+	require.NoError(t, err)
+	_, err = src.XAdd("stream", "1-1", []string{"f", "v"})
+	require.NoError(t, err)
+	require.NoError(t, src.Set("bin", "\xff\xfe"))
+
+	cases := []struct {
+		key string
+		out Outcome
+		err error
+	}{
+		{"str", Copied, nil},
+		{"list", Copied, nil},
+		{"set", Copied, nil},
+		{"hash", Copied, nil},
+		{"zset", Copied, nil},
+		{"stream", Copied, nil},
+		{"nosuchkey", Missing, nil},
+		{"bin", 0, ErrBinaryValue},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			out, err := cp.Copy(c.key)
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.out, out)
+		})
+	}
+
+	dst.Redis.CheckGet(t, "str", "a")
+	require.Equal(t, time.Minute, dst.Redis.TTL("str"))
+	dst.Redis.CheckList(t, "list", "a", "b", "c")
+	dst.Redis.CheckSet(t, "set", "x", "y")
+	require.Equal(t, "v2", dst.Redis.HGet("hash", "f2"))
+	score, err := dst.Redis.ZScore("zset", "m1")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, score)
+	entries, err := dst.Redis.Stream("stream")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "1-1", entries[0].ID)
+
+	t.Run("exists", func(t *testing.T) {
+		require.NoError(t, src.Set("str", "b"))
+		out, err := cp.Copy("str")
+		require.NoError(t, err)
+		require.Equal(t, Exists, out)
+		dst.Redis.CheckGet(t, "str", "a")
+
+		rcp := &Copier{Source: pool, Target: dst.Client(), Replace: true}
+		out, err = rcp.Copy("str")
+		require.NoError(t, err)
+		require.Equal(t, Copied, out)
+		dst.Redis.CheckGet(t, "str", "b")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, cp.Delete("str"))
+		require.False(t, dst.Redis.Exists("str"))
+		require.NoError(t, cp.Delete("str"))
+	})
+}
+
+func TestScan(t *testing.T) {
+	src := miniredis.RunT(t)
+	conn, err := redis.Dial("tcp", src.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, src.Set("a:1", "1"))
+	require.NoError(t, src.Set("a:2", "2"))
+	require.NoError(t, src.Set("b:1", "1"))
+	_, err = src.Push("a:list", "x")
+	require.NoError(t, err)
+
+	cursor, keys, err := Scan(conn, "0", "a:*", "", 10)
+	require.NoError(t, err)
+	require.Equal(t, "0", cursor)
+	require.ElementsMatch(t, []string{"a:1", "a:2", "a:list"}, keys)
+
+	_, keys, err = Scan(conn, "0", "a:*", "string", 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a:1", "a:2"}, keys)
+}
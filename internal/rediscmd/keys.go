@@ -0,0 +1,413 @@
+package rediscmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keySpec describes the positions of the keys in the arguments of a command.
+// If find is nil, the keys are the arguments from first to last (inclusive,
+// a negative last counts from the end, -1 being the last argument), every
+// step arguments, as in the COMMAND INFO reply of Redis. The positions are
+// indexes in the command, the command name being at index 0. A spec with a
+// first of 0 and no find function describes a command without keys.
+type keySpec struct {
+	first, last, step int
+	find              func(cmd []interface{}) []int
+}
+
+var (
+	noKeys      = keySpec{}
+	firstKey    = keySpec{first: 1, last: 1, step: 1}
+	secondKey   = keySpec{first: 2, last: 2, step: 1}
+	firstTwo    = keySpec{first: 1, last: 2, step: 1}
+	allKeys     = keySpec{first: 1, last: -1, step: 1}
+	allButLast  = keySpec{first: 1, last: -2, step: 1}
+	keyValPairs = keySpec{first: 1, last: -1, step: 2}
+)
+
+// keySpecs is the set of commands for which the positions of the keys are
+// known, with the set of commands without keys.
+var keySpecs = map[string]keySpec{
+	// commands without keys
+	"acl":      noKeys,
+	"dbsize":   noKeys,
+	"discard":  noKeys,
+	"echo":     noKeys,
+	"exec":     noKeys,
+	"flushall": noKeys,
+	"flushdb":  noKeys,
+	"info":     noKeys,
+	"lastsave": noKeys,
+	"multi":    noKeys,
+	"ping":     noKeys,
+	"publish":  noKeys,
+	"script":   noKeys,
+	"time":     noKeys,
+	"unwatch":  noKeys,
+
+	// the pattern of KEYS and SCAN is prefixed like a key, so that the
+	// matching keys are restricted to those with the prefix
+	"keys":      firstKey,
+	"randomkey": noKeys,
+	"scan":      {find: findOption("match")},
+
+	// generic
+	"copy":        firstTwo,
+	"del":         allKeys,
+	"dump":        firstKey,
+	"exists":      allKeys,
+	"expire":      firstKey,
+	"expireat":    firstKey,
+	"expiretime":  firstKey,
+	"persist":     firstKey,
+	"pexpire":     firstKey,
+	"pexpireat":   firstKey,
+	"pexpiretime": firstKey,
+	"pttl":        firstKey,
+	"rename":      firstTwo,
+	"renamenx":    firstTwo,
+	"restore":     firstKey,
+	"sort":        {find: findSort},
+	"sort_ro":     firstKey,
+	"touch":       allKeys,
+	"ttl":         firstKey,
+	"type":        firstKey,
+	"unlink":      allKeys,
+	"watch":       allKeys,
+
+	// strings and bitmaps
+	"append":      firstKey,
+	"bitcount":    firstKey,
+	"bitfield":    firstKey,
+	"bitfield_ro": firstKey,
+	"bitop":       {first: 2, last: -1, step: 1},
+	"bitpos":      firstKey,
+	"decr":        firstKey,
+	"decrby":      firstKey,
+	"get":         firstKey,
+	"getbit":      firstKey,
+	"getdel":      firstKey,
+	"getex":       firstKey,
+	"getrange":    firstKey,
+	"getset":      firstKey,
+	"incr":        firstKey,
+	"incrby":      firstKey,
+	"incrbyfloat": firstKey,
+	"lcs":         firstTwo,
+	"mget":        allKeys,
+	"mset":        keyValPairs,
+	"msetnx":      keyValPairs,
+	"psetex":      firstKey,
+	"set":         firstKey,
+	"setbit":      firstKey,
+	"setex":       firstKey,
+	"setnx":       firstKey,
+	"setrange":    firstKey,
+	"strlen":      firstKey,
+	"substr":      firstKey,
+
+	// hashes
+	"hdel":         firstKey,
+	"hexists":      firstKey,
+	"hget":         firstKey,
+	"hgetall":      firstKey,
+	"hincrby":      firstKey,
+	"hincrbyfloat": firstKey,
+	"hkeys":        firstKey,
+	"hlen":         firstKey,
+	"hmget":        firstKey,
+	"hmset":        firstKey,
+	"hrandfield":   firstKey,
+	"hscan":        firstKey,
+	"hset":         firstKey,
+	"hsetnx":       firstKey,
+	"hstrlen":      firstKey,
+	"hvals":        firstKey,
+
+	// lists
+	"blmove":     firstTwo,
+	"blmpop":     {find: findNumKeys(2)},
+	"blpop":      allButLast,
+	"brpop":      allButLast,
+	"brpoplpush": firstTwo,
+	"lindex":     firstKey,
+	"linsert":    firstKey,
+	"llen":       firstKey,
+	"lmove":      firstTwo,
+	"lmpop":      {find: findNumKeys(1)},
+	"lpop":       firstKey,
+	"lpos":       firstKey,
+	"lpush":      firstKey,
+	"lpushx":     firstKey,
+	"lrange":     firstKey,
+	"lrem":       firstKey,
+	"lset":       firstKey,
+	"ltrim":      firstKey,
+	"rpop":       firstKey,
+	"rpoplpush":  firstTwo,
+	"rpush":      firstKey,
+	"rpushx":     firstKey,
+
+	// sets
+	"sadd":        firstKey,
+	"scard":       firstKey,
+	"sdiff":       allKeys,
+	"sdiffstore":  allKeys,
+	"sinter":      allKeys,
+	"sintercard":  {find: findNumKeys(1)},
+	"sinterstore": allKeys,
+	"sismember":   firstKey,
+	"smembers":    firstKey,
+	"smismember":  firstKey,
+	"smove":       firstTwo,
+	"spop":        firstKey,
+	"srandmember": firstKey,
+	"srem":        firstKey,
+	"sscan":       firstKey,
+	"sunion":      allKeys,
+	"sunionstore": allKeys,
+
+	// sorted sets
+	"bzmpop":           {find: findNumKeys(2)},
+	"bzpopmax":         allButLast,
+	"bzpopmin":         allButLast,
+	"zadd":             firstKey,
+	"zcard":            firstKey,
+	"zcount":           firstKey,
+	"zdiff":            {find: findNumKeys(1)},
+	"zdiffstore":       {find: findDestNumKeys},
+	"zincrby":          firstKey,
+	"zinter":           {find: findNumKeys(1)},
+	"zintercard":       {find: findNumKeys(1)},
+	"zinterstore":      {find: findDestNumKeys},
+	"zlexcount":        firstKey,
+	"zmpop":            {find: findNumKeys(1)},
+	"zmscore":          firstKey,
+	"zpopmax":          firstKey,
+	"zpopmin":          firstKey,
+	"zrandmember":      firstKey,
+	"zrange":           firstKey,
+	"zrangebylex":      firstKey,
+	"zrangebyscore":    firstKey,
+	"zrangestore":      firstTwo,
+	"zrank":            firstKey,
+	"zrem":             firstKey,
+	"zremrangebylex":   firstKey,
+	"zremrangebyrank":  firstKey,
+	"zremrangebyscore": firstKey,
+	"zrevrange":        firstKey,
+	"zrevrangebylex":   firstKey,
+	"zrevrangebyscore": firstKey,
+	"zrevrank":         firstKey,
+	"zscan":            firstKey,
+	"zscore":           firstKey,
+	"zunion":           {find: findNumKeys(1)},
+	"zunionstore":      {find: findDestNumKeys},
+
+	// geo and hyperloglog
+	"geoadd":               firstKey,
+	"geodist":              firstKey,
+	"geohash":              firstKey,
+	"geopos":               firstKey,
+	"georadius":            {find: findGeoRadius},
+	"georadius_ro":         firstKey,
+	"georadiusbymember":    {find: findGeoRadius},
+	"georadiusbymember_ro": firstKey,
+	"geosearch":            firstKey,
+	"geosearchstore":       firstTwo,
+	"pfadd":                firstKey,
+	"pfcount":              allKeys,
+	"pfmerge":              allKeys,
+
+	// streams
+	"xack":       firstKey,
+	"xadd":       firstKey,
+	"xautoclaim": firstKey,
+	"xclaim":     firstKey,
+	"xdel":       firstKey,
+	"xgroup":     secondKey,
+	"xinfo":      secondKey,
+	"xlen":       firstKey,
+	"xpending":   firstKey,
+	"xrange":     firstKey,
+	"xread":      {find: findStreams},
+	"xreadgroup": {find: findStreams},
+	"xrevrange":  firstKey,
+	"xtrim":      firstKey,
+
+	// scripts and functions
+	"eval":       {find: findNumKeys(2)},
+	"eval_ro":    {find: findNumKeys(2)},
+	"evalsha":    {find: findNumKeys(2)},
+	"evalsha_ro": {find: findNumKeys(2)},
+	"fcall":      {find: findNumKeys(2)},
+	"fcall_ro":   {find: findNumKeys(2)},
+
+	// JSON
+	"json.arrappend": firstKey,
+	"json.arrindex":  firstKey,
+	"json.arrinsert": firstKey,
+	"json.arrlen":    firstKey,
+	"json.arrpop":    firstKey,
+	"json.arrtrim":   firstKey,
+	"json.clear":     firstKey,
+	"json.del":       firstKey,
+	"json.forget":    firstKey,
+	"json.get":       firstKey,
+	"json.merge":     firstKey,
+	"json.mget":      allButLast,
+	"json.mset":      {first: 1, last: -1, step: 3},
+	"json.numincrby": firstKey,
+	"json.nummultby": firstKey,
+	"json.objkeys":   firstKey,
+	"json.objlen":    firstKey,
+	"json.resp":      firstKey,
+	"json.set":       firstKey,
+	"json.strappend": firstKey,
+	"json.strlen":    firstKey,
+	"json.toggle":    firstKey,
+	"json.type":      firstKey,
+}
+
+// KeyIndexes returns the indexes of the keys in the command cmd, the command
+// name being at index 0 and its arguments being strings or numbers. It
+// returns false if the positions of the keys of the command are unknown. The
+// indexes are in increasing order.
+func KeyIndexes(cmd []interface{}) ([]int, bool) {
+	if len(cmd) == 0 {
+		return nil, false
+	}
+	name, ok := cmd[0].(string)
+	if !ok {
+		return nil, false
+	}
+	spec, ok := keySpecs[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	if spec.find != nil {
+		return spec.find(cmd), true
+	}
+	if spec.first == 0 {
+		return nil, true
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(cmd)
+	}
+	if last >= len(cmd) {
+		last = len(cmd) - 1
+	}
+	var ixs []int
+	for i := spec.first; i <= last; i += spec.step {
+		ixs = append(ixs, i)
+	}
+	return ixs, true
+}
+
+// findNumKeys returns a find function for the commands that have the number
+// of keys at index ix, followed by the keys.
+func findNumKeys(ix int) func([]interface{}) []int {
+	return func(cmd []interface{}) []int {
+		return numKeysAt(cmd, ix)
+	}
+}
+
+// findDestNumKeys finds the keys of the commands that have a destination key
+// followed by the number of keys and the keys, e.g. ZUNIONSTORE.
+func findDestNumKeys(cmd []interface{}) []int {
+	if len(cmd) < 2 {
+		return nil
+	}
+	return append([]int{1}, numKeysAt(cmd, 2)...)
+}
+
+func numKeysAt(cmd []interface{}, ix int) []int {
+	if ix >= len(cmd) {
+		return nil
+	}
+	n, err := strconv.Atoi(argString(cmd[ix]))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	var ixs []int
+	for i := ix + 1; i <= ix+n && i < len(cmd); i++ {
+		ixs = append(ixs, i)
+	}
+	return ixs
+}
+
+// findOption returns a find function for the commands that have a key (or
+// key pattern) as the value of an option, e.g. SCAN's MATCH.
+func findOption(opt string) func([]interface{}) []int {
+	return func(cmd []interface{}) []int {
+		return optionValues(cmd, 1, opt)
+	}
+}
+
+// optionValues returns the indexes of the values of the options named opt
+// in cmd, starting at index from.
+func optionValues(cmd []interface{}, from int, opts ...string) []int {
+	var ixs []int
+	for i := from; i < len(cmd)-1; i++ {
+		arg := argString(cmd[i])
+		for _, opt := range opts {
+			if strings.EqualFold(arg, opt) {
+				i++
+				ixs = append(ixs, i)
+				break
+			}
+		}
+	}
+	return ixs
+}
+
+// findSort finds the keys of SORT, the sorted key and the STORE destination.
+// The BY and GET patterns are not keys.
+func findSort(cmd []interface{}) []int {
+	if len(cmd) < 2 {
+		return nil
+	}
+	return append([]int{1}, optionValues(cmd, 2, "store")...)
+}
+
+// findGeoRadius finds the keys of GEORADIUS and GEORADIUSBYMEMBER, the
+// queried key and the STORE and STOREDIST destinations.
+func findGeoRadius(cmd []interface{}) []int {
+	if len(cmd) < 2 {
+		return nil
+	}
+	return append([]int{1}, optionValues(cmd, 2, "store", "storedist")...)
+}
+
+// findStreams finds the keys of XREAD and XREADGROUP, which are the first
+// half of the arguments that follow STREAMS, the other half being the IDs.
+func findStreams(cmd []interface{}) []int {
+	for i := 1; i < len(cmd); i++ {
+		if !strings.EqualFold(argString(cmd[i]), "streams") {
+			continue
+		}
+		n := (len(cmd) - i - 1) / 2
+		ixs := make([]int, n)
+		for j := range ixs {
+			ixs[j] = i + 1 + j
+		}
+		return ixs
+	}
+	return nil
+}
+
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	default:
+		return fmt.Sprint(arg)
+	}
+}
@@ -0,0 +1,103 @@
+// Package rediscmd provides information about the Redis commands that is
+// shared by the client and the server packages of this module.
+package rediscmd
+
+import "strings"
+
+// readOnlyCommands is the set of commands that do not modify the data. It
+// is based on the commands flagged as "readonly" by Redis, excluding the
+// administrative ones.
+var readOnlyCommands = map[string]bool{
+	"bitcount":             true,
+	"bitfield_ro":          true,
+	"bitpos":               true,
+	"dbsize":               true,
+	"dump":                 true,
+	"echo":                 true,
+	"eval_ro":              true,
+	"evalsha_ro":           true,
+	"exists":               true,
+	"expiretime":           true,
+	"fcall_ro":             true,
+	"geodist":              true,
+	"geohash":              true,
+	"geopos":               true,
+	"georadius_ro":         true,
+	"georadiusbymember_ro": true,
+	"geosearch":            true,
+	"get":                  true,
+	"getbit":               true,
+	"getrange":             true,
+	"hexists":              true,
+	"hget":                 true,
+	"hgetall":              true,
+	"hkeys":                true,
+	"hlen":                 true,
+	"hmget":                true,
+	"hrandfield":           true,
+	"hscan":                true,
+	"hstrlen":              true,
+	"hvals":                true,
+	"keys":                 true,
+	"lastsave":             true,
+	"lcs":                  true,
+	"lindex":               true,
+	"llen":                 true,
+	"lpos":                 true,
+	"lrange":               true,
+	"mget":                 true,
+	"pexpiretime":          true,
+	"pfcount":              true,
+	"ping":                 true,
+	"pttl":                 true,
+	"randomkey":            true,
+	"scan":                 true,
+	"scard":                true,
+	"sdiff":                true,
+	"sinter":               true,
+	"sintercard":           true,
+	"sismember":            true,
+	"smembers":             true,
+	"smismember":           true,
+	"sort_ro":              true,
+	"srandmember":          true,
+	"sscan":                true,
+	"strlen":               true,
+	"substr":               true,
+	"sunion":               true,
+	"time":                 true,
+	"touch":                true,
+	"ttl":                  true,
+	"type":                 true,
+	"xinfo":                true,
+	"xlen":                 true,
+	"xpending":             true,
+	"xrange":               true,
+	"xread":                true,
+	"xrevrange":            true,
+	"zcard":                true,
+	"zcount":               true,
+	"zdiff":                true,
+	"zinter":               true,
+	"zintercard":           true,
+	"zlexcount":            true,
+	"zmscore":              true,
+	"zrandmember":          true,
+	"zrange":               true,
+	"zrangebylex":          true,
+	"zrangebyscore":        true,
+	"zrank":                true,
+	"zrevrange":            true,
+	"zrevrangebylex":       true,
+	"zrevrangebyscore":     true,
+	"zrevrank":             true,
+	"zscan":                true,
+	"zscore":               true,
+	"zunion":               true,
+}
+
+// IsReadOnly returns true if the command (case-insensitive) does not modify
+// the data.
+func IsReadOnly(cmd string) bool {
+	return readOnlyCommands[strings.ToLower(cmd)]
+}
@@ -0,0 +1,115 @@
+package rediscmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var errUnbalancedQuotes = errors.New("invalid argument(s): unbalanced quotes")
+
+// SplitArgs splits the line into arguments following the same rules as
+// redis-cli: arguments are separated by whitespace, may be enclosed in double
+// quotes (in which case escape sequences such as \n, \t and \xHH are
+// supported) or in single quotes (in which case only \' is supported).
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+
+	rs := []rune(line)
+	for i := 0; ; {
+		// skip leading whitespace
+		for i < len(rs) && unicode.IsSpace(rs[i]) {
+			i++
+		}
+		if i >= len(rs) {
+			return args, nil
+		}
+
+		var (
+			sb              strings.Builder
+			inDouble, inSgl bool
+			done            bool
+		)
+		for !done {
+			switch {
+			case inDouble:
+				if i >= len(rs) {
+					return nil, errUnbalancedQuotes
+				}
+				switch c := rs[i]; {
+				case c == '\\' && i+3 < len(rs) && rs[i+1] == 'x' && isHex(rs[i+2]) && isHex(rs[i+3]):
+					b, _ := strconv.ParseUint(string(rs[i+2:i+4]), 16, 8)
+					sb.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(rs):
+					i++
+					switch rs[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 'r':
+						sb.WriteByte('\r')
+					case 't':
+						sb.WriteByte('\t')
+					case 'b':
+						sb.WriteByte('\b')
+					case 'a':
+						sb.WriteByte('\a')
+					default:
+						sb.WriteRune(rs[i])
+					}
+				case c == '"':
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					sb.WriteRune(c)
+				}
+
+			case inSgl:
+				if i >= len(rs) {
+					return nil, errUnbalancedQuotes
+				}
+				switch c := rs[i]; {
+				case c == '\\' && i+1 < len(rs) && rs[i+1] == '\'':
+					i++
+					sb.WriteRune('\'')
+				case c == '\'':
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					sb.WriteRune(c)
+				}
+
+			default:
+				if i >= len(rs) {
+					done = true
+					break
+				}
+				switch c := rs[i]; {
+				case unicode.IsSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSgl = true
+				default:
+					sb.WriteRune(c)
+				}
+			}
+			if i < len(rs) {
+				i++
+			}
+		}
+		args = append(args, sb.String())
+	}
+}
+
+func isHex(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
@@ -0,0 +1,20 @@
+package upstashdis
+
+import (
+	"fmt"
+
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+// prefixKeys prepends prefix to the keys of the serialized command cmd. It
+// returns an error if the positions of the keys of the command are unknown.
+func prefixKeys(cmd []interface{}, prefix string) error {
+	ixs, ok := rediscmd.KeyIndexes(cmd)
+	if !ok {
+		return fmt.Errorf("upstashdis: keys of %v are unknown and cannot be prefixed with KeyPrefix", cmd[0])
+	}
+	for _, ix := range ixs {
+		cmd[ix] = prefix + fmt.Sprint(cmd[ix])
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package upstashdis_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	ctx := context.Background()
+	cli := srv.Client()
+	cli.KeyPrefix = "app:"
+
+	t.Run("single key", func(t *testing.T) {
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "a", "1"))
+		srv.Redis.CheckGet(t, "app:a", "1")
+		require.False(t, srv.Redis.Exists("a"))
+
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "get", "a"))
+		require.Equal(t, "1", s)
+	})
+
+	t.Run("variadic", func(t *testing.T) {
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "MSET", "b", "2", "c", "3"))
+		srv.Redis.CheckGet(t, "app:b", "2")
+		srv.Redis.CheckGet(t, "app:c", "3")
+
+		var vals []string
+		require.NoError(t, cli.NewRequest().ExecOne(&vals, "MGET", "a", "b", "c"))
+		require.Equal(t, []string{"1", "2", "3"}, vals)
+
+		var n int
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "DEL", "a", "b", "x"))
+		require.Equal(t, 2, n)
+		require.False(t, srv.Redis.Exists("app:a"))
+		require.True(t, srv.Redis.Exists("app:c"))
+	})
+
+	t.Run("number of keys", func(t *testing.T) {
+		_, err := srv.Redis.ZAdd("app:z1", 1, "m1")
+		require.NoError(t, err)
+		_, err = srv.Redis.ZAdd("app:z2", 2, "m2")
+		require.NoError(t, err)
+
+		var n int
+		require.NoError(t, cli.NewRequest().ExecOne(&n, "ZUNIONSTORE", "z3", 2, "z1", "z2", "WEIGHTS", 1, 1))
+		require.Equal(t, 2, n)
+		members, err := srv.Redis.ZMembers("app:z3")
+		require.NoError(t, err)
+		require.Equal(t, []string{"m1", "m2"}, members)
+
+		var keys []string
+		require.NoError(t, cli.NewRequest().ExecOne(&keys, "EVAL", "return KEYS", 2, "k1", "k2", "arg"))
+		require.Equal(t, []string{"app:k1", "app:k2"}, keys)
+
+		script := upstashdis.NewScript(1, "return {KEYS[1], ARGV[1]}")
+		require.NoError(t, script.Exec(cli.NewRequest(), &keys, "k1", "v1"))
+		require.Equal(t, []string{"app:k1", "v1"}, keys)
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		_, err := srv.Redis.XAdd("app:s", "1-1", []string{"f", "v"})
+		require.NoError(t, err)
+
+		var res []interface{}
+		require.NoError(t, cli.NewRequest().ExecOne(&res, "XREAD", "COUNT", 1, "STREAMS", "s", "0"))
+		require.Len(t, res, 1)
+		require.Equal(t, "app:s", res[0].([]interface{})[0])
+	})
+
+	t.Run("pattern", func(t *testing.T) {
+		require.NoError(t, srv.Redis.Set("other", "x"))
+
+		var keys []string
+		require.NoError(t, cli.NewRequest().ExecOne(&keys, "KEYS", "*"))
+		require.NotContains(t, keys, "other")
+		require.Contains(t, keys, "app:c")
+
+		var scanned []string
+		it := upstashdis.Commands{Client: cli}.Scan(ctx, upstashdis.ScanOptions{Match: "c*"})
+		for it.Next() {
+			scanned = append(scanned, it.Value())
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []string{"app:c"}, scanned)
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		err := cli.NewRequest().Send("OBJECT", "ENCODING", "a")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "keys of OBJECT are unknown")
+
+		// commands without keys are sent as-is
+		var s string
+		require.NoError(t, cli.NewRequest().ExecOne(&s, "ECHO", "a"))
+		require.Equal(t, "a", s)
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		ns := cli.Namespace("test:")
+		require.Equal(t, "app:", cli.KeyPrefix)
+		require.Equal(t, "app:test:", ns.KeyPrefix)
+
+		_, err := ns.Pipelined(ctx, func(p *upstashdis.Request) error {
+			_ = p.Send("INCR", "n")
+			return p.Send("RENAME", "n", "m")
+		})
+		require.NoError(t, err)
+		srv.Redis.CheckGet(t, "app:test:m", "1")
+	})
+}
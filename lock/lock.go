@@ -0,0 +1,241 @@
+// Package lock implements a distributed lock (a mutex) on top of the
+// upstashdis client, for processes that share an Upstash Redis database,
+// e.g. serverless functions that must not execute a task concurrently.
+//
+// A lock is acquired by setting its key to a random token, only if the key
+// does not exist and with an expiration (SET NX PX), so that a lock that is
+// never released (e.g. because its owner crashed) eventually expires. It is
+// released and extended with Lua scripts that check the token, so that a
+// lock that expired and was acquired by another owner is never released or
+// extended by mistake. As with any lock based on an expiration, the owner
+// must extend the lock (see Lock.Extend and Lock.KeepAlive) if its work may
+// take longer than the TTL.
+//
+//	m := &lock.Mutex{Client: client, Key: "lock:report"}
+//	l, err := m.TryLock(ctx)
+//	if errors.Is(err, lock.ErrNotObtained) {
+//		// another process holds the lock
+//	}
+//	defer l.Unlock(ctx)
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+var (
+	// ErrNotObtained is the error returned when the lock could not be
+	// obtained because it is held by another owner.
+	ErrNotObtained = errors.New("lock: not obtained")
+
+	// ErrNotHeld is the error returned when the lock is not held anymore by
+	// its owner, e.g. because it expired.
+	ErrNotHeld = errors.New("lock: not held")
+)
+
+const (
+	defaultTTL        = 10 * time.Second
+	defaultRetryDelay = 100 * time.Millisecond
+)
+
+// releaseScript deletes KEYS[1] if its value is the token ARGV[1]. It
+// returns 1 if the key was deleted, 0 otherwise.
+var releaseScript = upstashdis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendScript sets the TTL of KEYS[1] to ARGV[2] milliseconds if its value
+// is the token ARGV[1]. It returns 1 if the TTL was set, 0 otherwise.
+var extendScript = upstashdis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// ttlScript returns the TTL of KEYS[1] in milliseconds if its value is the
+// token ARGV[1], -1 otherwise.
+var ttlScript = upstashdis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PTTL', KEYS[1])
+end
+return -1
+`)
+
+// Mutex is a distributed lock on a key. The Client and Key fields must be
+// set, the others are optional. It is safe for concurrent use, each
+// successful call to Lock or TryLock returns a distinct Lock.
+type Mutex struct {
+	// Client is the client used to execute the commands.
+	Client *upstashdis.Client
+
+	// Key is the key that holds the lock.
+	Key string
+
+	// TTL is the time after which the lock expires if it is not released or
+	// extended. It is rounded down to the millisecond. If it is <= 0, it
+	// defaults to 10s.
+	TTL time.Duration
+
+	// RetryDelay is the delay between attempts to obtain the lock in Lock.
+	// If it is <= 0, it defaults to 100ms.
+	RetryDelay time.Duration
+}
+
+// Lock is a lock obtained by Mutex.Lock or Mutex.TryLock. It is safe for
+// concurrent use.
+type Lock struct {
+	client *upstashdis.Client
+	key    string
+	token  string
+	ttl    time.Duration
+}
+
+// TryLock tries to obtain the lock once. It returns ErrNotObtained if the
+// lock is held by another owner.
+func (m *Mutex) TryLock(ctx context.Context) (*Lock, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := m.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	var ok *string
+	if err := m.Client.NewRequestContext(ctx).ExecOne(&ok, "SET", m.Key, token, "NX", "PX", ttl.Milliseconds()); err != nil {
+		return nil, err
+	}
+	if ok == nil {
+		return nil, ErrNotObtained
+	}
+	return &Lock{client: m.Client, key: m.Key, token: token, ttl: ttl}, nil
+}
+
+// Lock obtains the lock, waiting for RetryDelay between attempts while it is
+// held by another owner, until it is obtained or ctx is done, in which case
+// the context's error is returned.
+func (m *Mutex) Lock(ctx context.Context) (*Lock, error) {
+	delay := m.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for {
+		l, err := m.TryLock(ctx)
+		if !errors.Is(err, ErrNotObtained) {
+			return l, err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Key returns the key that holds the lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the random token that identifies the owner of the lock.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Unlock releases the lock. It returns ErrNotHeld if the lock is not held
+// anymore, e.g. because it expired.
+func (l *Lock) Unlock(ctx context.Context) error {
+	var n int
+	if err := releaseScript.Exec(l.client.NewRequestContext(ctx), &n, l.key, l.token); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Extend sets the TTL of the lock to ttl, or to the TTL of the Mutex that
+// obtained it if ttl <= 0. It returns ErrNotHeld if the lock is not held
+// anymore.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = l.ttl
+	}
+
+	var n int
+	if err := extendScript.Exec(l.client.NewRequestContext(ctx), &n, l.key, l.token, ttl.Milliseconds()); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// TTL returns the remaining time to live of the lock. It returns
+// ErrNotHeld if the lock is not held anymore.
+func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
+	var ms int64
+	if err := ttlScript.Exec(l.client.NewRequestContext(ctx), &ms, l.key, l.token); err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return 0, ErrNotHeld
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// KeepAlive extends the lock to the TTL of the Mutex that obtained it every
+// interval, or every third of that TTL if interval <= 0, until ctx is done
+// or the lock cannot be extended. It returns the error that stopped it: the
+// context's error, ErrNotHeld if the lock was lost, or the error of the
+// failed request. It is typically called in a goroutine while the work
+// protected by the lock is executed, with a context that is cancelled when
+// the work is done, and the work should be aborted if it returns an error
+// other than the context's error.
+func (l *Lock) KeepAlive(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = l.ttl / 3
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if err := l.Extend(ctx, 0); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+func newToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
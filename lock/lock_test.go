@@ -0,0 +1,95 @@
+package lock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/lock"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutex(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	ctx := context.Background()
+	m := &lock.Mutex{Client: srv.Client(), Key: "lock", TTL: 10 * time.Second, RetryDelay: 10 * time.Millisecond}
+
+	t.Run("try lock", func(t *testing.T) {
+		l, err := m.TryLock(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "lock", l.Key())
+		srv.Redis.CheckGet(t, "lock", l.Token())
+
+		_, err = m.TryLock(ctx)
+		require.ErrorIs(t, err, lock.ErrNotObtained)
+
+		ttl, err := l.TTL(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 10*time.Second, ttl)
+
+		require.NoError(t, l.Unlock(ctx))
+		require.False(t, srv.Redis.Exists("lock"))
+		require.ErrorIs(t, l.Unlock(ctx), lock.ErrNotHeld)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		l1, err := m.TryLock(ctx)
+		require.NoError(t, err)
+		srv.FastForward(10 * time.Second)
+
+		l2, err := m.TryLock(ctx)
+		require.NoError(t, err)
+
+		// the expired lock does not affect the new owner
+		require.ErrorIs(t, l1.Extend(ctx, 0), lock.ErrNotHeld)
+		require.ErrorIs(t, l1.Unlock(ctx), lock.ErrNotHeld)
+		_, err = l1.TTL(ctx)
+		require.ErrorIs(t, err, lock.ErrNotHeld)
+		srv.Redis.CheckGet(t, "lock", l2.Token())
+
+		require.NoError(t, l2.Extend(ctx, time.Minute))
+		require.Equal(t, time.Minute, srv.Redis.TTL("lock"))
+		require.NoError(t, l2.Unlock(ctx))
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		l1, err := m.Lock(ctx)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = l1.Unlock(ctx)
+		}()
+		l2, err := m.Lock(ctx)
+		require.NoError(t, err)
+		require.NotEqual(t, l1.Token(), l2.Token())
+
+		tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = m.Lock(tctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NoError(t, l2.Unlock(ctx))
+	})
+
+	t.Run("keep alive", func(t *testing.T) {
+		l, err := m.TryLock(ctx)
+		require.NoError(t, err)
+
+		kctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- l.KeepAlive(kctx, 10*time.Millisecond) }()
+
+		srv.FastForward(5 * time.Second)
+		require.Eventually(t, func() bool {
+			return srv.Redis.TTL("lock") == 10*time.Second
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+
+		// the lock is lost
+		go func() { done <- l.KeepAlive(ctx, 10*time.Millisecond) }()
+		srv.Redis.Del("lock")
+		require.ErrorIs(t, <-done, lock.ErrNotHeld)
+	})
+}
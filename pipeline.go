@@ -0,0 +1,155 @@
+package upstashdis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errNotExecuted = errors.New("upstashdis: pipeline not executed")
+
+// Pipeline queues commands to execute in a pipeline, like Request.Send, but
+// each queued command returns a Pending handle to access its own result
+// once the pipeline is executed, instead of matching the results by
+// position. It is created by calling Client.NewPipeline and is not safe for
+// concurrent use.
+type Pipeline struct {
+	req     *Request
+	pending []*Pending
+}
+
+// Pending is the handle of a command queued in a Pipeline. Its result is
+// available once the pipeline is executed.
+type Pending struct {
+	res *Result
+	err error
+}
+
+// NewPipeline returns a Pipeline that makes its HTTP requests with ctx.
+func (c *Client) NewPipeline(ctx context.Context) *Pipeline {
+	req := c.NewRequestContext(ctx)
+	req.pipeline = true
+	return &Pipeline{req: req}
+}
+
+// Do queues the command for execution by Exec and returns its handle. If
+// the command cannot be queued (e.g. it is empty), the error is returned by
+// Exec and by the Err method of the handle, and the command is not executed.
+func (p *Pipeline) Do(cmd string, args ...interface{}) *Pending {
+	pd := &Pending{err: errNotExecuted}
+	if err := p.req.Send(cmd, args...); err != nil {
+		pd.err = err
+	}
+	p.pending = append(p.pending, pd)
+	return pd
+}
+
+// Exec executes all queued commands in a pipeline and sets the result of
+// their handles. The pipeline is empty after the call and can be reused, the
+// handles remain valid.
+//
+// Like Client.Pipelined, it returns an error of type *Error for the first
+// command that failed, if any, but the error of each command is available
+// from its handle. If the request itself failed, e.g. due to a network
+// error, that error is returned and set on all handles.
+func (p *Pipeline) Exec() error {
+	pending := p.pending
+	p.pending = nil
+
+	var queued []*Pending
+	for _, pd := range pending {
+		if pd.err == errNotExecuted {
+			queued = append(queued, pd)
+		}
+	}
+
+	if len(queued) > 0 {
+		res, err := p.req.ExecRaw()
+		if err == nil && len(res) != len(queued) {
+			err = fmt.Errorf("upstashdis: got %d results for %d commands", len(res), len(queued))
+		}
+		for i, pd := range queued {
+			if err != nil {
+				pd.err = err
+				continue
+			}
+			pd.res, pd.err = res[i], nil
+			if res[i].Error != "" {
+				pd.err = newError(res[i].Error, i)
+			}
+		}
+	}
+
+	for _, pd := range pending {
+		if pd.err != nil {
+			return pd.err
+		}
+	}
+	return nil
+}
+
+// Err returns the error of the command, which is an *Error if it was
+// returned by Redis. It returns a non-nil error if the pipeline was not
+// executed yet.
+func (pd *Pending) Err() error {
+	return pd.err
+}
+
+// Result returns the raw result of the command, or nil if it was not
+// executed.
+func (pd *Pending) Result() *Result {
+	return pd.res
+}
+
+// Scan unmarshals the result of the command into dst, as described for
+// Request.Exec. It returns the error of the command if it failed.
+func (pd *Pending) Scan(dst interface{}) error {
+	if pd.err != nil {
+		return pd.err
+	}
+	return pd.res.unmarshal(dst)
+}
+
+// AsString returns the result of the command as a string. It returns ErrNil
+// if the result is nil.
+func (pd *Pending) AsString() (string, error) {
+	var s *string
+	if err := pd.Scan(&s); err != nil {
+		return "", err
+	}
+	if s == nil {
+		return "", ErrNil
+	}
+	return *s, nil
+}
+
+// AsInt returns the result of the command as an integer. It returns ErrNil
+// if the result is nil.
+func (pd *Pending) AsInt() (int64, error) {
+	var n *int64
+	if err := pd.Scan(&n); err != nil {
+		return 0, err
+	}
+	if n == nil {
+		return 0, ErrNil
+	}
+	return *n, nil
+}
+
+// AsBool returns true if the result of the command is 1, false if it is 0.
+// It returns ErrNil if the result is nil.
+func (pd *Pending) AsBool() (bool, error) {
+	n, err := pd.AsInt()
+	return n == 1, err
+}
+
+// AsFloat returns the result of the command as a float, which is returned
+// as a string by Redis. It returns ErrNil if the result is nil.
+func (pd *Pending) AsFloat() (float64, error) {
+	s, err := pd.AsString()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,212 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineChunks(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	hooks := &recordHooks{}
+	cli := srv.Client()
+	cli.Hooks = hooks
+
+	chunkSizes := func() []int {
+		sizes := make([]int, len(hooks.ended))
+		for i, info := range hooks.ended {
+			sizes[i] = len(info.Commands)
+		}
+		hooks.ended = nil
+		return sizes
+	}
+
+	t.Run("commands", func(t *testing.T) {
+		cli.MaxPipelineCommands = 3
+		defer func() { cli.MaxPipelineCommands = 0 }()
+
+		req := cli.NewRequest()
+		dst := make([]interface{}, 7)
+		vals := make([]int, 7)
+		for i := range dst {
+			require.NoError(t, req.Send("INCR", "n"))
+			dst[i] = &vals[i]
+		}
+		require.NoError(t, req.Exec(dst...))
+		require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, vals)
+		require.Equal(t, []int{3, 3, 1}, chunkSizes())
+
+		// the error index is relative to the whole pipeline
+		res, err := cli.Pipelined(context.Background(), func(p *upstashdis.Request) error {
+			for i := 0; i < 5; i++ {
+				if err := p.Send("INCR", "n"); err != nil {
+					return err
+				}
+			}
+			return p.Send("HGET", "n", "x")
+		})
+		require.Len(t, res, 6)
+		var rerr *upstashdis.Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, 5, rerr.PipelineIndex)
+		require.Equal(t, []int{3, 3}, chunkSizes())
+
+		// transactions are not split
+		for i := 0; i < 5; i++ {
+			require.NoError(t, req.Send("INCR", "n"))
+		}
+		require.NoError(t, req.ExecTx())
+		require.Equal(t, []int{5}, chunkSizes())
+		srv.Redis.CheckGet(t, "n", "17")
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		// each command is encoded as 25 bytes, so 2 fit in 60 bytes
+		cli.MaxPipelineBytes = 60
+		defer func() { cli.MaxPipelineBytes = 0 }()
+
+		req := cli.NewRequest()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, req.Send("SET", fmt.Sprintf("k%d", i), "0123456789"))
+		}
+		res, err := req.ExecRaw()
+		require.NoError(t, err)
+		require.Len(t, res, 5)
+		require.Equal(t, []int{2, 2, 1}, chunkSizes())
+
+		// a command larger than the limit is sent alone
+		require.NoError(t, req.Send("GET", "k0"))
+		require.NoError(t, req.Send("SET", "big", strings.Repeat("x", 100)))
+		require.NoError(t, req.Send("GET", "big"))
+		var k0, big string
+		require.NoError(t, req.Exec(&k0, nil, &big))
+		require.Equal(t, "0123456789", k0)
+		require.Equal(t, strings.Repeat("x", 100), big)
+		require.Equal(t, []int{1, 1, 1}, chunkSizes())
+	})
+
+	t.Run("failed chunk", func(t *testing.T) {
+		cli := srv.Client()
+		cli.MaxPipelineCommands = 2
+		var calls int
+		cli.HTTPClient = doerFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			if calls > 1 {
+				return nil, errors.New("network down")
+			}
+			return http.DefaultClient.Do(r)
+		})
+
+		req := cli.NewRequest()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, req.Send("INCR", "failed"))
+		}
+		err := req.Exec()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "chunk starting at command 2")
+		require.Contains(t, err.Error(), "network down")
+		srv.Redis.CheckGet(t, "failed", "2")
+	})
+}
+
+func TestPipelineHandles(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	srv.Redis.Set("str", "abc")
+	srv.Redis.Set("float", "1.5")
+
+	t.Run("results", func(t *testing.T) {
+		p := cli.NewPipeline(context.Background())
+		get := p.Do("GET", "str")
+		incr := p.Do("INCR", "counter")
+		exists := p.Do("EXISTS", "str")
+		missing := p.Do("GET", "missing")
+		float := p.Do("GET", "float")
+		wrong := p.Do("INCR", "str")
+		mget := p.Do("MGET", "str", "missing")
+
+		_, err := get.AsString()
+		require.Error(t, err)
+		require.Nil(t, get.Result())
+
+		err = p.Exec()
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr))
+		require.Equal(t, 5, rerr.PipelineIndex)
+
+		s, err := get.AsString()
+		require.NoError(t, err)
+		require.Equal(t, "abc", s)
+		n, err := incr.AsInt()
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		ok, err := exists.AsBool()
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, err = missing.AsString()
+		require.ErrorIs(t, err, upstashdis.ErrNil)
+		require.NoError(t, missing.Err())
+		f, err := float.AsFloat()
+		require.NoError(t, err)
+		require.Equal(t, 1.5, f)
+		_, err = wrong.AsInt()
+		require.Equal(t, err, wrong.Err())
+		require.True(t, errors.As(err, &rerr))
+		require.Equal(t, 5, rerr.PipelineIndex)
+		var vals []*string
+		require.NoError(t, mget.Scan(&vals))
+		require.Len(t, vals, 2)
+		require.Equal(t, "abc", *vals[0])
+		require.Nil(t, vals[1])
+	})
+
+	t.Run("not queued", func(t *testing.T) {
+		p := cli.NewPipeline(context.Background())
+		incr := p.Do("INCR", "queued")
+		empty := p.Do("")
+		incr2 := p.Do("INCR", "queued")
+
+		err := p.Exec()
+		require.Error(t, err)
+		require.Equal(t, err, empty.Err())
+		n, err := incr.AsInt()
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+		n, err = incr2.AsInt()
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		// the pipeline is empty after Exec
+		require.NoError(t, p.Exec())
+		one := p.Do("GET", "queued")
+		require.NoError(t, p.Exec())
+		s, err := one.AsString()
+		require.NoError(t, err)
+		require.Equal(t, "2", s)
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		cli := srv.Client()
+		cli.HTTPClient = doerFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("network down")
+		})
+		p := cli.NewPipeline(context.Background())
+		a, b := p.Do("GET", "str"), p.Do("GET", "str")
+		err := p.Exec()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "network down")
+		require.Equal(t, err, a.Err())
+		require.Equal(t, err, b.Err())
+	})
+}
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,281 @@
+// Package ratelimit implements rate limiting on top of the upstashdis
+// client, with the same algorithms as the @upstash/ratelimit JavaScript
+// package: fixed window, sliding window and token bucket. The state of the
+// limits is stored in Redis and updated atomically with Lua scripts, so
+// that any number of processes can enforce the same limits.
+//
+//	limiter := &ratelimit.Limiter{
+//		Client:    client,
+//		Algorithm: ratelimit.SlidingWindow(10, time.Minute),
+//	}
+//	res, err := limiter.Limit(ctx, userID)
+//	if err == nil && !res.Success {
+//		// rate limited until res.Reset
+//	}
+//
+// The windows are computed from the clock of the caller, which should be
+// synchronized across processes.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mna/upstashdis"
+)
+
+const defaultPrefix = "ratelimit"
+
+// Result is the result of a call to Limiter.Limit.
+type Result struct {
+	// Success is true if the request is allowed.
+	Success bool
+
+	// Limit is the maximum number of requests allowed in a window (or the
+	// maximum number of tokens of the bucket).
+	Limit int64
+
+	// Remaining is the number of requests still allowed in the current
+	// window (or the number of tokens left in the bucket).
+	Remaining int64
+
+	// Reset is the time at which the current window ends (or the time of the
+	// next refill of the bucket).
+	Reset time.Time
+}
+
+// Algorithm is a rate limiting algorithm, see FixedWindow, SlidingWindow
+// and TokenBucket.
+type Algorithm interface {
+	limit(ctx context.Context, c *upstashdis.Client, key string, now time.Time) (Result, error)
+}
+
+// Limiter limits the rate of requests made by identifiers (e.g. user IDs or
+// IP addresses) according to its Algorithm. The Client and Algorithm fields
+// must be set. It is safe for concurrent use.
+type Limiter struct {
+	// Client is the client used to execute the commands.
+	Client *upstashdis.Client
+
+	// Algorithm is the rate limiting algorithm.
+	Algorithm Algorithm
+
+	// Prefix is the prefix of the keys that hold the state of the limits,
+	// followed by a colon and the identifier. If it is empty, "ratelimit" is
+	// used.
+	Prefix string
+
+	now func() time.Time // for tests, time.Now if nil
+}
+
+// Limit records a request for the identifier and returns whether it is
+// allowed, along with the state of the limit.
+func (l *Limiter) Limit(ctx context.Context, identifier string) (Result, error) {
+	if l.Algorithm == nil {
+		return Result{}, errors.New("ratelimit: no algorithm")
+	}
+
+	prefix := l.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	now := time.Now
+	if l.now != nil {
+		now = l.now
+	}
+	return l.Algorithm.limit(ctx, l.Client, prefix+":"+identifier, now())
+}
+
+// fixedWindowScript increments the counter KEYS[1] of the window, setting
+// its expiration to ARGV[1] milliseconds when it is created, and returns
+// the new count.
+var fixedWindowScript = upstashdis.NewScript(1, `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+type fixedWindow struct {
+	tokens int64
+	window time.Duration
+}
+
+// FixedWindow returns an algorithm that allows tokens requests per window,
+// the windows being consecutive periods of time of the same duration. It
+// is the cheapest algorithm, but it allows bursts of up to twice the
+// limit at the boundary of two windows. The window is rounded down to the
+// millisecond and must be at least 1ms.
+func FixedWindow(tokens int64, window time.Duration) Algorithm {
+	return fixedWindow{tokens: tokens, window: window}
+}
+
+func (a fixedWindow) limit(ctx context.Context, c *upstashdis.Client, key string, now time.Time) (Result, error) {
+	window := a.window.Milliseconds()
+	if window <= 0 {
+		return Result{}, errors.New("ratelimit: window must be at least 1ms")
+	}
+	ix := now.UnixMilli() / window
+
+	var n int64
+	if err := fixedWindowScript.Exec(c.NewRequestContext(ctx), &n, fmt.Sprintf("%s:%d", key, ix), window); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Success: n <= a.tokens,
+		Limit:   a.tokens,
+		Reset:   time.UnixMilli((ix + 1) * window),
+	}
+	if res.Success {
+		res.Remaining = a.tokens - n
+	}
+	return res, nil
+}
+
+// slidingWindowScript counts the request in the window KEYS[1] if the sum of
+// its count and of the count of the previous window KEYS[2], weighted by
+// the portion of the previous window that overlaps the sliding window, is
+// below the limit ARGV[1]. ARGV[2] is the current time and ARGV[3] the
+// window, in milliseconds. It returns the number of remaining requests, or
+// -1 if the request is not allowed.
+var slidingWindowScript = upstashdis.NewScript(2, `
+local tokens = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local elapsed = (now % window) / window
+previous = math.floor((1 - elapsed) * previous)
+if previous + current >= tokens then
+  return -1
+end
+
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+  redis.call('PEXPIRE', KEYS[1], window * 2 + 1000)
+end
+return tokens - (n + previous)
+`)
+
+type slidingWindow struct {
+	tokens int64
+	window time.Duration
+}
+
+// SlidingWindow returns an algorithm that allows tokens requests per
+// sliding window, which is approximated by weighting the count of the
+// previous fixed window by the portion of it that overlaps the sliding
+// window. It smooths the bursts allowed by FixedWindow at the boundary of
+// two windows. The window is rounded down to the millisecond and must be
+// at least 1ms.
+func SlidingWindow(tokens int64, window time.Duration) Algorithm {
+	return slidingWindow{tokens: tokens, window: window}
+}
+
+func (a slidingWindow) limit(ctx context.Context, c *upstashdis.Client, key string, now time.Time) (Result, error) {
+	window := a.window.Milliseconds()
+	if window <= 0 {
+		return Result{}, errors.New("ratelimit: window must be at least 1ms")
+	}
+	ms := now.UnixMilli()
+	ix := ms / window
+
+	var remaining int64
+	cur, prev := fmt.Sprintf("%s:%d", key, ix), fmt.Sprintf("%s:%d", key, ix-1)
+	if err := slidingWindowScript.Exec(c.NewRequestContext(ctx), &remaining, cur, prev, a.tokens, ms, window); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Success: remaining >= 0,
+		Limit:   a.tokens,
+		Reset:   time.UnixMilli((ix + 1) * window),
+	}
+	if res.Success {
+		res.Remaining = remaining
+	}
+	return res, nil
+}
+
+// tokenBucketScript takes a token from the bucket KEYS[1], a hash with the
+// number of tokens and the time of the last refill. ARGV[1] is the maximum
+// number of tokens, ARGV[2] the refill interval in milliseconds, ARGV[3]
+// the number of tokens added per interval and ARGV[4] the current time in
+// milliseconds. It returns the number of remaining tokens, or -1 if the
+// bucket is empty, and the time of the next refill.
+var tokenBucketScript = upstashdis.NewScript(1, `
+local max = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', KEYS[1], 'refilledAt', 'tokens')
+local refilledAt = now
+local tokens = max
+if bucket[1] then
+  refilledAt = tonumber(bucket[1])
+  tokens = tonumber(bucket[2])
+end
+
+if now >= refilledAt + interval then
+  local refills = math.floor((now - refilledAt) / interval)
+  tokens = math.min(max, tokens + refills * rate)
+  refilledAt = refilledAt + refills * interval
+end
+if tokens <= 0 then
+  return {-1, refilledAt + interval}
+end
+
+local remaining = tokens - 1
+redis.call('HSET', KEYS[1], 'refilledAt', refilledAt, 'tokens', remaining)
+redis.call('PEXPIRE', KEYS[1], math.ceil((max - remaining) / rate) * interval)
+return {remaining, refilledAt + interval}
+`)
+
+type tokenBucket struct {
+	rate     int64
+	interval time.Duration
+	max      int64
+}
+
+// TokenBucket returns an algorithm that allows a request for each token
+// taken from a bucket that holds at most maxTokens, which is refilled with
+// refillRate tokens every interval. A bucket starts full, so that it allows
+// bursts of up to maxTokens requests. The interval is rounded down to the
+// millisecond and must be at least 1ms, and refillRate must be at least 1.
+func TokenBucket(refillRate int64, interval time.Duration, maxTokens int64) Algorithm {
+	return tokenBucket{rate: refillRate, interval: interval, max: maxTokens}
+}
+
+func (a tokenBucket) limit(ctx context.Context, c *upstashdis.Client, key string, now time.Time) (Result, error) {
+	interval := a.interval.Milliseconds()
+	if interval <= 0 {
+		return Result{}, errors.New("ratelimit: interval must be at least 1ms")
+	}
+	if a.rate <= 0 {
+		return Result{}, errors.New("ratelimit: refill rate must be at least 1")
+	}
+
+	var vals []int64
+	if err := tokenBucketScript.Exec(c.NewRequestContext(ctx), &vals, key, a.max, interval, a.rate, now.UnixMilli()); err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected result %v", vals)
+	}
+
+	res := Result{
+		Success: vals[0] >= 0,
+		Limit:   a.max,
+		Reset:   time.UnixMilli(vals[1]),
+	}
+	if res.Success {
+		res.Remaining = vals[0]
+	}
+	return res, nil
+}
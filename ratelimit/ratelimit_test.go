@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time          { return c.now }
+func (c *testClock) Add(d time.Duration)     { c.now = c.now.Add(d) }
+func (c *testClock) Set(ms int64) *testClock { c.now = time.UnixMilli(ms); return c }
+
+func limitN(t *testing.T, l *Limiter, id string, n int) []Result {
+	t.Helper()
+	res := make([]Result, n)
+	for i := range res {
+		r, err := l.Limit(context.Background(), id)
+		require.NoError(t, err)
+		res[i] = r
+	}
+	return res
+}
+
+func remaining(res []Result) []int64 {
+	rems := make([]int64, len(res))
+	for i, r := range res {
+		rems[i] = r.Remaining
+		if !r.Success {
+			rems[i] = -1
+		}
+	}
+	return rems
+}
+
+func TestFixedWindow(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	clock := (&testClock{}).Set(1_000_000_000_250)
+	l := &Limiter{Client: srv.Client(), Algorithm: FixedWindow(3, time.Second), now: clock.Now}
+
+	res := limitN(t, l, "a", 4)
+	require.Equal(t, []int64{2, 1, 0, -1}, remaining(res))
+	require.Equal(t, int64(3), res[0].Limit)
+	require.Equal(t, time.UnixMilli(1_000_000_001_000), res[3].Reset)
+	require.True(t, srv.Redis.Exists("ratelimit:a:1000000000"))
+
+	// other identifiers are not affected
+	require.Equal(t, []int64{2}, remaining(limitN(t, l, "b", 1)))
+
+	// the next window starts with a new count
+	clock.Add(750 * time.Millisecond)
+	require.Equal(t, []int64{2, 1, 0, -1}, remaining(limitN(t, l, "a", 4)))
+
+	l.Prefix = "rl"
+	require.Equal(t, []int64{2}, remaining(limitN(t, l, "a", 1)))
+	require.True(t, srv.Redis.Exists("rl:a:1000000001"))
+}
+
+func TestSlidingWindow(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	clock := (&testClock{}).Set(1_000_000_000_000)
+	l := &Limiter{Client: srv.Client(), Algorithm: SlidingWindow(4, time.Second), now: clock.Now}
+
+	res := limitN(t, l, "a", 5)
+	require.Equal(t, []int64{3, 2, 1, 0, -1}, remaining(res))
+	require.Equal(t, time.UnixMilli(1_000_000_001_000), res[4].Reset)
+
+	// halfway through the next window, half of the previous count remains
+	clock.Add(1500 * time.Millisecond)
+	require.Equal(t, []int64{1, 0, -1}, remaining(limitN(t, l, "a", 3)))
+
+	// two windows later, the count is reset
+	clock.Add(2 * time.Second)
+	require.Equal(t, []int64{3}, remaining(limitN(t, l, "a", 1)))
+}
+
+func TestTokenBucket(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	clock := (&testClock{}).Set(1_000_000_000_000)
+	l := &Limiter{Client: srv.Client(), Algorithm: TokenBucket(1, time.Second, 2), now: clock.Now}
+
+	res := limitN(t, l, "a", 3)
+	require.Equal(t, []int64{1, 0, -1}, remaining(res))
+	require.Equal(t, int64(2), res[0].Limit)
+	require.Equal(t, time.UnixMilli(1_000_000_001_000), res[2].Reset)
+
+	// one token is refilled per second
+	clock.Add(1200 * time.Millisecond)
+	res = limitN(t, l, "a", 2)
+	require.Equal(t, []int64{0, -1}, remaining(res))
+	require.Equal(t, time.UnixMilli(1_000_000_002_000), res[1].Reset)
+
+	// the bucket holds at most 2 tokens
+	clock.Add(10 * time.Second)
+	require.Equal(t, []int64{1, 0, -1}, remaining(limitN(t, l, "a", 3)))
+}
+
+func TestLimiterErrors(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	ctx := context.Background()
+
+	_, err := (&Limiter{Client: srv.Client()}).Limit(ctx, "a")
+	require.Error(t, err)
+	_, err = (&Limiter{Client: srv.Client(), Algorithm: FixedWindow(1, time.Microsecond)}).Limit(ctx, "a")
+	require.Error(t, err)
+	_, err = (&Limiter{Client: srv.Client(), Algorithm: TokenBucket(0, time.Second, 1)}).Limit(ctx, "a")
+	require.Error(t, err)
+}
@@ -0,0 +1,692 @@
+package redisconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+const (
+	// numSlots is the number of hash slots of a Redis Cluster.
+	numSlots = 16384
+
+	// maxRedirects is the maximum number of MOVED and ASK redirections
+	// followed for a command.
+	maxRedirects = 5
+)
+
+var errClosed = errors.New("redisconn: connection closed")
+
+// Cluster manages the connections to the nodes of a Redis Cluster. It keeps
+// the mapping of the hash slots to the master nodes that serve them, loaded
+// with CLUSTER SLOTS on first use and refreshed when a MOVED redirection is
+// received, and a pool of connections for each node. It is safe for
+// concurrent use.
+type Cluster struct {
+	// Addrs is the list of addresses (host:port) of the nodes used to load the
+	// mapping of the slots. They do not need to be all the nodes of the
+	// cluster, the others are discovered via CLUSTER SLOTS.
+	Addrs []string
+
+	// DialOptions are the options used to connect to the nodes.
+	DialOptions []redis.DialOption
+
+	// MaxIdle is the maximum number of idle connections kept in the pool of
+	// each node.
+	MaxIdle int
+
+	// IdleTimeout is the duration after which the idle connections of the
+	// pools are closed. If zero, they are not closed based on their idle
+	// time.
+	IdleTimeout time.Duration
+
+	mu         sync.Mutex
+	slots      []string // the address of the node of each slot, nil until loaded
+	pools      map[string]*redis.Pool
+	closed     bool
+	refreshing int32
+}
+
+// Get returns a connection to the cluster. The connection to a node is
+// obtained the first time a command is sent to that node, and it is kept
+// until the returned connection is closed. The context is used to get the
+// connections and to execute the commands, unless the command is executed
+// with DoContext.
+func (c *Cluster) Get(ctx context.Context) *ClusterConn {
+	return &ClusterConn{c: c, ctx: ctx, conns: make(map[string]redis.Conn)}
+}
+
+// Refresh loads the mapping of the slots to the nodes of the cluster from
+// the first node that replies to CLUSTER SLOTS, trying the known nodes
+// first, then the Addrs. It is called automatically when the mapping is
+// needed for the first time and, in a separate goroutine, when a MOVED
+// redirection is received.
+func (c *Cluster) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	addrs := c.knownAddrs()
+	c.mu.Unlock()
+
+	var lastErr error
+	for _, addr := range addrs {
+		slots, err := c.loadSlots(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.slots = slots
+
+		// close the pools of the nodes that are not masters anymore
+		masters := make(map[string]bool)
+		for _, addr := range slots {
+			masters[addr] = true
+		}
+		for addr, p := range c.pools {
+			if !masters[addr] {
+				p.Close()
+				delete(c.pools, addr)
+			}
+		}
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no address")
+	}
+	return fmt.Errorf("redisconn: failed to load the cluster slots: %w", lastErr)
+}
+
+// Close closes the pools of connections of the nodes. The connections in use
+// are closed when they are released.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	var err error
+	for addr, p := range c.pools {
+		if e := p.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(c.pools, addr)
+	}
+	return err
+}
+
+// knownAddrs returns the addresses of the nodes of the mapping of the slots,
+// followed by the Addrs, without duplicates. The lock must be held by the
+// caller.
+func (c *Cluster) knownAddrs() []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{c.slots, c.Addrs} {
+		for _, addr := range list {
+			if addr != "" && !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// loadSlots returns the mapping of the slots as reported by the node at
+// addr.
+func (c *Cluster) loadSlots(ctx context.Context, addr string) ([]string, error) {
+	conn, err := c.getNodeConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	vals, err := redis.Values(redis.DoContext(conn, ctx, "CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := fmt.Errorf("invalid CLUSTER SLOTS reply from %s", addr)
+	slots := make([]string, numSlots)
+	for _, v := range vals {
+		// start, end, master node, replica nodes...
+		vv, err := redis.Values(v, nil)
+		if err != nil || len(vv) < 3 {
+			return nil, invalid
+		}
+		start, err1 := redis.Int(vv[0], nil)
+		end, err2 := redis.Int(vv[1], nil)
+		node, err3 := redis.Values(vv[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 {
+			return nil, invalid
+		}
+		host, err1 := redis.String(node[0], nil)
+		port, err2 := redis.Int(node[1], nil)
+		if err1 != nil || err2 != nil {
+			return nil, invalid
+		}
+		if host == "" || host == "?" {
+			// the node does not know its own address, use the one it was reached
+			// at
+			host, _, _ = net.SplitHostPort(addr)
+		}
+
+		nodeAddr := net.JoinHostPort(host, strconv.Itoa(port))
+		for i := start; i <= end && i < numSlots; i++ {
+			slots[i] = nodeAddr
+		}
+	}
+	return slots, nil
+}
+
+// refreshAsync refreshes the mapping of the slots in a separate goroutine,
+// unless a refresh is already in progress.
+func (c *Cluster) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		_ = c.Refresh(context.Background())
+	}()
+}
+
+// setSlot sets the address of the node of the slot, as reported by a MOVED
+// redirection.
+func (c *Cluster) setSlot(slot int, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots != nil && slot >= 0 && slot < numSlots {
+		c.slots[slot] = addr
+	}
+}
+
+// slotAddr returns the address of the node of the slot, or of any node if
+// slot is negative. It loads the mapping of the slots if needed.
+func (c *Cluster) slotAddr(ctx context.Context, slot int) (string, error) {
+	c.mu.Lock()
+	loaded := c.slots != nil
+	c.mu.Unlock()
+	if !loaded {
+		if err := c.Refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slot < 0 {
+		slot = rand.Intn(numSlots)
+		for i := 0; i < numSlots && c.slots[slot] == ""; i++ {
+			slot = (slot + 1) % numSlots
+		}
+	}
+	if addr := c.slots[slot]; addr != "" {
+		return addr, nil
+	}
+	return "", fmt.Errorf("redisconn: slot %d is not served by any node", slot)
+}
+
+// getNodeConn returns a connection from the pool of the node at addr.
+func (c *Cluster) getNodeConn(ctx context.Context, addr string) (redis.Conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errors.New("redisconn: cluster closed")
+	}
+	p := c.pools[addr]
+	if p == nil {
+		p = &redis.Pool{
+			MaxIdle:     c.MaxIdle,
+			IdleTimeout: c.IdleTimeout,
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", addr, c.DialOptions...)
+			},
+		}
+		if c.pools == nil {
+			c.pools = make(map[string]*redis.Pool)
+		}
+		c.pools[addr] = p
+	}
+	c.mu.Unlock()
+
+	return p.GetContext(ctx)
+}
+
+// ClusterConn is a connection to a Redis Cluster, returned by Cluster.Get.
+// It implements the redigo Conn interface. It is not safe for concurrent
+// use.
+//
+// The commands are executed on the node that serves the slot of their first
+// key, as reported by the rediscmd package, or on any node if the command
+// has no key or its keys are unknown. The AUTH and SELECT commands are
+// executed on all the connections to the nodes, including the ones obtained
+// later. The MULTI, EXEC and DISCARD commands are handled by the connection:
+// the commands of the transaction are queued and sent to the node of their
+// slot when EXEC is executed, failing with a CROSSSLOT error if their keys
+// are in different slots.
+//
+// The Send, Flush and Receive methods use a single connection to any node,
+// for the commands that require a dedicated connection, e.g. SUBSCRIBE.
+// Note that a MONITOR command reports only the commands of that node, and
+// that the keyspace notifications are only sent by the node of the key.
+type ClusterConn struct {
+	c     *Cluster
+	ctx   context.Context
+	conns map[string]redis.Conn
+	sub   redis.Conn
+	state [][]interface{} // the commands executed on each new connection
+	tx    [][]interface{}
+	inTx  bool
+	err   error
+}
+
+// Do sends a command to the node of its slot and returns the received
+// reply, using the context of the connection.
+func (cc *ClusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return cc.DoContext(cc.ctx, cmd, args...)
+}
+
+// DoContext sends a command to the node of its slot and returns the received
+// reply, using the provided context.
+func (cc *ClusterConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if cc.err != nil {
+		return nil, cc.err
+	}
+
+	switch name := strings.ToUpper(cmd); {
+	case name == "MULTI":
+		if cc.inTx {
+			return nil, redis.Error("ERR MULTI calls can not be nested")
+		}
+		cc.inTx = true
+		return "OK", nil
+
+	case name == "DISCARD":
+		if !cc.inTx {
+			return nil, redis.Error("ERR DISCARD without MULTI")
+		}
+		cc.inTx, cc.tx = false, nil
+		return "OK", nil
+
+	case name == "EXEC":
+		if !cc.inTx {
+			return nil, redis.Error("ERR EXEC without MULTI")
+		}
+		cmds := cc.tx
+		cc.inTx, cc.tx = false, nil
+		return cc.exec(ctx, cmds)
+
+	case cc.inTx:
+		cc.tx = append(cc.tx, append([]interface{}{cmd}, args...))
+		return "QUEUED", nil
+
+	case name == "AUTH" || name == "SELECT":
+		return cc.setState(ctx, cmd, args)
+
+	default:
+		return cc.do(ctx, cmdSlot(cmd, args), cmd, args)
+	}
+}
+
+// Send writes the command to the dedicated connection.
+func (cc *ClusterConn) Send(cmd string, args ...interface{}) error {
+	if cc.err != nil {
+		return cc.err
+	}
+	if cc.sub == nil {
+		addr, err := cc.c.slotAddr(cc.ctx, -1)
+		if err != nil {
+			return err
+		}
+		conn, err := cc.dial(cc.ctx, addr)
+		if err != nil {
+			return err
+		}
+		cc.sub = conn
+	}
+	return cc.sub.Send(cmd, args...)
+}
+
+// Flush flushes the output buffer of the dedicated connection.
+func (cc *ClusterConn) Flush() error {
+	if cc.err != nil {
+		return cc.err
+	}
+	if cc.sub == nil {
+		return nil
+	}
+	return cc.sub.Flush()
+}
+
+// Receive receives a reply from the dedicated connection.
+func (cc *ClusterConn) Receive() (interface{}, error) {
+	if cc.err != nil {
+		return nil, cc.err
+	}
+	if cc.sub == nil {
+		return nil, errors.New("redisconn: no command sent")
+	}
+	return cc.sub.Receive()
+}
+
+// Err returns a non-nil value when the connection is not usable.
+func (cc *ClusterConn) Err() error {
+	if cc.err != nil {
+		return cc.err
+	}
+	if cc.sub != nil {
+		if err := cc.sub.Err(); err != nil {
+			return err
+		}
+	}
+	for _, conn := range cc.conns {
+		if err := conn.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the connections to the nodes.
+func (cc *ClusterConn) Close() error {
+	if cc.err == errClosed {
+		return nil
+	}
+	cc.err = errClosed
+
+	var err error
+	if cc.sub != nil {
+		err = cc.sub.Close()
+		cc.sub = nil
+	}
+	for addr, conn := range cc.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(cc.conns, addr)
+	}
+	return err
+}
+
+// do executes the command on the node of the slot, following the
+// redirections.
+func (cc *ClusterConn) do(ctx context.Context, slot int, cmd string, args []interface{}) (interface{}, error) {
+	addr, err := cc.addr(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	var asking bool
+	for i := 0; ; i++ {
+		conn, err := cc.conn(ctx, addr)
+		if err != nil {
+			cc.c.refreshAsync()
+			return nil, err
+		}
+		if asking {
+			if _, err := redis.DoContext(conn, ctx, "ASKING"); err != nil {
+				cc.dropBroken(addr, conn)
+				return nil, err
+			}
+		}
+		res, err := redis.DoContext(conn, ctx, cmd, args...)
+		cc.dropBroken(addr, conn)
+
+		r, ok := parseRedirect(err, addr)
+		if !ok || i >= maxRedirects {
+			return res, err
+		}
+		if !r.ask {
+			cc.c.setSlot(r.slot, r.addr)
+			cc.c.refreshAsync()
+		}
+		addr, asking = r.addr, r.ask
+	}
+}
+
+// exec executes the commands of a transaction on the node of their slot,
+// following the redirections.
+func (cc *ClusterConn) exec(ctx context.Context, cmds [][]interface{}) (interface{}, error) {
+	slot := -1
+	for _, cmd := range cmds {
+		s := cmdSlot(fmt.Sprint(cmd[0]), cmd[1:])
+		if s < 0 {
+			continue
+		}
+		if slot >= 0 && s != slot {
+			return nil, redis.Error("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+		slot = s
+	}
+
+	addr, err := cc.addr(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	var asking bool
+	for i := 0; ; i++ {
+		conn, err := cc.conn(ctx, addr)
+		if err != nil {
+			cc.c.refreshAsync()
+			return nil, err
+		}
+
+		// the ASKING flag applies to the next command only, so it must be sent
+		// before each command of the transaction.
+		send := func(cmd string, args ...interface{}) error {
+			if asking {
+				if err := conn.Send("ASKING"); err != nil {
+					return err
+				}
+			}
+			return conn.Send(cmd, args...)
+		}
+		err = send("MULTI")
+		for _, cmd := range cmds {
+			if err != nil {
+				break
+			}
+			err = send(fmt.Sprint(cmd[0]), cmd[1:]...)
+		}
+		if err == nil && asking {
+			err = conn.Send("ASKING")
+		}
+		if err != nil {
+			cc.dropBroken(addr, conn)
+			return nil, err
+		}
+
+		// Do returns the reply of EXEC and the first error, which is the one of
+		// the command that failed to be queued, if any, in which case the
+		// transaction is aborted.
+		res, err := redis.DoContext(conn, ctx, "EXEC")
+		cc.dropBroken(addr, conn)
+
+		r, ok := parseRedirect(err, addr)
+		if !ok || i >= maxRedirects {
+			return res, err
+		}
+		if !r.ask {
+			cc.c.setSlot(r.slot, r.addr)
+			cc.c.refreshAsync()
+		}
+		addr, asking = r.addr, r.ask
+	}
+}
+
+// setState executes the command that changes the state of the connection
+// (e.g. AUTH) on all the connections to the nodes, and records it so that it
+// is executed on the connections obtained later.
+func (cc *ClusterConn) setState(ctx context.Context, cmd string, args []interface{}) (interface{}, error) {
+	if len(cc.conns) == 0 {
+		// execute it at least once, to return its reply
+		addr, err := cc.c.slotAddr(ctx, -1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := cc.conn(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	var res interface{}
+	for addr, conn := range cc.conns {
+		var err error
+		res, err = redis.DoContext(conn, ctx, cmd, args...)
+		cc.dropBroken(addr, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cc.state = append(cc.state, append([]interface{}{cmd}, args...))
+	return res, nil
+}
+
+// addr returns the address of the node of the slot, or if slot is negative,
+// the address of a node to which the connection is already connected, if
+// any.
+func (cc *ClusterConn) addr(ctx context.Context, slot int) (string, error) {
+	if slot < 0 {
+		for addr := range cc.conns {
+			return addr, nil
+		}
+	}
+	return cc.c.slotAddr(ctx, slot)
+}
+
+// conn returns the connection to the node at addr, getting it from the pool
+// of the node if the connection is not already connected to it.
+func (cc *ClusterConn) conn(ctx context.Context, addr string) (redis.Conn, error) {
+	if conn := cc.conns[addr]; conn != nil {
+		return conn, nil
+	}
+	conn, err := cc.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	cc.conns[addr] = conn
+	return conn, nil
+}
+
+// dial gets a connection from the pool of the node at addr and executes the
+// commands that changed the state of the connection.
+func (cc *ClusterConn) dial(ctx context.Context, addr string) (redis.Conn, error) {
+	conn, err := cc.c.getNodeConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range cc.state {
+		if _, err := redis.DoContext(conn, ctx, cmd[0].(string), cmd[1:]...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// dropBroken releases the connection to the node at addr if it is broken,
+// e.g. after a network error, so that a new one is obtained for the next
+// command.
+func (cc *ClusterConn) dropBroken(addr string, conn redis.Conn) {
+	if conn.Err() == nil {
+		return
+	}
+	conn.Close()
+	if cc.conns[addr] == conn {
+		delete(cc.conns, addr)
+	}
+	cc.c.refreshAsync()
+}
+
+// redirect is a MOVED or ASK redirection returned by a node.
+type redirect struct {
+	ask  bool
+	slot int
+	addr string
+}
+
+// parseRedirect returns the redirection of err, if it is a MOVED or ASK
+// error, e.g. "MOVED 3999 127.0.0.1:6381". The node that returned it is at
+// from, which is used if the address of the redirection has no host.
+func parseRedirect(err error, from string) (redirect, bool) {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return redirect{}, false
+	}
+
+	fields := strings.Fields(string(rerr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return redirect{}, false
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return redirect{}, false
+	}
+	addr := fields[2]
+	if strings.HasPrefix(addr, ":") {
+		host, _, _ := net.SplitHostPort(from)
+		addr = net.JoinHostPort(host, addr[1:])
+	}
+	return redirect{ask: fields[0] == "ASK", slot: slot, addr: addr}, true
+}
+
+// cmdSlot returns the slot of the first key of the command, or -1 if it has
+// no key or its keys are unknown.
+func cmdSlot(cmd string, args []interface{}) int {
+	full := append([]interface{}{cmd}, args...)
+	ixs, ok := rediscmd.KeyIndexes(full)
+	if !ok || len(ixs) == 0 {
+		return -1
+	}
+
+	var key string
+	switch v := full[ixs[0]].(type) {
+	case string:
+		key = v
+	case []byte:
+		key = string(v)
+	default:
+		key = fmt.Sprint(v)
+	}
+	return Slot(key)
+}
+
+// Slot returns the hash slot of the key in a Redis Cluster. If the key
+// contains a hash tag, i.e. a non-empty substring between the first '{' and
+// the next '}', only the hash tag is hashed, so that the keys with the same
+// hash tag are in the same slot.
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % numSlots
+}
+
+// crc16 returns the CRC16 (XMODEM) of s, as used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,363 @@
+package redisconn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCluster is a Redis Cluster of miniredis nodes. Each node replies to
+// CLUSTER SLOTS and returns a MOVED redirection for the keys of the slots
+// it does not serve, or an ASK redirection for the missing keys of a slot
+// being migrated.
+type fakeCluster struct {
+	mu        sync.Mutex
+	nodes     []*miniredis.Miniredis
+	slots     []int       // the index of the node of each slot
+	migrating map[int]int // the index of the target node of the slots being migrated
+	asking    map[*server.Peer]bool
+}
+
+func newFakeCluster(t *testing.T, n int) *fakeCluster {
+	fc := &fakeCluster{
+		slots:     make([]int, numSlots),
+		migrating: make(map[int]int),
+		asking:    make(map[*server.Peer]bool),
+	}
+	for i := 0; i < n; i++ {
+		m := miniredis.RunT(t)
+		m.Server().SetPreHook(fc.hook(i))
+		fc.nodes = append(fc.nodes, m)
+	}
+	for i := range fc.slots {
+		fc.slots[i] = i * n / numSlots
+	}
+	return fc
+}
+
+func (fc *fakeCluster) addr(i int) string {
+	return fc.nodes[i].Addr()
+}
+
+func (fc *fakeCluster) setSlot(slot, node int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.slots[slot] = node
+}
+
+func (fc *fakeCluster) migrate(slot, node int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.migrating[slot] = node
+}
+
+func (fc *fakeCluster) hook(node int) server.Hook {
+	return func(c *server.Peer, cmd string, args ...string) bool {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+
+		switch cmd {
+		case "CLUSTER":
+			fc.writeSlots(c)
+			return true
+		case "ASKING":
+			fc.asking[c] = true
+			c.WriteOK()
+			return true
+		}
+
+		asking := fc.asking[c]
+		delete(fc.asking, c)
+
+		iargs := make([]interface{}, len(args))
+		for i, arg := range args {
+			iargs[i] = arg
+		}
+		slot := cmdSlot(cmd, iargs)
+		if slot < 0 || cmd == "MULTI" || cmd == "EXEC" {
+			return false
+		}
+
+		if owner := fc.slots[slot]; owner == node {
+			if target, ok := fc.migrating[slot]; ok && !fc.nodes[node].Exists(args[0]) {
+				c.WriteError(fmt.Sprintf("ASK %d %s", slot, fc.addr(target)))
+				return true
+			}
+			return false
+		}
+		if target, ok := fc.migrating[slot]; ok && target == node && asking {
+			return false
+		}
+		c.WriteError(fmt.Sprintf("MOVED %d %s", slot, fc.addr(fc.slots[slot])))
+		return true
+	}
+}
+
+func (fc *fakeCluster) writeSlots(c *server.Peer) {
+	type slotRange struct{ start, end, node int }
+	var ranges []slotRange
+	for slot, node := range fc.slots {
+		if len(ranges) > 0 && ranges[len(ranges)-1].node == node {
+			ranges[len(ranges)-1].end = slot
+			continue
+		}
+		ranges = append(ranges, slotRange{start: slot, end: slot, node: node})
+	}
+
+	c.WriteLen(len(ranges))
+	for _, r := range ranges {
+		addr := fc.nodes[r.node].Server().Addr()
+		c.WriteLen(3)
+		c.WriteInt(r.start)
+		c.WriteInt(r.end)
+		c.WriteLen(3)
+		c.WriteBulk(addr.IP.String())
+		c.WriteInt(addr.Port)
+		c.WriteBulk(fmt.Sprintf("node%d", r.node))
+	}
+}
+
+func TestSlot(t *testing.T) {
+	cases := []struct {
+		key  string
+		want int
+	}{
+		{"123456789", 0x31C3},
+		{"foo", 12182},
+		{"bar", 5061},
+		{"{bar}", 5061},
+		{"foo{bar}{zap}", 5061},
+		{"foo{}{bar}", int(crc16("foo{}{bar}")) % numSlots},
+		{"foo{{bar}}zap", int(crc16("{bar")) % numSlots},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			require.Equal(t, c.want, Slot(c.key))
+		})
+	}
+	require.Equal(t, Slot("{user1000}.following"), Slot("{user1000}.followers"))
+}
+
+func TestParseRedirect(t *testing.T) {
+	r, ok := parseRedirect(redis.Error("MOVED 3999 127.0.0.1:6381"), "127.0.0.1:6380")
+	require.True(t, ok)
+	require.Equal(t, redirect{slot: 3999, addr: "127.0.0.1:6381"}, r)
+
+	r, ok = parseRedirect(redis.Error("ASK 3999 :6381"), "10.0.0.1:6380")
+	require.True(t, ok)
+	require.Equal(t, redirect{ask: true, slot: 3999, addr: "10.0.0.1:6381"}, r)
+
+	_, ok = parseRedirect(redis.Error("ERR MOVED"), "127.0.0.1:6380")
+	require.False(t, ok)
+	_, ok = parseRedirect(redis.Error("MOVED x 127.0.0.1:6381"), "127.0.0.1:6380")
+	require.False(t, ok)
+	_, ok = parseRedirect(nil, "127.0.0.1:6380")
+	require.False(t, ok)
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("routing", func(t *testing.T) {
+		fc := newFakeCluster(t, 3)
+		c := &Cluster{Addrs: []string{fc.addr(1)}}
+		defer c.Close()
+
+		conn := c.Get(ctx)
+		defer conn.Close()
+
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%d", i)
+			_, err := conn.Do("SET", key, i)
+			require.NoError(t, err)
+
+			node := fc.nodes[fc.slots[Slot(key)]]
+			node.CheckGet(t, key, fmt.Sprint(i))
+
+			v, err := redis.Int(conn.Do("GET", key))
+			require.NoError(t, err)
+			require.Equal(t, i, v)
+		}
+		require.Len(t, conn.conns, 3)
+
+		// a command without key is executed on one of the nodes already used
+		v, err := redis.String(conn.Do("PING"))
+		require.NoError(t, err)
+		require.Equal(t, "PONG", v)
+		require.Len(t, conn.conns, 3)
+	})
+
+	t.Run("moved", func(t *testing.T) {
+		fc := newFakeCluster(t, 2)
+		c := &Cluster{Addrs: []string{fc.addr(0), fc.addr(1)}}
+		defer c.Close()
+		require.NoError(t, c.Refresh(ctx))
+
+		slot := Slot("k")
+		from := fc.slots[slot]
+		to := 1 - from
+		fc.setSlot(slot, to)
+
+		conn := c.Get(ctx)
+		defer conn.Close()
+		_, err := conn.Do("SET", "k", "v")
+		require.NoError(t, err)
+		fc.nodes[to].CheckGet(t, "k", "v")
+		require.False(t, fc.nodes[from].Exists("k"))
+
+		addr, err := c.slotAddr(ctx, slot)
+		require.NoError(t, err)
+		require.Equal(t, fc.addr(to), addr)
+	})
+
+	t.Run("ask", func(t *testing.T) {
+		fc := newFakeCluster(t, 2)
+		c := &Cluster{Addrs: []string{fc.addr(0)}}
+		defer c.Close()
+
+		slot := Slot("{a}1")
+		from := fc.slots[slot]
+		to := 1 - from
+		fc.nodes[from].Set("{a}1", "old")
+		fc.migrate(slot, to)
+
+		conn := c.Get(ctx)
+		defer conn.Close()
+
+		// the existing key is still served by the source node, the new one by
+		// the target
+		v, err := redis.String(conn.Do("GET", "{a}1"))
+		require.NoError(t, err)
+		require.Equal(t, "old", v)
+		_, err = conn.Do("SET", "{a}2", "new")
+		require.NoError(t, err)
+		fc.nodes[to].CheckGet(t, "{a}2", "new")
+
+		// the mapping is not updated by an ASK redirection
+		addr, err := c.slotAddr(ctx, slot)
+		require.NoError(t, err)
+		require.Equal(t, fc.addr(from), addr)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		fc := newFakeCluster(t, 2)
+		c := &Cluster{Addrs: []string{fc.addr(0)}}
+		defer c.Close()
+
+		conn := c.Get(ctx)
+		defer conn.Close()
+
+		v, err := conn.Do("MULTI")
+		require.NoError(t, err)
+		require.Equal(t, "OK", v)
+		v, err = conn.Do("SET", "{t}a", "1")
+		require.NoError(t, err)
+		require.Equal(t, "QUEUED", v)
+		_, err = conn.Do("INCR", "{t}b")
+		require.NoError(t, err)
+		vals, err := redis.Values(conn.Do("EXEC"))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"OK", int64(1)}, vals)
+
+		node := fc.nodes[fc.slots[Slot("t")]]
+		node.CheckGet(t, "{t}a", "1")
+		node.CheckGet(t, "{t}b", "1")
+
+		_, err = conn.Do("MULTI")
+		require.NoError(t, err)
+		_, err = conn.Do("SET", "{t}a", "2")
+		require.NoError(t, err)
+		_, err = conn.Do("SET", "{u}a", "2")
+		require.NoError(t, err)
+		_, err = conn.Do("EXEC")
+		require.EqualError(t, err, "CROSSSLOT Keys in request don't hash to the same slot")
+		node.CheckGet(t, "{t}a", "1")
+
+		_, err = conn.Do("MULTI")
+		require.NoError(t, err)
+		_, err = conn.Do("SET", "{t}a", "3")
+		require.NoError(t, err)
+		_, err = conn.Do("DISCARD")
+		require.NoError(t, err)
+		_, err = conn.Do("EXEC")
+		require.EqualError(t, err, "ERR EXEC without MULTI")
+		node.CheckGet(t, "{t}a", "1")
+
+		// the transaction follows a MOVED redirection
+		slot := Slot("t")
+		to := 1 - fc.slots[slot]
+		fc.setSlot(slot, to)
+		_, err = conn.Do("MULTI")
+		require.NoError(t, err)
+		_, err = conn.Do("SET", "{t}c", "4")
+		require.NoError(t, err)
+		vals, err = redis.Values(conn.Do("EXEC"))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"OK"}, vals)
+		fc.nodes[to].CheckGet(t, "{t}c", "4")
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		fc := newFakeCluster(t, 2)
+		for _, node := range fc.nodes {
+			node.RequireUserAuth("user", "pwd")
+		}
+		c := &Cluster{Addrs: []string{fc.addr(0)}}
+		defer c.Close()
+
+		conn := c.Get(ctx)
+		defer conn.Close()
+
+		_, err := conn.Do("AUTH", "user", "nope")
+		require.Error(t, err)
+		_, err = conn.Do("AUTH", "user", "pwd")
+		require.NoError(t, err)
+
+		// the connections obtained after AUTH are authenticated
+		for i := 0; i < 10; i++ {
+			_, err := conn.Do("SET", fmt.Sprintf("key%d", i), i)
+			require.NoError(t, err)
+		}
+		require.Len(t, conn.conns, 2)
+	})
+
+	t.Run("send receive", func(t *testing.T) {
+		fc := newFakeCluster(t, 2)
+		c := &Cluster{Addrs: []string{fc.addr(0)}}
+		defer c.Close()
+
+		conn := c.Get(ctx)
+		require.NoError(t, conn.Send("PING"))
+		require.NoError(t, conn.Flush())
+		v, err := redis.String(conn.Receive())
+		require.NoError(t, err)
+		require.Equal(t, "PONG", v)
+		require.NoError(t, conn.Err())
+
+		require.NoError(t, conn.Close())
+		require.ErrorIs(t, conn.Err(), errClosed)
+		_, err = conn.Do("PING")
+		require.ErrorIs(t, err, errClosed)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		addr := m.Addr()
+		m.Close()
+
+		c := &Cluster{Addrs: []string{addr}}
+		defer c.Close()
+		conn := c.Get(ctx)
+		defer conn.Close()
+
+		_, err := conn.Do("GET", "a")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to load the cluster slots")
+	})
+}
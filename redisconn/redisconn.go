@@ -0,0 +1,31 @@
+// Package redisconn provides redigo connections to Redis deployments that
+// are not a single Redis instance: a Redis Cluster, where the keys are
+// spread over multiple nodes, and a primary-replicas setup managed by Redis
+// Sentinel, where the address of the primary (the master) may change. The
+// connections can be used as the GetConnFunc of a restserver.Server, or by
+// any code that uses redigo connections.
+//
+// A Cluster routes each command to the node that serves the slot of its
+// keys, following the MOVED and ASK redirections when the slots are
+// migrated, so that the commands of a pipeline may be executed on different
+// nodes. The commands of a MULTI/EXEC transaction are sent to a single node
+// when EXEC is executed, so all their keys must hash to the same slot (see
+// the hash tags in [1]).
+//
+//	cluster := &redisconn.Cluster{Addrs: []string{"localhost:7000", "localhost:7001"}}
+//	defer cluster.Close()
+//	server := &restserver.Server{
+//		APIToken: "<token>",
+//		GetConnFunc: func(ctx context.Context) restserver.Conn {
+//			return cluster.Get(ctx)
+//		},
+//	}
+//
+// A Sentinel discovers the address of the master via the Sentinel instances
+// and dials it, e.g. as the DialContext function of a redigo pool:
+//
+//	sentinel := &redisconn.Sentinel{Addrs: []string{"localhost:26379"}, MasterName: "mymaster"}
+//	pool := &redis.Pool{DialContext: sentinel.Dial, TestOnBorrow: redisconn.TestRole}
+//
+//	[1]: https://redis.io/docs/reference/cluster-spec/#hash-tags
+package redisconn
@@ -0,0 +1,142 @@
+package redisconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Sentinel connects to the master of a Redis deployment managed by Redis
+// Sentinel. It asks the Sentinel instances for the address of the master
+// each time it dials a connection, so that the new connections follow a
+// failover. It is safe for concurrent use.
+type Sentinel struct {
+	// Addrs is the list of addresses (host:port) of the Sentinel instances.
+	Addrs []string
+
+	// MasterName is the name of the master monitored by the Sentinel
+	// instances.
+	MasterName string
+
+	// DialOptions are the options used to connect to the master.
+	DialOptions []redis.DialOption
+
+	// SentinelDialOptions are the options used to connect to the Sentinel
+	// instances.
+	SentinelDialOptions []redis.DialOption
+
+	mu    sync.Mutex
+	addrs []string // the Addrs, the last one that replied first
+}
+
+// MasterAddr returns the address of the master, as reported by the first
+// Sentinel instance that knows it. That instance is tried first on the next
+// calls.
+func (s *Sentinel) MasterAddr(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if s.addrs == nil {
+		s.addrs = append([]string(nil), s.Addrs...)
+	}
+	addrs := append([]string(nil), s.addrs...)
+	s.mu.Unlock()
+
+	var lastErr error
+	for i, addr := range addrs {
+		master, err := s.queryMaster(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if i > 0 {
+			s.mu.Lock()
+			for j, a := range s.addrs {
+				if a == addr {
+					copy(s.addrs[1:j+1], s.addrs[:j])
+					s.addrs[0] = addr
+					break
+				}
+			}
+			s.mu.Unlock()
+		}
+		return master, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no Sentinel address")
+	}
+	return "", fmt.Errorf("redisconn: failed to get the address of master %s: %w", s.MasterName, lastErr)
+}
+
+// Dial connects to the master and checks that the instance is a master with
+// the ROLE command, so that a connection to a former master that has not
+// been reconfigured yet after a failover is not returned. It can be used as
+// the DialContext function of a redigo pool.
+func (s *Sentinel) Dial(ctx context.Context) (redis.Conn, error) {
+	addr, err := s.MasterAddr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := redis.DialContext(ctx, "tcp", addr, s.DialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRole(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// TestRole returns an error if the connection is not connected to a master,
+// e.g. because a failover happened since it was dialed. It can be used as
+// the TestOnBorrow function of a redigo pool whose connections are returned
+// by Sentinel.Dial, so that the connections to the former master are
+// discarded.
+func TestRole(conn redis.Conn, _ time.Time) error {
+	return checkRole(context.Background(), conn)
+}
+
+func checkRole(ctx context.Context, conn redis.Conn) error {
+	vals, err := redis.Values(redis.DoContext(conn, ctx, "ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(vals) == 0 {
+		return errors.New("redisconn: invalid ROLE reply")
+	}
+	role, err := redis.String(vals[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("redisconn: instance is a %s, not a master", role)
+	}
+	return nil
+}
+
+// queryMaster returns the address of the master as reported by the Sentinel
+// instance at addr.
+func (s *Sentinel) queryMaster(ctx context.Context, addr string) (string, error) {
+	conn, err := redis.DialContext(ctx, "tcp", addr, s.SentinelDialOptions...)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	vals, err := redis.Strings(redis.DoContext(conn, ctx, "SENTINEL", "get-master-addr-by-name", s.MasterName))
+	if errors.Is(err, redis.ErrNil) {
+		return "", fmt.Errorf("%s: unknown master", addr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", addr, err)
+	}
+	if len(vals) != 2 {
+		return "", fmt.Errorf("%s: invalid SENTINEL get-master-addr-by-name reply", addr)
+	}
+	return net.JoinHostPort(vals[0], vals[1]), nil
+}
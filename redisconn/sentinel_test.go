@@ -0,0 +1,148 @@
+package redisconn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a miniredis instance that replies to the ROLE command.
+type fakeRedis struct {
+	*miniredis.Miniredis
+
+	mu   sync.Mutex
+	role string
+}
+
+func newFakeRedis(t *testing.T, role string) *fakeRedis {
+	fr := &fakeRedis{Miniredis: miniredis.RunT(t), role: role}
+	require.NoError(t, fr.Server().Register("ROLE", func(c *server.Peer, cmd string, args []string) {
+		fr.mu.Lock()
+		defer fr.mu.Unlock()
+		c.WriteLen(3)
+		c.WriteBulk(fr.role)
+		c.WriteInt(0)
+		c.WriteLen(0)
+	}))
+	return fr
+}
+
+func (fr *fakeRedis) setRole(role string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.role = role
+}
+
+func (fr *fakeRedis) conn(t *testing.T) redis.Conn {
+	conn, err := redis.Dial("tcp", fr.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// fakeSentinel is a Sentinel instance that monitors a single master.
+type fakeSentinel struct {
+	*miniredis.Miniredis
+
+	mu     sync.Mutex
+	name   string
+	master *fakeRedis
+}
+
+func newFakeSentinel(t *testing.T, name string, master *fakeRedis) *fakeSentinel {
+	fs := &fakeSentinel{Miniredis: miniredis.RunT(t), name: name, master: master}
+	require.NoError(t, fs.Server().Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		if len(args) != 2 || args[0] != "get-master-addr-by-name" || args[1] != fs.name {
+			c.WriteNull()
+			return
+		}
+		c.WriteLen(2)
+		c.WriteBulk(fs.master.Host())
+		c.WriteBulk(fs.master.Port())
+	}))
+	return fs
+}
+
+func (fs *fakeSentinel) setMaster(m *fakeRedis) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.master = m
+}
+
+func TestSentinel(t *testing.T) {
+	ctx := context.Background()
+
+	down := miniredis.RunT(t)
+	downAddr := down.Addr()
+	down.Close()
+
+	master := newFakeRedis(t, "master")
+	sentinel := newFakeSentinel(t, "mymaster", master)
+
+	t.Run("master addr", func(t *testing.T) {
+		s := &Sentinel{Addrs: []string{downAddr, sentinel.Addr()}, MasterName: "mymaster"}
+		addr, err := s.MasterAddr(ctx)
+		require.NoError(t, err)
+		require.Equal(t, master.Addr(), addr)
+
+		// the Sentinel that replied is tried first
+		require.Equal(t, []string{sentinel.Addr(), downAddr}, s.addrs)
+		require.Equal(t, []string{downAddr, sentinel.Addr()}, s.Addrs)
+	})
+
+	t.Run("unknown master", func(t *testing.T) {
+		s := &Sentinel{Addrs: []string{sentinel.Addr()}, MasterName: "nope"}
+		_, err := s.MasterAddr(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown master")
+	})
+
+	t.Run("no sentinel", func(t *testing.T) {
+		s := &Sentinel{Addrs: []string{downAddr}, MasterName: "mymaster"}
+		_, err := s.Dial(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get the address of master mymaster")
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		s := &Sentinel{Addrs: []string{sentinel.Addr()}, MasterName: "mymaster"}
+		pool := &redis.Pool{DialContext: s.Dial, TestOnBorrow: TestRole, MaxIdle: 1}
+		defer pool.Close()
+
+		conn := pool.Get()
+		_, err := conn.Do("SET", "a", "1")
+		require.NoError(t, err)
+		conn.Close()
+		master.CheckGet(t, "a", "1")
+
+		// the master is demoted before the Sentinel instances report the new
+		// one: the new connections fail and the idle one is discarded
+		master.setRole("slave")
+		_, err = s.Dial(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not a master")
+		require.Error(t, TestRole(master.conn(t), time.Time{}))
+		conn = pool.Get()
+		require.Error(t, conn.Err())
+		conn.Close()
+		require.Equal(t, 0, pool.IdleCount())
+
+		newMaster := newFakeRedis(t, "master")
+		sentinel.setMaster(newMaster)
+		conn = pool.Get()
+		defer conn.Close()
+		_, err = conn.Do("SET", "b", "2")
+		require.NoError(t, err)
+		newMaster.CheckGet(t, "b", "2")
+		require.False(t, master.Exists("b"))
+	})
+}
@@ -0,0 +1,478 @@
+package upstashdis
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The reply conversion helpers below are the equivalent of redigo's
+// redis.String, redis.Int, etc. They take a reply and an error, so that
+// they can wrap a call that returns both, and they convert the reply to the
+// requested Go type, dealing with the specifics of the JSON encoding of the
+// REST API: integers may be encoded as JSON numbers or as strings (e.g. the
+// result of GET on a key that holds a counter), floats are encoded as
+// strings, and nil results are JSON nulls.
+//
+// The reply may be a *Result or Result (as returned by Request.ExecRaw), or
+// a json.RawMessage or []byte holding the raw JSON result (e.g. obtained by
+// passing a *json.RawMessage to Request.ExecOne, or an element returned by
+// Values). If err is not nil, it is returned as-is. If the reply is a
+// Result with an error, that error is returned as an *Error. If the reply
+// is nil, ErrNil is returned.
+
+// String converts the reply to a string. Integers are converted to their
+// decimal representation.
+func String(reply interface{}, err error) (string, error) {
+	if b, ok, err := replyBinary(reply, err); ok {
+		return string(b), err
+	}
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return "", err
+	}
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return "", replyTypeError("String", raw)
+	}
+	return string(b), nil
+}
+
+// Bytes converts the reply to a []byte, as for String.
+func Bytes(reply interface{}, err error) ([]byte, error) {
+	if b, ok, err := replyBinary(reply, err); ok {
+		return b, err
+	}
+	s, err := String(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// Int converts the reply to an int.
+func Int(reply interface{}, err error) (int, error) {
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return 0, replyTypeError("Int", raw)
+	}
+	n, err := strconv.ParseInt(string(b), 10, 0)
+	return int(n), err
+}
+
+// Int64 converts the reply to an int64.
+func Int64(reply interface{}, err error) (int64, error) {
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return 0, replyTypeError("Int64", raw)
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// Float64 converts the reply to a float64.
+func Float64(reply interface{}, err error) (float64, error) {
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return 0, replyTypeError("Float64", raw)
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// Bool converts the reply to a bool. An integer is true if it is not 0, a
+// string is parsed with strconv.ParseBool.
+func Bool(reply interface{}, err error) (bool, error) {
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return false, err
+	}
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return false, replyTypeError("Bool", raw)
+	}
+	if raw[0] != '"' {
+		n, err := strconv.ParseInt(string(b), 10, 64)
+		return n != 0, err
+	}
+	return strconv.ParseBool(string(b))
+}
+
+// Values converts the array reply to a slice of raw JSON values, that can
+// in turn be converted with the other helpers.
+func Values(reply interface{}, err error) ([]json.RawMessage, error) {
+	raw, err := replyRaw(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if raw[0] != '[' {
+		return nil, replyTypeError("Values", raw)
+	}
+	var vals []json.RawMessage
+	err = json.Unmarshal(raw, &vals)
+	return vals, err
+}
+
+// Strings converts the array reply to a slice of strings. Nil elements are
+// converted to empty strings.
+func Strings(reply interface{}, err error) ([]string, error) {
+	bs, err := ByteSlices(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(bs))
+	for i, b := range bs {
+		strs[i] = string(b)
+	}
+	return strs, nil
+}
+
+// ByteSlices converts the array reply to a slice of []byte. Nil elements
+// are converted to nil slices.
+func ByteSlices(reply interface{}, err error) ([][]byte, error) {
+	if res := replyResult(reply); err == nil && res != nil && res.base64 && res.Error == "" {
+		v, err := decodeBase64(res.Result)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, ErrNil
+		}
+		if bs, ok := byteSlices(v); ok {
+			return bs, nil
+		}
+	}
+
+	vals, err := Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	bs := make([][]byte, len(vals))
+	for i, v := range vals {
+		b, err := Bytes(v, nil)
+		if err != nil && !errors.Is(err, ErrNil) {
+			return nil, err
+		}
+		bs[i] = b
+	}
+	return bs, nil
+}
+
+// StringMap converts the array reply, which must hold alternating keys and
+// values (e.g. the result of HGETALL), to a map of strings.
+func StringMap(reply interface{}, err error) (map[string]string, error) {
+	strs, err := Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(strs)%2 != 0 {
+		return nil, fmt.Errorf("upstashdis: StringMap expects an even number of values, got %d", len(strs))
+	}
+	m := make(map[string]string, len(strs)/2)
+	for i := 0; i < len(strs); i += 2 {
+		m[strs[i]] = strs[i+1]
+	}
+	return m, nil
+}
+
+// ScanStruct scans src, which must hold alternating field names and values
+// (e.g. the result of HGETALL converted with Values), into the fields of the
+// struct pointed to by dst. The name of a struct field is the value of its
+// "redis" tag if set, otherwise the name of the field. Fields with a tag of
+// "-" are ignored, as are names that do not match a field and nil values.
+//
+// Values are converted to the type of the field: string and []byte fields
+// receive the value as-is, numeric and bool fields parse it, and other
+// types are unmarshaled as described for Request.Exec.
+func ScanStruct(src []json.RawMessage, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("upstashdis: ScanStruct expects a non-nil pointer to a struct, got %T", dst)
+	}
+	if len(src)%2 != 0 {
+		return fmt.Errorf("upstashdis: ScanStruct expects an even number of values, got %d", len(src))
+	}
+
+	v = v.Elem()
+	fields := structFields(v.Type())
+	for i := 0; i < len(src); i += 2 {
+		name, err := String(src[i], nil)
+		if err != nil {
+			return err
+		}
+		ix, ok := fields[name]
+		if !ok || isNull(src[i+1]) {
+			continue
+		}
+		if err := setValue(v.Field(ix), src[i+1]); err != nil {
+			return fmt.Errorf("upstashdis: ScanStruct field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ScanSlice scans src into the slice pointed to by dst. If the slice
+// elements are structs (or pointers to structs), each element is scanned
+// from the next len(fieldNames) values, assigned to the struct fields with
+// those names (as described for ScanStruct). Otherwise, each value is
+// converted to the type of the elements, as for the struct fields in
+// ScanStruct.
+//
+// For example, the result of a SORT with multiple GET patterns can be
+// scanned into a slice of structs with ScanSlice(vals, &s, "Name", "Age").
+func ScanSlice(src []json.RawMessage, dst interface{}, fieldNames ...string) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("upstashdis: ScanSlice expects a non-nil pointer to a slice, got %T", dst)
+	}
+	v = v.Elem()
+
+	elemType := v.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	if elemType.Kind() != reflect.Struct || elemType == timeType {
+		slice := reflect.MakeSlice(v.Type(), len(src), len(src))
+		for i, raw := range src {
+			if isNull(raw) {
+				continue
+			}
+			if err := setValue(slice.Index(i), raw); err != nil {
+				return fmt.Errorf("upstashdis: ScanSlice index %d: %w", i, err)
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	if len(fieldNames) == 0 {
+		return errors.New("upstashdis: ScanSlice requires field names to scan into a slice of structs")
+	}
+	if len(src)%len(fieldNames) != 0 {
+		return fmt.Errorf("upstashdis: ScanSlice expects a multiple of %d values, got %d", len(fieldNames), len(src))
+	}
+	fields := structFields(elemType)
+	ixs := make([]int, len(fieldNames))
+	for i, name := range fieldNames {
+		ix, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("upstashdis: ScanSlice unknown field %s for %s", name, elemType)
+		}
+		ixs[i] = ix
+	}
+
+	n := len(src) / len(fieldNames)
+	slice := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		ev := slice.Index(i)
+		if isPtr {
+			ev.Set(reflect.New(elemType))
+			ev = ev.Elem()
+		}
+		for j, ix := range ixs {
+			raw := src[i*len(fieldNames)+j]
+			if isNull(raw) {
+				continue
+			}
+			if err := setValue(ev.Field(ix), raw); err != nil {
+				return fmt.Errorf("upstashdis: ScanSlice index %d field %s: %w", i, fieldNames[j], err)
+			}
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+// replyResult returns the reply as a *Result, or nil if it is not a Result.
+func replyResult(reply interface{}) *Result {
+	switch reply := reply.(type) {
+	case *Result:
+		return reply
+	case Result:
+		return &reply
+	}
+	return nil
+}
+
+// replyBinary returns the decoded bytes of the reply if it is a Result with
+// a base64-encoded string value, in which case it returns true. Otherwise
+// the reply must be converted from its raw JSON result.
+func replyBinary(reply interface{}, err error) ([]byte, bool, error) {
+	res := replyResult(reply)
+	if err != nil || res == nil || !res.base64 || res.Error != "" {
+		return nil, false, nil
+	}
+	v, err := decodeBase64(res.Result)
+	if err != nil {
+		return nil, true, err
+	}
+	b, ok := v.([]byte)
+	return b, ok, nil
+}
+
+// replyRaw returns the raw JSON result of the reply, or an error if err is
+// not nil, if the reply is an error or if it is nil.
+func replyRaw(reply interface{}, err error) (json.RawMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+	switch reply := reply.(type) {
+	case nil:
+		return nil, ErrNil
+	case *Result:
+		if reply == nil {
+			return nil, ErrNil
+		}
+		return replyRaw(*reply, nil)
+	case Result:
+		if reply.Error != "" {
+			return nil, newError(reply.Error, 0)
+		}
+		if raw, err = reply.raw(); err != nil {
+			return nil, err
+		}
+	case json.RawMessage:
+		raw = reply
+	case []byte:
+		raw = reply
+	default:
+		return nil, fmt.Errorf("upstashdis: unexpected reply type %T", reply)
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || isNull(raw) {
+		return nil, ErrNil
+	}
+	return raw, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+func replyTypeError(fn string, raw json.RawMessage) error {
+	if len(raw) > 32 {
+		raw = append(raw[:29:29], "..."...)
+	}
+	return fmt.Errorf("upstashdis: %s cannot convert result %s", fn, raw)
+}
+
+// structFields returns the index of the fields of the struct type by name,
+// as described for ScanStruct.
+func structFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("redis"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// setValue sets v to the raw value, converted to the type of v.
+func setValue(v reflect.Value, raw json.RawMessage) error {
+	if v.Kind() == reflect.Ptr {
+		pv := reflect.New(v.Type().Elem())
+		if err := setValue(pv.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(pv)
+		return nil
+	}
+
+	if v.Type() != bytesType {
+		// types with custom unmarshaling, time.Time, TextUnmarshaler, etc.
+		switch v.Addr().Interface().(type) {
+		case *time.Time, json.Unmarshaler, encoding.TextUnmarshaler, encoding.BinaryUnmarshaler:
+			return unmarshalResult(raw, v.Addr().Interface())
+		}
+	}
+
+	b, ok := scalarBytes(raw)
+	if !ok {
+		return unmarshalResult(raw, v.Addr().Interface())
+	}
+	s := string(b)
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return unmarshalResult(raw, v.Addr().Interface())
+		}
+		v.SetBytes([]byte(s))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		if raw[0] != '"' {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			v.SetBool(n != 0)
+			return nil
+		}
+		bv, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(bv)
+	default:
+		return unmarshalResult(raw, v.Addr().Interface())
+	}
+	return nil
+}
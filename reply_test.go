@@ -0,0 +1,181 @@
+package upstashdis
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rawValues(vals ...string) []json.RawMessage {
+	res := make([]json.RawMessage, len(vals))
+	for i, v := range vals {
+		res[i] = json.RawMessage(v)
+	}
+	return res
+}
+
+func TestReplyHelpers(t *testing.T) {
+	t.Run("string", func(t *testing.T) {
+		s, err := String(json.RawMessage(`"abc"`), nil)
+		require.NoError(t, err)
+		require.Equal(t, "abc", s)
+
+		s, err = String(&Result{Result: json.RawMessage(`12`)}, nil)
+		require.NoError(t, err)
+		require.Equal(t, "12", s)
+
+		_, err = String(json.RawMessage(`["a"]`), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("nil and errors", func(t *testing.T) {
+		_, err := String(json.RawMessage(`null`), nil)
+		require.True(t, errors.Is(err, ErrNil), "%v", err)
+		_, err = Int(nil, nil)
+		require.True(t, errors.Is(err, ErrNil), "%v", err)
+		_, err = Int(&Result{}, nil)
+		require.True(t, errors.Is(err, ErrNil), "%v", err)
+
+		_, err = Int(json.RawMessage(`1`), io.EOF)
+		require.Equal(t, io.EOF, err)
+
+		_, err = Int(Result{Error: "WRONGTYPE bad"}, nil)
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Equal(t, "WRONGTYPE", rerr.Kind)
+
+		_, err = Int(42, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("numbers", func(t *testing.T) {
+		n, err := Int([]byte(`"42"`), nil)
+		require.NoError(t, err)
+		require.Equal(t, 42, n)
+
+		n64, err := Int64(json.RawMessage(`-9000000000`), nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(-9000000000), n64)
+
+		f, err := Float64(json.RawMessage(`"1.5"`), nil)
+		require.NoError(t, err)
+		require.Equal(t, 1.5, f)
+
+		_, err = Int(json.RawMessage(`"abc"`), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("bool", func(t *testing.T) {
+		b, err := Bool(json.RawMessage(`1`), nil)
+		require.NoError(t, err)
+		require.True(t, b)
+
+		b, err = Bool(json.RawMessage(`0`), nil)
+		require.NoError(t, err)
+		require.False(t, b)
+
+		b, err = Bool(json.RawMessage(`"true"`), nil)
+		require.NoError(t, err)
+		require.True(t, b)
+	})
+
+	t.Run("values", func(t *testing.T) {
+		vals, err := Values(json.RawMessage(`["a", 1, null, ["b"]]`), nil)
+		require.NoError(t, err)
+		require.Len(t, vals, 4)
+		n, err := Int(vals[1], nil)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		strs, err := Strings(vals[3], nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, strs)
+
+		_, err = Values(json.RawMessage(`"a"`), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("strings", func(t *testing.T) {
+		strs, err := Strings(json.RawMessage(`["a", 1, null]`), nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "1", ""}, strs)
+	})
+
+	t.Run("string map", func(t *testing.T) {
+		m, err := StringMap(json.RawMessage(`["a", "1", "b", "2"]`), nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+
+		_, err = StringMap(json.RawMessage(`["a"]`), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("scan struct", func(t *testing.T) {
+		var s struct {
+			Name    string `redis:"name"`
+			Age     int    `redis:"age"`
+			Score   *float64
+			Active  bool      `redis:"active"`
+			Seen    time.Time `redis:"seen"`
+			Addr    net.IP    `redis:"addr"`
+			Data    []byte    `redis:"data"`
+			Ignored string    `redis:"-"`
+		}
+		s.Name = "unchanged"
+		err := ScanStruct(rawValues(
+			`"name"`, `null`,
+			`"age"`, `"30"`,
+			`"Score"`, `"2.5"`,
+			`"active"`, `"1"`,
+			`"seen"`, `1651400430`,
+			`"addr"`, `"10.0.0.1"`,
+			`"data"`, `"xyz"`,
+			`"-"`, `"x"`,
+			`"unknown"`, `"x"`,
+		), &s)
+		require.NoError(t, err)
+		require.Equal(t, "unchanged", s.Name)
+		require.Equal(t, 30, s.Age)
+		require.Equal(t, 2.5, *s.Score)
+		require.True(t, s.Active)
+		require.Equal(t, int64(1651400430), s.Seen.Unix())
+		require.Equal(t, "10.0.0.1", s.Addr.String())
+		require.Equal(t, []byte("xyz"), s.Data)
+		require.Empty(t, s.Ignored)
+
+		err = ScanStruct(rawValues(`"age"`, `"old"`), &s)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "field age")
+
+		require.Error(t, ScanStruct(rawValues(`"age"`), &s))
+		require.Error(t, ScanStruct(nil, s))
+	})
+
+	t.Run("scan slice", func(t *testing.T) {
+		var ints []int
+		require.NoError(t, ScanSlice(rawValues(`1`, `"2"`, `null`), &ints))
+		require.Equal(t, []int{1, 2, 0}, ints)
+
+		var ptrs []*string
+		require.NoError(t, ScanSlice(rawValues(`"a"`, `null`), &ptrs))
+		require.Equal(t, "a", *ptrs[0])
+		require.Nil(t, ptrs[1])
+
+		type person struct {
+			Name string
+			Age  int `redis:"age"`
+		}
+		var people []*person
+		require.NoError(t, ScanSlice(rawValues(`"a"`, `"1"`, `"b"`, `2`), &people, "Name", "age"))
+		require.Equal(t, []*person{{"a", 1}, {"b", 2}}, people)
+
+		var ps []person
+		require.Error(t, ScanSlice(rawValues(`"a"`, `"1"`, `"b"`), &ps, "Name", "age"))
+		require.Error(t, ScanSlice(rawValues(`"a"`), &ps, "Nope"))
+		require.Error(t, ScanSlice(rawValues(`"a"`), &ps))
+	})
+}
@@ -0,0 +1,81 @@
+package restserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+// isReadOnlyCmd returns true if the command can be executed with a
+// read-only API token.
+func isReadOnlyCmd(cmd string) bool {
+	return rediscmd.IsReadOnly(cmd)
+}
+
+// destructiveCommands is the set of commands that trigger an
+// EventDestructiveCommand notification when executed, as they destroy the
+// data of a whole database or change the configuration of the server.
+var destructiveCommands = map[string]bool{
+	"config":   true,
+	"debug":    true,
+	"flushall": true,
+	"flushdb":  true,
+	"function": true,
+	"script":   true,
+	"shutdown": true,
+	"swapdb":   true,
+}
+
+func isDestructiveCmd(cmd string) bool {
+	return destructiveCommands[strings.ToLower(cmd)]
+}
+
+// scriptingCommands is the set of commands that execute server-side
+// scripts, which can execute any command with redis.call regardless of the
+// AllowCommands and DenyCommands of the server.
+var scriptingCommands = map[string]bool{
+	"eval":       true,
+	"eval_ro":    true,
+	"evalsha":    true,
+	"evalsha_ro": true,
+	"fcall":      true,
+	"fcall_ro":   true,
+}
+
+// commandAllowed returns true if the command with its arguments can be
+// executed according to the AllowCommands and DenyCommands of the server.
+// If DenyCommands is set, the scripting commands must be listed in
+// AllowCommands, as the scripts could execute the denied commands.
+func (s *Server) commandAllowed(cmd string, args []interface{}) bool {
+	allowed := matchCommand(s.AllowCommands, cmd, args)
+	if len(s.AllowCommands) > 0 && !allowed {
+		return false
+	}
+	if len(s.DenyCommands) > 0 && !allowed && scriptingCommands[strings.ToLower(cmd)] {
+		return false
+	}
+	return !matchCommand(s.DenyCommands, cmd, args)
+}
+
+// matchCommand returns true if the command matches one of the entries of
+// list, which are command names or command and subcommand names separated
+// by "|" (e.g. "config|set"), case-insensitive.
+func matchCommand(list []string, cmd string, args []interface{}) bool {
+	var sub string
+	if len(args) > 0 {
+		sub = cmd + "|" + fmt.Sprint(args[0])
+	}
+	for _, entry := range list {
+		if strings.EqualFold(entry, cmd) || (sub != "" && strings.EqualFold(entry, sub)) {
+			return true
+		}
+	}
+	return false
+}
+
+// notAllowedError returns the error result for a command that is not
+// allowed by the AllowCommands and DenyCommands of the server.
+func notAllowedError(cmd string) errorResult {
+	return errorResult{Error: fmt.Sprintf("ERR command is not allowed: '%s'", strings.ToLower(cmd))}
+}
@@ -0,0 +1,84 @@
+package restserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHeaders is the list of request headers allowed in cross-origin
+// requests, in addition to the CORSHeaders of the server and the headers
+// with the corsHeaderPrefix.
+var corsHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	cursorHeader,
+	dbHeader,
+}
+
+// corsHeaderPrefix is the prefix of the request headers that are always
+// allowed in cross-origin requests, e.g. Upstash-Encoding.
+const corsHeaderPrefix = "Upstash-"
+
+// corsMaxAge is the number of seconds that the browsers may cache the
+// response to a preflight request.
+const corsMaxAge = "600"
+
+// serveCORS sets the CORS response headers if the request is a cross-origin
+// request from an allowed origin. It returns true if the request was a
+// preflight request, in which case the response has been sent.
+func (s *Server) serveCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !s.originAllowed(origin) {
+		return false
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		h.Set("Access-Control-Expose-Headers", cursorHeader)
+		return false
+	}
+
+	// preflight request
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", "GET, POST")
+	h.Set("Access-Control-Max-Age", corsMaxAge)
+
+	var allowed []string
+	for _, v := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if v = strings.TrimSpace(v); v != "" && s.corsHeaderAllowed(v) {
+			allowed = append(allowed, v)
+		}
+	}
+	if len(allowed) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(allowed, ", "))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (s *Server) originAllowed(origin string) bool {
+	for _, v := range s.CORSOrigins {
+		if v == "*" || strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) corsHeaderAllowed(header string) bool {
+	if len(header) >= len(corsHeaderPrefix) && strings.EqualFold(header[:len(corsHeaderPrefix)], corsHeaderPrefix) {
+		return true
+	}
+	for _, list := range [][]string{corsHeaders, s.CORSHeaders} {
+		for _, v := range list {
+			if strings.EqualFold(v, header) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package restserver
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// encodingHeader is the request header that selects the encoding of the
+// string values of the results, as supported by the Upstash Redis REST API.
+const encodingHeader = "Upstash-Encoding"
+
+// encodeResults returns the results v encoded as requested by r. If the
+// base64 encoding is requested, the string values of the successful results
+// are base64-encoded, recursively for arrays, so that binary values are
+// returned intact. Integers, nulls and errors are not encoded.
+func encodeResults(r *http.Request, v interface{}) interface{} {
+	if !strings.EqualFold(r.Header.Get(encodingHeader), "base64") {
+		return v
+	}
+
+	switch v := v.(type) {
+	case successResult:
+		return successResult{Result: encodeBase64(v.Result)}
+	case []interface{}:
+		// results of a pipeline or transaction
+		res := make([]interface{}, len(v))
+		for i, vv := range v {
+			res[i] = encodeResults(r, vv)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func encodeBase64(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, vv := range v {
+			res[i] = encodeBase64(vv)
+		}
+		return res
+	default:
+		return v
+	}
+}
@@ -0,0 +1,52 @@
+package restserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/fixture"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFixtures replays the golden fixtures of the testdata/fixtures
+// directory, recorded with the cmd/upstash-redis-rest-fixture command.
+func TestFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f, err := os.Open(file)
+			require.NoError(t, err)
+			defer f.Close()
+			fx, err := fixture.ReadFixture(f)
+			require.NoError(t, err)
+
+			redsrv := miniredis.RunT(t)
+			pool := redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return redis.Dial("tcp", redsrv.Addr())
+				},
+			}
+			defer pool.Close()
+
+			const token = "_token_"
+			httpsrv := httptest.NewServer(&Server{
+				APIToken: token,
+				GetConnFunc: func(ctx context.Context) Conn {
+					return pool.Get()
+				},
+			})
+			defer httpsrv.Close()
+
+			srv := &fixture.Server{BaseURL: httpsrv.URL, APIToken: token}
+			require.NoError(t, srv.Replay(fx))
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// errBodyTooLarge is the error returned when reading a request body that
+// exceeds the MaxBodyBytes of the server.
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitReader returns errBodyTooLarge if more than n bytes are read from r.
+// It reads up to one byte past the limit, so that a body of exactly n bytes
+// can be told apart from a larger one.
+type limitReader struct {
+	r io.Reader
+	n int64 // remaining bytes, < 0 if unlimited
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return l.r.Read(p)
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), 0
+		return n, errBodyTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// lineIndex records the offsets of the newlines of the data written to it,
+// so that the line and column of an offset can be computed without keeping
+// the data.
+type lineIndex struct {
+	n        int64   // number of bytes written
+	newlines []int64 // offsets of the newlines
+}
+
+func (x *lineIndex) Write(p []byte) (int, error) {
+	for i, b := range p {
+		if b == '\n' {
+			x.newlines = append(x.newlines, x.n+int64(i))
+		}
+	}
+	x.n += int64(len(p))
+	return len(p), nil
+}
+
+// lineCol returns the 1-based line and column of the byte offset.
+func (x *lineIndex) lineCol(offset int64) (line, col int) {
+	if offset > x.n {
+		offset = x.n
+	}
+	start := int64(0)
+	line = 1
+	for _, nl := range x.newlines {
+		if nl >= offset {
+			break
+		}
+		line++
+		start = nl + 1
+	}
+	return line, int(offset-start) + 1
+}
+
+// decodeCommands decodes the body of a pipeline or transaction request, a
+// JSON array of commands, each one a JSON array, one command at a time so
+// that the body is not buffered. The numbers are decoded as json.Number so
+// that they are sent to Redis as-is.
+func decodeCommands(r io.Reader) ([][]interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds [][]interface{}
+	switch tok {
+	case nil:
+		// null decodes to no commands, as with json.Unmarshal
+	case json.Delim('['):
+		for dec.More() {
+			var cmd []interface{}
+			if err := dec.Decode(&cmd); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			cmds = append(cmds, cmd)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	default:
+		return nil, &json.UnmarshalTypeError{Value: jsonTypeName(tok), Offset: dec.InputOffset()}
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		if errors.Is(err, errBodyTooLarge) {
+			return nil, err
+		}
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return cmds, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// jsonTypeName returns the name of the type of the JSON token, as used in
+// the json.UnmarshalTypeError messages.
+func jsonTypeName(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", tok)
+	}
+}
+
+// timeoutConn wraps the Conn used to serve a request so that its commands
+// are executed with the context of the request, which has the deadline of
+// the RequestTimeout of the server. The deadline is enforced if the Conn
+// supports it, via a DoContext or DoWithTimeout method as implemented by the
+// redigo connections.
+type timeoutConn struct {
+	Conn
+	ctx context.Context
+}
+
+func (c timeoutConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch conn := c.Conn.(type) {
+	case interface {
+		DoContext(context.Context, string, ...interface{}) (interface{}, error)
+	}:
+		return conn.DoContext(c.ctx, cmd, args...)
+
+	case interface {
+		DoWithTimeout(time.Duration, string, ...interface{}) (interface{}, error)
+	}:
+		if deadline, ok := c.ctx.Deadline(); ok {
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				return nil, context.DeadlineExceeded
+			}
+			return conn.DoWithTimeout(timeout, cmd, args...)
+		}
+	}
+
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Conn.Do(cmd, args...)
+}
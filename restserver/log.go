@@ -0,0 +1,177 @@
+package restserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Level is the severity of a message logged by the Server.
+type Level int
+
+// List of log levels, in increasing order of severity.
+const (
+	// LevelDebug is used for the execution of each command.
+	LevelDebug Level = iota
+	// LevelInfo is used for the access log.
+	LevelInfo
+	// LevelWarn is used for the authentication failures.
+	LevelWarn
+	// LevelError is used for the failures to reach the Redis server.
+	LevelError
+)
+
+// String returns the name of the level, in uppercase.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Logger is the interface used by the Server to log messages. The keyvals
+// are alternating keys (strings) and values that add structured context to
+// the message. The values never include the API tokens, passwords or the
+// arguments of the commands.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// NewStdLogger returns a Logger that writes the messages of at least the
+// min level to l, on a single line with the level, the message and the
+// keyvals formatted as key=value.
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	return stdLogger{l: l, min: min}
+}
+
+type stdLogger struct {
+	l   *log.Logger
+	min Level
+}
+
+func (l stdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&sb, " %v=%v", keyvals[i], v)
+	}
+	l.l.Print(sb.String())
+}
+
+// log logs the message with the server's Logger, if set.
+func (s *Server) log(level Level, msg string, keyvals ...interface{}) {
+	if s.Logger == nil {
+		return
+	}
+	s.Logger.Log(level, msg, keyvals...)
+}
+
+// requestLog is the http.ResponseWriter used to serve a request when the
+// access log is enabled. It records the information logged when the
+// request is done.
+type requestLog struct {
+	http.ResponseWriter
+	start    time.Time
+	status   int
+	username string
+	cmds     []string
+}
+
+func (l *requestLog) WriteHeader(code int) {
+	if l.status == 0 {
+		l.status = code
+	}
+	l.ResponseWriter.WriteHeader(code)
+}
+
+func (l *requestLog) Write(b []byte) (int, error) {
+	if l.status == 0 {
+		l.status = http.StatusOK
+	}
+	return l.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does, as it
+// is required to serve the subscriptions.
+func (l *requestLog) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logAccess logs the access log entry of the request.
+func (s *Server) logAccess(r *http.Request, l *requestLog) {
+	keyvals := []interface{}{
+		"method", r.Method,
+		"path", logPath(r.URL.Path),
+		"status", l.status,
+		"duration", time.Since(l.start),
+		"remote_addr", r.RemoteAddr,
+	}
+	if l.username != "" {
+		keyvals = append(keyvals, "username", l.username)
+	}
+	if len(l.cmds) > 0 {
+		keyvals = append(keyvals, "commands", strings.Join(l.cmds, ","))
+	}
+	s.log(LevelInfo, "request", keyvals...)
+}
+
+// logPath returns the path of the request as logged, without the arguments
+// of the commands sent in the path (e.g. /set/key/value is logged as
+// /set/...), as they may contain sensitive values.
+func logPath(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	switch path {
+	case "", "/pipeline", "/multi-exec", "/admin/commandstats":
+		return path
+	}
+	cmd, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if rest != "" {
+		return "/" + cmd + "/..."
+	}
+	return "/" + cmd
+}
+
+// logConn wraps the Conn used to serve a request to log the execution of
+// commands.
+type logConn struct {
+	Conn
+	s *Server
+	l *requestLog // nil if the access log is disabled
+}
+
+func (c logConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	res, err := c.Conn.Do(cmd, args...)
+	name := strings.ToUpper(cmd)
+	if c.l != nil {
+		c.l.cmds = append(c.l.cmds, name)
+	}
+
+	c.s.log(LevelDebug, "command", "command", name, "duration", time.Since(start), "error", err != nil)
+	if isNetworkError(err) {
+		c.s.log(LevelError, "redis connection failed", "command", name, "error", err)
+	}
+	return res, err
+}
@@ -0,0 +1,87 @@
+package restserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mna/upstashdis/internal/metrics"
+)
+
+// serverMetrics holds the metrics of the server, exposed in the Prometheus
+// text format by the MetricsHandler.
+type serverMetrics struct {
+	reg          metrics.Registry
+	commands     *metrics.CounterVec
+	errors       *metrics.CounterVec
+	latency      *metrics.HistogramVec
+	authFailures *metrics.CounterVec
+	conns        *metrics.GaugeVec
+}
+
+// metrics returns the metrics of the server, registering them on first use.
+func (s *Server) metrics() *serverMetrics {
+	s.metricsOnce.Do(func() {
+		m := &serverMetrics{}
+		m.commands = m.reg.NewCounter("upstash_rest_server_commands_total",
+			"Number of commands executed, by command name (transactions are counted as multi-exec).", "command")
+		m.errors = m.reg.NewCounter("upstash_rest_server_command_errors_total",
+			"Number of commands that failed, by command name.", "command")
+		m.latency = m.reg.NewHistogram("upstash_rest_server_command_duration_seconds",
+			"Latency of the commands, by command name.", nil, "command")
+		m.authFailures = m.reg.NewCounter("upstash_rest_server_auth_failures_total",
+			"Number of requests rejected because of an invalid API token.")
+		m.conns = m.reg.NewGauge("upstash_rest_server_redis_connections_active",
+			"Number of Redis connections currently in use to serve requests.")
+		s.metricsv = m
+	})
+	return s.metricsv
+}
+
+// recordCmd records the execution of the command in the statistics and the
+// metrics of the server.
+func (s *Server) recordCmd(cmd string, dur time.Duration, failed bool) {
+	name := s.stats.record(cmd, dur, failed)
+
+	m := s.metrics()
+	m.commands.With(name).Inc()
+	if failed {
+		m.errors.With(name).Inc()
+	}
+	m.latency.With(name).Observe(dur.Seconds())
+}
+
+// MetricsHandler returns an http.Handler that serves the metrics of the
+// server in the Prometheus text format: the number of commands executed and
+// failed and their latency by command name, the number of authentication
+// failures and the number of Redis connections in use. The handler does
+// not require authentication, so it should typically be served on a
+// separate, internal address.
+func (s *Server) MetricsHandler() http.Handler {
+	return &s.metrics().reg
+}
+
+// HealthHandler returns an http.Handler that reports the health of the
+// server: it executes a PING on a connection returned by GetConnFunc and
+// responds with a 200 status code if it succeeds, a 503 otherwise, with a
+// JSON body that describes the status. The handler does not require
+// authentication.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		conn := s.GetConnFunc(ctx)
+		defer conn.Close()
+
+		type health struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}
+		if _, err := conn.Do("PING"); err != nil {
+			reply(w, health{Status: "unavailable", Error: err.Error()}, http.StatusServiceUnavailable)
+			return
+		}
+		reply(w, health{Status: "ok"}, http.StatusOK)
+	})
+}
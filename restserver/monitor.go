@@ -0,0 +1,300 @@
+package restserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	monitorPath         = "/monitor"
+	monitorKeyspacePath = "/monitor/keyspace/"
+
+	// ndjsonContentType is the content type of the newline-delimited JSON
+	// streams, returned instead of server-sent events if the Accept request
+	// header contains it.
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// monitorRoute returns true if the path is a monitor request. If it is a
+// request for the keyspace notifications, e.g. /monitor/keyspace/<pattern>,
+// the key pattern is returned.
+func monitorRoute(path string) (pattern string, ok bool) {
+	if strings.TrimSuffix(path, "/") == monitorPath {
+		return "", true
+	}
+	if strings.HasPrefix(path, monitorKeyspacePath) {
+		pattern = strings.TrimSuffix(path[len(monitorKeyspacePath):], "/")
+		return pattern, pattern != ""
+	}
+	return "", false
+}
+
+// monitorEvent is the event streamed for each command received by the Redis
+// server on the /monitor endpoint.
+type monitorEvent struct {
+	Time    float64  `json:"time"`
+	DB      int      `json:"db"`
+	Client  string   `json:"client"`
+	Command []string `json:"command"`
+}
+
+// keyspaceEvent is the event streamed for each keyspace notification on the
+// /monitor/keyspace/<pattern> endpoint.
+type keyspaceEvent struct {
+	Key   string `json:"key"`
+	Event string `json:"event"`
+}
+
+// serveMonitor streams the commands received by the Redis server, as
+// reported by the MONITOR command, or the keyspace notifications of the keys
+// that match pattern if it is not empty, until the client disconnects. Each
+// event is a JSON object, streamed as server-sent events or as
+// newline-delimited JSON if the client accepts it. Only admin API tokens can
+// monitor the server, as it exposes the commands of all clients.
+func (s *Server) serveMonitor(w http.ResponseWriter, r *http.Request, a auth, pattern string) {
+	if a.ReadOnly || a.Username != "" {
+		reply(w, errorResult{"NOPERM this user has no permissions to monitor the server"}, http.StatusForbidden)
+		return
+	}
+
+	cmd, args := "monitor", []interface{}(nil)
+	if pattern != "" {
+		db := s.DefaultDB
+		if v := requestDB(r); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				reply(w, errorResult{"ERR invalid DB index"}, http.StatusBadRequest)
+				return
+			}
+			if n != s.DefaultDB && !s.dbAllowed(n) {
+				reply(w, errorResult{"ERR DB index is not allowed"}, http.StatusForbidden)
+				return
+			}
+			db = n
+		}
+		cmd, args = "psubscribe", []interface{}{fmt.Sprintf("__keyspace@%d__:%s", db, pattern)}
+	}
+	if !s.commandAllowed(cmd, args) {
+		reply(w, notAllowedError(cmd), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		reply(w, errorResult{"ERR streaming is not supported"}, http.StatusInternalServerError)
+		return
+	}
+
+	conn := s.subscriberConn(r)
+	if conn == nil {
+		reply(w, errorResult{"ERR monitor is not supported"}, http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+	conns := s.metrics().conns.With()
+	conns.Add(1)
+	defer conns.Add(-1)
+
+	if err := conn.Send(strings.ToUpper(cmd), args...); err != nil {
+		reply(w, errorResult{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		reply(w, errorResult{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	ndjson := strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// receive the events in a separate goroutine, so that the client's
+	// disconnection can be detected.
+	events := make(chan interface{})
+	if pattern != "" {
+		go receiveKeyspaceEvents(conn, events)
+	} else {
+		go receiveMonitorEvents(conn, events)
+	}
+
+	bw := bufio.NewWriter(w)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if ndjson {
+				bw.Write(b)
+				bw.WriteString("\n")
+			} else {
+				writeEvent(bw, []string{string(b)})
+			}
+			if bw.Flush() == nil {
+				flusher.Flush()
+			}
+
+		case <-r.Context().Done():
+			// a connection in MONITOR mode can only be quit, which closes it so
+			// that it is not reused, while a subscribed one can unsubscribe. Wait
+			// for the receiving goroutine to terminate before the connection is
+			// closed.
+			if pattern != "" {
+				_ = conn.Send("PUNSUBSCRIBE")
+			} else {
+				_ = conn.Send("QUIT")
+			}
+			_ = conn.Flush()
+			for range events {
+			}
+			return
+		}
+	}
+}
+
+// receiveMonitorEvents receives the commands reported by the connection in
+// MONITOR mode and sends them on events, until an error occurs, at which
+// point it closes events.
+func receiveMonitorEvents(conn SubscriberConn, events chan<- interface{}) {
+	defer close(events)
+	for {
+		v, err := conn.Receive()
+		if err != nil {
+			return
+		}
+
+		var line string
+		switch v := v.(type) {
+		case string:
+			line = v
+		case []byte:
+			line = string(v)
+		default:
+			continue
+		}
+		if ev, ok := parseMonitorLine(line); ok {
+			events <- ev
+		}
+	}
+}
+
+// receiveKeyspaceEvents receives the keyspace notifications of the
+// subscribed connection and sends them on events, until an error occurs or
+// the connection unsubscribes, at which point it closes events.
+func receiveKeyspaceEvents(conn SubscriberConn, events chan<- interface{}) {
+	msgs := make(chan []string)
+	go receiveMessages(conn, msgs)
+
+	defer close(events)
+	for msg := range msgs {
+		// pmessage, pattern, channel, event
+		if len(msg) != 4 || msg[0] != "pmessage" {
+			continue
+		}
+		key := msg[2]
+		if ix := strings.Index(key, "__:"); ix >= 0 {
+			key = key[ix+3:]
+		}
+		events <- keyspaceEvent{Key: key, Event: msg[3]}
+	}
+}
+
+// parseMonitorLine parses a line reported by MONITOR, e.g.
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+//
+// It returns false if the line is not in that format, e.g. for the OK reply
+// to the MONITOR command.
+func parseMonitorLine(line string) (monitorEvent, bool) {
+	var ev monitorEvent
+
+	ts, rest, ok := strings.Cut(line, " [")
+	if !ok {
+		return ev, false
+	}
+	t, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return ev, false
+	}
+	ev.Time = t
+
+	info, rest, ok := strings.Cut(rest, "] ")
+	if !ok {
+		return ev, false
+	}
+	db, client, _ := strings.Cut(info, " ")
+	if ev.DB, err = strconv.Atoi(db); err != nil {
+		return ev, false
+	}
+	ev.Client = client
+
+	ev.Command, ok = parseQuotedArgs(rest)
+	return ev, ok
+}
+
+// parseQuotedArgs parses the space-separated, double-quoted arguments of a
+// command as reported by MONITOR, with the escape sequences used by Redis.
+func parseQuotedArgs(s string) ([]string, bool) {
+	var args []string
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		if s[0] != '"' {
+			return nil, false
+		}
+
+		var sb strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			c := s[i]
+			if c != '\\' {
+				sb.WriteByte(c)
+				continue
+			}
+			if i++; i >= len(s) {
+				return nil, false
+			}
+			switch c = s[i]; c {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'a':
+				sb.WriteByte('\a')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'x':
+				if i+2 >= len(s) {
+					return nil, false
+				}
+				b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return nil, false
+				}
+				sb.WriteByte(byte(b))
+				i += 2
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		if i >= len(s) {
+			return nil, false
+		}
+		args = append(args, sb.String())
+		s = s[i+1:]
+	}
+	return args, len(args) > 0
+}
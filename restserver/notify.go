@@ -0,0 +1,262 @@
+package restserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// List of event types sent to the Server's Notify function.
+const (
+	// EventAuthFailure is sent when a request is rejected because it does not
+	// provide a valid API token.
+	EventAuthFailure = "auth_failure"
+
+	// EventDestructiveCommand is sent when a command that destroys data or
+	// changes the server's configuration (e.g. FLUSHALL, FLUSHDB or CONFIG) is
+	// executed successfully.
+	EventDestructiveCommand = "destructive_command"
+
+	// EventBackendDown is sent when a command fails because the Redis server
+	// is unreachable.
+	EventBackendDown = "backend_down"
+)
+
+// Event describes a notable event that occurred while serving a request.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// RemoteAddr is the network address of the client that made the request,
+	// set for EventAuthFailure.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Username is the ACL username associated with the token of the request,
+	// if any.
+	Username string `json:"username,omitempty"`
+	// Command is the name of the command, set for EventDestructiveCommand and
+	// EventBackendDown.
+	Command string `json:"command,omitempty"`
+	// Error is the error message, set for EventBackendDown.
+	Error string `json:"error,omitempty"`
+
+	// Suppressed is the number of events of the same type that were not sent
+	// since the previous one due to the throttling of the Webhook.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// notify calls the Notify function of the server, if set.
+func (s *Server) notify(e Event) {
+	if s.Notify == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	s.Notify(e)
+}
+
+// notifyConn wraps the Conn used to serve a request to send the events
+// related to the execution of commands.
+type notifyConn struct {
+	Conn
+	s *Server
+	a auth
+}
+
+func (c notifyConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	res, err := c.Conn.Do(cmd, args...)
+	switch {
+	case err == nil && isDestructiveCmd(cmd):
+		c.s.notify(Event{Type: EventDestructiveCommand, Username: c.a.Username, Command: strings.ToUpper(cmd)})
+	case isNetworkError(err):
+		c.s.notify(Event{Type: EventBackendDown, Username: c.a.Username, Command: strings.ToUpper(cmd), Error: err.Error()})
+	}
+	return res, err
+}
+
+// isNetworkError returns true if err is an error caused by the connection
+// to the Redis server (as opposed to an error returned by Redis).
+func isNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Webhook sends events as JSON to a URL via POST requests. Its Notify method
+// can be used as the Server's Notify function. Events are queued and sent in
+// the background, one at a time, retrying failed requests. If the queue is
+// full, the event is dropped. The fields should be set before the first call
+// to Notify and should not be changed thereafter.
+type Webhook struct {
+	// URL is the webhook URL that receives the events.
+	URL string
+
+	// HTTPClient is the HTTP client used to send the events. If nil, a client
+	// with a timeout of 10 seconds is used.
+	HTTPClient *http.Client
+
+	// MaxAttempts is the maximum number of attempts to send an event. Requests
+	// that fail with a network error, a 429 or a 5xx status code are retried.
+	// If <= 0, it defaults to 3.
+	MaxAttempts int
+
+	// RetryDelay is the delay before the first retry, it doubles after each
+	// attempt. If <= 0, it defaults to 1 second.
+	RetryDelay time.Duration
+
+	// Throttle is the minimum delay between two events of the same type. Events
+	// received before that delay are not sent, but are counted in the
+	// Suppressed field of the next event of that type. If <= 0, events are not
+	// throttled.
+	Throttle time.Duration
+
+	// QueueSize is the maximum number of events waiting to be sent. If <= 0,
+	// it defaults to 100.
+	QueueSize int
+
+	// ErrorLog is called with the error when an event could not be sent or was
+	// dropped. It may be nil.
+	ErrorLog func(error)
+
+	once  sync.Once
+	queue chan Event
+	done  chan struct{}
+
+	mu       sync.Mutex // protects the following fields
+	closed   bool
+	lastSent map[string]time.Time
+	skipped  map[string]int
+}
+
+func (w *Webhook) init() {
+	size := w.QueueSize
+	if size <= 0 {
+		size = 100
+	}
+	w.queue = make(chan Event, size)
+	w.done = make(chan struct{})
+	w.lastSent = make(map[string]time.Time)
+	w.skipped = make(map[string]int)
+	go w.run()
+}
+
+// Notify queues the event to be sent to the webhook. It does not block.
+func (w *Webhook) Notify(e Event) {
+	w.once.Do(w.init)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.Throttle > 0 {
+		if last, ok := w.lastSent[e.Type]; ok && e.Time.Sub(last) < w.Throttle {
+			w.skipped[e.Type]++
+			return
+		}
+		w.lastSent[e.Type] = e.Time
+		e.Suppressed = w.skipped[e.Type]
+		delete(w.skipped, e.Type)
+	}
+
+	select {
+	case w.queue <- e:
+	default:
+		w.logError(fmt.Errorf("webhook: queue full, dropped %s event", e.Type))
+	}
+}
+
+// Close stops accepting new events and waits for the queued events to be
+// sent, or for ctx to be done.
+func (w *Webhook) Close(ctx context.Context) error {
+	w.once.Do(w.init)
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		if err := w.send(e); err != nil {
+			w.logError(fmt.Errorf("webhook: failed to send %s event: %w", e.Type, err))
+		}
+	}
+}
+
+func (w *Webhook) send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cli := w.HTTPClient
+	if cli == nil {
+		cli = &http.Client{Timeout: 10 * time.Second}
+	}
+	attempts := w.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	delay := w.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	for i := 1; ; i++ {
+		retry, err := w.post(cli, body)
+		if err == nil || !retry || i >= attempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends the body to the webhook URL and returns whether the request
+// should be retried if it failed.
+func (w *Webhook) post(cli *http.Client, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status: %s", res.Status)
+}
+
+func (w *Webhook) logError(err error) {
+	if w.ErrorLog != nil {
+		w.ErrorLog(err)
+	}
+}
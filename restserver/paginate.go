@@ -0,0 +1,280 @@
+package restserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cursorHeader is the response header that holds the cursor to get the next
+// page of a truncated array reply, and the request header that can be used
+// instead of the _cursor query string parameter to request that page.
+const cursorHeader = "X-Redis-Cursor"
+
+// defaultPageLen is the number of elements requested from Redis for a page
+// when only MaxReplyBytes is set.
+const defaultPageLen = 1000
+
+// rangeCmd describes a read-only command that returns the elements of a key
+// in an index range, e.g. LRANGE key start stop.
+type rangeCmd struct {
+	lenCmd string          // the command that returns the number of elements
+	opts   map[string]bool // the options allowed after the range
+}
+
+// rangeCmds are the index range commands that support pagination. Their
+// range is shifted to get each page.
+var rangeCmds = map[string]rangeCmd{
+	"lrange":    {lenCmd: "LLEN"},
+	"zrange":    {lenCmd: "ZCARD", opts: map[string]bool{"rev": true, "withscores": true}},
+	"zrevrange": {lenCmd: "ZCARD", opts: map[string]bool{"withscores": true}},
+}
+
+// scanCmd describes a read-only command that returns all elements of a key,
+// e.g. SMEMBERS key.
+type scanCmd struct {
+	scanCmd string // the SCAN command that iterates over the elements
+	pairs   bool   // the elements are field-value pairs
+}
+
+// scanCmds are the commands that return all elements of a key that support
+// pagination. Each page is requested with the corresponding SCAN command.
+var scanCmds = map[string]scanCmd{
+	"hgetall":  {scanCmd: "HSCAN", pairs: true},
+	"smembers": {scanCmd: "SSCAN"},
+}
+
+func requestCursor(r *http.Request) string {
+	cur := r.URL.Query().Get("_cursor")
+	if cur == "" {
+		cur = r.Header.Get(cursorHeader)
+	}
+	return cur
+}
+
+// paginationEnabled returns true if array replies may be truncated.
+func (s *Server) paginationEnabled() bool {
+	return s.MaxArrayReply > 0 || s.MaxReplyBytes > 0
+}
+
+// execPagedCmd executes the page at the request's cursor of the command on
+// behalf of the user, if pagination is enabled and the command is one of
+// the read-only range commands that support it. If there are more elements
+// after that page, the cursor of the next page is set in the response
+// header. It returns false if the command is not paginated, in which case
+// it must be executed as usual.
+func (s *Server) execPagedCmd(w http.ResponseWriter, r *http.Request, conn Conn, cmd string, args []interface{}) (interface{}, int, bool) {
+	if !s.paginationEnabled() {
+		return nil, 0, false
+	}
+
+	var page func() (interface{}, int)
+	name := strings.ToLower(cmd)
+	if rc, ok := rangeCmds[name]; ok {
+		start, stop, pairs, ok := rc.parse(args)
+		if !ok {
+			return nil, 0, false
+		}
+		page = func() (interface{}, int) {
+			return s.pageRange(w, r, conn, cmd, rc.lenCmd, args, start, stop, pairs)
+		}
+	} else if sc, ok := scanCmds[name]; ok && len(args) == 1 {
+		page = func() (interface{}, int) {
+			return s.pageScan(w, r, conn, sc.scanCmd, args[0], sc.pairs)
+		}
+	}
+	if page == nil {
+		return nil, 0, false
+	}
+
+	// the paginated commands are read-only, so only the allowed commands need
+	// to be checked, and the command is recorded as if it was executed as-is.
+	if !s.commandAllowed(cmd, args) {
+		s.recordCmd(cmd, 0, true)
+		return notAllowedError(cmd), http.StatusBadRequest, true
+	}
+	start := time.Now()
+	v, code := page()
+	s.recordCmd(cmd, time.Since(start), code != http.StatusOK)
+	return v, code, true
+}
+
+// parse returns the range of the command's arguments and whether its reply
+// is made of pairs of elements. It returns false if the command is not in
+// the form that supports pagination.
+func (rc rangeCmd) parse(args []interface{}) (start, stop int64, pairs, ok bool) {
+	if len(args) < 3 {
+		return 0, 0, false, false
+	}
+	start, err1 := strconv.ParseInt(fmt.Sprint(args[1]), 10, 64)
+	stop, err2 := strconv.ParseInt(fmt.Sprint(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, false
+	}
+	for _, arg := range args[3:] {
+		opt := strings.ToLower(fmt.Sprint(arg))
+		if !rc.opts[opt] {
+			return 0, 0, false, false
+		}
+		pairs = pairs || opt == "withscores"
+	}
+	return start, stop, pairs, true
+}
+
+// pageRange executes the page of the index range command that returns the
+// elements from start to stop. The cursor is the number of elements (or
+// pairs) of the range returned by the previous pages, and only the page is
+// requested from Redis.
+func (s *Server) pageRange(w http.ResponseWriter, r *http.Request, conn Conn, cmd, lenCmd string, args []interface{}, start, stop int64, pairs bool) (interface{}, int) {
+	var offset int64
+	if cur := requestCursor(r); cur != "" {
+		n, err := strconv.ParseInt(cur, 10, 64)
+		if err != nil || n < 0 {
+			return errorResult{"ERR invalid cursor"}, http.StatusBadRequest
+		}
+		offset = n
+	}
+
+	// negative indices are relative to the end, they must be resolved so
+	// that the range can be shifted.
+	if start < 0 || stop < 0 {
+		v, code := s.execCmd(conn, lenCmd, args[0])
+		if code != http.StatusOK {
+			return v, code
+		}
+		n, _ := v.(successResult).Result.(int64)
+		if start < 0 {
+			if start += n; start < 0 {
+				start = 0
+			}
+		}
+		if stop < 0 {
+			stop += n
+		}
+	}
+
+	from := start + offset
+	to := from + int64(s.pageLen(pairs)) - 1
+	if to > stop {
+		to = stop
+	}
+	if from > to {
+		return successResult{Result: []interface{}{}}, http.StatusOK
+	}
+
+	pageArgs := append([]interface{}{args[0], from, to}, args[3:]...)
+	v, code := s.execCmd(conn, cmd, pageArgs...)
+	if code != http.StatusOK {
+		return v, code
+	}
+	vals, ok := v.(successResult).Result.([]interface{})
+	if !ok {
+		return v, code
+	}
+
+	step := 1
+	if pairs {
+		step = 2
+	}
+	n := s.fitReplyBytes(vals, step)
+	if n < len(vals) || (to < stop && len(vals) == int(to-from+1)*step) {
+		w.Header().Set(cursorHeader, strconv.FormatInt(offset+int64(n/step), 10))
+	}
+	return successResult{Result: vals[:n]}, http.StatusOK
+}
+
+// pageScan executes the page of the command that returns all elements of
+// key, using the corresponding SCAN command. The cursor is that of the SCAN
+// command. As its COUNT option is only a hint, the pages may have more
+// elements than the limits (e.g. for small keys, that are returned in a
+// single page) and an element may be returned in more than one page.
+func (s *Server) pageScan(w http.ResponseWriter, r *http.Request, conn Conn, scanCmd string, key interface{}, pairs bool) (interface{}, int) {
+	cur := requestCursor(r)
+	if cur == "" {
+		cur = "0"
+	} else if _, err := strconv.ParseUint(cur, 10, 64); err != nil {
+		return errorResult{"ERR invalid cursor"}, http.StatusBadRequest
+	}
+
+	v, code := s.execCmd(conn, scanCmd, key, cur, "COUNT", s.pageLen(pairs))
+	if code != http.StatusOK {
+		return v, code
+	}
+	res, ok := v.(successResult).Result.([]interface{})
+	if !ok || len(res) != 2 {
+		return errorResult{"ERR unexpected " + scanCmd + " reply"}, http.StatusInternalServerError
+	}
+	vals, _ := res[1].([]interface{})
+	if vals == nil {
+		vals = []interface{}{}
+	}
+
+	var next string
+	switch c := res[0].(type) {
+	case []byte:
+		next = string(c)
+	case string:
+		next = c
+	}
+	if next != "" && next != "0" {
+		w.Header().Set(cursorHeader, next)
+	}
+	return successResult{Result: vals}, http.StatusOK
+}
+
+// pageLen returns the number of elements, or pairs of elements, to request
+// from Redis for a page.
+func (s *Server) pageLen(pairs bool) int {
+	n := s.MaxArrayReply
+	if n <= 0 {
+		n = defaultPageLen
+	}
+	if pairs {
+		if n /= 2; n == 0 {
+			n = 1
+		}
+	}
+	return n
+}
+
+// fitReplyBytes returns the number of elements of vals that fit in
+// MaxReplyBytes, a multiple of step so that pairs are not split. At least
+// step elements are always kept so that the pagination progresses, even if
+// they are larger than MaxReplyBytes.
+func (s *Server) fitReplyBytes(vals []interface{}, step int) int {
+	if s.MaxReplyBytes <= 0 {
+		return len(vals)
+	}
+
+	var size int
+	for i := 0; i < len(vals); i += step {
+		for j := i; j < i+step && j < len(vals); j++ {
+			size += replySize(vals[j])
+		}
+		if size > s.MaxReplyBytes && i > 0 {
+			return i
+		}
+	}
+	return len(vals)
+}
+
+// replySize returns the approximate size in bytes of the reply value once
+// encoded in the response.
+func replySize(v interface{}) int {
+	switch v := v.(type) {
+	case []byte:
+		return len(v) + 3 // quotes and comma
+	case string:
+		return len(v) + 3
+	case []interface{}:
+		n := 3 // brackets and comma
+		for _, vv := range v {
+			n += replySize(vv)
+		}
+		return n
+	default:
+		return 21 // integers, nil
+	}
+}
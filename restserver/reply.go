@@ -0,0 +1,119 @@
+package restserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// normalizeReply returns the reply v of a Redis command converted to the
+// types that encode to JSON the way the Upstash Redis REST API encodes
+// them, based on the RESP2 representation of the reply:
+//
+//   - nil (null bulk string or array) is null;
+//   - simple and bulk strings are strings, and so are the floating-point
+//     values (as returned by e.g. ZSCORE or INCRBYFLOAT);
+//   - integers are numbers, and so are the booleans (as 1 or 0);
+//   - errors nested in arrays are strings with the error message;
+//   - arrays are arrays, recursively normalized, and so are the maps, as a
+//     flat array of key-value pairs.
+//
+// The redigo connections return replies that mostly encode correctly, the
+// conversion is mostly useful for Conn implementations that return other
+// Go types, e.g. []string or map[string]string.
+func normalizeReply(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, []byte, int64:
+		return v
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, vv := range v {
+			res[i] = normalizeReply(vv)
+		}
+		return res
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return uint64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return v
+	case float32:
+		return formatFloat(float64(v), 32)
+	case float64:
+		return formatFloat(v, 64)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		return v.String()
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			res[i] = normalizeReply(rv.Index(i).Interface())
+		}
+		return res
+	case reflect.Map:
+		// the order of a Go map is random, sort the pairs by key so that the
+		// result is deterministic.
+		keys := rv.MapKeys()
+		pairs := make([][2]interface{}, len(keys))
+		for i, k := range keys {
+			pairs[i] = [2]interface{}{normalizeReply(k.Interface()), normalizeReply(rv.MapIndex(k).Interface())}
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return fmt.Sprint(pairs[i][0]) < fmt.Sprint(pairs[j][0])
+		})
+		res := make([]interface{}, 0, 2*len(pairs))
+		for _, p := range pairs {
+			res = append(res, p[0], p[1])
+		}
+		return res
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeReply(rv.Elem().Interface())
+	}
+	return v
+}
+
+// formatFloat formats f as Redis does in its replies.
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, bitSize)
+}
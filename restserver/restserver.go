@@ -15,10 +15,94 @@
 //
 // Only requests that provide a valid API token are authorized. Valid API
 // tokens are the one specified when creating the Server value (by setting
-// its APIToken field), and any other token generated by executing the ACL
-// RESTTOKEN command with a valid Redis username and password. Using this
+// its APIToken field), the ones listed in its ExtraAPITokens and
+// ReadOnlyAPITokens fields, and any other token generated by executing the
+// ACL RESTTOKEN command with a valid Redis username and password. Using this
 // token results in executing the command(s) as this user, with their access
-// rights and restrictions. See [2] and [3] for more details.
+// rights and restrictions. See [2] and [3] for more details. If the server
+// has a RestTokenSecret, those tokens are derived from the credentials and
+// that secret instead of being random, so they survive server restarts.
+// Otherwise, the random tokens are kept in the TokenStore of the server (in
+// memory by default, see FileTokenStore to persist them), expire after the
+// RestTokenTTL, if set, and an admin API token can list them with ACL
+// RESTTOKEN LIST and revoke one with ACL RESTTOKEN DEL <token>.
+//
+// A read-only API token can only execute commands that do not modify the
+// database, like the read-only token of Upstash databases. Other commands
+// fail with a NOPERM error.
+//
+// Transactions
+//
+// Like the Upstash Redis REST API, the /multi-exec endpoint executes the
+// commands in the body atomically, in a MULTI/EXEC transaction. If a command
+// fails to be queued, the whole transaction is discarded.
+//
+// Command statistics
+//
+// The /admin/commandstats endpoint returns the number of calls, failed calls
+// and latencies of each command executed since the server started serving
+// commands, similar to Redis' INFO commandstats. Transactions are reported
+// as a whole under the "multi-exec" name. Only admin API tokens can access
+// it.
+//
+// Subscriptions
+//
+// A GET or POST request to /subscribe/<channel> subscribes to that channel
+// and streams the messages published to it as server-sent events, until the
+// client disconnects. The data of each event is the comma-separated message
+// received from Redis, starting with the confirmation of the subscription,
+// e.g. "subscribe,<channel>,1" followed by "message,<channel>,<payload>"
+// events. Similarly, /psubscribe/<pattern> subscribes to the channels that
+// match the pattern, with "pmessage,<pattern>,<channel>,<payload>" events.
+// See the GetSubscriberConnFunc field for the connection used.
+//
+// Monitoring
+//
+// With an admin API token, a GET or POST request to /monitor streams the
+// commands received by the Redis server, as reported by the MONITOR
+// command, and a request to /monitor/keyspace/<pattern> streams the keyspace
+// notifications of the keys that match the pattern (the
+// notify-keyspace-events configuration of the Redis server must enable
+// them). Each event is a JSON object, sent as a server-sent event, or as a
+// line of newline-delimited JSON if the Accept request header contains
+// application/x-ndjson. Those endpoints use the same connections as the
+// subscriptions.
+//
+// Pagination
+//
+// If the MaxArrayReply or MaxReplyBytes fields of the Server are set, the
+// replies of the read-only range commands LRANGE, ZRANGE, ZREVRANGE,
+// SMEMBERS and HGETALL, executed as single commands, are paginated, and the
+// X-Redis-Cursor response header holds the cursor of the next page. Sending
+// the same request with that cursor in the _cursor query string parameter
+// (or the X-Redis-Cursor request header) returns the next page. Only the
+// elements of the page are requested from Redis: the range of LRANGE,
+// ZRANGE and ZREVRANGE is shifted (ZRANGE is paginated only in its index
+// form), and SMEMBERS and HGETALL are executed with SSCAN and HSCAN, so
+// their pages may exceed the limits for small keys and may repeat elements.
+// The pairs of elements returned by HGETALL and WITHSCORES are never split
+// across pages. There is no consistency guarantee between pages, and the
+// replies of the other commands are never truncated.
+//
+// Binary values
+//
+// Like the Upstash Redis REST API, if the Upstash-Encoding request header is
+// set to "base64", the string values of the results are base64-encoded, so
+// that binary values that are not valid UTF-8 are returned intact.
+//
+// Database selection
+//
+// Upstash databases do not support multiple logical databases, but for
+// local setups that use them, a request can select the database on which
+// its commands are executed via the _db query string parameter or the
+// X-Redis-DB header, if that database is listed in the AllowedDBs field of
+// the Server.
+//
+// Browser clients
+//
+// To use the server from a browser, e.g. with the @upstash/redis client
+// during development, set the CORSOrigins field of the Server to the origins
+// of the web pages allowed to send requests (or "*" for any origin).
 //
 //     [1]: https://docs.upstash.com/redis/features/restapi
 //     [2]: https://redis.io/docs/manual/security/acl/
@@ -27,15 +111,19 @@
 package restserver
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/wI2L/jettison"
 )
@@ -57,27 +145,203 @@ type Server struct {
 	// requests, unless ACL RESTTOKEN is used to generate other valid API tokens.
 	APIToken string
 
+	// ExtraAPITokens is an optional list of additional API tokens that have
+	// the same rights as the APIToken.
+	ExtraAPITokens []string
+
+	// ReadOnlyAPITokens is an optional list of API tokens that can only execute
+	// read-only commands.
+	ReadOnlyAPITokens []string
+
 	// GetConnFunc must be set to a function that returns a Conn value to execute
 	// the actual commands against a Redis database. The redigo package is
 	// straightforward to use with this function signature.
 	GetConnFunc func(context.Context) Conn
 
-	mu         sync.Mutex // protects the rest token map
-	restTokens map[string]auth
+	// GetSubscriberConnFunc is an optional function that returns a
+	// SubscriberConn value to subscribe to channels for the /subscribe and
+	// /psubscribe endpoints. The connection is held for as long as the client
+	// stays connected. If nil, the connection returned by GetConnFunc is used
+	// if it implements SubscriberConn (redigo connections do, including the
+	// ones from a redigo Pool), otherwise those endpoints are not supported.
+	GetSubscriberConnFunc func(context.Context) SubscriberConn
+
+	// DialConnFunc is an optional function that returns a new connection to
+	// the Redis database, dedicated to the requests authenticated with a
+	// token generated by ACL RESTTOKEN. If set, the server keeps a pool of
+	// idle connections per token, already authenticated with the credentials
+	// of that token, so that its requests do not need to authenticate a
+	// connection returned by GetConnFunc first. As for GetConnFunc, a failure
+	// to connect can be reported by returning a Conn that fails with that
+	// error. If nil, those requests use GetConnFunc.
+	DialConnFunc func(context.Context) Conn
+
+	// MaxIdleUserConns is the maximum number of idle connections kept in the
+	// pool of each token when DialConnFunc is set, the others are closed when
+	// released. If <= 0, a single idle connection is kept per token.
+	MaxIdleUserConns int
+
+	// UserConnIdleTimeout is the duration after which an idle connection of
+	// the pool of a token is closed, which is checked when a connection is
+	// requested from the pools. If <= 0, the idle connections are not closed.
+	UserConnIdleTimeout time.Duration
+
+	// AllowedDBs is the list of Redis logical databases that a request can
+	// select. If empty, only the DefaultDB can be used.
+	AllowedDBs []int
+
+	// DefaultDB is the Redis logical database used by the connections returned
+	// by GetConnFunc. After a request that selected a different database, the
+	// connection is reset to that database.
+	DefaultDB int
+
+	// RestTokenSecret is an optional secret used to derive the tokens
+	// generated by ACL RESTTOKEN. If set, the tokens are derived
+	// deterministically from the username, password and secret, so that they
+	// remain valid across restarts and are accepted by all servers configured
+	// with the same secret. Otherwise, a random token is generated and only
+	// remembered in memory by this server.
+	RestTokenSecret string
+
+	// RestTokenTTL is the time after which the tokens generated by ACL
+	// RESTTOKEN expire. If <= 0, they never expire. It does not apply to the
+	// tokens derived from the RestTokenSecret, which are valid for as long as
+	// the credentials are.
+	RestTokenTTL time.Duration
+
+	// TokenStore is the store of the tokens generated by ACL RESTTOKEN. If
+	// nil, they are stored in memory and are lost when the server stops. It
+	// is not used for the tokens derived from the RestTokenSecret, which are
+	// not stored.
+	TokenStore TokenStore
+
+	// MaxArrayReply is the maximum number of elements returned in a page of
+	// the paginated range commands (see the Pagination section of the
+	// package documentation). If the reply has more elements, the cursor to
+	// request the next page is returned in the X-Redis-Cursor response
+	// header. If <= 0, there is no limit.
+	MaxArrayReply int
+
+	// MaxReplyBytes is the approximate maximum size in bytes of a page of the
+	// paginated range commands, as described for MaxArrayReply. It does not
+	// apply to SMEMBERS and HGETALL, and at least one element (or pair) is
+	// always returned. If MaxArrayReply is not set, the pages are requested
+	// from Redis by 1000 elements. If <= 0, there is no limit.
+	MaxReplyBytes int
+
+	// MaxBodyBytes is the maximum size in bytes of the body of a request. A
+	// request with a larger body is rejected with a 413 status code. If <= 0,
+	// there is no limit.
+	MaxBodyBytes int64
+
+	// RequestTimeout is the maximum duration of the round trips to Redis to
+	// execute the commands of a request, including the time to get a
+	// connection from GetConnFunc, which receives a context with that
+	// deadline. It is enforced if the Conn implements a DoContext or a
+	// DoWithTimeout method (redigo connections do), and a command that times
+	// out fails with a 504 status code. If <= 0, there is no timeout. It does
+	// not apply to the subscription endpoints.
+	RequestTimeout time.Duration
+
+	// AllowCommands is an optional list of the commands that can be executed.
+	// If it is not empty, the other commands are rejected with an error. An
+	// entry is either a command name (e.g. "get") or a command and its
+	// subcommand separated by "|" (e.g. "config|get"), case-insensitive.
+	//
+	// Note that the lists only apply to the commands of the requests: a
+	// script executed with EVAL, EVALSHA or FCALL (and their read-only
+	// variants) can execute any command with redis.call. Allowing those
+	// commands allows all commands.
+	AllowCommands []string
+
+	// DenyCommands is an optional list of the commands that cannot be
+	// executed, in the same format as AllowCommands, e.g. "flushall",
+	// "config", "shutdown" and "keys". It applies after AllowCommands, so
+	// that a command in both lists is rejected. The lists apply to the
+	// commands of all requests, including pipelines, transactions and
+	// subscriptions, regardless of the API token used. If it is not empty,
+	// the scripting commands (see AllowCommands) are also rejected unless
+	// they are explicitly listed in AllowCommands, as the scripts could
+	// execute the denied commands.
+	DenyCommands []string
+
+	// CORSOrigins is the list of origins allowed to send cross-origin requests,
+	// e.g. from a browser, "*" allowing any origin. If empty, cross-origin
+	// requests are not allowed. The preflight OPTIONS requests from the
+	// allowed origins are served without authentication.
+	CORSOrigins []string
+
+	// CORSHeaders is an optional list of the request headers allowed in
+	// cross-origin requests, in addition to the headers used by the server
+	// (Authorization, Content-Type, X-Redis-DB, X-Redis-Cursor and the
+	// headers starting with "Upstash-").
+	CORSHeaders []string
+
+	// Notify is an optional function called when a notable event occurs, such
+	// as an authentication failure or the execution of a destructive command.
+	// It is called synchronously while serving the request, so it should not
+	// block. The Notify method of the Webhook type can be used to send the
+	// events to a webhook URL.
+	Notify func(Event)
+
+	// Logger is an optional logger for the messages of the server, such as
+	// the authentication failures and the failures to reach the Redis
+	// server, and the execution of each command (at LevelDebug). If nil,
+	// nothing is logged.
+	Logger Logger
+
+	// AccessLog enables the logging of an access log entry (at LevelInfo)
+	// with the Logger for each request served, with its method, path,
+	// status code, duration, remote address, ACL username and the names of
+	// the commands executed. The arguments of the commands are never logged.
+	AccessLog bool
+
+	memTokens memoryTokenStore // the token store if TokenStore is nil
+	userConns userConnPools
+
+	stats commandStats
+
+	metricsOnce sync.Once
+	metricsv    *serverMetrics
 }
 
 type auth struct {
 	Username string
 	Password string
+	ReadOnly bool
 }
 
 // ServeHTTP implements the http.Handler for the REST API server.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	userPass, ok := s.authenticate(requestToken(r))
+	if s.Logger == nil || !s.AccessLog {
+		s.serveHTTP(w, r, nil)
+		return
+	}
+
+	l := &requestLog{ResponseWriter: w, start: time.Now()}
+	s.serveHTTP(l, r, l)
+	s.logAccess(r, l)
+}
+
+// serveHTTP serves the request, recording the information of the access log
+// in l if it is not nil.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request, l *requestLog) {
+	if s.serveCORS(w, r) {
+		return
+	}
+
+	tok := requestToken(r)
+	userPass, ok := s.authenticate(r.Context(), tok)
 	if !ok {
+		s.notify(Event{Type: EventAuthFailure, RemoteAddr: r.RemoteAddr})
+		s.log(LevelWarn, "authentication failed", "remote_addr", r.RemoteAddr)
+		s.metrics().authFailures.With().Inc()
 		reply(w, errorResult{"Unauthorized"}, http.StatusUnauthorized)
 		return
 	}
+	if l != nil {
+		l.username = userPass.Username
+	}
 
 	// only GET or POST methods are allowed
 	if r.Method != "GET" && r.Method != "POST" {
@@ -85,19 +349,85 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// read the full body, we need to know if there is one, and if so we need it
-	// all.
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		reply(w, errorResult{err.Error()}, http.StatusInternalServerError)
+	if strings.TrimSuffix(r.URL.Path, "/") == "/admin/commandstats" {
+		s.serveCommandStats(w, userPass)
+		return
+	}
+	if cmd, channel, ok := subscribeRoute(r.URL.Path); ok {
+		s.serveSubscribe(w, r, userPass, cmd, channel)
+		return
+	}
+	if pattern, ok := monitorRoute(r.URL.Path); ok {
+		s.serveMonitor(w, r, userPass, pattern)
+		return
+	}
+
+	// the commands of pipelines and transactions are decoded as the body is
+	// read, otherwise read the full body, we need to know if there is one, and
+	// if so we need it all. Errors to parse the commands are reported after the
+	// connection is authenticated and the database selected, so that those
+	// errors take precedence.
+	var (
+		body    []byte
+		cmds    [][]interface{}
+		bodyErr error
+		lines   lineIndex
+	)
+	bodyr := io.TeeReader(&limitReader{r: r.Body, n: s.maxBodyBytes()}, &lines)
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/pipeline", "/multi-exec":
+		cmds, bodyErr = decodeCommands(bodyr)
+	default:
+		if body, bodyErr = io.ReadAll(bodyr); bodyErr != nil && !errors.Is(bodyErr, errBodyTooLarge) {
+			reply(w, errorResult{bodyErr.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+	if errors.Is(bodyErr, errBodyTooLarge) {
+		reply(w, errorResult{"ERR request body too large"}, http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	conn := s.GetConnFunc(r.Context())
-	defer conn.Close()
+	ctx := r.Context()
+	if s.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+	}
+
+	// the requests authenticated with a REST token use the connections of the
+	// pool of that token, if enabled, that are already authenticated.
+	var rawConn Conn
+	userConn := s.userConnsEnabled(userPass)
+	if userConn {
+		conn, v, code := s.getUserConn(ctx, tok, userPass)
+		if code != http.StatusOK {
+			reply(w, v, code)
+			return
+		}
+		rawConn = conn
+		defer s.putUserConn(tok, rawConn)
+	} else {
+		rawConn = s.GetConnFunc(ctx)
+		defer rawConn.Close()
+	}
+	conns := s.metrics().conns.With()
+	conns.Add(1)
+	defer conns.Add(-1)
+
+	conn := rawConn
+	if s.RequestTimeout > 0 {
+		conn = timeoutConn{Conn: conn, ctx: ctx}
+	}
+	if s.Notify != nil {
+		conn = notifyConn{Conn: conn, s: s, a: userPass}
+	}
+	if s.Logger != nil {
+		conn = logConn{Conn: conn, s: s, l: l}
+	}
 
 	// might need to authenticate the connection with the proper user-password
-	if userPass != (auth{}) {
+	if userPass.Username != "" && !userConn {
 		vAuth, code := s.execCmd(conn, "AUTH", userPass.Username, userPass.Password)
 		if code != http.StatusOK {
 			reply(w, vAuth, code)
@@ -105,6 +435,29 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// might need to select a different database for this request
+	if v := requestDB(r); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil {
+			reply(w, errorResult{"ERR invalid DB index"}, http.StatusBadRequest)
+			return
+		}
+		if db != s.DefaultDB {
+			if !s.dbAllowed(db) {
+				reply(w, errorResult{"ERR DB index is not allowed"}, http.StatusForbidden)
+				return
+			}
+			vSel, code := s.execCmd(conn, "SELECT", db)
+			if code != http.StatusOK {
+				reply(w, vSel, code)
+				return
+			}
+			// reset the database before the connection is closed (returned to the
+			// pool), regardless of the request timeout
+			defer func() { _, _ = rawConn.Do("SELECT", s.DefaultDB) }()
+		}
+	}
+
 	// both GET and POST are supported regardless of how data is sent (path,
 	// body, query string). We switch on the path with any trailing slash
 	// removed.
@@ -113,26 +466,31 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		var args []interface{}
 
 		// a full single command in the body (a single array)
-		if err := json.Unmarshal(body, &args); err != nil {
-			reply(w, errorResult{"ERR failed to parse command"}, http.StatusBadRequest)
+		if err := unmarshalBody(body, &args); err != nil {
+			reply(w, parseError("command", commandHint, &lines, err), http.StatusBadRequest)
 			return
 		}
 		if len(args) == 0 {
 			reply(w, errorResult{"ERR empty command"}, http.StatusBadRequest)
 			return
 		}
+		if _, ok := args[0].([]interface{}); ok {
+			reply(w, errorResult{"ERR failed to parse command: got an array of arrays; " + commandHint + ", use the /pipeline endpoint to execute multiple commands"}, http.StatusBadRequest)
+			return
+		}
 
 		cmd := fmt.Sprint(args[0])
-		v, code := s.execCmd(conn, cmd, args[1:]...)
-		reply(w, v, code)
+		v, code, paged := s.execPagedCmd(w, r, conn, cmd, args[1:])
+		if !paged {
+			v, code = s.execUserCmd(ctx, conn, userPass, cmd, args[1:]...)
+		}
+		reply(w, encodeResults(r, v), code)
 		return
 
 	case "/pipeline":
-		var cmds [][]interface{}
-
 		// multiple full commands in the body (an array of arrays)
-		if err := json.Unmarshal(body, &cmds); err != nil {
-			reply(w, errorResult{"ERR failed to parse pipeline request"}, http.StatusBadRequest)
+		if bodyErr != nil {
+			reply(w, parseError("pipeline request", pipelineHint, &lines, bodyErr), http.StatusBadRequest)
 			return
 		}
 		if len(cmds) == 0 {
@@ -148,10 +506,25 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			cmdName := fmt.Sprint(cmd[0])
-			v, _ := s.execCmd(conn, cmdName, cmd[1:]...)
+			v, _ := s.execUserCmd(ctx, conn, userPass, cmdName, cmd[1:]...)
 			results = append(results, v)
 		}
-		reply(w, results, http.StatusOK)
+		reply(w, encodeResults(r, results), http.StatusOK)
+		return
+
+	case "/multi-exec":
+		// multiple full commands in the body (an array of arrays)
+		if bodyErr != nil {
+			reply(w, parseError("transaction request", pipelineHint, &lines, bodyErr), http.StatusBadRequest)
+			return
+		}
+		if len(cmds) == 0 {
+			reply(w, errorResult{"ERR empty transaction request"}, http.StatusBadRequest)
+			return
+		}
+
+		v, code := s.execUserTx(conn, userPass, cmds)
+		reply(w, encodeResults(r, v), code)
 		return
 
 	default:
@@ -172,8 +545,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				// if the query key has a value, then it becomes 2 redis arguments, e.g.
 				// EX=100.
 				kv := strings.SplitN(qpart, "=", 2)
-				// ignore the _token query parameter, this is not part of the command
-				if kv[0] == "_token" {
+				// ignore the _token, _db and _cursor query parameters, they are not
+				// part of the command
+				if kv[0] == "_token" || kv[0] == "_db" || kv[0] == "_cursor" {
 					continue
 				}
 				segments = append(segments, kv...)
@@ -184,12 +558,68 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		for i, v := range segments[1:] {
 			args[i] = v
 		}
-		v, code := s.execCmd(conn, segments[0], args...)
-		reply(w, v, code)
+		v, code, paged := s.execPagedCmd(w, r, conn, segments[0], args)
+		if !paged {
+			v, code = s.execUserCmd(ctx, conn, userPass, segments[0], args...)
+		}
+		reply(w, encodeResults(r, v), code)
 		return
 	}
 }
 
+// unmarshalBody unmarshals the JSON body into v, decoding the numbers as
+// json.Number so that they are sent to Redis as-is (e.g. large integers
+// would otherwise be sent in exponent format).
+func unmarshalBody(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// hints about the expected shape of the body, added to the parse errors.
+const (
+	commandHint  = `expected a JSON array of the command and its arguments, e.g. ["SET", "key", "value"]`
+	pipelineHint = `expected a JSON array of commands, each one a JSON array, e.g. [["SET", "key", "value"], ["GET", "key"]]`
+)
+
+// parseError returns the error result for a body that failed to parse as
+// what, with the position of the error in the body, if known, and the hint
+// about the expected shape of the body.
+func parseError(what, hint string, lines *lineIndex, err error) errorResult {
+	var (
+		msg    string
+		offset int64 = -1
+		synErr *json.SyntaxError
+		typErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		msg = "empty body"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		msg = "unexpected end of JSON input"
+	case errors.As(err, &synErr):
+		msg = synErr.Error()
+		offset = synErr.Offset - 1 // offset is after the invalid byte
+	case errors.As(err, &typErr):
+		msg = fmt.Sprintf("unexpected JSON %s", typErr.Value)
+		offset = typErr.Offset
+	default:
+		msg = err.Error()
+	}
+
+	if offset >= 0 {
+		line, col := lines.lineCol(offset)
+		msg += fmt.Sprintf(" at line %d, column %d (offset %d)", line, col, offset)
+	}
+	return errorResult{Error: fmt.Sprintf("ERR failed to parse %s: %s; %s", what, msg, hint)}
+}
+
 type errorResult struct {
 	Error string `json:"error"`
 }
@@ -198,21 +628,133 @@ type successResult struct {
 	Result interface{} `json:"result"`
 }
 
-func (s *Server) execCmd(conn Conn, cmd string, args ...interface{}) (interface{}, int) {
-	if strings.ToLower(cmd) == "acl" && len(args) > 0 && strings.ToLower(fmt.Sprint(args[0])) == "resttoken" {
-		return s.execACLRestToken(conn, cmd, args...)
+// execUserCmd executes the command on behalf of the authenticated user,
+// checking first that this user is allowed to run it.
+func (s *Server) execUserCmd(ctx context.Context, conn Conn, a auth, cmd string, args ...interface{}) (interface{}, int) {
+	if a.ReadOnly && !isReadOnlyCmd(cmd) {
+		s.recordCmd(cmd, 0, true)
+		return errorResult{Error: fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(cmd))}, http.StatusBadRequest
+	}
+	if !s.commandAllowed(cmd, args) {
+		s.recordCmd(cmd, 0, true)
+		return notAllowedError(cmd), http.StatusBadRequest
+	}
+
+	start := time.Now()
+	var (
+		v    interface{}
+		code int
+	)
+	if isRestTokenCmd(cmd, args) {
+		v, code = s.execACLRestToken(ctx, conn, a, args...)
+	} else {
+		v, code = s.execCmd(conn, cmd, args...)
+	}
+	s.recordCmd(cmd, time.Since(start), code != http.StatusOK)
+	return v, code
+}
+
+// execUserTx executes the commands in a MULTI/EXEC transaction on behalf of
+// the authenticated user. If any command is not allowed or fails to be
+// queued, the whole transaction is discarded and an error is returned,
+// otherwise the result of each command is returned.
+func (s *Server) execUserTx(conn Conn, a auth, cmds [][]interface{}) (interface{}, int) {
+	// the statistics are recorded for the transaction as a whole
+	start := time.Now()
+	v, code := s.execTx(conn, a, cmds)
+	s.recordCmd(txStatsName, time.Since(start), code != http.StatusOK)
+	return v, code
+}
+
+func (s *Server) execTx(conn Conn, a auth, cmds [][]interface{}) (interface{}, int) {
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			return errorResult{"ERR empty transaction command"}, http.StatusBadRequest
+		}
+		name := fmt.Sprint(cmd[0])
+		if a.ReadOnly && !isReadOnlyCmd(name) {
+			return errorResult{Error: fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(name))}, http.StatusBadRequest
+		}
+		if !s.commandAllowed(name, cmd[1:]) {
+			return notAllowedError(name), http.StatusBadRequest
+		}
+	}
+
+	if _, err := conn.Do("MULTI"); err != nil {
+		return errorResult{Error: err.Error()}, http.StatusBadRequest
+	}
+	for _, cmd := range cmds {
+		if _, err := conn.Do(fmt.Sprint(cmd[0]), cmd[1:]...); err != nil {
+			_, _ = conn.Do("DISCARD")
+			return errorResult{Error: err.Error()}, http.StatusBadRequest
+		}
+	}
+	res, err := conn.Do("EXEC")
+	if err != nil {
+		return errorResult{Error: err.Error()}, http.StatusBadRequest
+	}
+
+	vals, _ := res.([]interface{})
+	results := make([]interface{}, 0, len(vals))
+	for _, v := range vals {
+		if err, ok := v.(error); ok {
+			results = append(results, errorResult{Error: err.Error()})
+			continue
+		}
+		results = append(results, successResult{Result: normalizeReply(v)})
 	}
+	return results, http.StatusOK
+}
 
+func (s *Server) execCmd(conn Conn, cmd string, args ...interface{}) (interface{}, int) {
 	res, err := conn.Do(cmd, args...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errorResult{Error: "ERR request timed out"}, http.StatusGatewayTimeout
+	}
 	if err != nil {
 		return errorResult{Error: err.Error()}, http.StatusBadRequest
 	}
-	return successResult{Result: res}, http.StatusOK
+	return successResult{Result: normalizeReply(res)}, http.StatusOK
 }
 
-func (s *Server) execACLRestToken(conn Conn, _ string, args ...interface{}) (interface{}, int) {
-	if len(args) != 3 { // RESTTOKEN <username> <password>
-		return errorResult{Error: "ERR invalid syntax. Usage: ACL RESTTOKEN username password"}, http.StatusBadRequest
+// isRestTokenCmd returns true if the command is ACL RESTTOKEN, which is
+// executed by the server.
+func isRestTokenCmd(cmd string, args []interface{}) bool {
+	return strings.EqualFold(cmd, "acl") && len(args) > 0 && strings.EqualFold(fmt.Sprint(args[0]), "resttoken")
+}
+
+// execACLRestToken executes the ACL RESTTOKEN command, the args starting
+// with RESTTOKEN:
+//
+//	ACL RESTTOKEN username password: generate a token for the credentials
+//	ACL RESTTOKEN LIST: list the generated tokens (admin only)
+//	ACL RESTTOKEN DEL token: revoke a generated token (admin only)
+func (s *Server) execACLRestToken(ctx context.Context, conn Conn, a auth, args ...interface{}) (interface{}, int) {
+	switch {
+	case len(args) == 2 && strings.EqualFold(fmt.Sprint(args[1]), "list"):
+		if a.ReadOnly || a.Username != "" {
+			return errorResult{"NOPERM this user has no permissions to manage the REST tokens"}, http.StatusForbidden
+		}
+		return s.listRestTokens(ctx)
+
+	case len(args) == 3 && strings.EqualFold(fmt.Sprint(args[1]), "del"):
+		if a.ReadOnly || a.Username != "" {
+			return errorResult{"NOPERM this user has no permissions to manage the REST tokens"}, http.StatusForbidden
+		}
+		token := fmt.Sprint(args[2])
+		ok, err := s.tokenStore().Delete(ctx, token)
+		if err != nil {
+			return errorResult{Error: "ERR " + err.Error()}, http.StatusInternalServerError
+		}
+		// the connections of the token must not be used anymore
+		s.dropUserConns(token)
+		if ok {
+			return successResult{Result: 1}, http.StatusOK
+		}
+		return successResult{Result: 0}, http.StatusOK
+
+	case len(args) != 3: // RESTTOKEN <username> <password>
+		return errorResult{Error: "ERR invalid syntax. Usage: ACL RESTTOKEN username password | LIST | DEL token"}, http.StatusBadRequest
 	}
 
 	user, pwd := fmt.Sprint(args[1]), fmt.Sprint(args[2])
@@ -224,26 +766,75 @@ func (s *Server) execACLRestToken(conn Conn, _ string, args ...interface{}) (int
 	}
 
 	// auth succeeded, generate the associated token
+	if s.RestTokenSecret != "" {
+		token, err := sealRestToken(s.RestTokenSecret, user, pwd)
+		if err != nil {
+			return errorResult{Error: err.Error()}, http.StatusInternalServerError
+		}
+		return successResult{Result: token}, http.StatusOK
+	}
+
 	var buf [48]byte
 	if _, err := rand.Read(buf[:]); err != nil {
 		return errorResult{Error: err.Error()}, http.StatusInternalServerError
 	}
-	token := base64.URLEncoding.EncodeToString(buf[:])
 
-	s.mu.Lock()
-	if s.restTokens == nil {
-		s.restTokens = make(map[string]auth)
+	tok := RestToken{
+		Token:     base64.URLEncoding.EncodeToString(buf[:]),
+		Username:  user,
+		Password:  pwd,
+		CreatedAt: time.Now().UTC(),
+	}
+	if s.RestTokenTTL > 0 {
+		tok.ExpiresAt = tok.CreatedAt.Add(s.RestTokenTTL)
+	}
+	if err := s.tokenStore().Set(ctx, tok); err != nil {
+		return errorResult{Error: "ERR " + err.Error()}, http.StatusInternalServerError
+	}
+	return successResult{Result: tok.Token}, http.StatusOK
+}
+
+// listRestTokens returns the generated tokens that are not expired, each
+// as an array of alternating field names and values, the times being Unix
+// timestamps in seconds (0 if the token never expires).
+func (s *Server) listRestTokens(ctx context.Context) (interface{}, int) {
+	toks, err := s.tokenStore().List(ctx)
+	if err != nil {
+		return errorResult{Error: "ERR " + err.Error()}, http.StatusInternalServerError
+	}
+
+	now := time.Now()
+	list := make([]interface{}, 0, len(toks))
+	for _, tok := range toks {
+		if tok.expired(now) {
+			continue
+		}
+		var exp int64
+		if !tok.ExpiresAt.IsZero() {
+			exp = tok.ExpiresAt.Unix()
+		}
+		list = append(list, []interface{}{
+			"token", tok.Token,
+			"username", tok.Username,
+			"created_at", tok.CreatedAt.Unix(),
+			"expires_at", exp,
+		})
 	}
-	s.restTokens[token] = auth{user, pwd}
-	s.mu.Unlock()
+	return successResult{Result: list}, http.StatusOK
+}
 
-	return successResult{Result: token}, http.StatusOK
+// tokenStore returns the store of the generated tokens.
+func (s *Server) tokenStore() TokenStore {
+	if s.TokenStore != nil {
+		return s.TokenStore
+	}
+	return &s.memTokens
 }
 
 func reply(w http.ResponseWriter, v interface{}, status int) {
 	var body []byte
 	if v != nil {
-		b, err := jettison.MarshalOpts(v, jettison.NoUTF8Coercion(), jettison.RawByteSlice())
+		b, err := marshalReply(v)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -258,6 +849,12 @@ func reply(w http.ResponseWriter, v interface{}, status int) {
 	}
 }
 
+// marshalReply returns the JSON encoding of the reply v, the byte slices
+// being encoded as strings without coercion to valid UTF-8.
+func marshalReply(v interface{}) ([]byte, error) {
+	return jettison.MarshalOpts(v, jettison.NoUTF8Coercion(), jettison.RawByteSlice())
+}
+
 func requestToken(r *http.Request) string {
 	// token is either in Authorization header or _token query string
 	tok := r.URL.Query().Get("_token")
@@ -267,18 +864,66 @@ func requestToken(r *http.Request) string {
 	return tok
 }
 
-func (s *Server) authenticate(tok string) (auth, bool) {
+// dbHeader is the request header that selects the database, as an
+// alternative to the _db query string parameter.
+const dbHeader = "X-Redis-DB"
+
+func requestDB(r *http.Request) string {
+	// db is either in the _db query string or the X-Redis-DB header
+	db := r.URL.Query().Get("_db")
+	if db == "" {
+		db = r.Header.Get(dbHeader)
+	}
+	return db
+}
+
+func (s *Server) maxBodyBytes() int64 {
+	if s.MaxBodyBytes <= 0 {
+		return -1
+	}
+	return s.MaxBodyBytes
+}
+
+func (s *Server) dbAllowed(db int) bool {
+	for _, v := range s.AllowedDBs {
+		if v == db {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) authenticate(ctx context.Context, tok string) (auth, bool) {
 	if tok == s.APIToken {
 		return auth{}, true
 	}
+	for _, t := range s.ExtraAPITokens {
+		if tok == t {
+			return auth{}, true
+		}
+	}
+	for _, t := range s.ReadOnlyAPITokens {
+		if tok == t {
+			return auth{ReadOnly: true}, true
+		}
+	}
 
 	// else look for ACL RESTTOKEN authentication...
-	s.mu.Lock()
-	userPass, ok := s.restTokens[tok]
-	s.mu.Unlock()
+	if s.RestTokenSecret != "" {
+		user, pwd, err := openRestToken(s.RestTokenSecret, tok)
+		if err != nil {
+			return auth{}, false
+		}
+		return auth{Username: user, Password: pwd}, true
+	}
 
-	if !ok {
+	rt, ok, err := s.tokenStore().Get(ctx, tok)
+	if err != nil {
+		s.log(LevelError, "token store failed", "error", err)
+		return auth{}, false
+	}
+	if !ok || rt.expired(time.Now()) {
 		return auth{}, false
 	}
-	return userPass, true
+	return auth{Username: rt.Username, Password: rt.Password}, true
 }
@@ -1,14 +1,25 @@
 package restserver
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +28,9 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// rawBody is a request body sent as-is instead of being encoded as JSON.
+type rawBody string
+
 type result struct {
 	Result  interface{} `json:"result"`
 	Results []result    `json:"results"`
@@ -32,9 +46,11 @@ func TestServerMiniredis(t *testing.T) {
 	}
 	defer pool.Close()
 
-	const goodToken, badToken = "_token_", "_badtoken_"
+	const goodToken, badToken, extraToken, roToken = "_token_", "_badtoken_", "_extratoken_", "_rotoken_"
 	server := &Server{
-		APIToken: goodToken,
+		APIToken:          goodToken,
+		ExtraAPITokens:    []string{extraToken},
+		ReadOnlyAPITokens: []string{roToken},
 		GetConnFunc: func(ctx context.Context) Conn {
 			return pool.Get()
 		},
@@ -69,6 +85,29 @@ func TestServerMiniredis(t *testing.T) {
 		require.Equal(t, res.Result, "a")
 	})
 
+	t.Run("extra token", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, extraToken, "/set/ro/1", nil, "")
+		require.Empty(t, res.Error)
+		require.Equal(t, res.Result, "OK")
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, roToken, "/get/ro", nil, "")
+		require.Empty(t, res.Error)
+		require.Equal(t, res.Result, "1")
+
+		res = makeRequest(t, http.StatusBadRequest, roToken, "/set/ro/2", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+
+		res = makeRequest(t, http.StatusOK, roToken, "/pipeline", [][]interface{}{{"GET", "ro"}, {"INCR", "ro"}}, "")
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "1", res.Results[0].Result)
+		require.Contains(t, res.Results[1].Error, "NOPERM")
+
+		res = makeRequest(t, http.StatusBadRequest, roToken, "/multi-exec", [][]interface{}{{"GET", "ro"}, {"INCR", "ro"}}, "")
+		require.Contains(t, res.Error, "NOPERM")
+	})
+
 	t.Run("no command", func(t *testing.T) {
 		res := makeRequest(t, http.StatusBadRequest, goodToken, "/", nil, "")
 		require.Contains(t, res.Error, "failed to parse command")
@@ -79,6 +118,30 @@ func TestServerMiniredis(t *testing.T) {
 		require.Contains(t, res.Error, "failed to parse command")
 	})
 
+	t.Run("syntax error in command", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/", rawBody("[\"SET\",\n \"a\" \"b\"]"), "")
+		require.Contains(t, res.Error, "failed to parse command: invalid character")
+		require.Contains(t, res.Error, "at line 2, column 6 (offset 13)")
+		require.Contains(t, res.Error, `expected a JSON array of the command and its arguments`)
+	})
+
+	t.Run("pipeline sent as command", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/", [][]string{{"GET", "a"}}, "")
+		require.Contains(t, res.Error, "got an array of arrays")
+		require.Contains(t, res.Error, "/pipeline")
+	})
+
+	t.Run("command sent as pipeline", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/pipeline", []string{"GET", "a"}, "")
+		require.Contains(t, res.Error, "failed to parse pipeline request: unexpected JSON string at line 1")
+		require.Contains(t, res.Error, "expected a JSON array of commands, each one a JSON array")
+	})
+
+	t.Run("truncated transaction", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/multi-exec", rawBody(`[["GET", "a"]`), "")
+		require.Contains(t, res.Error, "failed to parse transaction request: unexpected end of JSON input")
+	})
+
 	t.Run("empty command", func(t *testing.T) {
 		res := makeRequest(t, http.StatusBadRequest, goodToken, "/", []interface{}{}, "")
 		require.Contains(t, res.Error, "empty command")
@@ -139,6 +202,16 @@ func TestServerMiniredis(t *testing.T) {
 		require.Equal(t, res.Result, "a")
 	})
 
+	t.Run("body command with numbers", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/", []interface{}{"echo", 86400000}, "")
+		require.Empty(t, res.Error)
+		require.Equal(t, res.Result, "86400000")
+
+		res = makeRequest(t, http.StatusOK, goodToken, "/", []interface{}{"echo", 1.5}, "")
+		require.Empty(t, res.Error)
+		require.Equal(t, res.Result, "1.5")
+	})
+
 	t.Run("no pipeline command", func(t *testing.T) {
 		res := makeRequest(t, http.StatusBadRequest, goodToken, "/pipeline", nil, "")
 		require.Contains(t, res.Error, "failed to parse pipeline request")
@@ -177,6 +250,28 @@ func TestServerMiniredis(t *testing.T) {
 		require.Equal(t, res.Results[2], result{Result: []interface{}{"a", "1", "b", "2"}})
 	})
 
+	t.Run("empty transaction", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/multi-exec", [][]interface{}{}, "")
+		require.Contains(t, res.Error, "empty transaction request")
+	})
+
+	t.Run("valid transaction", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/multi-exec", [][]interface{}{{"SET", "tx", "1"}, {"INCR", "tx"}, {"HGETALL", "tx"}, {"GET", "tx"}}, "")
+		require.Len(t, res.Results, 4)
+		require.Equal(t, "OK", res.Results[0].Result)
+		require.Equal(t, float64(2), res.Results[1].Result)
+		require.Contains(t, res.Results[2].Error, "WRONGTYPE")
+		require.Equal(t, "2", res.Results[3].Result)
+	})
+
+	t.Run("discarded transaction", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/multi-exec", [][]interface{}{{"SET", "tx", "3"}, {"NOTACMD"}}, "")
+		require.NotEmpty(t, res.Error)
+		v, err := redsrv.Get("tx")
+		require.NoError(t, err)
+		require.Equal(t, "2", v)
+	})
+
 	t.Run("acl resttoken invalid user", func(t *testing.T) {
 		redsrv.RequireUserAuth("user", "pwd")
 		defer redsrv.RequireUserAuth("user", "")
@@ -185,6 +280,1699 @@ func TestServerMiniredis(t *testing.T) {
 	})
 }
 
+func TestServerSubscribe(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken, roToken = "_token_", "_ro_"
+	server := &Server{
+		APIToken:          goodToken,
+		ReadOnlyAPITokens: []string{roToken},
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+
+	// subscribe starts a subscription and returns a function that returns the
+	// data of the next event.
+	subscribe := func(t *testing.T, ctx context.Context, path string) func() string {
+		req, err := http.NewRequestWithContext(ctx, "GET", httpsrv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodToken)
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		br := bufio.NewReader(res.Body)
+		return func() string {
+			var lines []string
+			for {
+				line, err := br.ReadString('\n')
+				require.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					return strings.Join(lines, "\n")
+				}
+				require.True(t, strings.HasPrefix(line, "data: "), line)
+				lines = append(lines, strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	t.Run("subscribe", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		next := subscribe(t, ctx, "/subscribe/chat")
+		require.Equal(t, "subscribe,chat,1", next())
+
+		redsrv.Publish("chat", "hello")
+		require.Equal(t, "message,chat,hello", next())
+		redsrv.Publish("chat", "multi\nline")
+		require.Equal(t, "message,chat,multi\nline", next())
+
+		// the subscription ends when the client disconnects
+		cancel()
+		waitFor(t, func() bool { return redsrv.PubSubNumSub("chat")["chat"] == 0 })
+	})
+
+	t.Run("psubscribe", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		next := subscribe(t, ctx, "/psubscribe/news.*")
+		require.Equal(t, "psubscribe,news.*,1", next())
+
+		redsrv.Publish("news.tech", "go")
+		require.Equal(t, "pmessage,news.*,news.tech,go", next())
+
+		cancel()
+		waitFor(t, func() bool { return redsrv.PubSubNumPat() == 0 })
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+		res := makeRequest(t, http.StatusBadRequest, roToken, "/subscribe/chat", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		server := &Server{
+			APIToken: goodToken,
+			GetConnFunc: func(ctx context.Context) Conn {
+				return doOnlyConn{pool.Get()}
+			},
+		}
+		httpsrv := httptest.NewServer(server)
+		defer httpsrv.Close()
+
+		makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/subscribe/chat", nil, "")
+		require.Equal(t, "ERR subscribe is not supported", res.Error)
+	})
+}
+
+func TestServerMonitor(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken, roToken = "_token_", "_ro_"
+	server := &Server{
+		APIToken:          goodToken,
+		ReadOnlyAPITokens: []string{roToken},
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+
+	// monitor starts monitoring and returns a function that returns the next
+	// line of the response body.
+	monitor := func(t *testing.T, ctx context.Context, path, accept string) func() string {
+		req, err := http.NewRequestWithContext(ctx, "GET", httpsrv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodToken)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		br := bufio.NewReader(res.Body)
+		return func() string {
+			line, err := br.ReadString('\n')
+			require.NoError(t, err)
+			return strings.TrimSuffix(line, "\n")
+		}
+	}
+
+	t.Run("monitor", func(t *testing.T) {
+		conn := &monitorConn{replies: make(chan interface{}, 10)}
+		server.GetSubscriberConnFunc = func(ctx context.Context) SubscriberConn { return conn }
+		defer func() { server.GetSubscriberConnFunc = nil }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		next := monitor(t, ctx, "/monitor", "")
+		conn.replies <- "OK"
+		conn.replies <- `1339518083.107412 [0 127.0.0.1:60866] "set" "k" "a \"b\"\n\x41"`
+		require.Equal(t, `data: {"time":1339518083.107412,"db":0,"client":"127.0.0.1:60866","command":["set","k","a \"b\"\nA"]}`, next())
+		require.Equal(t, "", next())
+
+		cancel()
+		waitFor(t, func() bool { return conn.closed() })
+		require.Equal(t, []string{"MONITOR", "QUIT"}, conn.sentCmds())
+	})
+
+	t.Run("monitor ndjson", func(t *testing.T) {
+		conn := &monitorConn{replies: make(chan interface{}, 10)}
+		server.GetSubscriberConnFunc = func(ctx context.Context) SubscriberConn { return conn }
+		defer func() { server.GetSubscriberConnFunc = nil }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		next := monitor(t, ctx, "/monitor", "application/x-ndjson")
+		conn.replies <- "OK"
+		conn.replies <- `1339518085.5 [2 lua] "get" "k"`
+		conn.replies <- `1339518086.5 [0 127.0.0.1:60866] "ping"`
+		require.Equal(t, `{"time":1339518085.5,"db":2,"client":"lua","command":["get","k"]}`, next())
+		require.Equal(t, `{"time":1339518086.5,"db":0,"client":"127.0.0.1:60866","command":["ping"]}`, next())
+	})
+
+	t.Run("keyspace", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		next := monitor(t, ctx, "/monitor/keyspace/user:*", "application/x-ndjson")
+		waitFor(t, func() bool { return redsrv.PubSubNumPat() == 1 })
+
+		redsrv.Publish("__keyspace@0__:user:1", "set")
+		redsrv.Publish("__keyspace@0__:other", "set")
+		redsrv.Publish("__keyspace@0__:user:2", "expired")
+		require.Equal(t, `{"key":"user:1","event":"set"}`, next())
+		require.Equal(t, `{"key":"user:2","event":"expired"}`, next())
+
+		cancel()
+		waitFor(t, func() bool { return redsrv.PubSubNumPat() == 0 })
+	})
+
+	t.Run("keyspace db not allowed", func(t *testing.T) {
+		makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+		res := makeRequest(t, http.StatusForbidden, goodToken, "/monitor/keyspace/user:*", nil, "_db=1")
+		require.Equal(t, "ERR DB index is not allowed", res.Error)
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+		res := makeRequest(t, http.StatusForbidden, roToken, "/monitor", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+	})
+}
+
+func TestParseMonitorLine(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string // nil if invalid
+	}{
+		{`OK`, nil},
+		{`1.5 [0 lua] "get" "k"`, []string{"get", "k"}},
+		{`1.5 [0 lua] "set" "k" ""`, []string{"set", "k", ""}},
+		{`1.5 [0 lua] "echo" "\t\r\x00\\"`, []string{"echo", "\t\r\x00\\"}},
+		{`1.5 [0 lua] "echo`, nil},
+		{`1.5 [0 lua] "echo" "\x4"`, nil},
+		{`1.5 [0 lua] echo`, nil},
+		{`x [0 lua] "echo"`, nil},
+		{`1.5 [x lua] "echo"`, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			ev, ok := parseMonitorLine(c.in)
+			if c.want == nil {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			require.Equal(t, c.want, ev.Command)
+		})
+	}
+}
+
+// monitorConn is a SubscriberConn that returns the replies sent on its
+// channel, to simulate a connection in MONITOR mode.
+type monitorConn struct {
+	replies chan interface{}
+
+	mu       sync.Mutex
+	sent     []string
+	isClosed bool
+}
+
+func (c *monitorConn) Do(_ string, _ ...interface{}) (interface{}, error) { return nil, nil }
+func (c *monitorConn) Flush() error                                       { return nil }
+
+func (c *monitorConn) Send(cmd string, _ ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, cmd)
+	if cmd == "QUIT" {
+		c.replies <- "OK"
+		close(c.replies)
+	}
+	return nil
+}
+
+func (c *monitorConn) Receive() (interface{}, error) {
+	v, ok := <-c.replies
+	if !ok {
+		return nil, io.EOF
+	}
+	return v, nil
+}
+
+func (c *monitorConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isClosed = true
+	return nil
+}
+
+func (c *monitorConn) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isClosed
+}
+
+func (c *monitorConn) sentCmds() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent...)
+}
+
+// waitFor waits until cond returns true, failing the test after 5 seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		require.True(t, time.Now().Before(deadline), "condition not met")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// doOnlyConn hides the methods of the wrapped connection that are not part
+// of the Conn interface.
+type doOnlyConn struct {
+	Conn
+}
+
+func TestServerBase64(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	bin := "\xff\x00\xfe"
+	redsrv.Set("bin", bin)
+	redsrv.RPush("list", "a", bin)
+
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	do := func(t *testing.T, path, body string) string {
+		req, err := http.NewRequest("POST", httpsrv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodToken)
+		req.Header.Set("Upstash-Encoding", "base64")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	require.Equal(t, `{"result":"`+b64(bin)+`"}`, do(t, "/", `["GET","bin"]`))
+	require.Equal(t, `{"result":"`+b64("OK")+`"}`, do(t, "/set/a/1", ""))
+	require.Equal(t, `{"result":1}`, do(t, "/exists/a", ""))
+	require.Equal(t, `{"result":null}`, do(t, "/get/nope", ""))
+	require.Equal(t, `{"result":["`+b64("a")+`","`+b64(bin)+`"]}`, do(t, "/lrange/list/0/-1", ""))
+	require.Equal(t, `[{"result":"`+b64("1")+`"},{"error":"WRONGTYPE Operation against a key holding the wrong kind of value"}]`,
+		do(t, "/pipeline", `[["GET","a"],["HGETALL","a"]]`))
+	require.Equal(t, `[{"result":2},{"result":"`+b64("2")+`"}]`, do(t, "/multi-exec", `[["INCR","a"],["GET","a"]]`))
+}
+
+func TestServerPaginate(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	var cmds []string
+	server := &Server{
+		APIToken:      goodToken,
+		MaxArrayReply: 3,
+		MaxReplyBytes: 30,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return &cmdsConn{Conn: pool.Get(), cmds: &cmds}
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	// getPage requests the page at cursor and returns its values and the
+	// cursor of the next page.
+	getPage := func(t *testing.T, path, cursor string) ([]interface{}, string) {
+		req, err := http.NewRequest("GET", httpsrv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodToken)
+		if cursor != "" {
+			req.Header.Set(cursorHeader, cursor)
+		}
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var body result
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		vals, ok := body.Result.([]interface{})
+		require.True(t, ok, "%#v", body.Result)
+		return vals, res.Header.Get(cursorHeader)
+	}
+
+	makeRequest(t, http.StatusOK, goodToken, "/rpush/l/a/b/c/d/e/f/g", nil, "")
+	makeRequest(t, http.StatusOK, goodToken, "/rpush/big/"+strings.Repeat("x", 20)+"/"+strings.Repeat("y", 20)+"/z", nil, "")
+
+	t.Run("element limit", func(t *testing.T) {
+		var all []interface{}
+		var pages int
+		cur := ""
+		for {
+			vals, next := getPage(t, "/lrange/l/0/-1", cur)
+			require.LessOrEqual(t, len(vals), 3)
+			all = append(all, vals...)
+			pages++
+			if next == "" {
+				break
+			}
+			cur = next
+		}
+		require.Equal(t, 3, pages)
+		require.Equal(t, []interface{}{"a", "b", "c", "d", "e", "f", "g"}, all)
+	})
+
+	t.Run("byte limit", func(t *testing.T) {
+		vals, next := getPage(t, "/lrange/big/0/-1", "")
+		require.Equal(t, []interface{}{strings.Repeat("x", 20)}, vals)
+		require.Equal(t, "1", next)
+
+		vals, next = getPage(t, "/lrange/big/0/-1", next)
+		require.Equal(t, []interface{}{strings.Repeat("y", 20), "z"}, vals)
+		require.Empty(t, next)
+	})
+
+	t.Run("cursor query string", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/", []string{"LRANGE", "l", "0", "-1"}, "_cursor=6")
+		require.Equal(t, []interface{}{"g"}, res.Result)
+	})
+
+	t.Run("cursor past end", func(t *testing.T) {
+		vals, next := getPage(t, "/lrange/l/0/-1", "100")
+		require.Empty(t, vals)
+		require.Empty(t, next)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/lrange/l/0/-1", nil, "_cursor=abc")
+		require.Equal(t, "ERR invalid cursor", res.Error)
+	})
+
+	t.Run("not an array", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/llen/l", nil, "_cursor=2")
+		require.Equal(t, float64(7), res.Result)
+	})
+
+	t.Run("pipeline not paginated", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]string{{"LRANGE", "l", "0", "-1"}}, "")
+		require.Len(t, res.Results, 1)
+		require.Len(t, res.Results[0].Result, 7)
+	})
+
+	t.Run("page requested from redis", func(t *testing.T) {
+		cmds = nil
+		vals, next := getPage(t, "/lrange/l/1/-2", "3")
+		require.Equal(t, []interface{}{"e", "f"}, vals)
+		require.Empty(t, next)
+		require.Equal(t, []string{"LLEN l", "lrange l 4 5"}, cmds)
+
+		cmds = nil
+		vals, next = getPage(t, "/lrange/l/2/3", "")
+		require.Equal(t, []interface{}{"c", "d"}, vals)
+		require.Empty(t, next)
+		require.Equal(t, []string{"lrange l 2 3"}, cmds)
+	})
+
+	t.Run("pairs", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/zadd/z/1/a/2/b/3/c", nil, "")
+
+		var all []interface{}
+		cur := ""
+		for {
+			vals, next := getPage(t, "/zrange/z/0/-1/WITHSCORES", cur)
+			require.Len(t, vals, 2)
+			all = append(all, vals...)
+			if next == "" {
+				break
+			}
+			cur = next
+		}
+		require.Equal(t, []interface{}{"a", "1", "b", "2", "c", "3"}, all)
+
+		vals, next := getPage(t, "/zrange/z/0/-1/REV", "")
+		require.Equal(t, []interface{}{"c", "b", "a"}, vals)
+		require.Empty(t, next)
+
+		makeRequest(t, http.StatusOK, goodToken, "/hset/h/f1/v1/f2/v2", nil, "")
+		vals, next = getPage(t, "/hgetall/h", "")
+		require.ElementsMatch(t, []interface{}{"f1", "v1", "f2", "v2"}, vals)
+		require.Empty(t, next)
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/sadd/s/a/b", nil, "")
+		vals, next := getPage(t, "/smembers/s", "")
+		require.ElementsMatch(t, []interface{}{"a", "b"}, vals)
+		require.Empty(t, next)
+
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/smembers/s", nil, "_cursor=-1")
+		require.Equal(t, "ERR invalid cursor", res.Error)
+	})
+
+	t.Run("other commands not paginated", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/rpush/q/1/2/3/4/5", nil, "")
+		vals, next := getPage(t, "/lpop/q/5", "")
+		require.Equal(t, []interface{}{"1", "2", "3", "4", "5"}, vals)
+		require.Empty(t, next)
+
+		vals, next = getPage(t, "/zrangebyscore/z/-inf/+inf", "")
+		require.Equal(t, []interface{}{"a", "b", "c"}, vals)
+		require.Empty(t, next)
+	})
+}
+
+// cmdsConn records the commands executed on the connection.
+type cmdsConn struct {
+	Conn
+	cmds *[]string
+}
+
+func (c *cmdsConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	parts := []string{cmd}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+	*c.cmds = append(*c.cmds, strings.Join(parts, " "))
+	return c.Conn.Do(cmd, args...)
+}
+
+// scanPagesConn replies to SSCAN with two pages of elements.
+type scanPagesConn struct {
+	failedConn
+}
+
+func (scanPagesConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if fmt.Sprint(args[1]) == "0" {
+		return []interface{}{[]byte("7"), []interface{}{[]byte("a"), []byte("b")}}, nil
+	}
+	return []interface{}{[]byte("0"), []interface{}{[]byte("c")}}, nil
+}
+
+func TestPageScan(t *testing.T) {
+	s := &Server{MaxArrayReply: 2}
+	r := httptest.NewRequest("GET", "/smembers/s", nil)
+	w := httptest.NewRecorder()
+	v, code := s.pageScan(w, r, scanPagesConn{}, "SSCAN", "s", false)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, successResult{Result: []interface{}{[]byte("a"), []byte("b")}}, v)
+	require.Equal(t, "7", w.Header().Get(cursorHeader))
+
+	r.Header.Set(cursorHeader, "7")
+	w = httptest.NewRecorder()
+	v, code = s.pageScan(w, r, scanPagesConn{}, "SSCAN", "s", false)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, successResult{Result: []interface{}{[]byte("c")}}, v)
+	require.Empty(t, w.Header().Get(cursorHeader))
+}
+
+func TestServerSelectDB(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken:   goodToken,
+		AllowedDBs: []int{1},
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	t.Run("allowed db", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/set/a/1", nil, "_db=1")
+		require.Empty(t, res.Error)
+		require.Equal(t, "OK", res.Result)
+		got, err := redsrv.DB(1).Get("a")
+		require.NoError(t, err)
+		require.Equal(t, "1", got)
+		require.False(t, redsrv.DB(0).Exists("a"))
+
+		// the connection is reset to the default db
+		res = makeRequest(t, http.StatusOK, goodToken, "/get/a", nil, "")
+		require.Empty(t, res.Error)
+		require.Nil(t, res.Result)
+	})
+
+	t.Run("db header", func(t *testing.T) {
+		req, err := http.NewRequest("POST", httpsrv.URL+"/get/a", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+goodToken)
+		req.Header.Set("X-Redis-DB", "1")
+		resp, err := cli.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var res result
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "1", res.Result)
+	})
+
+	t.Run("default db", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/set/a/0", nil, "_db=0")
+		require.Empty(t, res.Error)
+		require.Equal(t, "OK", res.Result)
+		require.True(t, redsrv.DB(0).Exists("a"))
+	})
+
+	t.Run("db not allowed", func(t *testing.T) {
+		res := makeRequest(t, http.StatusForbidden, goodToken, "/get/a", nil, "_db=2")
+		require.Contains(t, res.Error, "not allowed")
+	})
+
+	t.Run("invalid db", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/get/a", nil, "_db=x")
+		require.Contains(t, res.Error, "invalid DB")
+	})
+}
+
+func TestServerRestTokenSecret(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	redsrv.RequireUserAuth("user", "pwd")
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	newServer := func(secret string) *httptest.Server {
+		srv := httptest.NewServer(&Server{
+			APIToken:        goodToken,
+			RestTokenSecret: secret,
+			GetConnFunc: func(ctx context.Context) Conn {
+				return pool.Get()
+			},
+		})
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	srv1 := newServer("secret")
+	srv2 := newServer("secret")
+	srv3 := newServer("other")
+	makeRequest1 := genMakeRequestFunc(srv1.URL, cli)
+	makeRequest2 := genMakeRequestFunc(srv2.URL, cli)
+	makeRequest3 := genMakeRequestFunc(srv3.URL, cli)
+
+	res := makeRequest1(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+	require.Empty(t, res.Error)
+	tok, _ := res.Result.(string)
+	require.NotEmpty(t, tok)
+
+	t.Run("deterministic", func(t *testing.T) {
+		res := makeRequest2(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+		require.Equal(t, tok, res.Result)
+
+		res = makeRequest3(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+		require.NotEqual(t, tok, res.Result)
+	})
+
+	t.Run("same secret", func(t *testing.T) {
+		res := makeRequest1(t, http.StatusOK, tok, "/set/a/1", nil, "")
+		require.Equal(t, "OK", res.Result)
+		res = makeRequest2(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "1", res.Result)
+	})
+
+	t.Run("other secret", func(t *testing.T) {
+		res := makeRequest3(t, http.StatusUnauthorized, tok, "/get/a", nil, "")
+		require.Equal(t, "Unauthorized", res.Error)
+	})
+
+	t.Run("tampered token", func(t *testing.T) {
+		b := []byte(tok)
+		b[0] ^= 1
+		res := makeRequest1(t, http.StatusUnauthorized, string(b), "/get/a", nil, "")
+		require.Equal(t, "Unauthorized", res.Error)
+	})
+
+	t.Run("password changed", func(t *testing.T) {
+		redsrv.RequireUserAuth("user", "newpwd")
+		defer redsrv.RequireUserAuth("user", "pwd")
+		res := makeRequest1(t, http.StatusBadRequest, tok, "/get/a", nil, "")
+		require.Contains(t, res.Error, "WRONGPASS")
+	})
+}
+
+func TestServerRestTokenLifecycle(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	redsrv.RequireUserAuth("user", "pwd")
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken, roToken = "_token_", "_rotoken_"
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	newServer := func() *httptest.Server {
+		srv := httptest.NewServer(&Server{
+			APIToken:          goodToken,
+			ReadOnlyAPITokens: []string{roToken},
+			RestTokenTTL:      time.Hour,
+			TokenStore:        &FileTokenStore{Path: path},
+			GetConnFunc: func(ctx context.Context) Conn {
+				return pool.Get()
+			},
+		})
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(newServer().URL, cli)
+
+	res := makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+	tok, _ := res.Result.(string)
+	require.NotEmpty(t, tok)
+	res = makeRequest(t, http.StatusOK, tok, "/set/a/1", nil, "")
+	require.Equal(t, "OK", res.Result)
+
+	t.Run("list", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/list", nil, "")
+		list, _ := res.Result.([]interface{})
+		require.Len(t, list, 1)
+		fields := list[0].([]interface{})
+		require.Equal(t, []interface{}{"token", tok, "username", "user", "created_at"}, fields[:5])
+		created, expires := fields[5].(float64), fields[7].(float64)
+		require.Equal(t, float64(3600), expires-created)
+
+		res = makeRequest(t, http.StatusForbidden, tok, "/acl/resttoken/list", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+		res = makeRequest(t, http.StatusBadRequest, roToken, "/acl/resttoken/list", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		makeRequest := genMakeRequestFunc(newServer().URL, cli)
+		res := makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "1", res.Result)
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		store := &FileTokenStore{Path: path}
+		require.NoError(t, store.Set(context.Background(), RestToken{
+			Token:     "expired",
+			Username:  "user",
+			Password:  "pwd",
+			CreatedAt: time.Now().Add(-time.Hour),
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}))
+		makeRequest := genMakeRequestFunc(newServer().URL, cli)
+		res := makeRequest(t, http.StatusUnauthorized, "expired", "/get/a", nil, "")
+		require.Equal(t, "Unauthorized", res.Error)
+		res = makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/list", nil, "")
+		require.Len(t, res.Result, 1)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		res := makeRequest(t, http.StatusForbidden, tok, "/acl/resttoken/del/"+tok, nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+
+		res = makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/del/"+tok, nil, "")
+		require.Equal(t, float64(1), res.Result)
+		res = makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/del/"+tok, nil, "")
+		require.Equal(t, float64(0), res.Result)
+
+		res = makeRequest(t, http.StatusUnauthorized, tok, "/get/a", nil, "")
+		require.Equal(t, "Unauthorized", res.Error)
+		res = makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/list", nil, "")
+		require.Empty(t, res.Result)
+	})
+
+	t.Run("invalid syntax", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/acl/resttoken/user", nil, "")
+		require.Contains(t, res.Error, "invalid syntax")
+	})
+}
+
+func TestServerUserConns(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	redsrv.RequireUserAuth("user", "pwd")
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	var dials int32
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+		DialConnFunc: func(ctx context.Context) Conn {
+			atomic.AddInt32(&dials, 1)
+			conn, err := redis.DialContext(ctx, "tcp", redsrv.Addr())
+			if err != nil {
+				return failedConn{err: err}
+			}
+			return conn
+		},
+	}
+	defer server.CloseUserConns()
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	res := makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+	require.Empty(t, res.Error)
+	tok := res.Result.(string)
+	require.Equal(t, int32(0), atomic.LoadInt32(&dials))
+
+	t.Run("reuse", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			res := makeRequest(t, http.StatusOK, tok, "/incr/a", nil, "")
+			require.Equal(t, float64(i+1), res.Result)
+		}
+		res := makeRequest(t, http.StatusOK, tok, "/pipeline", [][]interface{}{{"GET", "a"}}, "")
+		require.Len(t, res.Results, 1)
+		require.Equal(t, "5", res.Results[0].Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+		// admin requests do not use the pools
+		res = makeRequest(t, http.StatusOK, goodToken, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	})
+
+	t.Run("reset state", func(t *testing.T) {
+		atomic.StoreInt32(&dials, 0)
+
+		// the transaction, watched keys and selected database are reset before
+		// the connection is returned to the pool
+		res := makeRequest(t, http.StatusOK, tok, "/pipeline", [][]interface{}{
+			{"WATCH", "a"}, {"SELECT", "1"}, {"SET", "a", "db1"}, {"MULTI"}, {"INCR", "a"},
+		}, "")
+		require.Len(t, res.Results, 5)
+		require.Equal(t, "QUEUED", res.Results[4].Result)
+		res = makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(0), atomic.LoadInt32(&dials))
+		redsrv.Select(1)
+		redsrv.CheckGet(t, "a", "db1")
+		redsrv.Select(0)
+
+		// a connection whose state cannot be reset is closed, even if the
+		// command failed (CLIENT is not supported by miniredis)
+		makeRequest(t, http.StatusBadRequest, tok, "/client/setname/x", nil, "")
+		res = makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	})
+
+	t.Run("revoked token", func(t *testing.T) {
+		atomic.StoreInt32(&dials, 0)
+		res := makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/user/pwd", nil, "")
+		require.Empty(t, res.Error)
+		tok2 := res.Result.(string)
+
+		res = makeRequest(t, http.StatusOK, tok2, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+		server.userConns.mu.Lock()
+		require.Len(t, server.userConns.pools[tok2].idle, 1)
+		server.userConns.mu.Unlock()
+
+		res = makeRequest(t, http.StatusOK, goodToken, "/acl/resttoken/del/"+tok2, nil, "")
+		require.Equal(t, float64(1), res.Result)
+		server.userConns.mu.Lock()
+		require.Nil(t, server.userConns.pools[tok2])
+		server.userConns.mu.Unlock()
+		makeRequest(t, http.StatusUnauthorized, tok2, "/get/a", nil, "")
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		require.NoError(t, server.tokenStore().Set(context.Background(), RestToken{Token: "bad", Username: "user", Password: "nope"}))
+		res := makeRequest(t, http.StatusBadRequest, "bad", "/get/a", nil, "")
+		require.Contains(t, res.Error, "WRONGPASS")
+		res = makeRequest(t, http.StatusBadRequest, "bad", "/get/a", nil, "")
+		require.Contains(t, res.Error, "WRONGPASS")
+		require.Equal(t, int32(3), atomic.LoadInt32(&dials))
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		atomic.StoreInt32(&dials, 0)
+		server.UserConnIdleTimeout = 10 * time.Millisecond
+		defer func() { server.UserConnIdleTimeout = 0 }()
+
+		time.Sleep(20 * time.Millisecond)
+		res := makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	})
+
+	t.Run("close", func(t *testing.T) {
+		atomic.StoreInt32(&dials, 0)
+		server.CloseUserConns()
+		res := makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		res = makeRequest(t, http.StatusOK, tok, "/get/a", nil, "")
+		require.Equal(t, "5", res.Result)
+		require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	})
+}
+
+func TestServerNotify(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	takeEvents := func() []Event {
+		mu.Lock()
+		defer mu.Unlock()
+		evs := events
+		events = nil
+		return evs
+	}
+
+	const goodToken = "_token_"
+	down := false
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			if down {
+				return failedConn{err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+			}
+			return pool.Get()
+		},
+		Notify: func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	t.Run("auth failure", func(t *testing.T) {
+		makeRequest(t, http.StatusUnauthorized, "nope", "/get/a", nil, "")
+		evs := takeEvents()
+		require.Len(t, evs, 1)
+		require.Equal(t, EventAuthFailure, evs[0].Type)
+		require.NotEmpty(t, evs[0].RemoteAddr)
+		require.False(t, evs[0].Time.IsZero())
+	})
+
+	t.Run("regular commands", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/set/a/1", nil, "")
+		makeRequest(t, http.StatusBadRequest, goodToken, "/hgetall/a", nil, "")
+		require.Empty(t, takeEvents())
+	})
+
+	t.Run("destructive command", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]interface{}{{"GET", "a"}, {"flushdb"}}, "")
+		evs := takeEvents()
+		require.Len(t, evs, 1)
+		require.Equal(t, EventDestructiveCommand, evs[0].Type)
+		require.Equal(t, "FLUSHDB", evs[0].Command)
+	})
+
+	t.Run("backend down", func(t *testing.T) {
+		down = true
+		defer func() { down = false }()
+
+		makeRequest(t, http.StatusBadRequest, goodToken, "/get/a", nil, "")
+		evs := takeEvents()
+		require.Len(t, evs, 1)
+		require.Equal(t, EventBackendDown, evs[0].Type)
+		require.Equal(t, "GET", evs[0].Command)
+		require.Contains(t, evs[0].Error, "connection refused")
+	})
+}
+
+type logEntry struct {
+	level   Level
+	msg     string
+	keyvals map[string]interface{}
+}
+
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := logEntry{level: level, msg: msg, keyvals: make(map[string]interface{})}
+	for i := 0; i < len(keyvals); i += 2 {
+		e.keyvals[keyvals[i].(string)] = keyvals[i+1]
+	}
+	l.entries = append(l.entries, e)
+}
+
+func (l *recordLogger) take() []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.entries
+	l.entries = nil
+	return entries
+}
+
+func TestServerLog(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	var logger recordLogger
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+		Logger:    &logger,
+		AccessLog: true,
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	t.Run("auth failure", func(t *testing.T) {
+		makeRequest(t, http.StatusUnauthorized, "nope", "/get/a", nil, "")
+		entries := logger.take()
+		require.Len(t, entries, 2)
+		require.Equal(t, LevelWarn, entries[0].level)
+		require.Equal(t, "authentication failed", entries[0].msg)
+		require.Equal(t, LevelInfo, entries[1].level)
+		require.Equal(t, http.StatusUnauthorized, entries[1].keyvals["status"])
+	})
+
+	t.Run("command in path", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/set/a/secret", nil, "")
+		entries := logger.take()
+		require.Len(t, entries, 2)
+		require.Equal(t, LevelDebug, entries[0].level)
+		require.Equal(t, "SET", entries[0].keyvals["command"])
+		require.Equal(t, false, entries[0].keyvals["error"])
+
+		access := entries[1]
+		require.Equal(t, LevelInfo, access.level)
+		require.Equal(t, "request", access.msg)
+		require.Contains(t, []interface{}{"GET", "POST"}, access.keyvals["method"])
+		require.Equal(t, "/set/...", access.keyvals["path"])
+		require.Equal(t, http.StatusOK, access.keyvals["status"])
+		require.Equal(t, "SET", access.keyvals["commands"])
+		require.NotNil(t, access.keyvals["duration"])
+		for _, e := range entries {
+			for _, v := range e.keyvals {
+				require.NotContains(t, fmt.Sprint(v), "secret")
+			}
+		}
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]interface{}{{"GET", "a"}, {"hgetall", "a"}}, "")
+		entries := logger.take()
+		require.Len(t, entries, 3)
+		require.Equal(t, false, entries[0].keyvals["error"])
+		require.Equal(t, true, entries[1].keyvals["error"])
+		require.Equal(t, "/pipeline", entries[2].keyvals["path"])
+		require.Equal(t, "GET,HGETALL", entries[2].keyvals["commands"])
+	})
+
+	t.Run("no access log", func(t *testing.T) {
+		server.AccessLog = false
+		defer func() { server.AccessLog = true }()
+
+		makeRequest(t, http.StatusOK, goodToken, "/get/a", nil, "")
+		entries := logger.take()
+		require.Len(t, entries, 1)
+		require.Equal(t, "command", entries[0].msg)
+	})
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	l.Log(LevelDebug, "command", "command", "GET")
+	l.Log(LevelInfo, "request", "path", "/pipeline", "status", 200)
+	l.Log(LevelError, "odd", "key")
+	require.Equal(t, "INFO request path=/pipeline status=200\nERROR odd key=(missing)\n", buf.String())
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		received []Event
+	)
+	hooksrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			// fail the first attempt, the event should be retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		received = append(received, e)
+	}))
+	defer hooksrv.Close()
+
+	var errs []error
+	hook := &Webhook{
+		URL:        hooksrv.URL,
+		RetryDelay: time.Millisecond,
+		Throttle:   time.Minute,
+		ErrorLog:   func(err error) { errs = append(errs, err) },
+	}
+
+	now := time.Now().UTC()
+	hook.Notify(Event{Type: EventAuthFailure, Time: now, RemoteAddr: "a"})
+	hook.Notify(Event{Type: EventAuthFailure, Time: now.Add(time.Second), RemoteAddr: "b"})
+	hook.Notify(Event{Type: EventAuthFailure, Time: now.Add(2 * time.Second), RemoteAddr: "c"})
+	hook.Notify(Event{Type: EventBackendDown, Time: now, Error: "down"})
+	hook.Notify(Event{Type: EventAuthFailure, Time: now.Add(2 * time.Minute), RemoteAddr: "d"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, hook.Close(ctx))
+	require.Empty(t, errs)
+
+	// events after Close are ignored
+	hook.Notify(Event{Type: EventAuthFailure, Time: now.Add(time.Hour)})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 4, attempts)
+	require.Len(t, received, 3)
+	require.Equal(t, "a", received[0].RemoteAddr)
+	require.Equal(t, EventBackendDown, received[1].Type)
+	require.Equal(t, "d", received[2].RemoteAddr)
+	require.Equal(t, 2, received[2].Suppressed)
+}
+
+func TestServerCommandStats(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken, roToken = "_token_", "_rotoken_"
+	server := &Server{
+		APIToken:          goodToken,
+		ReadOnlyAPITokens: []string{roToken},
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	makeRequest(t, http.StatusOK, goodToken, "/set/a/1", nil, "")
+	makeRequest(t, http.StatusOK, goodToken, "/", []string{"get", "a"}, "")
+	makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]string{{"GET", "a"}, {"HGETALL", "a"}}, "")
+	makeRequest(t, http.StatusOK, goodToken, "/multi-exec", [][]string{{"INCR", "a"}, {"GET", "a"}}, "")
+	makeRequest(t, http.StatusBadRequest, roToken, "/set/a/2", nil, "")
+
+	t.Run("admin token", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/admin/commandstats", nil, "")
+		require.Empty(t, res.Error)
+
+		stats := res.Result.(map[string]interface{})
+		require.NotEmpty(t, stats["since"])
+		cmds := stats["commands"].(map[string]interface{})
+		require.Len(t, cmds, 4)
+
+		get := cmds["get"].(map[string]interface{})
+		require.Equal(t, float64(2), get["calls"])
+		require.Equal(t, float64(0), get["failed_calls"])
+		require.Greater(t, get["p99_usec"], float64(0))
+		require.GreaterOrEqual(t, get["p99_usec"], get["p50_usec"])
+
+		set := cmds["set"].(map[string]interface{})
+		require.Equal(t, float64(2), set["calls"])
+		require.Equal(t, float64(1), set["failed_calls"])
+
+		hgetall := cmds["hgetall"].(map[string]interface{})
+		require.Equal(t, float64(1), hgetall["failed_calls"])
+
+		tx := cmds["multi-exec"].(map[string]interface{})
+		require.Equal(t, float64(1), tx["calls"])
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		res := makeRequest(t, http.StatusForbidden, roToken, "/admin/commandstats", nil, "")
+		require.Contains(t, res.Error, "NOPERM")
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		makeRequest(t, http.StatusUnauthorized, "nope", "/admin/commandstats", nil, "")
+	})
+}
+
+func TestServerCommandLists(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+		DenyCommands: []string{"FLUSHALL", "keys", "config|set"},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	t.Run("deny", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/set/a/1", nil, "")
+		require.Equal(t, "OK", res.Result)
+
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/flushall", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'flushall'", res.Error)
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "", []interface{}{"Keys", "*"}, "")
+		require.Equal(t, "ERR command is not allowed: 'keys'", res.Error)
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/config/set/x/y", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'config'", res.Error)
+		redsrv.CheckGet(t, "a", "1")
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]interface{}{{"GET", "a"}, {"FLUSHALL"}}, "")
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "1", res.Results[0].Result)
+		require.Equal(t, "ERR command is not allowed: 'flushall'", res.Results[1].Error)
+		redsrv.CheckGet(t, "a", "1")
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "/multi-exec", [][]interface{}{{"SET", "a", "2"}, {"KEYS", "*"}}, "")
+		require.Equal(t, "ERR command is not allowed: 'keys'", res.Error)
+		redsrv.CheckGet(t, "a", "1")
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		server.AllowCommands = []string{"get", "flushall", "config|get"}
+		defer func() { server.AllowCommands = nil }()
+
+		res := makeRequest(t, http.StatusOK, goodToken, "/get/a", nil, "")
+		require.Equal(t, "1", res.Result)
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/set/a/2", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'set'", res.Error)
+		// denied even if allowed
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/flushall", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'flushall'", res.Error)
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/subscribe/ch", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'subscribe'", res.Error)
+	})
+
+	t.Run("scripts", func(t *testing.T) {
+		// the scripts could execute the denied commands
+		res := makeRequest(t, http.StatusBadRequest, goodToken, "", []interface{}{"EVAL", "return redis.call('FLUSHALL')", 0}, "")
+		require.Equal(t, "ERR command is not allowed: 'eval'", res.Error)
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/evalsha/abc/0", nil, "")
+		require.Equal(t, "ERR command is not allowed: 'evalsha'", res.Error)
+		redsrv.CheckGet(t, "a", "1")
+
+		// unless explicitly allowed
+		server.AllowCommands = []string{"get", "eval"}
+		defer func() { server.AllowCommands = nil }()
+		res = makeRequest(t, http.StatusOK, goodToken, "", []interface{}{"EVAL", "return redis.call('GET', KEYS[1])", 1, "a"}, "")
+		require.Equal(t, "1", res.Result)
+	})
+}
+
+// chunkReader returns its chunks one Read at a time, an empty chunk being
+// returned as 0 bytes and a nil error.
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestLimitReader(t *testing.T) {
+	cases := []struct {
+		chunks []string
+		n      int64
+		want   string
+		err    error
+	}{
+		{[]string{"abc"}, -1, "abc", nil},
+		{[]string{"abc"}, 3, "abc", nil},
+		{[]string{"abc"}, 4, "abc", nil},
+		{[]string{"abcd"}, 3, "abc", errBodyTooLarge},
+		{[]string{"ab", "c", "d"}, 3, "abc", errBodyTooLarge},
+		// a Read that returns no data at the limit is not the end of the body
+		{[]string{"abc", "", "d"}, 3, "abc", errBodyTooLarge},
+		{[]string{"abc", "", ""}, 3, "abc", nil},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%q %d", c.chunks, c.n), func(t *testing.T) {
+			b, err := io.ReadAll(&limitReader{r: &chunkReader{chunks: append([]string(nil), c.chunks...)}, n: c.n})
+			require.Equal(t, c.err, err)
+			require.Equal(t, c.want, string(b))
+		})
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+		MaxBodyBytes:   64,
+		RequestTimeout: 5 * time.Second,
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	t.Run("body size", func(t *testing.T) {
+		large := strings.Repeat("x", 100)
+
+		res := makeRequest(t, http.StatusOK, goodToken, "/set/a", rawBody(large[:50]), "")
+		require.Equal(t, "OK", res.Result)
+		res = makeRequest(t, http.StatusRequestEntityTooLarge, goodToken, "/set/a", rawBody(large), "")
+		require.Equal(t, "ERR request body too large", res.Error)
+		res = makeRequest(t, http.StatusRequestEntityTooLarge, goodToken, "", []interface{}{"SET", "a", large}, "")
+		require.Equal(t, "ERR request body too large", res.Error)
+		res = makeRequest(t, http.StatusRequestEntityTooLarge, goodToken, "/pipeline", [][]interface{}{{"SET", "a", "1"}, {"SET", "b", large}}, "")
+		require.Equal(t, "ERR request body too large", res.Error)
+		res = makeRequest(t, http.StatusRequestEntityTooLarge, goodToken, "/multi-exec", [][]interface{}{{"SET", "a", large}}, "")
+		require.Equal(t, "ERR request body too large", res.Error)
+		redsrv.CheckGet(t, "a", large[:50])
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		res := makeRequest(t, http.StatusOK, goodToken, "/pipeline", rawBody(" [ [\"SET\", \"a\", 12345678901234567890],\n[\"GET\", \"a\"] ]\n"), "")
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "OK", res.Results[0].Result)
+		require.Equal(t, "12345678901234567890", res.Results[1].Result)
+
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/pipeline", rawBody("null"), "")
+		require.Equal(t, "ERR empty pipeline request", res.Error)
+
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/pipeline", rawBody("[[\"SET\", \"a\", \"1\"],\n [\"GET\" \"a\"]]"), "")
+		require.Contains(t, res.Error, "failed to parse pipeline request: invalid character")
+		require.Contains(t, res.Error, "at line 2, column 9 (offset 28)")
+
+		res = makeRequest(t, http.StatusBadRequest, goodToken, "/multi-exec", rawBody("[[\"GET\", \"a\"]] []"), "")
+		require.Contains(t, res.Error, "failed to parse transaction request: unexpected data after the JSON value")
+		redsrv.CheckGet(t, "a", "12345678901234567890")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server.RequestTimeout = 50 * time.Millisecond
+		server.GetConnFunc = func(ctx context.Context) Conn {
+			return slowConn{Conn: pool.Get(), delay: time.Second}
+		}
+		defer func() {
+			server.RequestTimeout = 5 * time.Second
+			server.GetConnFunc = func(ctx context.Context) Conn { return pool.Get() }
+		}()
+
+		res := makeRequest(t, http.StatusGatewayTimeout, goodToken, "/set/a/1", nil, "")
+		require.Equal(t, "ERR request timed out", res.Error)
+
+		res = makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]interface{}{{"SET", "a", "1"}, {"GET", "a"}}, "")
+		require.Len(t, res.Results, 2)
+		require.Equal(t, "ERR request timed out", res.Results[0].Error)
+		require.Equal(t, "ERR request timed out", res.Results[1].Error)
+		redsrv.CheckGet(t, "a", "12345678901234567890")
+	})
+}
+
+// slowConn is a Conn that waits for delay before executing each command, or
+// until the context is done.
+type slowConn struct {
+	redis.Conn
+	delay time.Duration
+}
+
+func (c slowConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	select {
+	case <-time.After(c.delay):
+		return c.Conn.Do(cmd, args...)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestServerCORS(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			return pool.Get()
+		},
+		CORSOrigins: []string{"http://localhost:3000"},
+		CORSHeaders: []string{"X-Custom"},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	do := func(t *testing.T, method, origin string, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, httpsrv.URL+"/get/a", nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return res
+	}
+
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"authorization,upstash-encoding,x-custom,x-other"},
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		res := do(t, http.MethodOptions, "http://localhost:3000", preflight)
+		require.Equal(t, http.StatusNoContent, res.StatusCode)
+		require.Equal(t, "http://localhost:3000", res.Header.Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "GET, POST", res.Header.Get("Access-Control-Allow-Methods"))
+		require.Equal(t, "authorization, upstash-encoding, x-custom", res.Header.Get("Access-Control-Allow-Headers"))
+		require.Contains(t, res.Header.Values("Vary"), "Origin")
+	})
+
+	t.Run("preflight not allowed", func(t *testing.T) {
+		res := do(t, http.MethodOptions, "http://example.com", preflight)
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("request", func(t *testing.T) {
+		auth := http.Header{"Authorization": {"Bearer " + goodToken}}
+		res := do(t, http.MethodGet, "http://localhost:3000", auth)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "http://localhost:3000", res.Header.Get("Access-Control-Allow-Origin"))
+		require.Equal(t, cursorHeader, res.Header.Get("Access-Control-Expose-Headers"))
+
+		res = do(t, http.MethodGet, "http://example.com", auth)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+		res = do(t, http.MethodGet, "", auth)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		server.CORSOrigins = []string{"*"}
+		defer func() { server.CORSOrigins = []string{"http://localhost:3000"} }()
+
+		res := do(t, http.MethodOptions, "http://example.com", preflight)
+		require.Equal(t, http.StatusNoContent, res.StatusCode)
+		require.Equal(t, "http://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server.CORSOrigins = nil
+		defer func() { server.CORSOrigins = []string{"http://localhost:3000"} }()
+
+		res := do(t, http.MethodOptions, "http://localhost:3000", preflight)
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestServerMetrics(t *testing.T) {
+	redsrv := miniredis.RunT(t)
+	pool := redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redsrv.Addr())
+		},
+	}
+	defer pool.Close()
+
+	const goodToken = "_token_"
+	down := false
+	server := &Server{
+		APIToken: goodToken,
+		GetConnFunc: func(ctx context.Context) Conn {
+			if down {
+				return failedConn{err: errors.New("connection refused")}
+			}
+			return pool.Get()
+		},
+	}
+
+	httpsrv := httptest.NewServer(server)
+	defer httpsrv.Close()
+
+	cli := &http.Client{Timeout: 5 * time.Second}
+	makeRequest := genMakeRequestFunc(httpsrv.URL, cli)
+
+	makeRequest(t, http.StatusUnauthorized, "nope", "/get/a", nil, "")
+	makeRequest(t, http.StatusOK, goodToken, "/set/a/1", nil, "")
+	makeRequest(t, http.StatusOK, goodToken, "/pipeline", [][]interface{}{{"GET", "a"}, {"HGETALL", "a"}}, "")
+	makeRequest(t, http.StatusOK, goodToken, "/multi-exec", [][]interface{}{{"GET", "a"}}, "")
+
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, want := range []string{
+		"upstash_rest_server_auth_failures_total 1\n",
+		`upstash_rest_server_commands_total{command="get"} 1` + "\n",
+		`upstash_rest_server_commands_total{command="set"} 1` + "\n",
+		`upstash_rest_server_commands_total{command="multi-exec"} 1` + "\n",
+		`upstash_rest_server_command_errors_total{command="hgetall"} 1` + "\n",
+		`upstash_rest_server_command_duration_seconds_count{command="set"} 1` + "\n",
+		"upstash_rest_server_redis_connections_active 0\n",
+	} {
+		require.Contains(t, body, want)
+	}
+
+	t.Run("health", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		server.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+		down = true
+		defer func() { down = false }()
+		rec = httptest.NewRecorder()
+		server.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.JSONEq(t, `{"status":"unavailable","error":"connection refused"}`, rec.Body.String())
+	})
+}
+
+func TestNormalizeReply(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, `null`},
+		{"OK", `"OK"`},
+		{[]byte("v"), `"v"`},
+		{int64(-1), `-1`},
+		{42, `42`},
+		{uint8(1), `1`},
+		{uint64(math.MaxUint64), `18446744073709551615`},
+		{json.Number("9007199254740993"), `9007199254740993`},
+		{1.5, `"1.5"`},
+		{float32(0.25), `"0.25"`},
+		{math.Inf(-1), `"-inf"`},
+		{true, `1`},
+		{false, `0`},
+		{redis.Error("ERR nested"), `"ERR nested"`},
+		{[]string{"a", "b"}, `["a","b"]`},
+		{[][]byte{[]byte("a"), nil}, `["a",null]`},
+		{[]interface{}{"0", []interface{}{[]byte("k"), int64(1)}}, `["0",["k",1]]`},
+		{[]interface{}{[]interface{}{"1-0", map[string]string{"f2": "v2", "f1": "v1"}}}, `[["1-0",["f1","v1","f2","v2"]]]`},
+		{map[string]float64{"b": 2.5, "a": 1}, `["a","1","b","2.5"]`},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%T", c.in), func(t *testing.T) {
+			b, err := marshalReply(normalizeReply(c.in))
+			require.NoError(t, err)
+			require.Equal(t, c.want, string(b))
+		})
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	cases := []struct {
+		dur  time.Duration
+		want float64
+	}{
+		{0, 1},
+		{time.Microsecond, 1},
+		{2 * time.Microsecond, 2},
+		{3 * time.Microsecond, 3.4},
+		{time.Millisecond, 1024},
+		{2 * time.Hour, bucketUpperBound(latencyBuckets - 1)},
+	}
+	for _, c := range cases {
+		t.Run(c.dur.String(), func(t *testing.T) {
+			require.Equal(t, c.want, bucketUpperBound(latencyBucket(c.dur)))
+		})
+	}
+}
+
 func TestServerRedisPool(t *testing.T) {
 	redisAddr := os.Getenv("UPSTASHDIS_TEST_REDIS_ADDR")
 	if redisAddr == "" {
@@ -334,7 +2122,9 @@ func genMakeRequestFunc(srvURL string, cli *http.Client) func(*testing.T, int, s
 		}
 
 		var rbody io.Reader
-		if body != nil {
+		if raw, ok := body.(rawBody); ok {
+			rbody = strings.NewReader(string(raw))
+		} else if body != nil {
 			b, err := json.Marshal(body)
 			require.NoError(t, err)
 			rbody = bytes.NewReader(b)
@@ -363,7 +2153,7 @@ func genMakeRequestFunc(srvURL string, cli *http.Client) func(*testing.T, int, s
 		require.Equal(t, code, res.StatusCode, string(resBody))
 
 		var restResult result
-		if path == "/pipeline" && res.StatusCode == http.StatusOK {
+		if (path == "/pipeline" || path == "/multi-exec") && res.StatusCode == http.StatusOK {
 			require.NoError(t, json.Unmarshal(resBody, &restResult.Results), string(resBody))
 		} else if len(resBody) > 0 {
 			require.NoError(t, json.Unmarshal(resBody, &restResult))
@@ -0,0 +1,80 @@
+package restserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// deriveKey derives a 32-bytes key for the specified purpose from the
+// secret.
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("upstashdis resttoken " + purpose))
+	return mac.Sum(nil)
+}
+
+func restTokenAEAD(secret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealRestToken derives the ACL RESTTOKEN for the username and password: it
+// is the encryption of the credentials with a key derived from the secret,
+// so that the server can authenticate with those credentials when it
+// receives the token without having to store them. The nonce is the HMAC of
+// the credentials, so that the same credentials always result in the same
+// token.
+func sealRestToken(secret, user, pwd string) (string, error) {
+	aead, err := restTokenAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+
+	plain := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(user)+len(pwd))
+	n := binary.PutUvarint(plain, uint64(len(user)))
+	plain = append(plain[:n], user...)
+	plain = append(plain, pwd...)
+
+	mac := hmac.New(sha256.New, deriveKey(secret, "nonce"))
+	mac.Write(plain)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openRestToken returns the username and password encrypted in a token
+// generated by sealRestToken with the same secret.
+func openRestToken(secret, tok string) (user, pwd string, err error) {
+	aead, err := restTokenAEAD(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return "", "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", "", errors.New("invalid token")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	ulen, n := binary.Uvarint(plain)
+	if n <= 0 || ulen > uint64(len(plain)-n) {
+		return "", "", errors.New("invalid token")
+	}
+	plain = plain[n:]
+	return string(plain[:ulen]), string(plain[ulen:]), nil
+}
@@ -0,0 +1,158 @@
+package restserver
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// number of latency histogram buckets per power of 2, and total number of
+	// buckets (the last one holds all latencies above ~2^32µs).
+	bucketsPerOctave = 4
+	latencyBuckets   = 32*bucketsPerOctave + 1
+
+	// maximum number of distinct command names tracked, the others are
+	// aggregated under otherCmdStats. This prevents unbounded growth of the
+	// stats when clients send arbitrary (invalid) command names.
+	maxCmdStats   = 512
+	otherCmdStats = "_other"
+
+	// name under which the statistics of transactions are recorded.
+	txStatsName = "multi-exec"
+)
+
+// commandStats tracks the statistics of the commands executed by the
+// server, keyed by lowercase command name.
+type commandStats struct {
+	mu    sync.Mutex
+	start time.Time
+	cmds  map[string]*cmdStats
+}
+
+type cmdStats struct {
+	calls  int64
+	failed int64
+	total  time.Duration
+	hist   [latencyBuckets]int64
+}
+
+// cmdStatsResult is the JSON representation of the statistics of a command
+// returned by the /admin/commandstats endpoint. Latencies are in
+// microseconds, the percentiles are estimated from a histogram with a
+// precision of about 20%.
+type cmdStatsResult struct {
+	Calls       int64   `json:"calls"`
+	FailedCalls int64   `json:"failed_calls"`
+	Usec        int64   `json:"usec"`
+	UsecPerCall float64 `json:"usec_per_call"`
+	P50         float64 `json:"p50_usec"`
+	P90         float64 `json:"p90_usec"`
+	P99         float64 `json:"p99_usec"`
+}
+
+type commandStatsResult struct {
+	Since    time.Time                  `json:"since"`
+	Commands map[string]*cmdStatsResult `json:"commands"`
+}
+
+// record records the execution of the command and returns the name under
+// which it was recorded.
+func (s *commandStats) record(cmd string, dur time.Duration, failed bool) string {
+	cmd = strings.ToLower(cmd)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmds == nil {
+		s.start = time.Now().UTC()
+		s.cmds = make(map[string]*cmdStats)
+	}
+	st := s.cmds[cmd]
+	if st == nil {
+		if len(s.cmds) >= maxCmdStats {
+			cmd = otherCmdStats
+			st = s.cmds[cmd]
+		}
+		if st == nil {
+			st = &cmdStats{}
+			s.cmds[cmd] = st
+		}
+	}
+
+	st.calls++
+	if failed {
+		st.failed++
+	}
+	st.total += dur
+	st.hist[latencyBucket(dur)]++
+	return cmd
+}
+
+// serveCommandStats serves the /admin/commandstats endpoint.
+func (s *Server) serveCommandStats(w http.ResponseWriter, a auth) {
+	if a.ReadOnly || a.Username != "" {
+		reply(w, errorResult{"NOPERM this user has no permissions to access the command statistics"}, http.StatusForbidden)
+		return
+	}
+	reply(w, successResult{Result: s.stats.snapshot()}, http.StatusOK)
+}
+
+func (s *commandStats) snapshot() commandStatsResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := commandStatsResult{
+		Since:    s.start,
+		Commands: make(map[string]*cmdStatsResult, len(s.cmds)),
+	}
+	for name, st := range s.cmds {
+		usec := st.total.Microseconds()
+		res.Commands[name] = &cmdStatsResult{
+			Calls:       st.calls,
+			FailedCalls: st.failed,
+			Usec:        usec,
+			UsecPerCall: float64(usec) / float64(st.calls),
+			P50:         st.percentile(50),
+			P90:         st.percentile(90),
+			P99:         st.percentile(99),
+		}
+	}
+	return res
+}
+
+// percentile returns the upper bound in microseconds of the histogram bucket
+// that holds the p percentile.
+func (st *cmdStats) percentile(p int) float64 {
+	rank := (st.calls*int64(p) + 99) / 100
+	if rank == 0 {
+		rank = 1
+	}
+	var n int64
+	for i, count := range st.hist {
+		if n += count; n >= rank {
+			return bucketUpperBound(i)
+		}
+	}
+	return bucketUpperBound(latencyBuckets - 1)
+}
+
+// latencyBucket returns the index of the histogram bucket for dur: bucket i
+// holds the latencies in the range (2^((i-1)/4), 2^(i/4)] microseconds.
+func latencyBucket(dur time.Duration) int {
+	usec := float64(dur) / float64(time.Microsecond)
+	if usec <= 1 {
+		return 0
+	}
+	ix := int(math.Ceil(bucketsPerOctave * math.Log2(usec)))
+	if ix >= latencyBuckets {
+		ix = latencyBuckets - 1
+	}
+	return ix
+}
+
+func bucketUpperBound(ix int) float64 {
+	return math.Round(math.Exp2(float64(ix)/bucketsPerOctave)*10) / 10
+}
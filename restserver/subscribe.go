@@ -0,0 +1,186 @@
+package restserver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SubscriberConn defines the methods required for a redis connection used
+// to subscribe to channels. It is a subset of the popular redigo Conn
+// interface, and like redigo connections, it must support one concurrent
+// caller to Receive and one concurrent caller to Send and Flush.
+type SubscriberConn interface {
+	Conn
+
+	// Send writes the command to the client's output buffer.
+	Send(commandName string, args ...interface{}) error
+
+	// Flush flushes the output buffer to the Redis server.
+	Flush() error
+
+	// Receive receives a single reply from the Redis server.
+	Receive() (reply interface{}, err error)
+}
+
+// subscribeRoute returns the subscribe command and the channel (or pattern)
+// if the path is a subscribe request, e.g. /subscribe/<channel> or
+// /psubscribe/<pattern>.
+func subscribeRoute(path string) (cmd, channel string, ok bool) {
+	for _, cmd := range []string{"subscribe", "psubscribe"} {
+		prefix := "/" + cmd + "/"
+		if strings.HasPrefix(path, prefix) {
+			ch := strings.TrimSuffix(path[len(prefix):], "/")
+			return cmd, ch, ch != ""
+		}
+	}
+	return "", "", false
+}
+
+// subscriberConn returns the connection to use to subscribe to channels, or
+// nil if none is available.
+func (s *Server) subscriberConn(r *http.Request) SubscriberConn {
+	if s.GetSubscriberConnFunc != nil {
+		return s.GetSubscriberConnFunc(r.Context())
+	}
+	conn := s.GetConnFunc(r.Context())
+	if sc, ok := conn.(SubscriberConn); ok {
+		return sc
+	}
+	conn.Close()
+	return nil
+}
+
+// serveSubscribe subscribes to the channel (or pattern, if cmd is
+// psubscribe) and streams the messages as server-sent events until the
+// client disconnects. Each event's data is the comma-separated message
+// received from Redis, e.g. "message,<channel>,<payload>".
+func (s *Server) serveSubscribe(w http.ResponseWriter, r *http.Request, a auth, cmd, channel string) {
+	if a.ReadOnly && !isReadOnlyCmd(cmd) {
+		reply(w, errorResult{Error: fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", cmd)}, http.StatusBadRequest)
+		return
+	}
+	if !s.commandAllowed(cmd, []interface{}{channel}) {
+		reply(w, notAllowedError(cmd), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		reply(w, errorResult{"ERR streaming is not supported"}, http.StatusInternalServerError)
+		return
+	}
+
+	conn := s.subscriberConn(r)
+	if conn == nil {
+		reply(w, errorResult{"ERR subscribe is not supported"}, http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+	conns := s.metrics().conns.With()
+	conns.Add(1)
+	defer conns.Add(-1)
+
+	if a.Username != "" {
+		if _, err := conn.Do("AUTH", a.Username, a.Password); err != nil {
+			reply(w, errorResult{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := conn.Send(strings.ToUpper(cmd), channel); err != nil {
+		reply(w, errorResult{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		reply(w, errorResult{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// receive the messages in a separate goroutine, so that the client's
+	// disconnection can be detected.
+	msgs := make(chan []string)
+	go receiveMessages(conn, msgs)
+
+	bw := bufio.NewWriter(w)
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			writeEvent(bw, msg)
+			if bw.Flush() == nil {
+				flusher.Flush()
+			}
+
+		case <-r.Context().Done():
+			// unsubscribe and wait for the receiving goroutine to terminate before
+			// the connection is closed.
+			_ = conn.Send("UNSUBSCRIBE")
+			_ = conn.Send("PUNSUBSCRIBE")
+			_ = conn.Flush()
+			for range msgs {
+			}
+			return
+		}
+	}
+}
+
+// receiveMessages receives the messages from conn and sends them on msgs,
+// until an error occurs or the connection unsubscribes from all channels,
+// at which point it closes msgs.
+func receiveMessages(conn SubscriberConn, msgs chan<- []string) {
+	defer close(msgs)
+	for {
+		v, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		vals, ok := v.([]interface{})
+		if !ok || len(vals) == 0 {
+			continue
+		}
+
+		msg := make([]string, len(vals))
+		for i, v := range vals {
+			switch v := v.(type) {
+			case []byte:
+				msg[i] = string(v)
+			case int64:
+				msg[i] = strconv.FormatInt(v, 10)
+			default:
+				msg[i] = fmt.Sprint(v)
+			}
+		}
+
+		switch strings.ToLower(msg[0]) {
+		case "unsubscribe", "punsubscribe":
+			// the count of remaining subscriptions is the last value
+			if msg[len(msg)-1] == "0" {
+				return
+			}
+		default:
+			msgs <- msg
+		}
+	}
+}
+
+// writeEvent writes the message as a server-sent event. The message values
+// are joined with commas and the lines of the data are written as separate
+// data fields, as required by the event stream format.
+func writeEvent(bw *bufio.Writer, msg []string) {
+	data := strings.Join(msg, ",")
+	for _, line := range strings.Split(data, "\n") {
+		bw.WriteString("data: ")
+		bw.WriteString(strings.TrimSuffix(line, "\r"))
+		bw.WriteString("\n")
+	}
+	bw.WriteString("\n")
+}
@@ -0,0 +1,218 @@
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RestToken is an API token generated by ACL RESTTOKEN, with the Redis
+// credentials used to execute the commands of the requests authenticated
+// with it.
+type RestToken struct {
+	Token     string    `json:"token"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is the time after which the token is not valid anymore, zero
+	// if it never expires.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// expired returns true if the token is expired at time now.
+func (t RestToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// TokenStore stores the API tokens generated by ACL RESTTOKEN. Its methods
+// may be called concurrently. The server checks the expiration of the
+// tokens returned by Get, so implementations may return expired tokens,
+// but they should eventually remove them.
+type TokenStore interface {
+	// Get returns the token, or false if it does not exist.
+	Get(ctx context.Context, token string) (RestToken, bool, error)
+
+	// Set stores the token, replacing it if it exists.
+	Set(ctx context.Context, tok RestToken) error
+
+	// Delete deletes the token and returns true if it existed.
+	Delete(ctx context.Context, token string) (bool, error)
+
+	// List returns all tokens, in unspecified order.
+	List(ctx context.Context) ([]RestToken, error)
+}
+
+// memoryTokenStore is the default TokenStore, it stores the tokens in
+// memory.
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]RestToken
+}
+
+func (s *memoryTokenStore) Get(_ context.Context, token string) (RestToken, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.tokens[token]
+	return tok, ok, nil
+}
+
+func (s *memoryTokenStore) Set(_ context.Context, tok RestToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]RestToken)
+	}
+	removeExpired(s.tokens, time.Now())
+	s.tokens[tok.Token] = tok
+	return nil
+}
+
+func (s *memoryTokenStore) Delete(_ context.Context, token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tokens[token]
+	delete(s.tokens, token)
+	return ok, nil
+}
+
+func (s *memoryTokenStore) List(_ context.Context) ([]RestToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return tokenList(s.tokens), nil
+}
+
+// FileTokenStore is a TokenStore that persists the tokens as JSON in a file,
+// so that they survive server restarts. The file is read on first use and
+// written after each change, the tokens being kept in memory. It holds the
+// Redis credentials associated with the tokens, so it is created with
+// read-write permissions for the owner only, and it must not be shared by
+// multiple servers.
+type FileTokenStore struct {
+	// Path is the path of the file. It is created if it does not exist.
+	Path string
+
+	mu     sync.Mutex
+	tokens map[string]RestToken // nil until loaded
+}
+
+func (s *FileTokenStore) Get(_ context.Context, token string) (RestToken, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return RestToken{}, false, err
+	}
+	tok, ok := s.tokens[token]
+	return tok, ok, nil
+}
+
+func (s *FileTokenStore) Set(_ context.Context, tok RestToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	removeExpired(s.tokens, time.Now())
+	s.tokens[tok.Token] = tok
+	return s.save()
+}
+
+func (s *FileTokenStore) Delete(_ context.Context, token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return false, err
+	}
+	if _, ok := s.tokens[token]; !ok {
+		return false, nil
+	}
+	delete(s.tokens, token)
+	return true, s.save()
+}
+
+func (s *FileTokenStore) List(_ context.Context) ([]RestToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return tokenList(s.tokens), nil
+}
+
+// load reads the tokens from the file if they are not loaded yet.
+func (s *FileTokenStore) load() error {
+	if s.tokens != nil {
+		return nil
+	}
+
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.tokens = make(map[string]RestToken)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var toks []RestToken
+	if err := json.Unmarshal(b, &toks); err != nil {
+		return err
+	}
+	s.tokens = make(map[string]RestToken, len(toks))
+	for _, tok := range toks {
+		s.tokens[tok.Token] = tok
+	}
+	return nil
+}
+
+// save writes the tokens to a temporary file and renames it to the file, so
+// that the file is never partially written.
+func (s *FileTokenStore) save() error {
+	b, err := json.MarshalIndent(tokenList(s.tokens), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails if renamed, ignore
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
+
+func removeExpired(tokens map[string]RestToken, now time.Time) {
+	for k, tok := range tokens {
+		if tok.expired(now) {
+			delete(tokens, k)
+		}
+	}
+}
+
+// tokenList returns the tokens sorted by creation time.
+func tokenList(tokens map[string]RestToken) []RestToken {
+	toks := make([]RestToken, 0, len(tokens))
+	for _, tok := range tokens {
+		toks = append(toks, tok)
+	}
+	sort.Slice(toks, func(i, j int) bool {
+		if toks[i].CreatedAt.Equal(toks[j].CreatedAt) {
+			return toks[i].Token < toks[j].Token
+		}
+		return toks[i].CreatedAt.Before(toks[j].CreatedAt)
+	})
+	return toks
+}
@@ -0,0 +1,252 @@
+package restserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userConnPools holds the pools of connections authenticated with the
+// credentials of the REST tokens, keyed by token.
+type userConnPools struct {
+	mu        sync.Mutex
+	pools     map[string]*userConnPool
+	lastPrune time.Time
+}
+
+// userConnPool is the pool of the idle connections of a token, the most
+// recently used last.
+type userConnPool struct {
+	idle  []idleConn
+	inUse int // number of connections returned by getUserConn not released yet
+}
+
+type idleConn struct {
+	conn Conn
+	t    time.Time
+}
+
+// userConn is a connection of a per-token pool in use. It tracks the
+// commands that change the state of the connection, so that it can be reset
+// before it is returned to the pool, or closed if it cannot be.
+type userConn struct {
+	Conn
+	defaultDB int
+
+	multi    bool // MULTI was sent without EXEC or DISCARD
+	watch    bool // WATCH was sent without UNWATCH, EXEC or DISCARD
+	selected bool // a database other than defaultDB was selected
+	dirty    bool // the state of the connection cannot be reset
+}
+
+func (c *userConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	v, err := c.Conn.Do(cmd, args...)
+	c.track(cmd, args, err)
+	return v, err
+}
+
+// DoContext is like Do, with the deadline of ctx enforced as for a
+// timeoutConn.
+func (c *userConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	v, err := timeoutConn{Conn: c.Conn, ctx: ctx}.Do(cmd, args...)
+	c.track(cmd, args, err)
+	return v, err
+}
+
+// track records the change of the state of the connection made by the
+// command, which failed if err is not nil.
+func (c *userConn) track(cmd string, args []interface{}, err error) {
+	name := strings.ToUpper(cmd)
+	switch name {
+	case "AUTH", "HELLO", "RESET", "CLIENT", "READONLY", "READWRITE", "MONITOR",
+		"SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+		c.dirty = true
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	switch name {
+	case "MULTI":
+		c.multi = true
+	case "EXEC", "DISCARD":
+		c.multi, c.watch = false, false
+	case "WATCH":
+		c.watch = true
+	case "UNWATCH":
+		c.watch = false
+	case "SELECT":
+		c.selected = len(args) != 1 || fmt.Sprint(args[0]) != strconv.Itoa(c.defaultDB)
+	}
+}
+
+// reset resets the state of the connection changed by the commands it
+// executed. It returns false if the connection must be closed instead.
+func (c *userConn) reset() bool {
+	if c.dirty {
+		return false
+	}
+	if c.multi {
+		if _, err := c.Do("DISCARD"); err != nil {
+			return false
+		}
+	}
+	if c.watch {
+		if _, err := c.Do("UNWATCH"); err != nil {
+			return false
+		}
+	}
+	if c.selected {
+		if _, err := c.Do("SELECT", c.defaultDB); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// userConnsEnabled returns true if the requests authenticated with the
+// token a use the connections of the per-token pools.
+func (s *Server) userConnsEnabled(a auth) bool {
+	return s.DialConnFunc != nil && a.Username != ""
+}
+
+// getUserConn returns a connection authenticated with the credentials of
+// the token, from the pool of that token if it has an idle connection,
+// otherwise a new connection returned by DialConnFunc. If the new connection
+// fails to authenticate, it is closed and the error is returned with its
+// status code. The connection must be released with putUserConn.
+func (s *Server) getUserConn(ctx context.Context, token string, a auth) (Conn, interface{}, int) {
+	now := time.Now()
+
+	s.userConns.mu.Lock()
+	s.pruneUserConns(now)
+	p := s.userConns.pools[token]
+	if p == nil {
+		if s.userConns.pools == nil {
+			s.userConns.pools = make(map[string]*userConnPool)
+		}
+		p = &userConnPool{}
+		s.userConns.pools[token] = p
+	}
+	p.inUse++
+	if len(p.idle) > 0 {
+		ic := p.idle[len(p.idle)-1]
+		p.idle[len(p.idle)-1] = idleConn{}
+		p.idle = p.idle[:len(p.idle)-1]
+		s.userConns.mu.Unlock()
+		return &userConn{Conn: ic.conn, defaultDB: s.DefaultDB}, nil, http.StatusOK
+	}
+	s.userConns.mu.Unlock()
+
+	conn := s.DialConnFunc(ctx)
+	if v, code := s.execCmd(conn, "AUTH", a.Username, a.Password); code != http.StatusOK {
+		conn.Close()
+		s.releaseUserConn(token, nil)
+		return nil, v, code
+	}
+	return &userConn{Conn: conn, defaultDB: s.DefaultDB}, nil, http.StatusOK
+}
+
+// putUserConn releases the connection returned by getUserConn, adding it to
+// the idle connections of the pool of the token unless it is broken, its
+// state cannot be reset, the pool is full or the token was revoked, in which
+// case it is closed.
+func (s *Server) putUserConn(token string, conn Conn) {
+	uc := conn.(*userConn)
+	if ec, ok := uc.Conn.(interface{ Err() error }); (ok && ec.Err() != nil) || !uc.reset() {
+		uc.Conn.Close()
+		s.releaseUserConn(token, nil)
+		return
+	}
+	s.releaseUserConn(token, uc.Conn)
+}
+
+// releaseUserConn releases a connection of the pool of the token, adding
+// conn to its idle connections if it is not nil. The connection is closed
+// if the pool is full or was dropped.
+func (s *Server) releaseUserConn(token string, conn Conn) {
+	max := s.MaxIdleUserConns
+	if max <= 0 {
+		max = 1
+	}
+
+	s.userConns.mu.Lock()
+	p := s.userConns.pools[token]
+	if p != nil {
+		p.inUse--
+	}
+	if conn == nil {
+		s.userConns.mu.Unlock()
+		return
+	}
+	if p == nil || len(p.idle) >= max {
+		s.userConns.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, t: time.Now()})
+	s.userConns.mu.Unlock()
+}
+
+// dropUserConns closes the idle connections of the pool of the token and
+// removes that pool, e.g. when the token is revoked. The connections of
+// that token in use when it is called are closed when they are released.
+func (s *Server) dropUserConns(token string) {
+	s.userConns.mu.Lock()
+	defer s.userConns.mu.Unlock()
+
+	if p := s.userConns.pools[token]; p != nil {
+		for _, ic := range p.idle {
+			ic.conn.Close()
+		}
+		delete(s.userConns.pools, token)
+	}
+}
+
+// pruneUserConns closes the connections that have been idle for longer than
+// the UserConnIdleTimeout and removes the empty pools. To limit the cost of
+// pruning, it runs at most once per half the timeout. The lock must be held
+// by the caller.
+func (s *Server) pruneUserConns(now time.Time) {
+	timeout := s.UserConnIdleTimeout
+	if timeout <= 0 || now.Sub(s.userConns.lastPrune) < timeout/2 {
+		return
+	}
+	s.userConns.lastPrune = now
+
+	for token, p := range s.userConns.pools {
+		// the idle connections are ordered from the least recently used
+		var n int
+		for n < len(p.idle) && now.Sub(p.idle[n].t) >= timeout {
+			p.idle[n].conn.Close()
+			n++
+		}
+		p.idle = append(p.idle[:0], p.idle[n:]...)
+		if len(p.idle) == 0 && p.inUse == 0 {
+			delete(s.userConns.pools, token)
+		}
+	}
+}
+
+// CloseUserConns closes the idle connections of the per-token pools, e.g.
+// when the server is shut down. The connections in use when it is called
+// are added to the pools when they are released.
+func (s *Server) CloseUserConns() {
+	s.userConns.mu.Lock()
+	defer s.userConns.mu.Unlock()
+
+	for token, p := range s.userConns.pools {
+		for _, ic := range p.idle {
+			ic.conn.Close()
+		}
+		p.idle = nil
+		if p.inUse == 0 {
+			delete(s.userConns.pools, token)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package upstashdis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mna/upstashdis/internal/rediscmd"
+)
+
+const (
+	defaultMinBackoff = 50 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+)
+
+// DefaultRetryPolicy is a sensible retry policy for the Upstash Redis REST
+// API, that can be set as Client.Retry.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  defaultMinBackoff,
+	MaxBackoff:  defaultMaxBackoff,
+	Jitter:      0.5,
+}
+
+// RetryPolicy configures the automatic retry of the REST API calls that
+// failed because of a transient error. By default, a call is only retried
+// when it is certain that the request was not executed: on a 429 Too Many
+// Requests or 503 Service Unavailable response, or if the connection to the
+// server could not be established. Other failures that may be transient (a
+// 500, 502 or 504 response, or a network error after the request may have
+// been sent) are only retried if the request is idempotent, that is if all
+// its commands are read-only (for Client.Call, if the HTTP method is
+// idempotent), or if RetryUnsafe is set. Calls are never retried once their
+// context is done.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first
+	// one. If it is <= 1, calls are not retried.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry, doubled for each
+	// subsequent retry up to MaxBackoff. If it is <= 0, it defaults to 50ms.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between attempts, including the delay
+	// requested by the Retry-After header of the response. If it is <= 0,
+	// it defaults to 2s.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of the delay that is randomized, between 0 (no
+	// jitter) and 1 (the delay is anywhere between 0 and its full value), so
+	// that clients that failed at the same time do not retry in lockstep.
+	Jitter float64
+
+	// RetryUnsafe enables the retry of calls that are not idempotent even if
+	// the request may have been executed. This is only safe if executing the
+	// commands more than once is not a problem.
+	RetryUnsafe bool
+}
+
+// RetryError is the error returned when a REST API call failed after it was
+// retried. Err is the error of the last attempt, it can be inspected with
+// errors.Is and errors.As.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error returns the error message.
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("upstashdis: failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// WithRetry sets the retry policy of the request, overriding the Retry
+// policy of the client. A nil policy disables the retry for this request. It
+// returns the request so that calls can be chained.
+func (r *Request) WithRetry(p *RetryPolicy) *Request {
+	r.retry = p
+	r.retrySet = true
+	return r
+}
+
+// retryPolicy returns the retry policy of the request.
+func (r *Request) retryPolicy() *RetryPolicy {
+	if r.retrySet {
+		return r.retry
+	}
+	return r.c.Retry
+}
+
+// idempotent returns true if all commands are read-only.
+func idempotent(cmds [][]interface{}) bool {
+	for _, cmd := range cmds {
+		if name, ok := cmd[0].(string); !ok || !rediscmd.IsReadOnly(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotentMethod returns true if the HTTP method is idempotent.
+func idempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// retryable returns whether the attempt that returned res and err can be
+// retried, and if so the delay requested by the server, if any.
+func (p *RetryPolicy) retryable(ctx context.Context, res *http.Response, err error, idempotent bool) (bool, time.Duration) {
+	if ctx != nil && ctx.Err() != nil {
+		return false, 0
+	}
+	unsafeOK := idempotent || p.RetryUnsafe
+
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true, 0
+		}
+		return unsafeOK, 0
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true, retryAfter(res.Header)
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return unsafeOK, 0
+	}
+	return false, 0
+}
+
+// backoff returns the delay before the retry that follows the attempt
+// (1-based) that failed.
+func (p *RetryPolicy) backoff(attempt int, after time.Duration) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		j := p.Jitter
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(rand.Float64() * j * float64(d))
+	}
+	if after > d {
+		d = after
+		if d > max {
+			d = max
+		}
+	}
+	return d
+}
+
+// retryAfter returns the delay of the Retry-After header of a response, in
+// seconds, or 0 if it is not set or is not a number of seconds.
+func retryAfter(hdr http.Header) time.Duration {
+	secs, err := strconv.Atoi(hdr.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// sleepCtx waits for d or until ctx is done, in which case it returns the
+// context's error.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
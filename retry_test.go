@@ -0,0 +1,164 @@
+package upstashdis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer fails the requests with the statuses in order, and then
+// succeeds.
+type flakyServer struct {
+	statuses []int
+	hits     int64
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt64(&f.hits, 1)
+	if int(n) <= len(f.statuses) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(f.statuses[n-1])
+		_, _ = w.Write([]byte(`{"error":"ERR transient"}`))
+		return
+	}
+	if r.URL.Path == "/pipeline" {
+		_, _ = w.Write([]byte(`[{"result":"OK"},{"result":"OK"}]`))
+		return
+	}
+	_, _ = w.Write([]byte(`{"result":"OK"}`))
+}
+
+func TestRetry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+	newClient := func(t *testing.T, statuses ...int) (*Client, *flakyServer) {
+		fake := &flakyServer{statuses: statuses}
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		return &Client{BaseURL: srv.URL, APIToken: "tok", Retry: policy}, fake
+	}
+
+	t.Run("rate limited", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SET", "a", "1"))
+		require.Equal(t, int64(3), fake.hits)
+	})
+
+	t.Run("unsafe not retried", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusInternalServerError)
+		err := cli.NewRequest().ExecOne(nil, "INCR", "a")
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		var retryErr *RetryError
+		require.False(t, errors.As(err, &retryErr))
+		require.Equal(t, int64(1), fake.hits)
+	})
+
+	t.Run("idempotent retried", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusBadGateway, http.StatusGatewayTimeout)
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.Send("TTL", "a"))
+		_, err := req.ExecRaw()
+		require.NoError(t, err)
+		require.Equal(t, int64(3), fake.hits)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		cli, fake := newClient(t, 500, 500, 500, 500)
+		err := cli.NewRequest().ExecOne(nil, "GET", "a")
+		var retryErr *RetryError
+		require.True(t, errors.As(err, &retryErr), "%v", err)
+		require.Equal(t, 3, retryErr.Attempts)
+		var rerr *Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Equal(t, "ERR transient", rerr.Message)
+		require.Equal(t, int64(3), fake.hits)
+	})
+
+	t.Run("retry unsafe", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusInternalServerError)
+		p := *policy
+		p.RetryUnsafe = true
+		require.NoError(t, cli.NewRequest().WithRetry(&p).ExecOne(nil, "INCR", "a"))
+		require.Equal(t, int64(2), fake.hits)
+	})
+
+	t.Run("disabled for request", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusTooManyRequests)
+		require.Error(t, cli.NewRequest().WithRetry(nil).ExecOne(nil, "GET", "a"))
+		require.Equal(t, int64(1), fake.hits)
+	})
+
+	t.Run("client error not retried", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusBadRequest)
+		require.Error(t, cli.NewRequest().ExecOne(nil, "GET", "a"))
+		require.Equal(t, int64(1), fake.hits)
+	})
+
+	t.Run("call", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusBadGateway)
+		_, err := cli.Call(context.Background(), "GET", "info", nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), fake.hits)
+
+		cli, fake = newClient(t, http.StatusBadGateway)
+		_, err = cli.Call(context.Background(), "POST", "upsert", nil)
+		require.Error(t, err)
+		require.Equal(t, int64(1), fake.hits)
+	})
+
+	t.Run("dial error", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		cli := &Client{BaseURL: "http://" + addr, Retry: policy}
+		err = cli.NewRequest().ExecOne(nil, "INCR", "a")
+		var retryErr *RetryError
+		require.True(t, errors.As(err, &retryErr), "%v", err)
+		require.Equal(t, 3, retryErr.Attempts)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		cli, fake := newClient(t, http.StatusTooManyRequests, http.StatusTooManyRequests)
+		p := *policy
+		p.MinBackoff, p.MaxBackoff = time.Minute, time.Minute
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := cli.NewRequestContext(ctx).WithRetry(&p).ExecOne(nil, "GET", "a")
+		require.Error(t, err)
+		require.Equal(t, int64(1), fake.hits)
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	require.Equal(t, 10*time.Millisecond, p.backoff(1, 0))
+	require.Equal(t, 20*time.Millisecond, p.backoff(2, 0))
+	require.Equal(t, 40*time.Millisecond, p.backoff(3, 0))
+	require.Equal(t, 50*time.Millisecond, p.backoff(4, 0))
+	require.Equal(t, 50*time.Millisecond, p.backoff(100, 0))
+
+	// retry-after is honored, up to the max
+	require.Equal(t, 30*time.Millisecond, p.backoff(1, 30*time.Millisecond))
+	require.Equal(t, 50*time.Millisecond, p.backoff(1, time.Second))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2, 0)
+		require.GreaterOrEqual(t, d, 10*time.Millisecond)
+		require.LessOrEqual(t, d, 20*time.Millisecond)
+	}
+
+	var zero RetryPolicy
+	require.Equal(t, defaultMinBackoff, zero.backoff(1, 0))
+}
@@ -0,0 +1,151 @@
+package upstashdis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ScanOptions are the options of a SCAN, HSCAN, SSCAN or ZSCAN iteration.
+type ScanOptions struct {
+	// Match only returns the elements that match the glob-style pattern.
+	Match string
+
+	// Count is a hint of the number of elements to return for each call,
+	// i.e. for each REST API request.
+	Count int64
+
+	// Type only returns the keys of that type, for SCAN only.
+	Type string
+}
+
+// ScanIterator iterates over the elements returned by a cursor-based scan,
+// executing the successive SCAN (or HSCAN, SSCAN, ZSCAN) calls as needed. It
+// is created by the Scan methods of Commands. Usage:
+//
+//	it := cmds.Scan(ctx, upstashdis.ScanOptions{Match: "user:*"})
+//	for it.Next() {
+//		key := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Note that the guarantees of the Redis scan commands apply, e.g. an element
+// may be returned more than once.
+type ScanIterator struct {
+	cmds Commands
+	ctx  context.Context
+	cmd  string
+	key  string
+	opts ScanOptions
+
+	cursor string
+	vals   []string
+	ix     int
+	done   bool
+	err    error
+}
+
+// Scan returns an iterator over the keys of the database.
+func (c Commands) Scan(ctx context.Context, opts ScanOptions) *ScanIterator {
+	return c.scan(ctx, "SCAN", "", opts)
+}
+
+// HScan returns an iterator over the fields of the hash. The iterator
+// returns each field followed by its value, so the field and value
+// alternate.
+func (c Commands) HScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return c.scan(ctx, "HSCAN", key, opts)
+}
+
+// SScan returns an iterator over the members of the set.
+func (c Commands) SScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return c.scan(ctx, "SSCAN", key, opts)
+}
+
+// ZScan returns an iterator over the members of the sorted set. The
+// iterator returns each member followed by its score, so the member and
+// score alternate.
+func (c Commands) ZScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return c.scan(ctx, "ZSCAN", key, opts)
+}
+
+func (c Commands) scan(ctx context.Context, cmd, key string, opts ScanOptions) *ScanIterator {
+	return &ScanIterator{cmds: c, ctx: ctx, cmd: cmd, key: key, opts: opts, cursor: "0", ix: -1}
+}
+
+// Next advances the iterator to the next element, executing the next scan
+// call if required. It returns false when the iteration is complete or if
+// an error occurred, in which case Err returns the error.
+func (it *ScanIterator) Next() bool {
+	for {
+		if it.err != nil {
+			return false
+		}
+		if it.ix+1 < len(it.vals) {
+			it.ix++
+			return true
+		}
+		if it.done {
+			return false
+		}
+		it.fetch()
+	}
+}
+
+// Value returns the current element. It must be called after a call to
+// Next that returned true.
+func (it *ScanIterator) Value() string {
+	return it.vals[it.ix]
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+// fetch executes the scan call for the current cursor.
+func (it *ScanIterator) fetch() {
+	args := make([]interface{}, 0, 8)
+	if it.key != "" {
+		args = append(args, it.key)
+	}
+	args = append(args, it.cursor)
+	if it.opts.Match != "" {
+		args = append(args, "MATCH", it.opts.Match)
+	}
+	if it.opts.Count > 0 {
+		args = append(args, "COUNT", it.opts.Count)
+	}
+	if it.opts.Type != "" && it.cmd == "SCAN" {
+		args = append(args, "TYPE", it.opts.Type)
+	}
+
+	var res []json.RawMessage
+	if err := it.cmds.exec(it.ctx, &res, it.cmd, args...); err != nil {
+		it.err = err
+		return
+	}
+	if len(res) != 2 {
+		it.err = fmt.Errorf("upstashdis: invalid %s result: %d values", it.cmd, len(res))
+		return
+	}
+
+	cursor, ok := scalarBytes(res[0])
+	if !ok {
+		it.err = fmt.Errorf("upstashdis: invalid %s cursor: %s", it.cmd, res[0])
+		return
+	}
+	var vals []string
+	if err := json.Unmarshal(res[1], &vals); err != nil {
+		it.err = fmt.Errorf("upstashdis: invalid %s elements: %w", it.cmd, err)
+		return
+	}
+
+	it.cursor = string(cursor)
+	it.done = it.cursor == "0"
+	it.vals = vals
+	it.ix = -1
+}
@@ -0,0 +1,79 @@
+package upstashdis_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func collect(t *testing.T, it *upstashdis.ScanIterator) []string {
+	t.Helper()
+	var vals []string
+	for it.Next() {
+		vals = append(vals, it.Value())
+	}
+	require.NoError(t, it.Err())
+	return vals
+}
+
+func TestScanIterator(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cmds := upstashdis.Commands{Client: srv.Client()}
+	ctx := context.Background()
+
+	srv.Redis.Set("user:1", "a")
+	srv.Redis.Set("user:2", "b")
+	srv.Redis.Set("other", "c")
+	srv.Redis.HSet("h", "f", "v")
+	srv.Redis.SAdd("s", "x", "y")
+	srv.Redis.ZAdd("z", 1.5, "m")
+
+	keys := collect(t, cmds.Scan(ctx, upstashdis.ScanOptions{Match: "user:*", Count: 10}))
+	sort.Strings(keys)
+	require.Equal(t, []string{"user:1", "user:2"}, keys)
+
+	keys = collect(t, cmds.Scan(ctx, upstashdis.ScanOptions{Type: "hash"}))
+	require.Equal(t, []string{"h"}, keys)
+
+	require.Equal(t, []string{"f", "v"}, collect(t, cmds.HScan(ctx, "h", upstashdis.ScanOptions{})))
+	require.Equal(t, []string{"x", "y"}, collect(t, cmds.SScan(ctx, "s", upstashdis.ScanOptions{})))
+	require.Equal(t, []string{"m", "1.5"}, collect(t, cmds.ZScan(ctx, "z", upstashdis.ScanOptions{})))
+	require.Empty(t, collect(t, cmds.SScan(ctx, "nope", upstashdis.ScanOptions{})))
+
+	it := cmds.SScan(ctx, "h", upstashdis.ScanOptions{})
+	require.False(t, it.Next())
+	var rerr *upstashdis.Error
+	require.True(t, errors.As(it.Err(), &rerr), "%v", it.Err())
+	require.Equal(t, "WRONGTYPE", rerr.Kind)
+}
+
+func TestScanIteratorCursor(t *testing.T) {
+	// the fake server returns the pages in order, keyed by cursor
+	pages := map[string]string{
+		"0":  `["12",["a","b"]]`,
+		"12": `[7,[]]`,
+		"7":  `["0",["c"]]`,
+	}
+	var cursors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cmd []interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&cmd))
+		cur := cmd[1].(string)
+		cursors = append(cursors, cur)
+		_, _ = w.Write([]byte(`{"result":` + pages[cur] + `}`))
+	}))
+	defer srv.Close()
+
+	cmds := upstashdis.Commands{Client: &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok"}}
+	vals := collect(t, cmds.Scan(context.Background(), upstashdis.ScanOptions{}))
+	require.Equal(t, []string{"a", "b", "c"}, vals)
+	require.Equal(t, []string{"0", "12", "7"}, cursors)
+}
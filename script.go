@@ -0,0 +1,94 @@
+package upstashdis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+)
+
+// Script is a Lua script executed with EVALSHA, so that the script's source
+// is only sent to the server when it is not already cached. It is like the
+// Script type of the redigo package, and it is safe for concurrent use.
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+	loaded   int32 // set to 1 once the script is known to be cached
+}
+
+// NewScript returns a new script object. If keyCount is greater than or
+// equal to zero, then the count is automatically inserted in the EVAL
+// command argument list. If keyCount is less than zero, then the application
+// supplies the count as the first value in the keysAndArgs argument to the
+// Exec and SendScript methods.
+func NewScript(keyCount int, src string) *Script {
+	h := sha1.Sum([]byte(src))
+	return &Script{keyCount: keyCount, src: src, hash: hex.EncodeToString(h[:])}
+}
+
+// Hash returns the SHA1 hash of the script's source, in hexadecimal.
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// args returns the arguments of the EVAL or EVALSHA command, with spec
+// being the script's source or hash.
+func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	var args []interface{}
+	if s.keyCount < 0 {
+		args = make([]interface{}, 1+len(keysAndArgs))
+		args[0] = spec
+		copy(args[1:], keysAndArgs)
+	} else {
+		args = make([]interface{}, 2+len(keysAndArgs))
+		args[0] = spec
+		args[1] = s.keyCount
+		copy(args[2:], keysAndArgs)
+	}
+	return args
+}
+
+// Load loads the script in the script cache of the server, using the
+// request's context. Any command already queued on the request is executed
+// in the same call, as for ExecOne.
+func (s *Script) Load(r *Request) error {
+	if err := r.ExecOne(nil, "SCRIPT", "LOAD", s.src); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.loaded, 1)
+	return nil
+}
+
+// Exec executes the script with the keys and arguments (the keys first, then
+// the arguments, with the number of keys first if keyCount is negative) and
+// unmarshals its result into dst, as for ExecOne. It first tries EVALSHA and
+// falls back to EVAL if the server returns a NOSCRIPT error, which loads the
+// script in the script cache for the subsequent calls. Any command already
+// queued on the request is executed in the first call, as for ExecOne.
+func (s *Script) Exec(r *Request, dst interface{}, keysAndArgs ...interface{}) error {
+	err := r.ExecOne(dst, "EVALSHA", s.args(s.hash, keysAndArgs)...)
+	var rerr *Error
+	if errors.As(err, &rerr) && rerr.Kind == KindNoScript {
+		atomic.StoreInt32(&s.loaded, 0)
+		err = r.ExecOne(dst, "EVAL", s.args(s.src, keysAndArgs)...)
+	}
+	if err == nil {
+		atomic.StoreInt32(&s.loaded, 1)
+	}
+	return err
+}
+
+// SendScript queues the execution of the script with the keys and arguments,
+// as for Script.Exec. As the commands of a pipeline cannot be retried
+// individually, it queues an EVALSHA command only if the script is known to
+// be cached on the server, i.e. after a successful call to Script.Exec or
+// Script.Load, and an EVAL command otherwise. Note that if the script cache
+// of the server is flushed after that, the EVALSHA command fails with a
+// NOSCRIPT error, and the next call to Script.Exec reloads the script.
+func (r *Request) SendScript(s *Script, keysAndArgs ...interface{}) error {
+	if atomic.LoadInt32(&s.loaded) == 1 {
+		return r.Send("EVALSHA", s.args(s.hash, keysAndArgs)...)
+	}
+	return r.Send("EVAL", s.args(s.src, keysAndArgs)...)
+}
@@ -0,0 +1,81 @@
+package upstashdis_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScript(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+
+	script := upstashdis.NewScript(1, `return redis.call('INCRBY', KEYS[1], ARGV[1])`)
+	require.Len(t, script.Hash(), 40)
+
+	t.Run("fallback to eval", func(t *testing.T) {
+		var n int
+		require.NoError(t, script.Exec(cli.NewRequest(), &n, "a", 2))
+		require.Equal(t, 2, n)
+
+		// now cached, executed with evalsha
+		var exists []int
+		require.NoError(t, cli.NewRequest().ExecOne(&exists, "SCRIPT", "EXISTS", script.Hash()))
+		require.Equal(t, []int{1}, exists)
+		require.NoError(t, script.Exec(cli.NewRequest(), &n, "a", 3))
+		require.Equal(t, 5, n)
+	})
+
+	t.Run("flushed", func(t *testing.T) {
+		require.NoError(t, cli.NewRequest().ExecOne(nil, "SCRIPT", "FLUSH"))
+
+		// queued with evalsha as the script is believed to be cached
+		req := cli.NewRequest()
+		require.NoError(t, req.SendScript(script, "a", 1))
+		require.NoError(t, req.Send("GET", "a"))
+		var s string
+		err := req.Exec(nil, &s)
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Equal(t, "NOSCRIPT", rerr.Kind)
+		require.Equal(t, "5", s)
+
+		// reloaded by Exec
+		var n int
+		require.NoError(t, script.Exec(cli.NewRequest(), &n, "a", 1))
+		require.Equal(t, 6, n)
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		other := upstashdis.NewScript(-1, `return #KEYS .. ':' .. ARGV[1]`)
+
+		// not loaded, sent with eval
+		var n int
+		var s string
+		req := cli.NewRequest()
+		require.NoError(t, req.SendScript(script, "b", 10))
+		require.NoError(t, req.SendScript(other, 2, "k1", "k2", "x"))
+		require.NoError(t, req.Exec(&n, &s))
+		require.Equal(t, 10, n)
+		require.Equal(t, "2:x", s)
+
+		require.NoError(t, other.Load(cli.NewRequest()))
+		req = cli.NewRequest()
+		require.NoError(t, req.SendScript(other, 0, "y"))
+		require.NoError(t, req.SendScript(script, "b", 1))
+		require.NoError(t, req.Exec(&s, &n))
+		require.Equal(t, "0:y", s)
+		require.Equal(t, 11, n)
+	})
+
+	t.Run("script error", func(t *testing.T) {
+		bad := upstashdis.NewScript(0, `return redis.call('NOTACMD')`)
+		err := bad.Exec(cli.NewRequest(), nil)
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.NotEqual(t, "NOSCRIPT", rerr.Kind)
+	})
+}
@@ -0,0 +1,206 @@
+package upstashdis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrStreamClosed is the error reported when the subscription stream is
+// closed by the server.
+var ErrStreamClosed = errors.New("upstashdis: subscription stream closed")
+
+// Message is a message received by a Subscriber.
+type Message struct {
+	// Channel is the channel the message was published to.
+	Channel string
+	// Pattern is the pattern that matched the channel, for a pattern
+	// subscription.
+	Pattern string
+	// Payload is the published message.
+	Payload string
+}
+
+// Subscriber receives the messages published to a channel via the subscribe
+// endpoint of the REST API, which streams them as server-sent events. The
+// subscription is reconnected with a backoff delay if the stream fails or
+// is closed. Note that messages published while the subscriber is
+// reconnecting are lost, as Redis does not persist published messages.
+//
+// The HTTPClient of the Client should not have a timeout (or a long one), as
+// it would end the stream, which would reconnect at each timeout.
+type Subscriber struct {
+	// Client is the client used to connect to the REST API.
+	Client *Client
+
+	// Channel is the channel to subscribe to. If Pattern is true, it is a
+	// pattern and the subscriber receives the messages published to all
+	// matching channels (see PSUBSCRIBE).
+	Channel string
+	Pattern bool
+
+	// MinBackoff is the delay before the first reconnection attempt, doubled
+	// for each consecutive failed attempt up to MaxBackoff. If they are <= 0,
+	// they default to 50ms and 2s respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxReconnects is the maximum number of consecutive failed attempts to
+	// reconnect before Run returns. If it is 0, it reconnects indefinitely,
+	// and if it is negative, it does not reconnect. Failures that are not
+	// transient, such as an invalid token, are never retried.
+	MaxReconnects int
+
+	// OnSubscribe, if set, is called each time the subscription is
+	// established, including after a reconnection.
+	OnSubscribe func()
+
+	// OnError, if set, is called with the error that ended the stream before
+	// each reconnection attempt.
+	OnError func(error)
+}
+
+// Run subscribes to the channel and calls fn for each message received,
+// until ctx is done or the subscription fails and can't be reconnected. It
+// returns the error that ended the subscription (ctx.Err() if ctx is done).
+// fn is called sequentially, a slow fn delays the reception of the
+// following messages. If DetectCapabilities is set on the Client and the
+// endpoint does not support subscriptions, it returns an error wrapping
+// ErrNotSupported.
+func (s *Subscriber) Run(ctx context.Context, fn func(Message)) error {
+	if err := s.Client.checkCapability(ctx, "subscribe", hasSubscribe); err != nil {
+		return err
+	}
+
+	policy := RetryPolicy{MinBackoff: s.MinBackoff, MaxBackoff: s.MaxBackoff, Jitter: 0.5}
+
+	var failures int
+	for {
+		subscribed, retry, err := s.stream(ctx, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if subscribed {
+			failures = 0
+		}
+		failures++
+		if !retry || s.MaxReconnects < 0 || (s.MaxReconnects > 0 && failures > s.MaxReconnects) {
+			return err
+		}
+		if s.OnError != nil {
+			s.OnError(err)
+		}
+		if err := sleepCtx(ctx, policy.backoff(failures, 0)); err != nil {
+			return err
+		}
+	}
+}
+
+// Messages is like Run, but it runs the subscription in a goroutine and
+// returns a channel that receives the messages, and a channel that receives
+// the error returned by Run once the subscription ends, at which point the
+// messages channel is closed.
+func (s *Subscriber) Messages(ctx context.Context) (<-chan Message, <-chan error) {
+	msgs := make(chan Message)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(msgs)
+		errc <- s.Run(ctx, func(m Message) {
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return msgs, errc
+}
+
+// stream connects to the subscribe endpoint and calls fn for each message
+// received until the stream ends. It returns true for subscribed if the
+// subscription was established, and true for retry if the error that ended
+// the stream is transient.
+func (s *Subscriber) stream(ctx context.Context, fn func(Message)) (subscribed, retry bool, err error) {
+	cmd := "subscribe"
+	if s.Pattern {
+		cmd = "psubscribe"
+	}
+
+	tok := s.Client.token()
+	res, err := s.Client.do(ctx, "GET", cmd+"/"+s.Channel, nil,
+		http.Header{"Accept": {"text/event-stream"}}, &tok, true)
+	if err != nil {
+		return false, true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return false, retry, statusError(res, -1)
+	}
+
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(nil, 1<<20)
+	var data []string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			// end of event
+			if len(data) == 0 {
+				continue
+			}
+			ev := strings.Join(data, "\n")
+			data = data[:0]
+
+			msg, kind, err := parseEvent(ev)
+			if err != nil {
+				return subscribed, false, err
+			}
+			switch kind {
+			case "subscribe", "psubscribe":
+				subscribed = true
+				if s.OnSubscribe != nil {
+					s.OnSubscribe()
+				}
+			case "message", "pmessage":
+				fn(msg)
+			}
+
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// other fields and comments are ignored
+	}
+	if err := sc.Err(); err != nil {
+		return subscribed, true, err
+	}
+	return subscribed, true, ErrStreamClosed
+}
+
+// parseEvent parses the data of a subscription event, which is the
+// comma-separated message received from Redis, e.g.
+// "message,<channel>,<payload>".
+func parseEvent(ev string) (Message, string, error) {
+	kind, rest, _ := strings.Cut(ev, ",")
+	switch kind {
+	case "message":
+		parts := strings.SplitN(rest, ",", 2)
+		if len(parts) != 2 {
+			break
+		}
+		return Message{Channel: parts[0], Payload: parts[1]}, kind, nil
+	case "pmessage":
+		parts := strings.SplitN(rest, ",", 3)
+		if len(parts) != 3 {
+			break
+		}
+		return Message{Pattern: parts[0], Channel: parts[1], Payload: parts[2]}, kind, nil
+	default:
+		return Message{}, kind, nil
+	}
+	return Message{}, kind, fmt.Errorf("upstashdis: invalid subscription event: %q", ev)
+}
@@ -0,0 +1,137 @@
+package upstashdis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+
+	t.Run("callback", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		subscribed := make(chan struct{})
+		sub := &upstashdis.Subscriber{
+			Client:      cli,
+			Channel:     "chat",
+			OnSubscribe: func() { close(subscribed) },
+		}
+
+		var got []upstashdis.Message
+		done := make(chan error)
+		go func() {
+			done <- sub.Run(ctx, func(m upstashdis.Message) {
+				got = append(got, m)
+				if len(got) == 2 {
+					cancel()
+				}
+			})
+		}()
+
+		<-subscribed
+		srv.Redis.Publish("chat", "hello")
+		srv.Redis.Publish("chat", "a,b\nc")
+		require.ErrorIs(t, <-done, context.Canceled)
+		require.Equal(t, []upstashdis.Message{
+			{Channel: "chat", Payload: "hello"},
+			{Channel: "chat", Payload: "a,b\nc"},
+		}, got)
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		subscribed := make(chan struct{})
+		sub := &upstashdis.Subscriber{
+			Client:      cli,
+			Channel:     "news.*",
+			Pattern:     true,
+			OnSubscribe: func() { close(subscribed) },
+		}
+		msgs, errc := sub.Messages(ctx)
+
+		<-subscribed
+		srv.Redis.Publish("news.tech", "go")
+		require.Equal(t, upstashdis.Message{Pattern: "news.*", Channel: "news.tech", Payload: "go"}, <-msgs)
+
+		cancel()
+		_, ok := <-msgs
+		require.False(t, ok)
+		require.ErrorIs(t, <-errc, context.Canceled)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		sub := &upstashdis.Subscriber{
+			Client:  cli.CloneWithToken("invalid"),
+			Channel: "chat",
+		}
+		err := sub.Run(context.Background(), func(upstashdis.Message) {})
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+	})
+}
+
+func TestSubscriberReconnect(t *testing.T) {
+	// the fake server sends a message and closes the stream, and fails the
+	// second connection attempt.
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&hits, 1)
+		if n == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: subscribe,chat,1\n\n: comment\ndata: message,chat,%d\n\n", n)
+	}))
+	defer srv.Close()
+
+	var subs, errs int
+	sub := &upstashdis.Subscriber{
+		Client:      &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok"},
+		Channel:     "chat",
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		OnSubscribe: func() { subs++ },
+		OnError:     func(error) { errs++ },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	err := sub.Run(ctx, func(m upstashdis.Message) {
+		got = append(got, m.Payload)
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []string{"1", "3", "4"}, got)
+	require.Equal(t, 3, subs)
+	require.Equal(t, 3, errs)
+
+	t.Run("max reconnects", func(t *testing.T) {
+		sub := &upstashdis.Subscriber{
+			Client:        &upstashdis.Client{BaseURL: srv.URL, APIToken: "tok"},
+			Channel:       "chat",
+			MaxReconnects: -1,
+		}
+		err := sub.Run(context.Background(), func(upstashdis.Message) {})
+		require.ErrorIs(t, err, upstashdis.ErrStreamClosed)
+	})
+}
@@ -0,0 +1,73 @@
+package upstashdis_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecTx(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+
+	t.Run("success", func(t *testing.T) {
+		var n int
+		var s string
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("SET", "a", "1"))
+		require.NoError(t, req.Send("INCRBY", "a", 2))
+		require.NoError(t, req.Send("GET", "a"))
+		require.NoError(t, req.ExecTx(nil, &n, &s))
+		require.Equal(t, 3, n)
+		require.Equal(t, "3", s)
+	})
+
+	t.Run("single command", func(t *testing.T) {
+		var n int
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("INCR", "a"))
+		require.NoError(t, req.ExecTx(&n))
+		require.Equal(t, 4, n)
+	})
+
+	t.Run("command error", func(t *testing.T) {
+		var s, got string
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("SET", "s", "x"))
+		require.NoError(t, req.Send("LPUSH", "s", "y"))
+		require.NoError(t, req.Send("GET", "s"))
+		err := req.ExecTx(&s, nil, &got)
+
+		// the error does not abort the transaction
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Equal(t, "WRONGTYPE", rerr.Kind)
+		require.Equal(t, 1, rerr.PipelineIndex)
+		require.Equal(t, "OK", s)
+		require.Equal(t, "x", got)
+	})
+
+	t.Run("discarded", func(t *testing.T) {
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("SET", "d", "1"))
+		require.NoError(t, req.Send("NOTACMD"))
+		err := req.ExecTx()
+
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Equal(t, -1, rerr.PipelineIndex)
+		require.False(t, srv.Redis.Exists("d"))
+	})
+
+	t.Run("request reusable", func(t *testing.T) {
+		var n int
+		req := cli.NewRequest()
+		require.NoError(t, req.Send("INCR", "r"))
+		require.NoError(t, req.ExecTx(&n))
+		require.NoError(t, req.ExecOne(&n, "INCR", "r"))
+		require.Equal(t, 2, n)
+	})
+}
@@ -0,0 +1,173 @@
+// Package upstashotel provides OpenTelemetry [1] instrumentation for the
+// upstashdis client. It implements the upstashdis.Hooks interface to record
+// a span and metrics for each REST API call. It is a separate module so
+// that the upstashdis module does not depend on OpenTelemetry.
+//
+//	client.Hooks, err = upstashotel.NewHooks()
+//
+// The spans follow the semantic conventions for database clients: their
+// name is the command for a single command (e.g. "GET"), "pipeline" or
+// "multi-exec" for a pipeline or transaction, and the HTTP method and
+// endpoint for a call made with Client.Call. The arguments of the commands
+// are never recorded.
+//
+// Two metrics are recorded, the upstashdis.request.duration histogram of
+// the duration of the calls in milliseconds, and the
+// upstashdis.request.commands counter of the commands executed. Their
+// attributes are the operation (as for the span name), the HTTP status code
+// and whether the call failed.
+//
+// [1]: https://opentelemetry.io/
+package upstashotel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mna/upstashdis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer and meter used to record
+// the spans and metrics.
+const InstrumentationName = "github.com/mna/upstashdis/upstashotel"
+
+// Attribute keys specific to this package.
+const (
+	PipelineSizeKey = attribute.Key("upstashdis.pipeline.size")
+	AttemptsKey     = attribute.Key("upstashdis.attempts")
+	ErrorKey        = attribute.Key("upstashdis.error")
+)
+
+// Option is an option of NewHooks.
+type Option func(*config)
+
+type config struct {
+	tp    trace.TracerProvider
+	mp    metric.MeterProvider
+	attrs []attribute.KeyValue
+}
+
+// WithTracerProvider sets the tracer provider used to create the spans. By
+// default, the global tracer provider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) { c.tp = tp }
+}
+
+// WithMeterProvider sets the meter provider used to record the metrics. By
+// default, the global meter provider is used.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) { c.mp = mp }
+}
+
+// WithAttributes adds attributes to all spans and metrics, e.g. to identify
+// the database.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(c *config) { c.attrs = append(c.attrs, attrs...) }
+}
+
+type hooks struct {
+	tracer   trace.Tracer
+	duration syncfloat64.Histogram
+	commands syncint64.Counter
+	attrs    []attribute.KeyValue
+}
+
+// NewHooks returns hooks that record a span and metrics for each REST API
+// call, to set as the upstashdis.Client.Hooks field. It returns an error if
+// the metric instruments cannot be created.
+func NewHooks(opts ...Option) (upstashdis.Hooks, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.tp == nil {
+		cfg.tp = otel.GetTracerProvider()
+	}
+	if cfg.mp == nil {
+		cfg.mp = global.MeterProvider()
+	}
+
+	meter := cfg.mp.Meter(InstrumentationName)
+	duration, err := meter.SyncFloat64().Histogram("upstashdis.request.duration",
+		instrument.WithDescription("Duration of the REST API calls."),
+		instrument.WithUnit(unit.Milliseconds))
+	if err != nil {
+		return nil, err
+	}
+	commands, err := meter.SyncInt64().Counter("upstashdis.request.commands",
+		instrument.WithDescription("Number of commands executed."),
+		instrument.WithUnit(unit.Dimensionless))
+	if err != nil {
+		return nil, err
+	}
+
+	return &hooks{
+		tracer:   cfg.tp.Tracer(InstrumentationName),
+		duration: duration,
+		commands: commands,
+		attrs:    append([]attribute.KeyValue{semconv.DBSystemRedis}, cfg.attrs...),
+	}, nil
+}
+
+// operation returns the name of the operation of the call.
+func operation(info *upstashdis.RequestInfo) string {
+	switch {
+	case info.Commands == nil:
+		return info.Method + " " + strings.TrimPrefix(info.Endpoint, "/")
+	case info.Endpoint == "" && len(info.Commands) == 1:
+		return info.Commands[0]
+	default:
+		return info.Endpoint
+	}
+}
+
+func (h *hooks) OnRequestStart(ctx context.Context, info *upstashdis.RequestInfo) context.Context {
+	op := operation(info)
+	attrs := make([]attribute.KeyValue, 0, len(h.attrs)+3)
+	attrs = append(attrs, h.attrs...)
+	attrs = append(attrs, semconv.DBOperationKey.String(op), semconv.HTTPMethodKey.String(info.Method))
+	if info.Commands != nil {
+		attrs = append(attrs, PipelineSizeKey.Int(len(info.Commands)))
+	}
+
+	ctx, _ = h.tracer.Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(info.Start),
+		trace.WithAttributes(attrs...))
+	return ctx
+}
+
+func (h *hooks) OnRequestEnd(ctx context.Context, info *upstashdis.RequestInfo) {
+	span := trace.SpanFromContext(ctx)
+	if info.StatusCode != 0 {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(info.StatusCode))
+	}
+	span.SetAttributes(AttemptsKey.Int(info.Attempts))
+	if info.Err != nil {
+		span.RecordError(info.Err)
+		span.SetStatus(codes.Error, info.Err.Error())
+	}
+	span.End(trace.WithTimestamp(info.Start.Add(info.Duration)))
+
+	attrs := make([]attribute.KeyValue, 0, len(h.attrs)+3)
+	attrs = append(attrs, h.attrs...)
+	attrs = append(attrs,
+		semconv.DBOperationKey.String(operation(info)),
+		semconv.HTTPStatusCodeKey.Int(info.StatusCode),
+		ErrorKey.Bool(info.Err != nil))
+	h.duration.Record(ctx, float64(info.Duration)/1e6, attrs...)
+	if n := len(info.Commands); n > 0 {
+		h.commands.Add(ctx, int64(n), attrs...)
+	}
+}
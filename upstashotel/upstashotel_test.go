@@ -0,0 +1,174 @@
+package upstashotel_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/mna/upstashdis/upstashotel"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/nonrecording"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordMeter records the values of the synchronous instruments, by name.
+type recordMeter struct {
+	metric.Meter
+
+	mu     sync.Mutex
+	values map[string][]float64
+	attrs  map[string][]attribute.KeyValue
+}
+
+func newRecordMeter() *recordMeter {
+	return &recordMeter{
+		Meter:  nonrecording.NewNoopMeter(),
+		values: make(map[string][]float64),
+		attrs:  make(map[string][]attribute.KeyValue),
+	}
+}
+
+func (m *recordMeter) SyncFloat64() syncfloat64.InstrumentProvider { return floatProvider{m} }
+func (m *recordMeter) SyncInt64() syncint64.InstrumentProvider     { return intProvider{m} }
+
+// meterProvider returns the recordMeter for all names.
+type meterProvider struct{ m *recordMeter }
+
+func (p meterProvider) Meter(string, ...metric.MeterOption) metric.Meter { return p.m }
+
+func (m *recordMeter) record(name string, v float64, attrs []attribute.KeyValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[name] = append(m.values[name], v)
+	m.attrs[name] = attrs
+}
+
+type floatProvider struct{ m *recordMeter }
+
+func (p floatProvider) Counter(string, ...instrument.Option) (syncfloat64.Counter, error) {
+	return nonrecording.NewNoopMeter().SyncFloat64().Counter("")
+}
+
+func (p floatProvider) UpDownCounter(string, ...instrument.Option) (syncfloat64.UpDownCounter, error) {
+	return nonrecording.NewNoopMeter().SyncFloat64().UpDownCounter("")
+}
+
+func (p floatProvider) Histogram(name string, _ ...instrument.Option) (syncfloat64.Histogram, error) {
+	h, err := nonrecording.NewNoopMeter().SyncFloat64().Histogram(name)
+	return floatHistogram{Histogram: h, name: name, m: p.m}, err
+}
+
+type floatHistogram struct {
+	syncfloat64.Histogram
+	name string
+	m    *recordMeter
+}
+
+func (h floatHistogram) Record(_ context.Context, v float64, attrs ...attribute.KeyValue) {
+	h.m.record(h.name, v, attrs)
+}
+
+type intProvider struct{ m *recordMeter }
+
+func (p intProvider) Counter(name string, _ ...instrument.Option) (syncint64.Counter, error) {
+	c, err := nonrecording.NewNoopMeter().SyncInt64().Counter(name)
+	return intCounter{Counter: c, name: name, m: p.m}, err
+}
+
+func (p intProvider) UpDownCounter(string, ...instrument.Option) (syncint64.UpDownCounter, error) {
+	return nonrecording.NewNoopMeter().SyncInt64().UpDownCounter("")
+}
+
+func (p intProvider) Histogram(string, ...instrument.Option) (syncint64.Histogram, error) {
+	return nonrecording.NewNoopMeter().SyncInt64().Histogram("")
+}
+
+type intCounter struct {
+	syncint64.Counter
+	name string
+	m    *recordMeter
+}
+
+func (c intCounter) Add(_ context.Context, v int64, attrs ...attribute.KeyValue) {
+	c.m.record(c.name, float64(v), attrs)
+}
+
+func attrMap(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestHooks(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	meter := newRecordMeter()
+
+	hooks, err := upstashotel.NewHooks(
+		upstashotel.WithTracerProvider(tp),
+		upstashotel.WithMeterProvider(meterProvider{meter}),
+		upstashotel.WithAttributes(attribute.String("db.name", "test")))
+	require.NoError(t, err)
+
+	cli := srv.Client()
+	cli.Hooks = hooks
+
+	// a parent span, to check that the context is propagated
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	require.NoError(t, cli.NewRequestContext(ctx).ExecOne(nil, "SET", "a", "1"))
+	parent.End()
+
+	req := cli.NewRequest()
+	require.NoError(t, req.Send("GET", "a"))
+	require.NoError(t, req.Send("INCR", "a"))
+	require.NoError(t, req.Exec(nil, nil))
+
+	require.Error(t, cli.NewRequestWithToken("invalid").ExecOne(nil, "GET", "a"))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 4)
+
+	set := spans[0]
+	require.Equal(t, "SET", set.Name())
+	require.Equal(t, trace.SpanKindClient, set.SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), set.Parent().SpanID())
+	attrs := attrMap(set.Attributes())
+	require.Equal(t, "redis", attrs["db.system"].AsString())
+	require.Equal(t, "test", attrs["db.name"].AsString())
+	require.Equal(t, "SET", attrs["db.operation"].AsString())
+	require.Equal(t, int64(1), attrs[upstashotel.PipelineSizeKey].AsInt64())
+	require.Equal(t, int64(http.StatusOK), attrs["http.status_code"].AsInt64())
+	require.Equal(t, int64(1), attrs[upstashotel.AttemptsKey].AsInt64())
+	require.Equal(t, codes.Unset, set.Status().Code)
+
+	pipe := spans[2]
+	require.Equal(t, "pipeline", pipe.Name())
+	require.Equal(t, int64(2), attrMap(pipe.Attributes())[upstashotel.PipelineSizeKey].AsInt64())
+
+	failed := spans[3]
+	require.Equal(t, "GET", failed.Name())
+	require.Equal(t, codes.Error, failed.Status().Code)
+	require.Equal(t, int64(http.StatusUnauthorized), attrMap(failed.Attributes())["http.status_code"].AsInt64())
+	require.Len(t, failed.Events(), 1) // the recorded error
+
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+	require.Len(t, meter.values["upstashdis.request.duration"], 3)
+	require.Equal(t, []float64{1, 2, 1}, meter.values["upstashdis.request.commands"])
+	last := attrMap(meter.attrs["upstashdis.request.duration"])
+	require.Equal(t, "GET", last["db.operation"].AsString())
+	require.True(t, last[upstashotel.ErrorKey].AsBool())
+}
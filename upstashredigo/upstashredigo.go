@@ -0,0 +1,391 @@
+// Package upstashredigo provides an adapter that implements redigo's
+// redis.Conn interface using the upstashdis client, so that code written
+// against redigo (including libraries such as rate limiters, session stores
+// or distributed locks) can execute its commands via the Upstash Redis REST
+// API without changes.
+//
+//	pool := &redis.Pool{
+//		Dial: func() (redis.Conn, error) {
+//			return upstashredigo.NewConn(client), nil
+//		},
+//	}
+//
+// As the REST API is stateless, the commands that depend on the state of a
+// connection are not supported: the publish-subscribe commands (see
+// upstashdis.Subscriber instead), WATCH, UNWATCH, SELECT and MONITOR. The
+// MULTI and EXEC (or DISCARD) commands are supported as long as they are
+// sent in the same flush of the connection, e.g. with Send("MULTI"), some
+// Send of the commands and Do("EXEC"), in which case the commands are
+// executed atomically using the /multi-exec endpoint.
+//
+// The commands sent with Send are buffered until Flush (or Do, or Receive
+// if no reply is available) is called, at which point they are executed in
+// a single pipeline. The replies are converted to the same types as those
+// of redigo (see upstashdis.Result.Value), with the "OK" and "PONG" status
+// replies returned as strings, and the error replies as redis.Error values.
+package upstashredigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis"
+)
+
+var (
+	errClosed  = errors.New("upstashredigo: connection closed")
+	errNoReply = errors.New("upstashredigo: no pending reply")
+)
+
+// unsupportedCommands is the set of commands that cannot be executed via
+// the stateless REST API.
+var unsupportedCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"SSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true,
+	"WATCH":        true,
+	"UNWATCH":      true,
+	"SELECT":       true,
+	"MONITOR":      true,
+}
+
+var (
+	_ redis.Conn            = (*Conn)(nil)
+	_ redis.ConnWithContext = (*Conn)(nil)
+	_ redis.ConnWithTimeout = (*Conn)(nil)
+)
+
+type command struct {
+	name string
+	args []interface{}
+}
+
+// Conn implements redigo's redis.Conn interface, as well as the optional
+// redis.ConnWithContext and redis.ConnWithTimeout interfaces, using an
+// upstashdis client. Like redigo's connections, it is not safe for
+// concurrent use. It must be created with NewConn.
+//
+// If a REST API request fails, e.g. because of a network error, the error
+// is returned and the connection becomes unusable, its Err method returns
+// that error. The error replies of Redis do not make the connection
+// unusable.
+type Conn struct {
+	client *upstashdis.Client
+
+	mu      sync.Mutex
+	err     error         // set when the connection is closed or failed
+	pending []command     // commands sent but not flushed
+	replies []interface{} // replies of the flushed commands, not received
+}
+
+// NewConn returns a connection that executes the commands using the client.
+// Closing the connection does not affect the client, which may be shared by
+// any number of connections.
+func NewConn(client *upstashdis.Client) *Conn {
+	return &Conn{client: client}
+}
+
+// Close closes the connection. The pending commands that were not flushed
+// are discarded.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = errClosed
+	}
+	c.pending, c.replies = nil, nil
+	return nil
+}
+
+// Err returns a non-nil value when the connection is not usable.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Send queues the command to be executed when the connection is flushed.
+func (c *Conn) Send(cmd string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	if err := checkCommand(cmd); err != nil {
+		return err
+	}
+	c.pending = append(c.pending, command{name: cmd, args: args})
+	return nil
+}
+
+// Flush executes the pending commands and buffers their replies, to be
+// returned by Receive.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	return c.flush(context.Background())
+}
+
+// Do executes the command and returns its reply, as described for
+// DoContext.
+func (c *Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoContext(context.Background(), cmd, args...)
+}
+
+// DoWithTimeout is like Do, but the REST API request is cancelled if it
+// does not complete within timeout. A timeout of 0 means no timeout.
+func (c *Conn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	return c.DoContext(ctx, cmd, args...)
+}
+
+// DoContext executes the pending commands and the command, and returns the
+// reply of the command. As for redigo, the returned error is the first
+// error reply of those commands, if any, and the replies of the pending
+// commands are discarded. If cmd is "", it executes the pending commands
+// and returns all their replies that were not received yet, as a
+// []interface{}. The REST API request is made with ctx.
+func (c *Conn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	if cmd != "" {
+		if err := checkCommand(cmd); err != nil {
+			return nil, err
+		}
+		c.pending = append(c.pending, command{name: cmd, args: args})
+	}
+	if len(c.pending) == 0 && len(c.replies) == 0 {
+		return nil, nil
+	}
+	if err := c.flush(ctx); err != nil {
+		return nil, err
+	}
+
+	replies := c.replies
+	c.replies = nil
+	if cmd == "" {
+		return replies, nil
+	}
+
+	var err error
+	for _, reply := range replies {
+		if e, ok := reply.(redis.Error); ok && err == nil {
+			err = e
+		}
+	}
+	return replies[len(replies)-1], err
+}
+
+// Receive returns the next reply of the flushed commands, as described for
+// ReceiveContext.
+func (c *Conn) Receive() (interface{}, error) {
+	return c.ReceiveContext(context.Background())
+}
+
+// ReceiveWithTimeout is like Receive, but the REST API request, if one is
+// required, is cancelled if it does not complete within timeout. A timeout
+// of 0 means no timeout.
+func (c *Conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	return c.ReceiveContext(ctx)
+}
+
+// ReceiveContext returns the next reply of the flushed commands. If there
+// is no such reply but there are pending commands, they are flushed first
+// using ctx. If the reply is an error reply, it is returned as the error.
+// Unlike redigo, it returns an error instead of blocking if there is no
+// reply to receive.
+func (c *Conn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.replies) == 0 && len(c.pending) > 0 {
+		if err := c.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.replies) == 0 {
+		return nil, errNoReply
+	}
+
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// flush executes the pending commands, in a pipeline for the standalone
+// commands and in a transaction for those between MULTI and EXEC. If a
+// request fails, the connection becomes unusable. The caller must hold the
+// lock.
+func (c *Conn) flush(ctx context.Context) error {
+	cmds := c.pending
+	c.pending = nil
+
+	for len(cmds) > 0 {
+		var (
+			n   int
+			err error
+		)
+		if isCommand(cmds[0], "MULTI") {
+			n, err = c.execTx(ctx, cmds)
+		} else {
+			n = len(cmds)
+			for i, cmd := range cmds {
+				if isCommand(cmd, "MULTI") {
+					n = i
+					break
+				}
+			}
+			err = c.execPipeline(ctx, cmds[:n])
+		}
+		if err != nil {
+			c.err = err
+			return err
+		}
+		cmds = cmds[n:]
+	}
+	return nil
+}
+
+// execPipeline executes the standalone commands and appends their replies.
+func (c *Conn) execPipeline(ctx context.Context, cmds []command) error {
+	res, err := c.client.Pipelined(ctx, func(p *upstashdis.Request) error {
+		return sendAll(p, cmds)
+	})
+	if res == nil && err != nil {
+		// the request itself failed, the errors of the commands are returned
+		// with their results
+		return err
+	}
+	if len(res) != len(cmds) {
+		return fmt.Errorf("upstashredigo: got %d results for %d commands", len(res), len(cmds))
+	}
+
+	for _, r := range res {
+		c.replies = append(c.replies, reply(r))
+	}
+	return nil
+}
+
+// execTx executes the transaction that starts with MULTI at cmds[0] and
+// appends its replies. It returns the number of commands consumed,
+// including MULTI and EXEC or DISCARD.
+func (c *Conn) execTx(ctx context.Context, cmds []command) (int, error) {
+	end := -1
+	for i := 1; i < len(cmds); i++ {
+		if isCommand(cmds[i], "EXEC") || isCommand(cmds[i], "DISCARD") {
+			end = i
+			break
+		}
+		if isCommand(cmds[i], "MULTI") {
+			return 0, errors.New("upstashredigo: MULTI calls can not be nested")
+		}
+	}
+	if end < 0 {
+		return 0, errors.New("upstashredigo: MULTI without EXEC or DISCARD in the same flush is not supported")
+	}
+
+	txCmds := cmds[1:end]
+	replies := []interface{}{"OK"}
+	for range txCmds {
+		replies = append(replies, "QUEUED")
+	}
+
+	switch {
+	case isCommand(cmds[end], "DISCARD"):
+		replies = append(replies, "OK")
+	case len(txCmds) == 0:
+		replies = append(replies, []interface{}{})
+	default:
+		res, err := c.client.TxPipelined(ctx, func(p *upstashdis.Request) error {
+			return sendAll(p, txCmds)
+		})
+		if res == nil && err != nil {
+			// the transaction was discarded
+			var rerr *upstashdis.Error
+			if !errors.As(err, &rerr) {
+				return 0, err
+			}
+			replies = append(replies, redis.Error(rerr.Message))
+			break
+		}
+
+		vals := make([]interface{}, len(res))
+		for i, r := range res {
+			vals[i] = reply(r)
+		}
+		replies = append(replies, vals)
+	}
+
+	c.replies = append(c.replies, replies...)
+	return end + 1, nil
+}
+
+func sendAll(p *upstashdis.Request, cmds []command) error {
+	for _, cmd := range cmds {
+		if err := p.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reply returns the result converted to a redigo reply.
+func reply(res *upstashdis.Result) interface{} {
+	v, err := res.Value()
+	if err != nil {
+		var rerr *upstashdis.Error
+		if errors.As(err, &rerr) {
+			return redis.Error(rerr.Message)
+		}
+		return redis.Error("ERR invalid result from the REST API: " + err.Error())
+	}
+	if b, ok := v.([]byte); ok && (string(b) == "OK" || string(b) == "PONG") {
+		return string(b)
+	}
+	return v
+}
+
+func checkCommand(cmd string) error {
+	if unsupportedCommands[strings.ToUpper(cmd)] {
+		return fmt.Errorf("upstashredigo: command %s is not supported by the REST API", cmd)
+	}
+	return nil
+}
+
+func isCommand(cmd command, name string) bool {
+	return strings.EqualFold(cmd.name, name)
+}
+
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
@@ -0,0 +1,203 @@
+package upstashredigo_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis/upstashredigo"
+	"github.com/mna/upstashdis/upstashtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnDo(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	conn := upstashredigo.NewConn(srv.Client())
+	defer conn.Close()
+
+	ok, err := conn.Do("SET", "a", "x")
+	require.NoError(t, err)
+	require.Equal(t, "OK", ok)
+
+	s, err := redis.String(conn.Do("GET", "a"))
+	require.NoError(t, err)
+	require.Equal(t, "x", s)
+
+	n, err := redis.Int64(conn.Do("INCRBY", "n", 3))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	_, err = redis.String(conn.Do("GET", "nope"))
+	require.Equal(t, redis.ErrNil, err)
+
+	_, err = conn.Do("RPUSH", "list", "a", []byte("b"), 1)
+	require.NoError(t, err)
+	strs, err := redis.Strings(conn.Do("LRANGE", "list", 0, -1))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "1"}, strs)
+
+	_, err = conn.Do("HSET", "h", "f1", "v1", "f2", "v2")
+	require.NoError(t, err)
+	m, err := redis.StringMap(conn.Do("HGETALL", "h"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"f1": "v1", "f2": "v2"}, m)
+
+	_, err = conn.Do("HGET", "a", "f1")
+	var rerr redis.Error
+	require.ErrorAs(t, err, &rerr)
+	require.Contains(t, rerr.Error(), "WRONGTYPE")
+	require.NoError(t, conn.Err())
+
+	_, err = conn.Do("SUBSCRIBE", "ch")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not supported")
+
+	require.NoError(t, conn.Close())
+	require.Error(t, conn.Err())
+	_, err = conn.Do("PING")
+	require.Error(t, err)
+}
+
+func TestConnPipeline(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	conn := upstashredigo.NewConn(srv.Client())
+	defer conn.Close()
+
+	require.NoError(t, conn.Send("SET", "a", "1"))
+	require.NoError(t, conn.Send("INCR", "a"))
+	require.NoError(t, conn.Send("HGET", "a", "x"))
+	require.NoError(t, conn.Send("GET", "a"))
+	require.NoError(t, conn.Flush())
+
+	v, err := conn.Receive()
+	require.NoError(t, err)
+	require.Equal(t, "OK", v)
+	n, err := redis.Int(conn.Receive())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	_, err = conn.Receive()
+	require.ErrorAs(t, err, new(redis.Error))
+	s, err := redis.String(conn.Receive())
+	require.NoError(t, err)
+	require.Equal(t, "2", s)
+
+	_, err = conn.Receive()
+	require.Error(t, err)
+
+	// Receive flushes the pending commands
+	require.NoError(t, conn.Send("PING"))
+	v, err = conn.Receive()
+	require.NoError(t, err)
+	require.Equal(t, "PONG", v)
+
+	// Do("") returns all the pending replies
+	require.NoError(t, conn.Send("INCR", "a"))
+	require.NoError(t, conn.Send("INCR", "a"))
+	vals, err := redis.Values(conn.Do(""))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int64(3), int64(4)}, vals)
+
+	// Do returns the first error and the last reply
+	require.NoError(t, conn.Send("HGET", "a", "x"))
+	v, err = conn.Do("GET", "a")
+	require.ErrorAs(t, err, new(redis.Error))
+	require.Equal(t, []byte("4"), v)
+}
+
+func TestConnRequestFailed(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	conn := upstashredigo.NewConn(srv.Client().CloneWithToken("nope"))
+	defer conn.Close()
+
+	// the failure of the request is not the error reply of a command, it
+	// makes the connection fail
+	_, err := conn.Do("GET", "a")
+	require.Error(t, err)
+	require.False(t, errors.As(err, new(redis.Error)), "%v", err)
+	require.Error(t, conn.Err())
+}
+
+func TestConnTransaction(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	conn := upstashredigo.NewConn(srv.Client())
+	defer conn.Close()
+
+	require.NoError(t, conn.Send("MULTI"))
+	require.NoError(t, conn.Send("SET", "a", "1"))
+	require.NoError(t, conn.Send("INCR", "a"))
+	vals, err := redis.Values(conn.Do("EXEC"))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"OK", int64(2)}, vals)
+
+	require.NoError(t, conn.Send("MULTI"))
+	require.NoError(t, conn.Send("INCR", "a"))
+	v, err := conn.Do("DISCARD")
+	require.NoError(t, err)
+	require.Equal(t, "OK", v)
+	srv.Redis.CheckGet(t, "a", "2")
+
+	// MULTI must be followed by EXEC in the same flush
+	require.NoError(t, conn.Send("MULTI"))
+	require.NoError(t, conn.Send("INCR", "a"))
+	require.Error(t, conn.Flush())
+	require.Error(t, conn.Err())
+}
+
+func TestConnScript(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	conn := upstashredigo.NewConn(srv.Client())
+	defer conn.Close()
+
+	script := redis.NewScript(1, `return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+	for i := 1; i <= 2; i++ {
+		// first with EVAL after EVALSHA fails, then with EVALSHA
+		n, err := redis.Int(script.Do(conn, "a", 10))
+		require.NoError(t, err)
+		require.Equal(t, i*10, n)
+	}
+}
+
+func TestConnPool(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return upstashredigo.NewConn(cli), nil
+		},
+		TestOnBorrow: func(c redis.Conn, _ time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	defer pool.Close()
+
+	conn := pool.Get()
+	_, err := conn.Do("SET", "a", "b")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	conn = pool.Get()
+	defer conn.Close()
+	s, err := redis.String(redis.DoWithTimeout(conn, time.Second, "GET", "a"))
+	require.NoError(t, err)
+	require.Equal(t, "b", s)
+}
+
+func TestConnBinarySafe(t *testing.T) {
+	srv := upstashtest.NewServer(t)
+	cli := srv.Client()
+	cli.BinarySafe = true
+	conn := upstashredigo.NewConn(cli)
+	defer conn.Close()
+
+	bin := "\xff\x00\xfe"
+	srv.Redis.Set("bin", bin)
+	b, err := redis.Bytes(conn.Do("GET", "bin"))
+	require.NoError(t, err)
+	require.Equal(t, []byte(bin), b)
+
+	v, err := conn.Do("SET", "a", "1")
+	require.NoError(t, err)
+	require.Equal(t, "OK", v)
+}
@@ -0,0 +1,31 @@
+package upstashtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis/upstashtest"
+)
+
+func Example() {
+	// In an actual test, t is the *testing.T received by the test function.
+	var t *testing.T
+	srv := upstashtest.NewServer(t)
+
+	// seed the data directly in Redis
+	srv.Redis.Set("name", "upstash")
+	srv.Redis.SetTTL("name", time.Minute)
+
+	// execute the code under test using the client
+	client := srv.Client()
+	var name string
+	if err := client.NewRequest().ExecOne(&name, "GET", "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	// advance the clock and assert that the key expired
+	srv.FastForward(time.Minute)
+	if srv.Redis.Exists("name") {
+		t.Fatal("key should have expired")
+	}
+}
@@ -0,0 +1,176 @@
+package upstashtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// inProcessURL is the base URL of the REST API of an in-process server. It
+// is never resolved, the requests are served by calling the handler.
+const inProcessURL = "http://upstashtest.invalid"
+
+// handlerDoer is an upstashdis.HTTPDoer that serves the requests in-process
+// by calling the handler, without network. The response is returned as
+// soon as its header is written, and its body is streamed via a pipe, so
+// that long-lived responses such as the subscription event streams are
+// supported.
+type handlerDoer struct {
+	h http.Handler
+}
+
+func (d handlerDoer) Do(req *http.Request) (*http.Response, error) {
+	sreq := req.Clone(req.Context())
+	sreq.RequestURI = req.URL.RequestURI()
+	sreq.RemoteAddr = "127.0.0.1:1"
+	if sreq.Body == nil {
+		sreq.Body = http.NoBody
+	}
+
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{
+		header: make(http.Header),
+		pw:     pw,
+		ready:  make(chan struct{}),
+	}
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				pw.CloseWithError(fmt.Errorf("upstashtest: handler panic: %v", e))
+			}
+		}()
+		d.h.ServeHTTP(w, sreq)
+		w.WriteHeader(http.StatusOK) // no-op if the header was written
+		pw.Close()
+	}()
+
+	select {
+	case <-w.ready:
+	case <-req.Context().Done():
+		pr.Close()
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode: w.status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     w.sent,
+		Body:       pr,
+		Request:    req,
+	}, nil
+}
+
+// pipeResponseWriter is the http.ResponseWriter of the handlerDoer. The
+// body is written directly to the pipe, so Flush is a no-op.
+type pipeResponseWriter struct {
+	header http.Header
+	pw     *io.PipeWriter
+	once   sync.Once
+	ready  chan struct{} // closed when the header is written
+
+	// set when the header is written
+	status int
+	sent   http.Header
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(code int) {
+	w.once.Do(func() {
+		w.status = code
+		w.sent = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+func (w *pipeResponseWriter) Flush() {}
+
+// memConnPair returns the two ends of an in-memory connection. Unlike the
+// net.Pipe connections, the writes are buffered, as they are by the network
+// stack for a TCP connection, so that the client and server may write
+// concurrently (e.g. UNSUBSCRIBE while a message is being published)
+// without deadlocking. Deadlines are not supported.
+func memConnPair() (net.Conn, net.Conn) {
+	a, b := newMemBuf(), newMemBuf()
+	return &memConn{r: a, w: b}, &memConn{r: b, w: a}
+}
+
+type memConn struct {
+	r, w *memBuf
+}
+
+func (c *memConn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *memConn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+func (c *memConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr                { return memAddr{} }
+func (c *memConn) RemoteAddr() net.Addr               { return memAddr{} }
+func (c *memConn) SetDeadline(t time.Time) error      { return nil }
+func (c *memConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *memConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type memAddr struct{}
+
+func (memAddr) Network() string { return "mem" }
+func (memAddr) String() string  { return "mem" }
+
+// memBuf is one direction of a memConn.
+type memBuf struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newMemBuf() *memBuf {
+	b := &memBuf{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *memBuf) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *memBuf) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.cond.Broadcast()
+	return b.buf.Write(p)
+}
+
+func (b *memBuf) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
@@ -0,0 +1,135 @@
+// Package upstashtest provides helpers to unit-test code that uses the
+// upstashdis client. It runs a restserver backed by an in-memory miniredis
+// instance behind an httptest server, so that tests execute the actual REST
+// API requests without requiring a running Redis database or network access.
+package upstashtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/upstashdis"
+	"github.com/mna/upstashdis/restserver"
+)
+
+// APIToken is the API token accepted by the test servers, and the one
+// configured on the Client returned by Server.Client.
+const APIToken = "upstashtest-token"
+
+// Server is a test Upstash-compatible REST API server. It is created by
+// NewServer and is automatically closed when the test completes.
+type Server struct {
+	// Redis is the miniredis instance that stores the data. It can be used to
+	// seed and assert the data directly (e.g. via its Set, CheckGet and
+	// HSet methods) and to control its clock (see FastForward).
+	Redis *miniredis.Miniredis
+
+	// HTTP is the httptest server that serves the REST API. It is nil for a
+	// server created with NewInProcessServer.
+	HTTP *httptest.Server
+
+	// REST is the REST API server handler. Its fields may be changed before
+	// the first request is made, e.g. to add read-only tokens.
+	REST *restserver.Server
+
+	pool *redis.Pool
+}
+
+// NewServer starts a new test server for the duration of the test t. All
+// resources are released via t.Cleanup, and any failure to start the server
+// fails the test.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := newServer(t, func(s *Server) (redis.Conn, error) {
+		return redis.Dial("tcp", s.Redis.Addr())
+	})
+	s.HTTP = httptest.NewServer(s.REST)
+	return s
+}
+
+// NewInProcessServer is like NewServer, but the REST API is served
+// in-process: the Client it returns calls the REST handler directly instead
+// of making HTTP requests, and the handler's connections to Redis are
+// in-memory. No HTTP listener is started and no network connection
+// is made, but the requests still go through the whole REST API layer, so
+// that the same authentication and error behaviors can be tested (e.g. an
+// invalid token fails with a 401 Unauthorized). Note that miniredis still
+// listens on a local port, although it is not used.
+func NewInProcessServer(t testing.TB) *Server {
+	t.Helper()
+
+	return newServer(t, func(s *Server) (redis.Conn, error) {
+		cli, srv := memConnPair()
+		s.Redis.Server().ServeConn(srv)
+		return redis.NewConn(cli, 0, 0), nil
+	})
+}
+
+func newServer(t testing.TB, dial func(*Server) (redis.Conn, error)) *Server {
+	s := &Server{Redis: miniredis.RunT(t)}
+	s.pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return dial(s)
+		},
+	}
+	s.REST = &restserver.Server{
+		APIToken: APIToken,
+		GetConnFunc: func(ctx context.Context) restserver.Conn {
+			return s.pool.Get()
+		},
+	}
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *Server) close() {
+	if s.HTTP != nil {
+		s.HTTP.Close()
+	}
+	s.pool.Close()
+}
+
+// URL returns the base URL of the REST API. For a server created with
+// NewInProcessServer, it is a placeholder URL that is only valid for the
+// Client returned by Server.Client.
+func (s *Server) URL() string {
+	if s.HTTP == nil {
+		return inProcessURL
+	}
+	return s.HTTP.URL
+}
+
+// Client returns a new client configured to execute requests against the
+// server. It uses the HTTP client of the httptest server, or an HTTPClient
+// that calls the REST handler directly for a server created with
+// NewInProcessServer.
+func (s *Server) Client() *upstashdis.Client {
+	if s.HTTP == nil {
+		return &upstashdis.Client{
+			BaseURL:    inProcessURL,
+			APIToken:   APIToken,
+			HTTPClient: handlerDoer{h: s.REST},
+		}
+	}
+	return &upstashdis.Client{
+		BaseURL:    s.HTTP.URL,
+		APIToken:   APIToken,
+		HTTPClient: s.HTTP.Client(),
+	}
+}
+
+// FastForward advances the clock of the Redis instance by d, expiring the
+// keys whose TTL has elapsed.
+func (s *Server) FastForward(d time.Duration) {
+	s.Redis.FastForward(d)
+}
+
+// FlushAll removes all keys from the Redis instance.
+func (s *Server) FlushAll() {
+	s.Redis.FlushAll()
+}
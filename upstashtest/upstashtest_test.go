@@ -0,0 +1,78 @@
+package upstashtest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mna/upstashdis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t)
+	client := srv.Client()
+
+	require.NoError(t, srv.Redis.Set("seeded", "v"))
+
+	var s string
+	require.NoError(t, client.NewRequest().ExecOne(&s, "GET", "seeded"))
+	require.Equal(t, "v", s)
+
+	require.NoError(t, client.NewRequest().ExecOne(&s, "SET", "k", "1", "EX", 10))
+	require.Equal(t, "OK", s)
+	srv.Redis.CheckGet(t, "k", "1")
+
+	srv.FastForward(11 * time.Second)
+	require.False(t, srv.Redis.Exists("k"))
+
+	srv.FlushAll()
+	require.False(t, srv.Redis.Exists("seeded"))
+}
+
+func TestInProcessServer(t *testing.T) {
+	srv := NewInProcessServer(t)
+	require.Nil(t, srv.HTTP)
+	client := srv.Client()
+
+	require.NoError(t, srv.Redis.Set("seeded", "v"))
+
+	var s string
+	require.NoError(t, client.NewRequest().ExecOne(&s, "GET", "seeded"))
+	require.Equal(t, "v", s)
+
+	req := client.NewRequest()
+	require.NoError(t, req.Send("INCR", "n"))
+	require.NoError(t, req.Send("HGET", "seeded", "x"))
+	var n int
+	err := req.Exec(&n, nil)
+	var rerr *upstashdis.Error
+	require.ErrorAs(t, err, &rerr)
+	require.Equal(t, "WRONGTYPE", rerr.Kind)
+	require.Equal(t, 1, n)
+
+	err = client.NewRequestWithToken("invalid").ExecOne(nil, "GET", "seeded")
+	require.ErrorAs(t, err, &rerr)
+	require.Equal(t, http.StatusUnauthorized, rerr.StatusCode)
+
+	t.Run("subscribe", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		sub := &upstashdis.Subscriber{
+			Client:  client,
+			Channel: "ch",
+			OnSubscribe: func() {
+				go func() { _ = client.NewRequest().ExecOne(nil, "PUBLISH", "ch", "hello") }()
+			},
+		}
+		var msg upstashdis.Message
+		err := sub.Run(ctx, func(m upstashdis.Message) {
+			msg = m
+			cancel()
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, "hello", msg.Payload)
+	})
+}
@@ -0,0 +1,166 @@
+// Package vector implements a client for the Upstash Vector REST API [1]. It
+// uses the upstashdis.Client to make the calls, so that it shares the same
+// HTTP configuration and authentication features as the Redis REST client.
+//
+//	[1]: https://upstash.com/docs/vector/api/get-started
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/mna/upstashdis"
+)
+
+// Vector is a vector stored in an index.
+type Vector struct {
+	// ID is the unique identifier of the vector in the index.
+	ID string `json:"id"`
+	// Vector is the vector's values. It may be empty in results if the
+	// vectors were not requested.
+	Vector []float32 `json:"vector,omitempty"`
+	// Metadata is the optional metadata associated with the vector. It may be
+	// empty in results if the metadata was not requested.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Query is a similarity search query.
+type Query struct {
+	// Vector is the vector to search for.
+	Vector []float32 `json:"vector"`
+	// TopK is the maximum number of results to return.
+	TopK int `json:"topK"`
+	// IncludeVectors indicates whether the vectors' values are returned.
+	IncludeVectors bool `json:"includeVectors,omitempty"`
+	// IncludeMetadata indicates whether the vectors' metadata is returned.
+	IncludeMetadata bool `json:"includeMetadata,omitempty"`
+	// Filter is an optional metadata filter expression that the results must
+	// satisfy, e.g. "genre = 'drama' AND year >= 2000". See the Upstash
+	// Vector documentation for the syntax.
+	Filter string `json:"filter,omitempty"`
+}
+
+// Match is a vector that matched a Query.
+type Match struct {
+	Vector
+	// Score is the similarity score of the vector with the query's vector,
+	// normalized between 0 and 1, where 1 is the most similar.
+	Score float32 `json:"score"`
+}
+
+// Range is the request to iterate over the vectors of an index.
+type Range struct {
+	// Cursor is the position at which to start the iteration, "0" (or empty)
+	// to start at the beginning, otherwise the NextCursor of the previous
+	// RangeResult.
+	Cursor string `json:"cursor"`
+	// Limit is the maximum number of vectors to return.
+	Limit int `json:"limit"`
+	// IncludeVectors indicates whether the vectors' values are returned.
+	IncludeVectors bool `json:"includeVectors,omitempty"`
+	// IncludeMetadata indicates whether the vectors' metadata is returned.
+	IncludeMetadata bool `json:"includeMetadata,omitempty"`
+}
+
+// RangeResult is the result of a Range request.
+type RangeResult struct {
+	// NextCursor is the cursor to use to continue the iteration, it is empty
+	// when the iteration is complete.
+	NextCursor string   `json:"nextCursor"`
+	Vectors    []Vector `json:"vectors"`
+}
+
+// Info holds information about an index.
+type Info struct {
+	VectorCount        int64  `json:"vectorCount"`
+	PendingVectorCount int64  `json:"pendingVectorCount"`
+	IndexSize          int64  `json:"indexSize"`
+	Dimension          int    `json:"dimension"`
+	SimilarityFunction string `json:"similarityFunction"`
+}
+
+// Index is a client for an Upstash Vector index. It is safe for concurrent
+// use if its Client is.
+type Index struct {
+	// Client is the REST client used to make the calls. Its BaseURL and
+	// APIToken must be those of the vector index (and not of a Redis
+	// database).
+	Client *upstashdis.Client
+}
+
+// Upsert inserts the vectors in the index, or updates them if vectors with
+// the same IDs already exist.
+func (ix *Index) Upsert(ctx context.Context, vectors ...Vector) error {
+	if len(vectors) == 0 {
+		return errors.New("vector: no vector to upsert")
+	}
+	_, err := ix.Client.Call(ctx, "POST", "upsert", vectors)
+	return err
+}
+
+// Query returns the vectors most similar to the query's vector.
+func (ix *Index) Query(ctx context.Context, q Query) ([]Match, error) {
+	var matches []Match
+	err := ix.call(ctx, "POST", "query", q, &matches)
+	return matches, err
+}
+
+// Fetch returns the vectors with the specified IDs, in the same order. The
+// vector is nil for IDs that do not exist.
+func (ix *Index) Fetch(ctx context.Context, ids []string, includeVectors, includeMetadata bool) ([]*Vector, error) {
+	body := struct {
+		IDs             []string `json:"ids"`
+		IncludeVectors  bool     `json:"includeVectors,omitempty"`
+		IncludeMetadata bool     `json:"includeMetadata,omitempty"`
+	}{ids, includeVectors, includeMetadata}
+
+	var vectors []*Vector
+	err := ix.call(ctx, "POST", "fetch", body, &vectors)
+	return vectors, err
+}
+
+// Delete deletes the vectors with the specified IDs and returns the number
+// of vectors that were deleted.
+func (ix *Index) Delete(ctx context.Context, ids ...string) (int, error) {
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+	err := ix.call(ctx, "POST", "delete", ids, &res)
+	return res.Deleted, err
+}
+
+// Range returns a page of the vectors of the index.
+func (ix *Index) Range(ctx context.Context, r Range) (*RangeResult, error) {
+	if r.Cursor == "" {
+		r.Cursor = "0"
+	}
+	var res RangeResult
+	if err := ix.call(ctx, "POST", "range", r, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Info returns information about the index.
+func (ix *Index) Info(ctx context.Context) (*Info, error) {
+	var info Info
+	if err := ix.call(ctx, "GET", "info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Reset deletes all vectors of the index.
+func (ix *Index) Reset(ctx context.Context) error {
+	_, err := ix.Client.Call(ctx, "DELETE", "reset", nil)
+	return err
+}
+
+func (ix *Index) call(ctx context.Context, method, path string, body, dst interface{}) error {
+	raw, err := ix.Client.Call(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
@@ -0,0 +1,260 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/mna/upstashdis"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "vector-token"
+
+// fakeIndex is a minimal in-memory implementation of the Upstash Vector REST
+// API, for tests.
+type fakeIndex struct {
+	mu         sync.Mutex
+	vectors    map[string]Vector
+	lastFilter string
+}
+
+func (f *fakeIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(code int, v interface{}) {
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": v})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": v, "status": code})
+	}
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		reply(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if f.vectors == nil {
+		f.vectors = make(map[string]Vector)
+	}
+
+	dec := json.NewDecoder(r.Body)
+	switch r.Method + " " + r.URL.Path {
+	case "POST /upsert":
+		var vs []Vector
+		if err := dec.Decode(&vs); err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, v := range vs {
+			if len(v.Vector) != 2 {
+				reply(http.StatusBadRequest, "Invalid vector dimension: "+strconv.Itoa(len(v.Vector))+", expected: 2")
+				return
+			}
+			f.vectors[v.ID] = v
+		}
+		reply(http.StatusOK, "Success")
+
+	case "POST /query":
+		var q Query
+		if err := dec.Decode(&q); err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return
+		}
+		f.lastFilter = q.Filter
+		var matches []Match
+		for _, v := range f.vectors {
+			m := Match{Vector: Vector{ID: v.ID}, Score: cosine(q.Vector, v.Vector)}
+			if q.IncludeVectors {
+				m.Vector.Vector = v.Vector
+			}
+			if q.IncludeMetadata {
+				m.Metadata = v.Metadata
+			}
+			matches = append(matches, m)
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+		if len(matches) > q.TopK {
+			matches = matches[:q.TopK]
+		}
+		reply(http.StatusOK, matches)
+
+	case "POST /fetch":
+		var req struct {
+			IDs             []string `json:"ids"`
+			IncludeMetadata bool     `json:"includeMetadata"`
+		}
+		if err := dec.Decode(&req); err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return
+		}
+		res := make([]*Vector, len(req.IDs))
+		for i, id := range req.IDs {
+			if v, ok := f.vectors[id]; ok {
+				res[i] = &Vector{ID: v.ID}
+				if req.IncludeMetadata {
+					res[i].Metadata = v.Metadata
+				}
+			}
+		}
+		reply(http.StatusOK, res)
+
+	case "POST /delete":
+		var ids []string
+		if err := dec.Decode(&ids); err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return
+		}
+		var n int
+		for _, id := range ids {
+			if _, ok := f.vectors[id]; ok {
+				delete(f.vectors, id)
+				n++
+			}
+		}
+		reply(http.StatusOK, map[string]int{"deleted": n})
+
+	case "POST /range":
+		var rg Range
+		if err := dec.Decode(&rg); err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return
+		}
+		ids := make([]string, 0, len(f.vectors))
+		for id := range f.vectors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		start, _ := strconv.Atoi(rg.Cursor)
+		var res RangeResult
+		for i := start; i < len(ids) && len(res.Vectors) < rg.Limit; i++ {
+			res.Vectors = append(res.Vectors, Vector{ID: ids[i]})
+			if i+1 < len(ids) {
+				res.NextCursor = strconv.Itoa(i + 1)
+			} else {
+				res.NextCursor = ""
+			}
+		}
+		reply(http.StatusOK, res)
+
+	case "GET /info":
+		reply(http.StatusOK, Info{VectorCount: int64(len(f.vectors)), Dimension: 2, SimilarityFunction: "COSINE"})
+
+	case "DELETE /reset":
+		f.vectors = nil
+		reply(http.StatusOK, "Success")
+
+	default:
+		reply(http.StatusNotFound, "Not Found")
+	}
+}
+
+func cosine(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	return float32((1 + dot/math.Sqrt(na*nb)) / 2)
+}
+
+func TestIndex(t *testing.T) {
+	fake := &fakeIndex{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ix := &Index{Client: &upstashdis.Client{BaseURL: srv.URL, APIToken: testToken}}
+	ctx := context.Background()
+
+	t.Run("upsert", func(t *testing.T) {
+		err := ix.Upsert(ctx,
+			Vector{ID: "a", Vector: []float32{1, 0}, Metadata: map[string]interface{}{"genre": "drama"}},
+			Vector{ID: "b", Vector: []float32{0, 1}},
+			Vector{ID: "c", Vector: []float32{1, 1}},
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("upsert invalid", func(t *testing.T) {
+		err := ix.Upsert(ctx, Vector{ID: "d", Vector: []float32{1}})
+		var rerr *upstashdis.Error
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		require.Contains(t, rerr.Message, "Invalid vector dimension")
+
+		require.Error(t, ix.Upsert(ctx))
+	})
+
+	t.Run("query", func(t *testing.T) {
+		matches, err := ix.Query(ctx, Query{Vector: []float32{1, 0.1}, TopK: 2, IncludeMetadata: true, Filter: "genre = 'drama'"})
+		require.NoError(t, err)
+		require.Len(t, matches, 2)
+		require.Equal(t, "a", matches[0].ID)
+		require.Equal(t, "drama", matches[0].Metadata["genre"])
+		require.Nil(t, matches[0].Vector.Vector)
+		require.Equal(t, "c", matches[1].ID)
+		require.Greater(t, matches[0].Score, matches[1].Score)
+		require.Equal(t, "genre = 'drama'", fake.lastFilter)
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		vs, err := ix.Fetch(ctx, []string{"a", "nope"}, false, true)
+		require.NoError(t, err)
+		require.Len(t, vs, 2)
+		require.Equal(t, "a", vs[0].ID)
+		require.Equal(t, "drama", vs[0].Metadata["genre"])
+		require.Nil(t, vs[1])
+	})
+
+	t.Run("range", func(t *testing.T) {
+		var ids []string
+		r := Range{Limit: 2}
+		for {
+			res, err := ix.Range(ctx, r)
+			require.NoError(t, err)
+			for _, v := range res.Vectors {
+				ids = append(ids, v.ID)
+			}
+			if res.NextCursor == "" {
+				break
+			}
+			r.Cursor = res.NextCursor
+		}
+		require.Equal(t, []string{"a", "b", "c"}, ids)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		n, err := ix.Delete(ctx, "b", "nope")
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("info", func(t *testing.T) {
+		info, err := ix.Info(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), info.VectorCount)
+		require.Equal(t, 2, info.Dimension)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		require.NoError(t, ix.Reset(ctx))
+		info, err := ix.Info(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), info.VectorCount)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		bad := &Index{Client: ix.Client.CloneWithToken("nope")}
+		_, err := bad.Info(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Unauthorized")
+	})
+}